@@ -0,0 +1,155 @@
+package enigmacache
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+// An AdmissionPolicy decides whether a new key may displace an old one
+// in a full cache (see WithMaxEntries and WithMaxMemory), from what
+// it has seen of the keys the cache is asked for, so that keys used
+// once, as by a scan, don't push out those in steady use. TinyLFU is
+// the built-in one. Policies must be safe for concurrent use.
+type AdmissionPolicy interface {
+	// Record notes a request for key: a read, hit or miss, or the
+	// write of a new key.
+	Record(key string)
+	// Admit reports whether candidate, a new key, should be let into
+	// the cache in place of victim, the key the cache would evict
+	// first to make room.
+	Admit(candidate, victim string) bool
+}
+
+// WithAdmissionPolicy has p decide which new keys a full cache takes
+// in. Every read is recorded with p, as is every write of a new key,
+// and a new key which would evict an entry is only stored if p admits
+// it over the entry which would go first; otherwise the write is
+// skipped, and counted in Stats().Rejected, as for
+// WithAdmissionFilter, which is consulted first. Where making room
+// takes more than one eviction, as when WithMaxMemory bounds the
+// cache, only the first is weighed. If p panics, the key is admitted.
+func WithAdmissionPolicy(p AdmissionPolicy) Option {
+	return func(o *options) {
+		o.admission = p
+	}
+}
+
+// admitOver reports whether the cache's admission policy, if it has
+// one, lets key in over the next victim. mc.policyMu mustn't be held.
+func (mc *MemoryCache) admitOver(key string) bool {
+	p := mc.opts.admission
+	if p == nil {
+		return true
+	}
+	mc.policyMu.Lock()
+	victim, ok := mc.policy.victim()
+	mc.policyMu.Unlock()
+	if !ok {
+		return true
+	}
+	admitted := true
+	mc.protect("admission policy", func() {
+		admitted = p.Admit(key, victim)
+	})
+	return admitted
+}
+
+// record notes a request for key with the cache's admission policy.
+func (mc *MemoryCache) record(key string) {
+	if p := mc.opts.admission; p != nil {
+		p.Record(key)
+	}
+}
+
+// TinyLFU is an AdmissionPolicy admitting a new key only if it has been
+// asked for more often, lately, than the key it would displace. It
+// estimates how often keys are asked for with a count-min sketch of
+// small counters, which are all halved every so often, so that keys
+// which were popular once but aren't now lose their hold.
+type TinyLFU struct {
+	seed maphash.Seed
+
+	mu sync.Mutex
+	// counters holds tinyLFURows rows of the sketch, each of width
+	// counters, width being a power of two.
+	counters []uint8
+	width    uint64
+	// recorded counts the requests recorded since the counters were
+	// last halved, which they're due to be again at resetAt.
+	recorded, resetAt int
+}
+
+const (
+	// tinyLFURows is the number of rows in a TinyLFU sketch: each key
+	// has a counter in every row, and its estimate is the least.
+	tinyLFURows = 4
+	// tinyLFUMax is the most a counter counts to.
+	tinyLFUMax = 15
+)
+
+// NewTinyLFU returns a TinyLFU sized for a cache of about capacity
+// keys: each row of the sketch has eight counters for each key, a few
+// bytes a key in all, and the counters are halved after ten times
+// capacity requests.
+func NewTinyLFU(capacity int) *TinyLFU {
+	capacity = max(capacity, 16)
+	width := uint64(1)
+	for width < 8*uint64(capacity) {
+		width <<= 1
+	}
+	return &TinyLFU{
+		seed:     maphash.MakeSeed(),
+		counters: make([]uint8, tinyLFURows*width),
+		width:    width,
+		resetAt:  10 * capacity,
+	}
+}
+
+// slots returns the indexes of key's counters, one in each row.
+func (t *TinyLFU) slots(key string) [tinyLFURows]uint64 {
+	h := maphash.String(t.seed, key)
+	// Double hashing: the halves of one hash make the rows'.
+	lo, hi := h&0xffffffff, (h>>32)|1
+	var slots [tinyLFURows]uint64
+	for i := range slots {
+		slots[i] = uint64(i)*t.width + (lo+uint64(i)*hi)&(t.width-1)
+	}
+	return slots
+}
+
+// Record counts a request for key.
+func (t *TinyLFU) Record(key string) {
+	slots := t.slots(key)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, i := range slots {
+		if t.counters[i] < tinyLFUMax {
+			t.counters[i]++
+		}
+	}
+	t.recorded++
+	if t.recorded >= t.resetAt {
+		for i := range t.counters {
+			t.counters[i] /= 2
+		}
+		t.recorded /= 2
+	}
+}
+
+// Estimate returns about how many times key has been requested lately.
+func (t *TinyLFU) Estimate(key string) int {
+	slots := t.slots(key)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := uint8(tinyLFUMax)
+	for _, i := range slots {
+		n = min(n, t.counters[i])
+	}
+	return int(n)
+}
+
+// Admit admits candidate if it has been requested more often than
+// victim.
+func (t *TinyLFU) Admit(candidate, victim string) bool {
+	return t.Estimate(candidate) > t.Estimate(victim)
+}
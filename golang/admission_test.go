@@ -0,0 +1,68 @@
+package enigmacache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTinyLFUResistsScans(t *testing.T) {
+	// A sketch sized well beyond the cache keeps collisions, and
+	// halving, out of the picture.
+	cache := NewMemoryCache(WithMaxEntries(10), WithAdmissionPolicy(NewTinyLFU(1000)))
+	for i := range 10 {
+		key := fmt.Sprint("hot", i)
+		cache.Set(key, i, time.Hour)
+		for range 3 {
+			cache.Get(key)
+		}
+	}
+
+	// The hot keys stay in use during the scan.
+	for i := range 100 {
+		key := fmt.Sprint("scan", i)
+		if _, ok := cache.Get(key); !ok {
+			cache.Set(key, i, time.Hour)
+		}
+		cache.Get(fmt.Sprint("hot", i%10))
+	}
+	for i := range 10 {
+		if key := fmt.Sprint("hot", i); !cache.Has(key) {
+			t.Errorf("scan evicted %s", key)
+		}
+	}
+	if n := cache.Stats().Rejected; n != 100 {
+		t.Errorf("%d writes rejected, want the 100 scanned keys", n)
+	}
+
+	// A key asked for often enough gets in.
+	for range 20 {
+		cache.Get("popular")
+	}
+	cache.Set("popular", 0, time.Hour)
+	if !cache.Has("popular") {
+		t.Error("a key asked for more often than the victim wasn't admitted")
+	}
+	if n := cache.Len(); n != 10 {
+		t.Errorf("Len = %d, want 10", n)
+	}
+}
+
+func TestTinyLFUAges(t *testing.T) {
+	lfu := NewTinyLFU(16)
+	for range 8 {
+		lfu.Record("old")
+	}
+	if n := lfu.Estimate("old"); n != 8 {
+		t.Fatalf("Estimate = %d after 8 requests, want 8", n)
+	}
+	for i := range 160 {
+		lfu.Record(fmt.Sprint("other", i))
+	}
+	if n := lfu.Estimate("old"); n >= 8 {
+		t.Errorf("Estimate = %d after the sketch was due to be halved, want less than 8", n)
+	}
+	if lfu.Admit("never", "old") {
+		t.Error("admitted a key never asked for over one asked for before")
+	}
+}
@@ -0,0 +1,112 @@
+package enigmacache
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+)
+
+// WithApproxLRU makes a bounded cache (see WithMaxEntries and
+// WithMaxMemory) evict by approximate LRU, as Redis does: to make room
+// it samples sampleSize keys at random and evicts whichever was read
+// least recently. Reads then only update the entry's own access time,
+// with no shared list to reorder, at the price of sometimes evicting
+// a key more recently used than the true LRU one. Larger samples get
+// closer to exact LRU; Redis defaults to 5. A sampleSize of zero or
+// less keeps exact LRU.
+func WithApproxLRU(sampleSize int) Option {
+	return func(o *options) {
+		o.lruSamples = sampleSize
+	}
+}
+
+// sampledPolicy implements WithApproxLRU. It keeps the keys in a
+// slice, so it can pick random ones, and reads each candidate's access
+// time from its entry.
+type sampledPolicy struct {
+	samples int
+	rng     *rand.Rand
+	keyList []string
+	index   map[string]int
+	// lastAccess returns the access time of key's entry, or false if
+	// it's no longer stored.
+	lastAccess func(key string) (int64, bool)
+}
+
+func newSampledPolicy(samples int, rng *rand.Rand, lastAccess func(string) (int64, bool)) *sampledPolicy {
+	return &sampledPolicy{
+		samples:    samples,
+		rng:        rng,
+		index:      make(map[string]int),
+		lastAccess: lastAccess,
+	}
+}
+
+func (p *sampledPolicy) add(key string) {
+	if _, ok := p.index[key]; ok {
+		return
+	}
+	p.index[key] = len(p.keyList)
+	p.keyList = append(p.keyList, key)
+}
+
+// access does nothing: the entry's own access time is all we go by.
+func (p *sampledPolicy) access(string) {}
+
+func (p *sampledPolicy) remove(key string) {
+	i, ok := p.index[key]
+	if !ok {
+		return
+	}
+	last := len(p.keyList) - 1
+	p.keyList[i] = p.keyList[last]
+	p.index[p.keyList[i]] = i
+	p.keyList = p.keyList[:last]
+	delete(p.index, key)
+}
+
+func (p *sampledPolicy) victim() (string, bool) {
+	if len(p.keyList) == 0 {
+		return "", false
+	}
+	var victim string
+	var oldest int64
+	for i := range min(p.samples, len(p.keyList)) {
+		key := p.keyList[p.rng.IntN(len(p.keyList))]
+		at, ok := p.lastAccess(key)
+		if !ok {
+			// Already gone; evict will drop it.
+			return key, true
+		}
+		if i == 0 || at < oldest {
+			victim, oldest = key, at
+		}
+	}
+	return victim, true
+}
+
+func (p *sampledPolicy) len() int {
+	return len(p.keyList)
+}
+
+// keys returns the keys least recently read first, which is the order
+// exact LRU would evict them in.
+func (p *sampledPolicy) keys() []string {
+	type keyed struct {
+		key string
+		at  int64
+	}
+	all := make([]keyed, 0, len(p.keyList))
+	for _, key := range p.keyList {
+		at, _ := p.lastAccess(key)
+		all = append(all, keyed{key, at})
+	}
+	slices.SortStableFunc(all, func(a, b keyed) int {
+		return cmp.Compare(a.at, b.at)
+	})
+	keys := make([]string, len(all))
+	for i, k := range all {
+		keys[i] = k.key
+	}
+	return keys
+}
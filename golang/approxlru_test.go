@@ -0,0 +1,87 @@
+package enigmacache
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestApproxLRUPrefersOlderEntries(t *testing.T) {
+	const n = 1000
+	cache, clock := NewTestCache(WithMaxEntries(n), WithApproxLRU(5))
+	for i := range n {
+		cache.Set(fmt.Sprint(i), i, time.Hour)
+	}
+	// Read the upper half, so the lower half is older.
+	clock.Advance(time.Second)
+	for i := n / 2; i < n; i++ {
+		cache.Get(fmt.Sprint(i))
+	}
+	clock.Advance(time.Second)
+	for i := range n / 2 {
+		cache.Set(fmt.Sprintf("new%d", i), i, time.Hour)
+	}
+
+	var cold, hot int
+	for i := range n {
+		if _, ok := cache.storage.Load(fmt.Sprint(i)); !ok {
+			continue
+		}
+		if i < n/2 {
+			cold++
+		} else {
+			hot++
+		}
+	}
+	if cache.Len() != n {
+		t.Fatalf("Len = %d, want %d", cache.Len(), n)
+	}
+	if hot <= 2*cold {
+		t.Fatalf("%d recently read and %d older entries survived; want far more recent ones", hot, cold)
+	}
+}
+
+func BenchmarkSetBounded(b *testing.B) {
+	keys := make([]string, 4096)
+	for i := range keys {
+		keys[i] = fmt.Sprint(i)
+	}
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"LRU", nil},
+		{"ApproxLRU", []Option{WithApproxLRU(5)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			cache := NewMemoryCache(append(bc.opts, WithMaxEntries(len(keys)/2))...)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					cache.Set(keys[i%len(keys)], i, time.Hour)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestApproxLRUWithRandSourceIsReproducible(t *testing.T) {
+	survivors := func() []string {
+		cache, clock := NewTestCache(WithMaxEntries(100), WithApproxLRU(3), WithRandSource(rand.NewPCG(1, 2)))
+		for i := range 300 {
+			cache.Set(fmt.Sprint(i), i, time.Hour)
+			clock.Advance(time.Millisecond)
+		}
+		keys := cache.Keys()
+		slices.Sort(keys)
+		return keys
+	}
+	first, second := survivors(), survivors()
+	if !slices.Equal(first, second) {
+		t.Fatalf("same seed kept different keys:\n%v\n%v", first, second)
+	}
+}
@@ -0,0 +1,361 @@
+package enigmacache
+
+import (
+	"maps"
+	"sync"
+	"sync/atomic"
+)
+
+// A backend is the map underlying a MemoryCache. It has the semantics
+// of the corresponding sync.Map methods, specialized to string keys
+// and *entry values.
+type backend interface {
+	Load(key string) (e *entry, ok bool)
+	LoadOrStore(key string, e *entry) (actual *entry, loaded bool)
+	LoadAndDelete(key string) (e *entry, loaded bool)
+	Swap(key string, e *entry) (previous *entry, loaded bool)
+	CompareAndSwap(key string, old, new *entry) (swapped bool)
+	CompareAndDelete(key string, old *entry) (deleted bool)
+	Range(f func(key string, e *entry) bool)
+	Clear()
+	// Replace swaps the backend's contents for entries in one step:
+	// no Load sees a mixture of the old and new contents. It returns
+	// the old contents.
+	Replace(entries map[string]*entry) (old map[string]*entry)
+	// Snapshot returns the backend's contents at a single instant, as
+	// maps which are never modified afterwards.
+	Snapshot() []map[string]*entry
+}
+
+// syncMapBackend is the default backend. sync.Map suits the write-once,
+// read-many access pattern we assume; see the README.
+//
+// The map is held by pointer so that Replace can swap in a new one
+// for readers in a single step. Writers hold swapMu for reading, so
+// none of them can write to the old map after Replace has taken its
+// contents, or to the map while Snapshot is copying it; readers don't
+// need it. Uncontended, that costs a writer a pair of atomic adds.
+type syncMapBackend struct {
+	m      atomic.Pointer[sync.Map]
+	swapMu sync.RWMutex
+}
+
+// current returns the map in use, creating it on first use so the
+// zero syncMapBackend is ready for use.
+func (s *syncMapBackend) current() *sync.Map {
+	if m := s.m.Load(); m != nil {
+		return m
+	}
+	s.m.CompareAndSwap(nil, new(sync.Map))
+	return s.m.Load()
+}
+
+func (s *syncMapBackend) Load(key string) (*entry, bool) {
+	v, ok := s.current().Load(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(*entry), true
+}
+
+func (s *syncMapBackend) LoadOrStore(key string, e *entry) (*entry, bool) {
+	s.swapMu.RLock()
+	defer s.swapMu.RUnlock()
+	v, loaded := s.current().LoadOrStore(key, e)
+	return v.(*entry), loaded
+}
+
+func (s *syncMapBackend) LoadAndDelete(key string) (*entry, bool) {
+	s.swapMu.RLock()
+	defer s.swapMu.RUnlock()
+	v, loaded := s.current().LoadAndDelete(key)
+	if !loaded {
+		return nil, false
+	}
+	return v.(*entry), true
+}
+
+func (s *syncMapBackend) Swap(key string, e *entry) (*entry, bool) {
+	s.swapMu.RLock()
+	defer s.swapMu.RUnlock()
+	v, loaded := s.current().Swap(key, e)
+	if !loaded {
+		return nil, false
+	}
+	return v.(*entry), true
+}
+
+func (s *syncMapBackend) CompareAndSwap(key string, old, new *entry) bool {
+	s.swapMu.RLock()
+	defer s.swapMu.RUnlock()
+	return s.current().CompareAndSwap(key, old, new)
+}
+
+func (s *syncMapBackend) CompareAndDelete(key string, old *entry) bool {
+	s.swapMu.RLock()
+	defer s.swapMu.RUnlock()
+	return s.current().CompareAndDelete(key, old)
+}
+
+// Range iterates over whichever map is current when it starts, so it
+// sees the contents from either before or after a concurrent Replace,
+// never a mixture.
+func (s *syncMapBackend) Range(f func(key string, e *entry) bool) {
+	s.current().Range(func(k, v any) bool {
+		return f(k.(string), v.(*entry))
+	})
+}
+
+func (s *syncMapBackend) Clear() {
+	s.swapMu.RLock()
+	defer s.swapMu.RUnlock()
+	s.current().Clear()
+}
+
+// Snapshot holds off writers while it copies the map, since a
+// sync.Map can't be frozen. Readers carry on, but every write stalls
+// for the whole O(n) copy, so a large, busy cache that is snapshotted
+// should be built WithShards, whose Snapshot copies nothing up front.
+func (s *syncMapBackend) Snapshot() []map[string]*entry {
+	s.swapMu.Lock()
+	defer s.swapMu.Unlock()
+	entries := make(map[string]*entry)
+	s.current().Range(func(k, v any) bool {
+		entries[k.(string)] = v.(*entry)
+		return true
+	})
+	return []map[string]*entry{entries}
+}
+
+func (s *syncMapBackend) Replace(entries map[string]*entry) map[string]*entry {
+	m := new(sync.Map)
+	for k, e := range entries {
+		m.Store(k, e)
+	}
+	s.swapMu.Lock()
+	defer s.swapMu.Unlock()
+	old := make(map[string]*entry)
+	if prev := s.m.Swap(m); prev != nil {
+		prev.Range(func(k, v any) bool {
+			old[k.(string)] = v.(*entry)
+			return true
+		})
+	}
+	return old
+}
+
+// shardedBackend spreads keys across a fixed number of mutex-guarded
+// maps, chosen by hashing the key. Under write-heavy workloads with
+// many distinct keys this holds up better than a single sync.Map.
+type shardedBackend struct {
+	hash   func(string) uint64
+	shards []shard
+}
+
+type shard struct {
+	mu      sync.RWMutex
+	entries map[string]*entry
+	// shared is set when a snapshot has taken entries, which must
+	// then be copied before it's next written to.
+	shared bool
+	// contended counts the times lock or rlock found mu taken and had
+	// to wait, for ShardStats.
+	contended atomic.Uint64
+}
+
+// lock locks sh.mu for writing, counting it as contended if it has to
+// wait.
+func (sh *shard) lock() {
+	if !sh.mu.TryLock() {
+		sh.contended.Add(1)
+		sh.mu.Lock()
+	}
+}
+
+// rlock locks sh.mu for reading, counting it as contended if it has to
+// wait.
+func (sh *shard) rlock() {
+	if !sh.mu.TryRLock() {
+		sh.contended.Add(1)
+		sh.mu.RLock()
+	}
+}
+
+// writable returns the shard's map, ready to be written to. sh.mu must
+// be held for writing.
+func (sh *shard) writable() map[string]*entry {
+	if sh.shared {
+		sh.entries = maps.Clone(sh.entries)
+		sh.shared = false
+	}
+	return sh.entries
+}
+
+func newShardedBackend(n int, hash func(string) uint64) *shardedBackend {
+	s := &shardedBackend{
+		hash:   hash,
+		shards: make([]shard, n),
+	}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]*entry)
+	}
+	return s
+}
+
+// shardIndex returns the index of the shard responsible for key.
+func (s *shardedBackend) shardIndex(key string) int {
+	return int(s.hash(key) % uint64(len(s.shards)))
+}
+
+func (s *shardedBackend) shardFor(key string) *shard {
+	return &s.shards[s.shardIndex(key)]
+}
+
+func (s *shardedBackend) Load(key string) (*entry, bool) {
+	sh := s.shardFor(key)
+	sh.rlock()
+	defer sh.mu.RUnlock()
+	e, ok := sh.entries[key]
+	return e, ok
+}
+
+func (s *shardedBackend) LoadOrStore(key string, e *entry) (*entry, bool) {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+	if existing, ok := sh.entries[key]; ok {
+		return existing, true
+	}
+	sh.writable()[key] = e
+	return e, false
+}
+
+func (s *shardedBackend) LoadAndDelete(key string) (*entry, bool) {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+	e, ok := sh.entries[key]
+	if ok {
+		delete(sh.writable(), key)
+	}
+	return e, ok
+}
+
+func (s *shardedBackend) Swap(key string, e *entry) (*entry, bool) {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+	previous, loaded := sh.entries[key]
+	sh.writable()[key] = e
+	return previous, loaded
+}
+
+func (s *shardedBackend) CompareAndSwap(key string, old, new *entry) bool {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+	if sh.entries[key] != old || old == nil {
+		return false
+	}
+	sh.writable()[key] = new
+	return true
+}
+
+func (s *shardedBackend) CompareAndDelete(key string, old *entry) bool {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+	if sh.entries[key] != old || old == nil {
+		return false
+	}
+	delete(sh.writable(), key)
+	return true
+}
+
+// Range calls f for the entries of a Snapshot, so it sees the
+// contents from either before or after a concurrent Replace, never a
+// mixture, and holds no locks while f runs: f is free to call back
+// into the backend, Replace included.
+func (s *shardedBackend) Range(f func(key string, e *entry) bool) {
+	for _, entries := range s.Snapshot() {
+		for k, e := range entries {
+			if !f(k, e) {
+				return
+			}
+		}
+	}
+}
+
+func (s *shardedBackend) Clear() {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		if sh.shared {
+			sh.entries = make(map[string]*entry)
+			sh.shared = false
+		} else {
+			clear(sh.entries)
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// Replace locks every shard at once, in order, to swap in the new
+// contents, so that Snapshot, which does the same, sees the contents
+// from either before or after it.
+func (s *shardedBackend) Replace(entries map[string]*entry) map[string]*entry {
+	fresh := make([]map[string]*entry, len(s.shards))
+	for i := range fresh {
+		fresh[i] = make(map[string]*entry)
+	}
+	for k, e := range entries {
+		fresh[s.shardIndex(k)][k] = e
+	}
+
+	for i := range s.shards {
+		s.shards[i].mu.Lock()
+	}
+	old := make(map[string]*entry)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		for k, e := range sh.entries {
+			old[k] = e
+		}
+		sh.entries = fresh[i]
+		sh.shared = false
+		sh.mu.Unlock()
+	}
+	return old
+}
+
+// Snapshot locks every shard at once, in order, but only to mark each
+// one shared: the maps aren't copied until they're next written to,
+// and then a shard at a time.
+func (s *shardedBackend) Snapshot() []map[string]*entry {
+	for i := range s.shards {
+		s.shards[i].mu.Lock()
+	}
+	frozen := make([]map[string]*entry, len(s.shards))
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.shared = true
+		frozen[i] = sh.entries
+		sh.mu.Unlock()
+	}
+	return frozen
+}
+
+// fnv1a is the default key hash: 64-bit FNV-1a. It's unseeded, so
+// shard placement is the same from one run to the next.
+func fnv1a(key string) uint64 {
+	const (
+		offset = 14695981039346656037
+		prime  = 1099511628211
+	)
+	h := uint64(offset)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= prime
+	}
+	return h
+}
@@ -0,0 +1,140 @@
+package enigmacache
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithHasherRoutesShards(t *testing.T) {
+	// Route every key to the shard named by its first byte.
+	hash := func(key string) uint64 { return uint64(key[0] - '0') }
+	cache := NewMemoryCache(WithShards(4), WithHasher(hash))
+	sharded := cache.storage.(*shardedBackend)
+
+	for _, key := range []string{"1a", "1b", "1c", "3a"} {
+		cache.Set(key, key, time.Minute)
+	}
+	if n := len(sharded.shards[1].entries); n != 3 {
+		t.Errorf("shard 1 holds %d entries, want 3", n)
+	}
+	if n := len(sharded.shards[3].entries); n != 1 {
+		t.Errorf("shard 3 holds %d entries, want 1", n)
+	}
+	for _, key := range []string{"1a", "1b", "1c", "3a"} {
+		if value, ok := cache.Get(key); !ok || value != key {
+			t.Errorf("Get(%q) = (%v, %v), want (%q, true)", key, value, ok, key)
+		}
+	}
+}
+
+func TestShardedStoreOperations(t *testing.T) {
+	cache := NewMemoryCache(WithShards(8))
+	cache.Set("key", "value", time.Minute)
+	if actual, loaded := cache.GetOrSet("key", "other", time.Minute); !loaded || actual != "value" {
+		t.Fatalf("GetOrSet got (%v, %v), want (value, true)", actual, loaded)
+	}
+	if !cache.Refresh("key", time.Hour) {
+		t.Fatal("Refresh did not find the key")
+	}
+	if value, loaded := cache.Expire("key"); !loaded || value != "value" {
+		t.Fatalf("Expire got (%v, %v), want (value, true)", value, loaded)
+	}
+
+	for i := 0; i < 100; i++ {
+		cache.Set(strings.Repeat("k", i+1), i, time.Minute)
+	}
+	cache.ExpireAll()
+	count := 0
+	cache.storage.Range(func(string, *entry) bool {
+		count++
+		return true
+	})
+	if count != 0 {
+		t.Fatalf("%d entries left after ExpireAll", count)
+	}
+}
+
+func TestShardedRangeHoldsNoLocks(t *testing.T) {
+	cache := NewMemoryCache(WithShards(4))
+	for i := 0; i < 8; i++ {
+		cache.Set(fmt.Sprint(i), i, time.Hour)
+	}
+	replaced := make(chan struct{})
+	first := true
+	cache.storage.Range(func(string, *entry) bool {
+		if first {
+			first = false
+			go func() {
+				cache.ReplaceAll(map[string]WarmEntry{"new": {Value: 1, TTL: time.Hour}})
+				close(replaced)
+			}()
+			// A Replace waiting on Range mustn't block Range called
+			// from f, nor may Range hold up the Replace.
+			<-replaced
+			if keys := cache.Keys(); len(keys) != 1 || keys[0] != "new" {
+				t.Errorf("Keys from within Range = %v, want [new]", keys)
+			}
+		}
+		return true
+	})
+}
+
+func TestFNV1aIsDeterministic(t *testing.T) {
+	// Known FNV-1a 64 vectors.
+	if h := fnv1a(""); h != 0xcbf29ce484222325 {
+		t.Errorf("fnv1a(\"\") = %#x", h)
+	}
+	if h := fnv1a("a"); h != 0xaf63dc4c8601ec8c {
+		t.Errorf("fnv1a(\"a\") = %#x", h)
+	}
+}
+
+// BenchmarkBackends compares the default sync.Map backend with a
+// sharded one, for picking between them: go test -bench Backends.
+func BenchmarkBackends(b *testing.B) {
+	keys := make([]string, 1<<16)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	for _, backend := range []struct {
+		name string
+		opts []Option
+	}{
+		{"syncmap", nil},
+		{"shards16", []Option{WithShards(16)}},
+		{"shards64", []Option{WithShards(64)}},
+	} {
+		for _, workload := range []struct {
+			name string
+			// writes in every 10 operations
+			writes int
+		}{
+			{"writes", 9},
+			{"mixed", 5},
+			{"reads", 1},
+		} {
+			b.Run(backend.name+"/"+workload.name, func(b *testing.B) {
+				cache := NewMemoryCache(backend.opts...)
+				for _, key := range keys {
+					cache.Set(key, key, time.Hour)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					r := rand.New(rand.NewPCG(rand.Uint64(), 0))
+					for i := 0; pb.Next(); i++ {
+						key := keys[r.IntN(len(keys))]
+						if i%10 < workload.writes {
+							cache.Set(key, key, time.Hour)
+						} else {
+							cache.Get(key)
+						}
+					}
+				})
+			})
+		}
+	}
+}
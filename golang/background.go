@@ -0,0 +1,101 @@
+package enigmacache
+
+import "context"
+
+// WithMaxBackgroundGoroutines caps the goroutines the cache starts for
+// work which can pile up without bound at n between them: loaders run
+// under WithCallbackTimeout, write-through writes SetContext leaves to
+// finish, and write-behind flushes triggered by a full batch. Once n
+// are running, a loader waits for one to finish, giving up with
+// ErrCallbackTimeout if its timeout passes first; SetContext waits
+// until its ctx is done; and a full batch is left for the next timed
+// flush. Eviction workers and the expiry backlog are bounded by their
+// own options, so they aren't counted against n, nor is expiration,
+// whose one timer only takes a goroutine briefly as it fires. An n of
+// zero or less, the default, leaves background work uncapped.
+func WithMaxBackgroundGoroutines(n int) Option {
+	return func(o *options) {
+		o.maxBackground = n
+	}
+}
+
+// BackgroundStats counts the cache's background work, by kind; see
+// GoroutineStats.
+type BackgroundStats struct {
+	// Timers is the number of entries scheduled to expire.
+	Timers int
+	// Loaders is the number of goroutines running loaders (see
+	// WithCallbackTimeout).
+	Loaders int
+	// StoreWrites is the number of goroutines writing to the
+	// write-through store: writes SetContext left running, and
+	// write-behind flushes.
+	StoreWrites int
+	// CallbackWorkers is the number of goroutines running eviction
+	// callbacks (see WithEvictionWorkers).
+	CallbackWorkers int
+	// Expirers is the number of goroutines working off the expiry
+	// backlog (see WithLateExpiryPacing), at most one.
+	Expirers int
+	// Watchers is the number of keys, and sizes, on which callers of
+	// Done, GetWait and WaitForSize are waiting.
+	Watchers int
+}
+
+// GoroutineStats reports how much background work the cache has
+// going, to keep an eye on its concurrency footprint (see
+// WithMaxBackgroundGoroutines). The counts are read one after another,
+// so under churn they needn't all be from the same instant.
+func (mc *MemoryCache) GoroutineStats() BackgroundStats {
+	s := BackgroundStats{
+		Timers:      int(mc.timers.Load()),
+		Loaders:     int(mc.loaders.Load()),
+		StoreWrites: int(mc.storeWrites.Load()),
+		Watchers:    mc.watchers.gone.len() + mc.watchers.stored.len() + mc.sizes.len(),
+	}
+	mc.callbacks.mu.Lock()
+	s.CallbackWorkers = mc.callbacks.running
+	mc.callbacks.mu.Unlock()
+	mc.backlog.mu.Lock()
+	if mc.backlog.running {
+		s.Expirers = 1
+	}
+	mc.backlog.mu.Unlock()
+	return s
+}
+
+// acquireBackground takes a slot for a background goroutine (see
+// WithMaxBackgroundGoroutines), waiting until ctx is done for one to
+// come free, and reports whether it got one. Every slot taken must be
+// given back with releaseBackground.
+func (mc *MemoryCache) acquireBackground(ctx context.Context) bool {
+	if mc.background == nil {
+		return true
+	}
+	select {
+	case mc.background <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// tryAcquireBackground takes a slot for a background goroutine only if
+// one is free.
+func (mc *MemoryCache) tryAcquireBackground() bool {
+	if mc.background == nil {
+		return true
+	}
+	select {
+	case mc.background <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (mc *MemoryCache) releaseBackground() {
+	if mc.background != nil {
+		<-mc.background
+	}
+}
@@ -0,0 +1,140 @@
+package enigmacache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForStats polls the cache's GoroutineStats until ok accepts them.
+func waitForStats(t *testing.T, mc *MemoryCache, ok func(BackgroundStats) bool) BackgroundStats {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s := mc.GoroutineStats()
+		if ok(s) {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GoroutineStats = %+v", s)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// blockingStore is a Store whose writes wait for release to close.
+type blockingStore struct {
+	release chan struct{}
+}
+
+func (s *blockingStore) Get(context.Context, string) (any, bool, error) { return nil, false, nil }
+
+func (s *blockingStore) Set(context.Context, string, any, time.Duration) error {
+	<-s.release
+	return nil
+}
+
+func (s *blockingStore) Delete(context.Context, string) error { return nil }
+
+func TestGoroutineStatsCounts(t *testing.T) {
+	mc := NewMemoryCache(WithCallbackTimeout(time.Minute))
+	for i := range 3 {
+		mc.Set(fmt.Sprint(i), i, time.Hour)
+	}
+	mc.Done("0")
+
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mc.GetOrComputeDetailed(fmt.Sprint("load", i), time.Hour, func(context.Context) (any, error) {
+				<-release
+				return i, nil
+			})
+		}()
+	}
+	got := waitForStats(t, mc, func(s BackgroundStats) bool { return s.Loaders == 2 })
+	if want := (BackgroundStats{Timers: 3, Loaders: 2, Watchers: 1}); got != want {
+		t.Errorf("GoroutineStats = %+v, want %+v", got, want)
+	}
+	close(release)
+	wg.Wait()
+	waitForStats(t, mc, func(s BackgroundStats) bool { return s.Loaders == 0 && s.Timers == 5 })
+
+	store := &blockingStore{release: make(chan struct{})}
+	wt := NewMemoryCache(WithWriteThrough(store))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := wt.SetContext(ctx, "k", 2, time.Hour); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("SetContext = %v, want context.DeadlineExceeded", err)
+	}
+	waitForStats(t, wt, func(s BackgroundStats) bool { return s.StoreWrites == 1 })
+	close(store.release)
+	wt.Drain(context.Background())
+	if got := wt.GoroutineStats().StoreWrites; got != 0 {
+		t.Errorf("StoreWrites = %d after Drain, want 0", got)
+	}
+}
+
+func TestMaxBackgroundGoroutinesCapsLoaders(t *testing.T) {
+	const limit, burst = 2, 10
+	mc := NewMemoryCache(WithCallbackTimeout(time.Minute), WithMaxBackgroundGoroutines(limit))
+
+	release := make(chan struct{})
+	var running, peak atomic.Int64
+	var wg sync.WaitGroup
+	for i := range burst {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, _, err := mc.GetOrComputeDetailed(fmt.Sprint(i), time.Hour, func(context.Context) (any, error) {
+				n := running.Add(1)
+				for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+				}
+				<-release
+				running.Add(-1)
+				return i, nil
+			})
+			if err != nil || value != i {
+				t.Errorf("GetOrComputeDetailed(%d) = %v, %v", i, value, err)
+			}
+		}()
+	}
+	waitForStats(t, mc, func(s BackgroundStats) bool { return s.Loaders == limit })
+	// Give the rest of the burst a chance to exceed the cap.
+	time.Sleep(20 * time.Millisecond)
+	if got := mc.GoroutineStats().Loaders; got != limit {
+		t.Errorf("Loaders = %d, want %d", got, limit)
+	}
+	close(release)
+	wg.Wait()
+	if got := peak.Load(); got != limit {
+		t.Errorf("%d loaders ran at once, want %d", got, limit)
+	}
+	if mc.Len() != burst {
+		t.Errorf("Len = %d, want %d", mc.Len(), burst)
+	}
+}
+
+func TestMaxBackgroundGoroutinesLoaderTimesOutWaiting(t *testing.T) {
+	mc := NewMemoryCache(WithCallbackTimeout(20*time.Millisecond), WithMaxBackgroundGoroutines(1))
+	release := make(chan struct{})
+	defer close(release)
+	go mc.GetOrComputeDetailed("slow", time.Hour, func(context.Context) (any, error) {
+		<-release
+		return nil, nil
+	})
+	waitForStats(t, mc, func(s BackgroundStats) bool { return s.Loaders == 1 })
+	_, _, err := mc.GetOrComputeDetailed("other", time.Hour, func(context.Context) (any, error) {
+		return 1, nil
+	})
+	if !errors.Is(err, ErrCallbackTimeout) {
+		t.Fatalf("err = %v, want ErrCallbackTimeout", err)
+	}
+}
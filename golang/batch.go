@@ -0,0 +1,70 @@
+package enigmacache
+
+import (
+	"context"
+	"time"
+)
+
+// SetMany sets each key in entries to its value with the given ttl,
+// as Set does, returning how many were stored. Values Set would
+// ignore are skipped.
+func (mc *MemoryCache) SetMany(entries map[string]any, ttl time.Duration) int {
+	stored := 0
+	for key, value := range entries {
+		key = mc.normalize(key)
+		ttl, ok := mc.valueTTL(key, value, ttl)
+		if !ok || !mc.set(key, value, ttl, 0) {
+			continue
+		}
+		_ = mc.writeThrough(context.Background(), key, value, ttl)
+		stored++
+	}
+	return stored
+}
+
+// GetMany returns the values of those of the given keys which are
+// present, keyed as they were given. Each key counts as a hit or a
+// miss as it would for Get, but the eviction policy is told about
+// every key read under a single lock, rather than once per key.
+func (mc *MemoryCache) GetMany(keys []string) map[string]any {
+	values := make(map[string]any, len(keys))
+	var read []string
+	now := mc.now()
+	for _, key := range keys {
+		normalized := mc.normalize(key)
+		e, ok := mc.storage.Load(normalized)
+		if !ok || !e.live(now) {
+			mc.missed(normalized)
+			continue
+		}
+		value, ok := mc.read(normalized, e)
+		if !ok {
+			mc.missed(normalized)
+			continue
+		}
+		mc.hit(normalized, e)
+		values[key] = value
+		read = append(read, normalized)
+	}
+	if mc.policyReads && len(read) > 0 {
+		mc.policyMu.Lock()
+		for _, key := range read {
+			mc.policy.access(key)
+		}
+		mc.policyMu.Unlock()
+	}
+	return values
+}
+
+// ExpireMany removes each of the given keys from the cache, as Expire
+// does, returning the values of those which were present, keyed as
+// they were given.
+func (mc *MemoryCache) ExpireMany(keys []string) map[string]any {
+	values := make(map[string]any)
+	for _, key := range keys {
+		if value, _, ok := mc.Pop(key); ok {
+			values[key] = value
+		}
+	}
+	return values
+}
@@ -0,0 +1,50 @@
+package enigmacache
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestSetMany(t *testing.T) {
+	cache := NewMemoryCache(WithRejectNil(true))
+	n := cache.SetMany(map[string]any{"a": 1, "b": 2, "nil": nil}, time.Minute)
+	if n != 2 {
+		t.Errorf("SetMany stored %d, want 2", n)
+	}
+	if cache.Has("nil") {
+		t.Error("nil value was stored")
+	}
+	if value, ok := cache.Get("b"); !ok || value != 2 {
+		t.Errorf("Get(b) = %v, %v; want 2, true", value, ok)
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	cache := NewMemoryCache(WithMaxEntries(3))
+	cache.SetMany(map[string]any{"a": 1, "b": 2, "c": 3}, time.Minute)
+	got := cache.GetMany([]string{"a", "c", "missing"})
+	if want := map[string]any{"a": 1, "c": 3}; !maps.Equal(got, want) {
+		t.Errorf("GetMany = %v, want %v", got, want)
+	}
+	if stats := cache.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("stats = %+v, want 2 hits and 1 miss", stats)
+	}
+	// The reads count as uses, so b is evicted first.
+	cache.Set("d", 4, time.Minute)
+	if cache.Has("b") {
+		t.Error("key b, unread, survived")
+	}
+}
+
+func TestExpireMany(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.SetMany(map[string]any{"a": 1, "b": 2, "c": 3}, time.Minute)
+	got := cache.ExpireMany([]string{"a", "b", "missing"})
+	if want := map[string]any{"a": 1, "b": 2}; !maps.Equal(got, want) {
+		t.Errorf("ExpireMany = %v, want %v", got, want)
+	}
+	if keys := cache.Keys(); len(keys) != 1 || keys[0] != "c" {
+		t.Errorf("Keys = %v after ExpireMany, want [c]", keys)
+	}
+}
@@ -0,0 +1,116 @@
+package enigmacache
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+// benchKeys is the number of keys the workload benchmarks spread their
+// operations over.
+const benchKeys = 1 << 16
+
+var benchKeyNames = func() []string {
+	keys := make([]string, benchKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	return keys
+}()
+
+// A benchDist picks the keys for a benchmark's operations.
+type benchDist struct {
+	name string
+	new  func(r *rand.Rand) func() int
+}
+
+var benchDists = []benchDist{
+	{"uniform", func(r *rand.Rand) func() int {
+		return func() int { return r.IntN(benchKeys) }
+	}},
+	// A Zipfian distribution, as a skewed real-world workload has,
+	// with a few keys taking most of the operations.
+	{"zipf", func(r *rand.Rand) func() int {
+		z := rand.NewZipf(r, 1.1, 1, benchKeys-1)
+		return func() int { return int(z.Uint64()) }
+	}},
+}
+
+// BenchmarkWorkloads runs mixes of Gets and Sets over a filled cache,
+// for every combination of read share, key distribution and value
+// size: go test -bench Workloads. BenchmarkBackends compares backends.
+func BenchmarkWorkloads(b *testing.B) {
+	for _, workload := range []struct {
+		name string
+		// writes in every 10 operations
+		writes int
+	}{
+		{"reads", 1},
+		{"mixed", 5},
+		{"writes", 9},
+	} {
+		for _, dist := range benchDists {
+			for _, size := range []int{64, 1 << 10, 16 << 10} {
+				name := fmt.Sprintf("%s/%s/%dB", workload.name, dist.name, size)
+				b.Run(name, func(b *testing.B) {
+					value := make([]byte, size)
+					cache := NewMemoryCache()
+					for _, key := range benchKeyNames {
+						cache.Set(key, value, time.Hour)
+					}
+					b.ReportAllocs()
+					b.SetBytes(int64(size))
+					b.ResetTimer()
+					b.RunParallel(func(pb *testing.PB) {
+						r := rand.New(rand.NewPCG(rand.Uint64(), 0))
+						next := dist.new(r)
+						for i := 0; pb.Next(); i++ {
+							key := benchKeyNames[next()]
+							if i%10 < workload.writes {
+								cache.Set(key, value, time.Hour)
+							} else {
+								cache.Get(key)
+							}
+						}
+					})
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkHitRatio runs a read-through workload, a Get followed by a
+// Set on a miss, over a cache with room for a tenth of the keys, with
+// each eviction policy, reporting the share of Gets which hit as
+// hit%: go test -bench HitRatio.
+func BenchmarkHitRatio(b *testing.B) {
+	for _, policy := range []struct {
+		name string
+		opts []Option
+	}{
+		{"lru", nil},
+		{"lfu", []Option{WithEvictionPolicy(LFU)}},
+		{"approxlru", []Option{WithApproxLRU(5)}},
+		{"tinylfu", []Option{WithAdmissionPolicy(NewTinyLFU(benchKeys / 10))}},
+	} {
+		for _, dist := range benchDists {
+			b.Run(policy.name+"/"+dist.name, func(b *testing.B) {
+				cache := NewMemoryCache(append([]Option{WithMaxEntries(benchKeys / 10)}, policy.opts...)...)
+				r := rand.New(rand.NewPCG(1, 2))
+				next := dist.new(r)
+				b.ReportAllocs()
+				for b.Loop() {
+					key := benchKeyNames[next()]
+					if _, ok := cache.Get(key); !ok {
+						cache.Set(key, key, time.Hour)
+					}
+				}
+				stats := cache.Stats()
+				if total := stats.Hits + stats.Misses; total > 0 {
+					b.ReportMetric(100*float64(stats.Hits)/float64(total), "hit%")
+				}
+			})
+		}
+	}
+}
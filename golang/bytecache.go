@@ -0,0 +1,416 @@
+package enigmacache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// byteSlabSize is the most memory a ByteCache allocates at once;
+	// a cache whose WithMaxMemory limit would hold fewer than
+	// byteMinSlabs of them uses smaller slabs.
+	byteSlabSize = 1 << 20
+	byteMinSlabs = 8
+	// byteMinChunk is the smallest chunk a slab is divided into. Each
+	// size class has chunks twice the size of the one before.
+	byteMinChunk = 64
+	byteClasses  = 15 // 64 B to 1 MiB
+)
+
+// A ByteCache is a bounded LRU cache for []byte values, for caches of
+// many small values where per-value allocations and the garbage
+// collector's scanning of them would dominate. Keys and values are
+// copied into an arena of preallocated slabs and referred to by
+// offset, so the cache holds no pointers for the GC to chase however
+// many entries it has, and a slab's memory is reused as its entries
+// are evicted rather than freed.
+//
+// Each slab is divided into equal chunks of one size class, and an
+// entry takes the smallest chunk its key and value fit in together, so
+// up to half a chunk can go unused. Each class has its own LRU list:
+// when a class has no free chunk and the cache can't allocate another
+// slab, its least recently used entry is evicted, and if it has no
+// entries at all, the cache takes a slab from the class holding the
+// most, evicting whatever is in it.
+//
+// A ByteCache implements Store, so it can sit behind a MemoryCache
+// built WithWriteThrough, and is safe for concurrent use.
+type ByteCache struct {
+	clock      Clock
+	hasher     func(string) uint64
+	maxEntries int
+	maxBytes   int64
+	slabSize   int
+
+	mu sync.Mutex
+	// index maps each key's hash to the first slot in its chain.
+	index     map[uint64]int32
+	slots     []byteSlot
+	freeSlots []int32
+	slabs     []byteSlab
+	classes   [byteClasses]byteClass
+	entries   int
+	// tick orders uses of entries across classes, for WithMaxEntries.
+	tick uint64
+}
+
+// A byteSlot describes one entry. Slots are linked by index, not by
+// pointer, into their hash chain and their class's LRU list, with -1
+// ending a list.
+type byteSlot struct {
+	hash      uint64
+	expiresAt int64
+	used      uint64
+	chunk     chunkRef
+	keyLen    int32
+	valueLen  int32
+	class     int32
+	hashNext  int32
+	// newer and older link the class's LRU list.
+	newer, older int32
+}
+
+type chunkRef struct {
+	slab, index int32
+}
+
+type byteSlab struct {
+	buf []byte
+	// owners holds the slot stored in each chunk, or -1 if it's free.
+	owners []int32
+}
+
+type byteClass struct {
+	size  int
+	slabs []int32
+	free  []chunkRef
+	// newest and oldest are the ends of the class's LRU list.
+	newest, oldest int32
+	entries        int
+}
+
+// NewByteCache returns an empty ByteCache. It honors WithMaxEntries,
+// WithMaxMemory (which bounds the slabs it allocates, rather than
+// estimated costs), WithClock and WithHasher, and ignores other
+// options. Without WithMaxEntries or WithMaxMemory, it grows without
+// bound. An entry whose key and value together are larger than a
+// slab, which is 1 MiB, or an eighth of a WithMaxMemory limit under
+// 8 MiB, is rejected.
+func NewByteCache(opts ...Option) *ByteCache {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.hasher == nil {
+		o.hasher = fnv1a
+	}
+	if o.clock == nil {
+		o.clock = systemClock{}
+	}
+	bc := &ByteCache{
+		clock:      o.clock,
+		hasher:     o.hasher,
+		maxEntries: o.maxEntries,
+		maxBytes:   o.maxBytes,
+		slabSize:   byteSlabSize,
+		index:      make(map[uint64]int32),
+	}
+	for bc.maxBytes > 0 && bc.slabSize > byteMinChunk && int64(bc.slabSize)*byteMinSlabs > bc.maxBytes {
+		bc.slabSize >>= 1
+	}
+	for c := range bc.classes {
+		bc.classes[c] = byteClass{size: byteMinChunk << c, newest: -1, oldest: -1}
+	}
+	return bc
+}
+
+// Get returns a copy of the value stored for key, which the caller may
+// keep, since the cache reuses the memory it reads it from.
+func (bc *ByteCache) Get(ctx context.Context, key string) (value any, ok bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	s := bc.find(key)
+	if s < 0 {
+		return nil, false, nil
+	}
+	slot := &bc.slots[s]
+	if slot.expiresAt <= bc.clock.Now().UnixNano() {
+		bc.remove(s)
+		return nil, false, nil
+	}
+	bc.unlink(s)
+	bc.pushNewest(s)
+	chunk := bc.chunk(slot.chunk, slot.class)
+	return append([]byte(nil), chunk[slot.keyLen:slot.keyLen+slot.valueLen]...), true, nil
+}
+
+// Set copies value, which must be a []byte, into the cache for ttl.
+// It returns an error wrapping ErrTypeMismatch for any other type,
+// one wrapping ErrValueTooLarge if the value is too large for the
+// biggest slab class, and one wrapping ErrRejected if there's no
+// memory for it; either way any previous value for key is gone. A
+// ttl of zero or less deletes the key.
+func (bc *ByteCache) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("byte cache set %q: %T: %w", key, value, ErrTypeMismatch)
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if s := bc.find(key); s >= 0 {
+		bc.remove(s)
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	class, fits := bc.classFor(len(key) + len(b))
+	if !fits {
+		return fmt.Errorf("byte cache set %q: %d bytes: %w", key, len(b), ErrValueTooLarge)
+	}
+	ref, ok := bc.alloc(class)
+	if !ok {
+		return fmt.Errorf("byte cache set %q: out of memory: %w", key, ErrRejected)
+	}
+
+	var s int32
+	if n := len(bc.freeSlots); n > 0 {
+		s = bc.freeSlots[n-1]
+		bc.freeSlots = bc.freeSlots[:n-1]
+	} else {
+		s = int32(len(bc.slots))
+		bc.slots = append(bc.slots, byteSlot{})
+	}
+	h := bc.hasher(key)
+	next, chained := bc.index[h]
+	if !chained {
+		next = -1
+	}
+	expiresAt := int64(math.MaxInt64)
+	if ttl != NoExpiration {
+		expiresAt = bc.clock.Now().Add(ttl).UnixNano()
+	}
+	bc.slots[s] = byteSlot{
+		hash:      h,
+		expiresAt: expiresAt,
+		chunk:     ref,
+		keyLen:    int32(len(key)),
+		valueLen:  int32(len(b)),
+		class:     int32(class),
+		hashNext:  next,
+	}
+	bc.index[h] = s
+	chunk := bc.chunk(ref, int32(class))
+	copy(chunk, key)
+	copy(chunk[len(key):], b)
+	bc.slabs[ref.slab].owners[ref.index] = s
+	bc.pushNewest(s)
+	bc.classes[class].entries++
+	bc.entries++
+
+	for bc.maxEntries > 0 && bc.entries > bc.maxEntries {
+		bc.evictOldest()
+	}
+	return nil
+}
+
+// Delete removes key from the cache.
+func (bc *ByteCache) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if s := bc.find(key); s >= 0 {
+		bc.remove(s)
+	}
+	return nil
+}
+
+// Len returns the number of entries in the cache, including any which
+// have expired but haven't been looked up since.
+func (bc *ByteCache) Len() int {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.entries
+}
+
+// find returns key's slot, or -1 if it has none.
+func (bc *ByteCache) find(key string) int32 {
+	s, ok := bc.index[bc.hasher(key)]
+	if !ok {
+		return -1
+	}
+	for ; s >= 0; s = bc.slots[s].hashNext {
+		slot := &bc.slots[s]
+		if int(slot.keyLen) == len(key) && string(bc.chunk(slot.chunk, slot.class)[:slot.keyLen]) == key {
+			return s
+		}
+	}
+	return -1
+}
+
+func (bc *ByteCache) chunk(ref chunkRef, class int32) []byte {
+	size := bc.classes[class].size
+	start := int(ref.index) * size
+	return bc.slabs[ref.slab].buf[start : start+size]
+}
+
+// classFor returns the smallest size class holding n bytes.
+func (bc *ByteCache) classFor(n int) (int, bool) {
+	for c := range bc.classes {
+		if size := bc.classes[c].size; size > bc.slabSize {
+			break
+		} else if n <= size {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// alloc returns a free chunk of class, making one if need be by
+// allocating a slab, evicting the class's least recently used entry,
+// or taking another class's slab, in that order of preference.
+func (bc *ByteCache) alloc(class int) (chunkRef, bool) {
+	cl := &bc.classes[class]
+	if len(cl.free) == 0 {
+		switch {
+		case bc.maxBytes <= 0 || int64(len(bc.slabs)+1)*int64(bc.slabSize) <= bc.maxBytes:
+			bc.slabs = append(bc.slabs, byteSlab{buf: make([]byte, bc.slabSize)})
+			bc.assign(int32(len(bc.slabs)-1), class)
+		case cl.oldest >= 0:
+			bc.remove(cl.oldest)
+		default:
+			if !bc.steal(class) {
+				return chunkRef{}, false
+			}
+		}
+	}
+	n := len(cl.free)
+	ref := cl.free[n-1]
+	cl.free = cl.free[:n-1]
+	return ref, true
+}
+
+// assign divides slab into free chunks of class.
+func (bc *ByteCache) assign(slab int32, class int) {
+	cl := &bc.classes[class]
+	n := bc.slabSize / cl.size
+	owners := make([]int32, n)
+	for i := n - 1; i >= 0; i-- {
+		owners[i] = -1
+		cl.free = append(cl.free, chunkRef{slab: slab, index: int32(i)})
+	}
+	bc.slabs[slab].owners = owners
+	cl.slabs = append(cl.slabs, slab)
+}
+
+// steal moves the most recently assigned slab of the class with the
+// most slabs to class, evicting its entries, and reports whether there
+// was one to take.
+func (bc *ByteCache) steal(class int) bool {
+	donor := -1
+	for c := range bc.classes {
+		if c != class && len(bc.classes[c].slabs) > 0 && (donor < 0 || len(bc.classes[c].slabs) > len(bc.classes[donor].slabs)) {
+			donor = c
+		}
+	}
+	if donor < 0 {
+		return false
+	}
+	dc := &bc.classes[donor]
+	slab := dc.slabs[len(dc.slabs)-1]
+	dc.slabs = dc.slabs[:len(dc.slabs)-1]
+	for _, s := range bc.slabs[slab].owners {
+		if s >= 0 {
+			bc.remove(s)
+		}
+	}
+	free := dc.free[:0]
+	for _, ref := range dc.free {
+		if ref.slab != slab {
+			free = append(free, ref)
+		}
+	}
+	dc.free = free
+	bc.assign(slab, class)
+	return true
+}
+
+// evictOldest removes the least recently used entry in the cache.
+func (bc *ByteCache) evictOldest() {
+	victim := int32(-1)
+	for c := range bc.classes {
+		if s := bc.classes[c].oldest; s >= 0 && (victim < 0 || bc.slots[s].used < bc.slots[victim].used) {
+			victim = s
+		}
+	}
+	if victim >= 0 {
+		bc.remove(victim)
+	}
+}
+
+// remove drops slot s, freeing its chunk.
+func (bc *ByteCache) remove(s int32) {
+	slot := &bc.slots[s]
+	if head := bc.index[slot.hash]; head == s {
+		if slot.hashNext < 0 {
+			delete(bc.index, slot.hash)
+		} else {
+			bc.index[slot.hash] = slot.hashNext
+		}
+	} else {
+		for prev := head; ; prev = bc.slots[prev].hashNext {
+			if bc.slots[prev].hashNext == s {
+				bc.slots[prev].hashNext = slot.hashNext
+				break
+			}
+		}
+	}
+	bc.unlink(s)
+	cl := &bc.classes[slot.class]
+	cl.free = append(cl.free, slot.chunk)
+	cl.entries--
+	bc.slabs[slot.chunk.slab].owners[slot.chunk.index] = -1
+	bc.entries--
+	bc.freeSlots = append(bc.freeSlots, s)
+}
+
+// pushNewest makes s the most recently used entry in its class.
+func (bc *ByteCache) pushNewest(s int32) {
+	slot := &bc.slots[s]
+	cl := &bc.classes[slot.class]
+	bc.tick++
+	slot.used = bc.tick
+	slot.newer, slot.older = -1, cl.newest
+	if cl.newest >= 0 {
+		bc.slots[cl.newest].newer = s
+	} else {
+		cl.oldest = s
+	}
+	cl.newest = s
+}
+
+// unlink takes s out of its class's LRU list.
+func (bc *ByteCache) unlink(s int32) {
+	slot := &bc.slots[s]
+	cl := &bc.classes[slot.class]
+	if slot.newer >= 0 {
+		bc.slots[slot.newer].older = slot.older
+	} else {
+		cl.newest = slot.older
+	}
+	if slot.older >= 0 {
+		bc.slots[slot.older].newer = slot.newer
+	} else {
+		cl.oldest = slot.newer
+	}
+}
@@ -0,0 +1,173 @@
+package enigmacache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestByteCacheRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	bc := NewByteCache()
+	value := []byte("hello")
+	if err := bc.Set(ctx, "k", value, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	value[0] = 'j'
+
+	got, ok, err := bc.Get(ctx, "k")
+	if err != nil || !ok || !bytes.Equal(got.([]byte), []byte("hello")) {
+		t.Fatalf("Get = %q, %v, %v; want hello, true, nil", got, ok, err)
+	}
+	got.([]byte)[0] = 'y'
+	if again, _, _ := bc.Get(ctx, "k"); !bytes.Equal(again.([]byte), []byte("hello")) {
+		t.Errorf("Get after modifying a returned value = %q; want hello", again)
+	}
+
+	if err := bc.Set(ctx, "k", []byte("a much longer value than before"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := bc.Get(ctx, "k"); !bytes.Equal(got.([]byte), []byte("a much longer value than before")) {
+		t.Errorf("Get after overwrite = %q", got)
+	}
+	if err := bc.Delete(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := bc.Get(ctx, "k"); ok || bc.Len() != 0 {
+		t.Errorf("key present after Delete; Len() = %d", bc.Len())
+	}
+}
+
+func TestByteCacheRejects(t *testing.T) {
+	ctx := context.Background()
+	bc := NewByteCache(WithMaxMemory(64 << 10))
+	if err := bc.Set(ctx, "k", "not bytes", time.Minute); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Set(string) = %v; want ErrTypeMismatch", err)
+	}
+	if err := bc.Set(ctx, "k", make([]byte, 16<<10), time.Minute); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("Set of a value larger than a slab = %v; want ErrValueTooLarge", err)
+	}
+}
+
+func TestByteCacheExpiry(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Unix(0, 0))
+	bc := NewByteCache(WithClock(clock))
+	bc.Set(ctx, "k", []byte("v"), time.Minute)
+	clock.Advance(time.Minute)
+	if _, ok, _ := bc.Get(ctx, "k"); ok {
+		t.Error("expired key still present")
+	}
+	if bc.Len() != 0 {
+		t.Errorf("Len() = %d after reading an expired key; want 0", bc.Len())
+	}
+}
+
+func TestByteCacheMaxEntriesEvictsLRU(t *testing.T) {
+	ctx := context.Background()
+	bc := NewByteCache(WithMaxEntries(2))
+	bc.Set(ctx, "a", []byte("1"), time.Minute)
+	bc.Set(ctx, "b", make([]byte, 500), time.Minute)
+	bc.Get(ctx, "a")
+	bc.Set(ctx, "c", []byte("3"), time.Minute)
+
+	if _, ok, _ := bc.Get(ctx, "b"); ok {
+		t.Error("least recently used key b survived")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok, _ := bc.Get(ctx, key); !ok {
+			t.Errorf("key %s evicted", key)
+		}
+	}
+}
+
+func TestByteCacheMaxMemoryReusesSlabs(t *testing.T) {
+	ctx := context.Background()
+	const limit = 64 << 10
+	bc := NewByteCache(WithMaxMemory(limit))
+	// Fill the cache with small values, then switch to larger ones,
+	// which have to take over the small values' slabs.
+	for i := range 10000 {
+		if err := bc.Set(ctx, fmt.Sprint("small", i), make([]byte, 10), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range 1000 {
+		if err := bc.Set(ctx, fmt.Sprint("large", i), make([]byte, 1000), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(bc.slabs) * bc.slabSize; got > limit {
+		t.Errorf("cache allocated %d bytes of slabs; limit is %d", got, limit)
+	}
+	if _, ok, _ := bc.Get(ctx, "large999"); !ok {
+		t.Error("most recent large value missing")
+	}
+	if bc.Len() == 0 || bc.Len() > 1000 {
+		t.Errorf("Len() = %d", bc.Len())
+	}
+}
+
+func TestByteCacheAsWriteThroughStore(t *testing.T) {
+	bc := NewByteCache()
+	mc := NewMemoryCache(WithWriteThrough(bc))
+	if err := mc.SetContext(context.Background(), "k", []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, _ := bc.Get(context.Background(), "k"); !ok || !bytes.Equal(got.([]byte), []byte("v")) {
+		t.Errorf("store holds %q, %v; want v, true", got, ok)
+	}
+}
+
+// BenchmarkByteValues fills a cache with many small []byte values and
+// then measures a forced collection, comparing MemoryCache, which holds
+// each value on the heap, with ByteCache, which keeps them in its
+// arena. Both store a copy of the caller's buffer.
+func BenchmarkByteValues(b *testing.B) {
+	ctx := context.Background()
+	const n = 200000
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprint("key", i)
+	}
+	buf := make([]byte, 100)
+
+	run := func(b *testing.B, set func(key string)) {
+		// Leave behind no garbage from earlier runs for the measured
+		// collection to clear up.
+		runtime.GC()
+		for _, key := range keys {
+			set(key)
+		}
+		b.ReportAllocs()
+		i := 0
+		for b.Loop() {
+			set(keys[i%n])
+			i++
+		}
+		b.StopTimer()
+		start := time.Now()
+		runtime.GC()
+		b.ReportMetric(float64(time.Since(start).Nanoseconds()), "gc-ns")
+	}
+
+	b.Run("MemoryCache", func(b *testing.B) {
+		mc := NewMemoryCache()
+		run(b, func(key string) {
+			mc.Set(key, append([]byte(nil), buf...), time.Hour)
+		})
+		// Stop the entries' timers, which would keep them alive
+		// through the next run.
+		mc.ExpireAll()
+	})
+	b.Run("ByteCache", func(b *testing.B) {
+		bc := NewByteCache()
+		run(b, func(key string) {
+			bc.Set(ctx, key, buf, time.Hour)
+		})
+	})
+}
@@ -0,0 +1,269 @@
+package enigmacache
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// WithOnEvicted sets a function to be called whenever an entry leaves
+// the cache, with the key, the value it held, and why it left.
+// Overwriting a key doesn't count as its old value leaving. The
+// function is called synchronously from whichever goroutine removed
+// the entry, with no cache locks held, unless the cache is built
+// WithEvictionWorkers.
+//
+// The function is called once per entry, after the entry has left
+// storage. Nothing holds up writes to the key meanwhile, so by the
+// time it runs the key may have been set again and a Get may already
+// see the new value. Callbacks for one key may even run out of order,
+// when several goroutines (or eviction workers) remove its successive
+// entries. A callback which must not act on a key that has moved on
+// should use WithOnEvictedVersion instead.
+func WithOnEvicted(fn func(key string, value any, reason EvictionReason)) Option {
+	if fn == nil {
+		return WithOnEvictedVersion(nil)
+	}
+	return WithOnEvictedVersion(func(key string, value any, _ uint64, reason EvictionReason) {
+		fn(key, value, reason)
+	})
+}
+
+// WithOnEvictedVersion is WithOnEvicted, but the function is also
+// given the version (see GetWithVersion) of the entry which left.
+// The entry is gone by the time the function runs, so if
+// GetWithVersion finds the key at all, under whatever version, it has
+// been written since and the value passed in is superseded. The
+// version tells apart the successive values of a key, for a callback
+// which keeps its own record of them.
+func WithOnEvictedVersion(fn func(key string, value any, version uint64, reason EvictionReason)) Option {
+	return func(o *options) {
+		o.onEvicted = fn
+	}
+}
+
+// WithEvictionWorkers makes the cache run the WithOnEvicted callback
+// on a pool of at most n goroutines, rather than on whichever
+// goroutine removed the entry. Removals queue the callback and return
+// straight away, so a burst of expirations can't run more than n
+// callbacks at once, and a slow callback doesn't hold up the removal.
+// Callbacks still waiting in the queue count as in-flight work for
+// Drain. Workers are started as callbacks arrive and exit when the
+// queue is empty.
+func WithEvictionWorkers(n int) Option {
+	return func(o *options) {
+		o.evictionWorkers = n
+	}
+}
+
+// WithCallbackTimeout sets a watchdog on the functions the cache is
+// given (see WithPanicRecovery for the list): one which runs for
+// longer than d is logged as a slow callback and counted in
+// Stats().SlowCallbacks, though the cache still waits for it to
+// finish. Loaders are the exception: a caller waiting on a slow
+// loader gives up after d with an error wrapping ErrCallbackTimeout,
+// and the loader carries on in the background, storing its value when
+// it's done. Zero, the default, disables the watchdog.
+func WithCallbackTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.callbackTimeout = d
+	}
+}
+
+// WithLogger sets the logger the cache reports problems to, such as
+// panics recovered from callbacks. The default is slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithPanicRecovery controls whether the cache recovers panics in the
+// functions it's given: WithOnEvicted, the admission filter, the cost
+// function, the fill threshold callback, the key normalizer, the TTL
+// function, the WithGetClone clone, loaders, and the Release method of
+// Releasable values. Recovery is on by default; a recovered panic is
+// logged, counted in Stats().CallbackPanics, and otherwise ignored,
+// except that a panicking loader fails its load with an error
+// wrapping ErrCallbackPanicked. Pass false to let panics propagate
+// instead.
+func WithPanicRecovery(enabled bool) Option {
+	return func(o *options) {
+		o.failFast = !enabled
+	}
+}
+
+func (mc *MemoryCache) logger() *slog.Logger {
+	if mc.opts.logger != nil {
+		return mc.opts.logger
+	}
+	return slog.Default()
+}
+
+// protect calls fn, the user-supplied callback named by what. If fn
+// panics and the cache recovers panics, protect logs and counts it,
+// and returns an error describing it. If fn is slow (see
+// WithCallbackTimeout), protect logs and counts that too.
+func (mc *MemoryCache) protect(what string, fn func()) error {
+	if d := mc.opts.callbackTimeout; d > 0 {
+		watchdog := mc.opts.clock.AfterFunc(d, func() { mc.slowCallback(what, d) })
+		defer watchdog.Stop()
+	}
+	return mc.recovering(what, fn)
+}
+
+// recovering is protect without the watchdog, for loaders, which
+// callLoader times itself.
+func (mc *MemoryCache) recovering(what string, fn func()) (err error) {
+	if mc.opts.failFast {
+		fn()
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			mc.stats.callbackPanics.Add(1)
+			mc.logger().Error("enigma-cache: recovered panic in callback", "callback", what, "panic", r)
+			err = fmt.Errorf("%w: %s: %v", ErrCallbackPanicked, what, r)
+		}
+	}()
+	fn()
+	return nil
+}
+
+// slowCallback logs and counts the callback named by what as having
+// run for longer than the callback timeout d.
+func (mc *MemoryCache) slowCallback(what string, d time.Duration) {
+	mc.stats.slowCallbacks.Add(1)
+	mc.logger().Warn("enigma-cache: slow callback", "callback", what, "timeout", d)
+}
+
+// evicted calls the OnEvicted callback, if there is one and notify is
+// set, then releases the value if it's Releasable and calls the
+// entry's cancel func, if it has one. With WithEvictionWorkers, all
+// of them are queued for the worker pool.
+func (mc *MemoryCache) evicted(key string, e *entry, reason EvictionReason, notify bool) {
+	fn := mc.opts.onEvicted
+	if !notify {
+		fn = nil
+	}
+	value := e.get()
+	r, releasable := value.(Releasable)
+	if fn == nil && !releasable && e.done == nil {
+		return
+	}
+	call := func() {
+		if fn != nil {
+			mc.protect("OnEvicted", func() {
+				fn(key, value, e.gen, reason)
+			})
+		}
+		if releasable {
+			mc.protect("Release", r.Release)
+		}
+		mc.finished(e)
+	}
+	if mc.opts.evictionWorkers > 0 {
+		mc.callbacks.submit(&mc.tasks, call)
+		return
+	}
+	call()
+}
+
+// replaced releases the value of e, which has just been overwritten,
+// if it's Releasable.
+func (mc *MemoryCache) replaced(e *entry) {
+	if r, ok := e.get().(Releasable); ok {
+		mc.protect("Release", r.Release)
+	}
+	mc.finished(e)
+}
+
+// finished calls e's cancel func, if it was stored with one, now that
+// it has left the cache.
+func (mc *MemoryCache) finished(e *entry) {
+	if e.done != nil {
+		mc.protect("cancel", e.done)
+	}
+}
+
+// SetWithCancel sets key like Set, tying cancel to the entry: the
+// cache calls it exactly once, when the entry leaves the cache for
+// any of the reasons a Releasable value is released, such as for the
+// handle of a long-running operation which should stop once it's no
+// longer cached. Refreshing the entry's TTL keeps cancel with it. If
+// the value is turned away, cancel is called straight away, since
+// nothing else would call it.
+func (mc *MemoryCache) SetWithCancel(key string, value any, cancel context.CancelFunc, ttl time.Duration) {
+	key = mc.normalize(key)
+	ttl, ok := mc.valueTTL(key, value, ttl)
+	if !ok || mc.rejects(value) {
+		mc.protect("cancel", cancel)
+		return
+	}
+	e := mc.newEntry(key, value, ttl, 0)
+	e.done = cancel
+	prev, ok := mc.putEntry(key, e)
+	if !ok {
+		mc.protect("cancel", cancel)
+		return
+	}
+	if prev != nil {
+		mc.replaced(prev)
+	}
+	_ = mc.writeThrough(context.Background(), key, value, ttl)
+}
+
+// A Releasable value holds a resource, such as a pooled buffer, which
+// must be given back once the cache is done with it. The cache calls
+// Release exactly once each time it stores a Releasable value, when
+// the entry leaves the cache for any reason: expiry, removal,
+// eviction, or being overwritten. Refreshing an entry's TTL doesn't
+// count as it leaving. A value the cache turns away is never stored,
+// so it isn't released either.
+type Releasable interface {
+	Release()
+}
+
+// A callbackPool runs queued callbacks on at most n goroutines at a
+// time. The zero callbackPool is unusable; n must be set.
+type callbackPool struct {
+	n       int
+	mu      sync.Mutex
+	running int
+	queue   []func()
+}
+
+// submit queues fn to run on the pool, counting it as a task until
+// it has run.
+func (p *callbackPool) submit(tasks *taskTracker, fn func()) {
+	tasks.start()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queue = append(p.queue, func() {
+		defer tasks.done()
+		fn()
+	})
+	if p.running < p.n {
+		p.running++
+		go p.work()
+	}
+}
+
+// work runs queued callbacks until the queue is empty.
+func (p *callbackPool) work() {
+	for {
+		p.mu.Lock()
+		if len(p.queue) == 0 {
+			p.running--
+			p.mu.Unlock()
+			return
+		}
+		fn := p.queue[0]
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+		p.mu.Unlock()
+		fn()
+	}
+}
@@ -0,0 +1,358 @@
+package enigmacache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var quietLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestOnEvictedReasons(t *testing.T) {
+	var mu sync.Mutex
+	reasons := make(map[string]EvictionReason)
+	cache, clock := NewTestCache(
+		WithMaxEntries(3),
+		WithOnEvicted(func(key string, value any, reason EvictionReason) {
+			mu.Lock()
+			defer mu.Unlock()
+			if value != key {
+				t.Errorf("callback for %q got value %v", key, value)
+			}
+			reasons[key] = reason
+		}),
+	)
+
+	cache.Set("expired", "expired", 10*time.Millisecond)
+	clock.Advance(30 * time.Millisecond)
+	cache.Set("manual", "manual", time.Minute)
+	cache.Expire("manual")
+	cache.Set("capacity", "capacity", time.Minute)
+	cache.Set("b", "b", time.Minute)
+	cache.Set("c", "c", time.Minute)
+	cache.Set("d", "d", time.Minute)
+	cache.Set("b", "b", time.Minute) // overwrites don't count
+	cache.ExpireAll()
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]EvictionReason{
+		"expired":  ReasonExpired,
+		"manual":   ReasonManual,
+		"capacity": ReasonCapacity,
+		"b":        ReasonCleared,
+		"c":        ReasonCleared,
+		"d":        ReasonCleared,
+	}
+	for key, reason := range want {
+		if got, ok := reasons[key]; !ok || got != reason {
+			t.Errorf("%q: reason %v (seen %v), want %v", key, got, ok, reason)
+		}
+	}
+	if len(reasons) != len(want) {
+		t.Errorf("callback fired for %v, want %v", reasons, want)
+	}
+}
+
+func TestPanickingOnEvictedIsRecovered(t *testing.T) {
+	cache, clock := NewTestCache(
+		WithLogger(quietLogger),
+		WithOnEvicted(func(string, any, EvictionReason) { panic("boom") }),
+	)
+
+	cache.Set("a", 1, 10*time.Millisecond)
+	cache.Set("b", 2, 20*time.Millisecond)
+	clock.Advance(50 * time.Millisecond)
+	if _, ok := cache.Get("b"); ok {
+		t.Fatal("expiration stopped working after a callback panicked")
+	}
+	if n := cache.Stats().CallbackPanics; n != 2 {
+		t.Fatalf("CallbackPanics = %d, want 2", n)
+	}
+
+	cache.Set("c", 3, time.Minute)
+	if value, ok := cache.Expire("c"); !ok || value != 3 {
+		t.Fatalf("Expire got (%v, %v) after a panic, want (3, true)", value, ok)
+	}
+}
+
+func TestPanickingLoaderReturnsError(t *testing.T) {
+	cache := NewMemoryCache(WithLogger(quietLogger))
+	_, _, err := cache.GetOrComputeDetailed("key", time.Minute, func(context.Context) (any, error) {
+		panic("boom")
+	})
+	if !errors.Is(err, ErrCallbackPanicked) {
+		t.Fatalf("got %v, want ErrCallbackPanicked", err)
+	}
+}
+
+func TestPanickingKeyFunctionsAreRecovered(t *testing.T) {
+	cache := NewMemoryCache(
+		WithLogger(quietLogger),
+		WithKeyNormalizer(func(key string) string {
+			if key == "bad" {
+				panic("boom")
+			}
+			return key
+		}),
+		WithTTLFunc(func(string, any) time.Duration { panic("boom") }),
+		WithGetClone(func(value any) any {
+			if value == "fragile" {
+				panic("boom")
+			}
+			return value
+		}),
+	)
+	cache.Set("bad", 1, time.Minute)
+	if value, ok := cache.Get("bad"); !ok || value != 1 {
+		t.Errorf("Get(bad) = %v, %v; want the raw key to be used", value, ok)
+	}
+	cache.Set("auto", 2, ValueTTL)
+	if cache.Has("auto") {
+		t.Error("a value whose TTL function panicked was stored")
+	}
+	cache.Set("k", "fragile", time.Minute)
+	if value, _ := cache.Get("k"); value != "fragile" {
+		t.Errorf("Get(k) = %v with a panicking clone, want the stored value", value)
+	}
+	if n := cache.Stats().CallbackPanics; n < 4 {
+		t.Errorf("CallbackPanics = %d, want at least one for each panic", n)
+	}
+}
+
+func TestWithPanicRecoveryDisabled(t *testing.T) {
+	cache := NewMemoryCache(
+		WithPanicRecovery(false),
+		WithOnEvicted(func(string, any, EvictionReason) { panic("boom") }),
+	)
+	cache.Set("key", "value", time.Minute)
+
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Fatalf("recovered %v, want the callback's panic", r)
+		}
+	}()
+	cache.Expire("key")
+	t.Fatal("Expire did not panic")
+}
+
+func TestEvictionWorkersBoundConcurrency(t *testing.T) {
+	const workers = 3
+	var (
+		mu            sync.Mutex
+		running, peak int
+		calls         int
+	)
+	cache, clock := NewTestCache(
+		WithEvictionWorkers(workers),
+		WithOnEvicted(func(string, any, EvictionReason) {
+			mu.Lock()
+			running++
+			peak = max(peak, running)
+			calls++
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}),
+	)
+	for i := range 30 {
+		cache.Set(fmt.Sprint(i), i, 10*time.Millisecond)
+	}
+
+	clock.Advance(30 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := cache.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 30 {
+		t.Fatalf("callback ran %d times, want 30", calls)
+	}
+	if peak > workers {
+		t.Fatalf("%d callbacks ran at once, want at most %d", peak, workers)
+	}
+}
+
+type resource struct {
+	released atomic.Int32
+}
+
+func (r *resource) Release() { r.released.Add(1) }
+
+func TestReleasableReleasedOnce(t *testing.T) {
+	cache, clock := NewTestCache(WithMaxEntries(2))
+	var all []*resource
+	set := func(key string, ttl time.Duration) {
+		r := &resource{}
+		all = append(all, r)
+		cache.Set(key, r, ttl)
+	}
+
+	set("overwritten", time.Minute)
+	set("overwritten", time.Minute)
+	set("expired", 10*time.Millisecond)
+	cache.Refresh("expired", 20*time.Millisecond) // not a removal
+	clock.Advance(50 * time.Millisecond)
+	set("evicted", time.Minute)
+	set("manual", time.Minute)
+	cache.Expire("manual")
+	set("cleared", time.Minute)
+	cache.ExpireAll()
+
+	for i, r := range all {
+		if n := r.released.Load(); n != 1 {
+			t.Errorf("value %d released %d times, want 1", i, n)
+		}
+	}
+}
+
+func TestSetWithCancelCancelsOnce(t *testing.T) {
+	cache, clock := NewTestCache()
+	var counts []*atomic.Int32
+	set := func(key string, ttl time.Duration) {
+		n := &atomic.Int32{}
+		counts = append(counts, n)
+		cache.SetWithCancel(key, "handle", func() { n.Add(1) }, ttl)
+	}
+
+	set("overwritten", time.Minute)
+	set("overwritten", time.Minute)
+	set("expired", 10*time.Millisecond)
+	cache.Refresh("expired", 20*time.Millisecond) // not a removal
+	set("manual", time.Minute)
+	cache.Expire("manual")
+	cache.Expire("manual")
+	clock.Advance(50 * time.Millisecond)
+
+	want := []int32{1, 0, 1, 1}
+	for i, n := range counts {
+		if got := n.Load(); got != want[i] {
+			t.Errorf("cancel %d called %d times, want %d", i, got, want[i])
+		}
+	}
+}
+
+func TestOnEvictedVersionFlagsSupersededValues(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen = make(map[uint64]bool)
+	)
+	var cache *MemoryCache
+	cache = NewMemoryCache(WithOnEvictedVersion(func(key string, value any, version uint64, _ EvictionReason) {
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[version] {
+			t.Errorf("version %d of %q evicted twice", version, key)
+		}
+		seen[version] = true
+		if _, current, ok := cache.GetWithVersion(key); ok && current == version {
+			t.Errorf("evicted version %d of %q while version %d is stored", version, key, current)
+		}
+	}))
+
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 500 {
+				cache.Set("key", w*1000+i, time.Duration(i%3)*time.Microsecond+time.Microsecond)
+				if i%2 == 0 {
+					cache.Expire("key")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	cache.Expire("key")
+	if err := cache.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) == 0 {
+		t.Fatal("no evictions seen")
+	}
+}
+
+func TestCallbacksMayReenterCache(t *testing.T) {
+	var cache *MemoryCache
+	cache = NewMemoryCache(
+		WithMaxEntries(2),
+		WithOnEvicted(func(key string, value any, _ EvictionReason) {
+			if strings.HasPrefix(key, "again/") {
+				return
+			}
+			cache.Set("again/"+key, value, time.Minute)
+			cache.Pin("again/" + key)
+			cache.Unpin("again/" + key)
+			cache.Expire("other")
+			cache.Len()
+		}),
+		WithAdmissionFilter(func(key string, _ int64) bool {
+			_, ok := cache.Get(key)
+			return !ok
+		}),
+	)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, key := range []string{"a", "b", "c", "d"} {
+			cache.Set(key, key, time.Minute)
+		}
+		cache.Expire("d")
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reentrant callbacks deadlocked")
+	}
+	if _, ok := cache.Get("again/d"); !ok {
+		t.Fatal("Set from OnEvicted didn't take effect")
+	}
+}
+
+func TestExpireAllWithOptions(t *testing.T) {
+	for _, fire := range []bool{true, false} {
+		var evicted int
+		cache, clock := NewTestCache(
+			WithMaxEntries(10),
+			WithOnEvicted(func(string, any, EvictionReason) { evicted++ }),
+		)
+		for i := range 5 {
+			cache.Set(fmt.Sprint(i), i, time.Minute)
+		}
+		cache.ExpireAllWithOptions(fire)
+
+		want := 0
+		if fire {
+			want = 5
+		}
+		if evicted != want {
+			t.Errorf("fireCallbacks %v: OnEvicted called %d times, want %d", fire, evicted, want)
+		}
+		stats, debug := cache.Stats(), cache.DebugStats()
+		if stats.Entries != 0 || stats.Bytes != 0 || debug.Timers != 0 || cache.policy.len() != 0 {
+			t.Errorf("fireCallbacks %v: left %d entries, %d bytes, %d timers and %d policy keys",
+				fire, stats.Entries, stats.Bytes, debug.Timers, cache.policy.len())
+		}
+		clock.Advance(time.Hour)
+		if evicted != want {
+			t.Errorf("fireCallbacks %v: timers fired OnEvicted after ExpireAll", fire)
+		}
+	}
+}
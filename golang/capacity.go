@@ -0,0 +1,354 @@
+package enigmacache
+
+import (
+	"container/list"
+	"context"
+	"reflect"
+	"time"
+)
+
+// An evictionPolicy tracks the keys in a bounded cache and chooses
+// which to evict when it's over capacity. Policies needn't be safe for
+// concurrent use; the cache serializes calls with its policyMu.
+type evictionPolicy interface {
+	// add records that key was inserted.
+	add(key string)
+	// access records that key was read or overwritten.
+	access(key string)
+	// remove forgets key.
+	remove(key string)
+	// victim returns the key which should be evicted next, if any.
+	victim() (key string, ok bool)
+	// len returns the number of keys tracked.
+	len() int
+	// keys returns the keys tracked, in the order they'd be evicted.
+	keys() []string
+}
+
+// lruPolicy evicts the least recently used key.
+type lruPolicy struct {
+	// order holds keys, most recently used at the front.
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+func newLRUPolicy() *lruPolicy {
+	return &lruPolicy{
+		order:    list.New(),
+		elements: make(map[string]*list.Element),
+	}
+}
+
+func (p *lruPolicy) add(key string) {
+	if el, ok := p.elements[key]; ok {
+		p.order.MoveToFront(el)
+		return
+	}
+	p.elements[key] = p.order.PushFront(key)
+}
+
+func (p *lruPolicy) access(key string) {
+	if el, ok := p.elements[key]; ok {
+		p.order.MoveToFront(el)
+	}
+}
+
+func (p *lruPolicy) remove(key string) {
+	if el, ok := p.elements[key]; ok {
+		p.order.Remove(el)
+		delete(p.elements, key)
+	}
+}
+
+func (p *lruPolicy) keys() []string {
+	keys := make([]string, 0, p.order.Len())
+	for el := p.order.Back(); el != nil; el = el.Prev() {
+		keys = append(keys, el.Value.(string))
+	}
+	return keys
+}
+
+func (p *lruPolicy) len() int {
+	return p.order.Len()
+}
+
+func (p *lruPolicy) victim() (string, bool) {
+	el := p.order.Back()
+	if el == nil {
+		return "", false
+	}
+	return el.Value.(string), true
+}
+
+// An EvictionPolicy chooses which entries a bounded cache evicts.
+type EvictionPolicy int
+
+const (
+	// LRU evicts the least recently used entry: the one which has
+	// gone longest without being read or written. It's the default.
+	LRU EvictionPolicy = iota
+	// FIFO evicts the entry which was inserted first. Reads don't
+	// affect the order, and neither does overwriting a key, which
+	// keeps its place; a key which leaves the cache and is set again
+	// joins the back of the queue.
+	FIFO
+	// LFU evicts the least frequently used entry: the one read or
+	// written the fewest times since it was inserted, its insertion
+	// included, with ties going to the least recently used. The
+	// key inserted last is never the one evicted to make room for
+	// itself. A key which leaves the cache starts counting afresh
+	// when it's set again.
+	LFU
+)
+
+// WithEvictionPolicy sets how a bounded cache (see WithMaxEntries and
+// WithMaxMemory) chooses entries to evict. WithApproxLRU and
+// WithSegmentedLRU only apply to LRU.
+func WithEvictionPolicy(p EvictionPolicy) Option {
+	return func(o *options) {
+		o.evictionPolicy = p
+	}
+}
+
+// fifoPolicy evicts the earliest inserted key.
+type fifoPolicy struct {
+	// order holds keys, earliest inserted at the front.
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+func newFIFOPolicy() *fifoPolicy {
+	return &fifoPolicy{
+		order:    list.New(),
+		elements: make(map[string]*list.Element),
+	}
+}
+
+func (p *fifoPolicy) add(key string) {
+	if _, ok := p.elements[key]; !ok {
+		p.elements[key] = p.order.PushBack(key)
+	}
+}
+
+func (p *fifoPolicy) access(string) {}
+
+func (p *fifoPolicy) remove(key string) {
+	if el, ok := p.elements[key]; ok {
+		p.order.Remove(el)
+		delete(p.elements, key)
+	}
+}
+
+func (p *fifoPolicy) keys() []string {
+	keys := make([]string, 0, p.order.Len())
+	for el := p.order.Front(); el != nil; el = el.Next() {
+		keys = append(keys, el.Value.(string))
+	}
+	return keys
+}
+
+func (p *fifoPolicy) len() int {
+	return p.order.Len()
+}
+
+func (p *fifoPolicy) victim() (string, bool) {
+	el := p.order.Front()
+	if el == nil {
+		return "", false
+	}
+	return el.Value.(string), true
+}
+
+// bounded reports whether the cache has an entry or byte limit.
+func (mc *MemoryCache) bounded() bool {
+	return mc.opts.maxEntries > 0 || mc.opts.maxBytes > 0
+}
+
+// full reports whether inserting a new entry costing cost would take
+// the cache over either of its limits.
+func (mc *MemoryCache) full(cost int64) bool {
+	if mc.opts.maxEntries > 0 && mc.count.Load() >= int64(mc.opts.maxEntries) {
+		return true
+	}
+	return mc.opts.maxBytes > 0 && mc.bytes.Load()+cost > mc.opts.maxBytes
+}
+
+// over reports whether the cache is over either of its limits.
+func (mc *MemoryCache) over() bool {
+	if mc.opts.maxEntries > 0 && mc.count.Load() > int64(mc.opts.maxEntries) {
+		return true
+	}
+	return mc.opts.maxBytes > 0 && mc.bytes.Load() > mc.opts.maxBytes
+}
+
+// fits reports whether e is small enough to store at all: an entry
+// costing more than the whole byte budget is refused, and counted as
+// rejected, rather than being allowed to evict everything else.
+func (mc *MemoryCache) fits(e *entry) bool {
+	if mc.opts.maxBytes > 0 && e.cost > mc.opts.maxBytes {
+		mc.stats.rejected.Add(1)
+		return false
+	}
+	return true
+}
+
+// admit reports whether a new key whose entry costs cost may be
+// inserted. It always may unless the cache is full and its admission
+// filter or policy turns the key away.
+func (mc *MemoryCache) admit(key string, cost int64) bool {
+	mc.record(key)
+	if !mc.full(cost) {
+		return true
+	}
+	mc.policyMu.Lock()
+	evictable := mc.policy.len() > 0
+	mc.policyMu.Unlock()
+	if !evictable {
+		// Everything left is pinned, so there's no room to make.
+		mc.stats.rejected.Add(1)
+		return false
+	}
+	// A filter which panics admits the key.
+	admitted := true
+	if mc.opts.admit != nil {
+		mc.protect("admission filter", func() {
+			admitted = mc.opts.admit(key, cost)
+		})
+	}
+	if admitted && mc.admitOver(key) {
+		return true
+	}
+	mc.stats.rejected.Add(1)
+	return false
+}
+
+// TrySet sets key like Set, reporting whether it did, but only if
+// room can be made for the value by evicting entries. Where Set on a
+// cache whose byte budget is mostly pinned (see Pin) would evict
+// every entry it could and still end up over budget, TrySet stores
+// nothing, evicts nothing and returns false. It also returns false
+// whenever Set would ignore the value.
+func (mc *MemoryCache) TrySet(key string, value any, ttl time.Duration) bool {
+	key = mc.normalize(key)
+	ttl, ok := mc.valueTTL(key, value, ttl)
+	if !ok || mc.rejects(value) {
+		return false
+	}
+	e := mc.newEntry(key, value, ttl, 0)
+	if !mc.roomFor(key, e.cost) {
+		mc.stats.rejected.Add(1)
+		return false
+	}
+	prev, ok := mc.putEntry(key, e)
+	if !ok {
+		return false
+	}
+	if prev != nil {
+		mc.replaced(prev)
+	}
+	_ = mc.writeThrough(context.Background(), key, value, ttl)
+	return true
+}
+
+// roomFor reports whether evicting every unpinned entry would make
+// room for key to hold an entry costing cost.
+func (mc *MemoryCache) roomFor(key string, cost int64) bool {
+	if !mc.full(cost) {
+		return true
+	}
+	mc.policyMu.Lock()
+	defer mc.policyMu.Unlock()
+	_, exists := mc.storage.Load(key)
+	if !exists && mc.opts.maxEntries > 0 && mc.count.Load()-int64(mc.policy.len()) >= int64(mc.opts.maxEntries) {
+		return false
+	}
+	if mc.opts.maxBytes <= 0 {
+		return true
+	}
+	pinned := cost
+	for k := range mc.pinned {
+		if e, ok := mc.storage.Load(k); ok && k != key {
+			pinned += e.cost
+		}
+	}
+	return pinned <= mc.opts.maxBytes
+}
+
+// evict removes entries, as chosen by the policy, until the cache is
+// back within both of its limits.
+func (mc *MemoryCache) evict() {
+	for mc.over() {
+		mc.policyMu.Lock()
+		key, ok := mc.policy.victim()
+		if _, pinned := mc.pinned[key]; ok && pinned {
+			// A pinned key should never be in the policy, but if one
+			// slips in, take it out rather than evict it.
+			mc.policy.remove(key)
+			mc.policyMu.Unlock()
+			continue
+		}
+		mc.policyMu.Unlock()
+		if !ok {
+			return
+		}
+		e, ok := mc.storage.Load(key)
+		if !ok {
+			// The key was removed but hasn't been dropped from the
+			// policy yet; don't let it wedge us.
+			mc.policyMu.Lock()
+			mc.policy.remove(key)
+			mc.policyMu.Unlock()
+			continue
+		}
+		if mc.storage.CompareAndDelete(key, e) {
+			mc.removed(key, e, ReasonCapacity)
+		}
+	}
+}
+
+// entryOverhead approximates the memory an entry takes up beyond its
+// key and value: the entry struct and the map slot pointing at it.
+const entryOverhead = 96
+
+// cost returns the cost of an entry for key holding value, which is
+// packed as stored: the cache's WithCost function's verdict, if it
+// has one, or else estimateCost's.
+func (mc *MemoryCache) cost(key string, value, packed any) int64 {
+	if fn := mc.opts.cost; fn != nil {
+		var cost int64
+		if mc.protect("cost", func() { cost = fn(key, value) }) == nil {
+			return max(cost, 0)
+		}
+	}
+	return estimateCost(key, packed)
+}
+
+// A sizer is a value which knows its own cost better than
+// estimateCost can guess it, such as the round tripper's cached
+// responses, whose bodies are most of their size.
+type sizer interface {
+	cacheCost() int64
+}
+
+// estimateCost approximates the memory, in bytes, taken up by an
+// entry for key holding value as stored (so compressed values count
+// at their compressed size). []byte and string values count their
+// length, and sizers what they say; other values count the size of
+// their type, which excludes anything they point to.
+func estimateCost(key string, value any) int64 {
+	cost := int64(entryOverhead + len(key))
+	switch v := value.(type) {
+	case nil:
+	case []byte:
+		cost += int64(len(v))
+	case string:
+		cost += int64(len(v))
+	case compressed:
+		cost += int64(len(v.data))
+	case sizer:
+		cost += v.cacheCost()
+	default:
+		cost += int64(reflect.TypeOf(v).Size())
+	}
+	return cost
+}
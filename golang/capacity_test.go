@@ -0,0 +1,260 @@
+package enigmacache
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMaxEntriesEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewMemoryCache(WithMaxEntries(3))
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, key, time.Minute)
+	}
+	cache.Get("a")
+	cache.Set("d", "d", time.Minute)
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("least recently used key b survived")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("key %q was evicted", key)
+		}
+	}
+}
+
+func TestFIFOEvictsEarliestInserted(t *testing.T) {
+	cache := NewMemoryCache(WithMaxEntries(3), WithEvictionPolicy(FIFO))
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, key, time.Minute)
+	}
+	cache.Get("a")
+	cache.Set("b", "b2", time.Minute) // keeps its place
+	cache.Set("d", "d", time.Minute)
+	cache.Set("e", "e", time.Minute)
+
+	for _, key := range []string{"a", "b"} {
+		if _, ok := cache.Get(key); ok {
+			t.Errorf("early key %q survived", key)
+		}
+	}
+	for _, key := range []string{"c", "d", "e"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("key %q was evicted", key)
+		}
+	}
+}
+
+func TestLFUEvictsLeastFrequentlyUsed(t *testing.T) {
+	cache := NewMemoryCache(WithMaxEntries(3), WithEvictionPolicy(LFU))
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, key, time.Minute)
+	}
+	for range 3 {
+		cache.Get("a")
+	}
+	cache.Get("b")
+	cache.Get("c")
+	// b and c have both been used twice; b less recently.
+	cache.Set("d", "d", time.Minute)
+	if _, ok := cache.Peek("b"); ok {
+		t.Error("least frequently used key b survived")
+	}
+	// d is now the only key used once, however recently.
+	cache.Set("e", "e", time.Minute)
+	if _, ok := cache.Peek("d"); ok {
+		t.Error("key d, used once, survived")
+	}
+	for _, key := range []string{"a", "c", "e"} {
+		if _, ok := cache.Peek(key); !ok {
+			t.Errorf("key %q was evicted", key)
+		}
+	}
+}
+
+func TestLFUPolicyOrder(t *testing.T) {
+	p := newLFUPolicy()
+	for _, key := range []string{"a", "b", "c"} {
+		p.add(key)
+	}
+	p.access("a")
+	p.access("a")
+	p.access("c")
+	if got, want := p.keys(), []string{"b", "c", "a"}; !slices.Equal(got, want) {
+		t.Errorf("keys() = %v, want %v", got, want)
+	}
+	p.remove("b")
+	p.remove("c")
+	if key, ok := p.victim(); !ok || key != "a" {
+		t.Errorf("victim() = %q, %v; want a, true", key, ok)
+	}
+	p.remove("a")
+	if _, ok := p.victim(); ok || p.len() != 0 || p.buckets.Len() != 0 {
+		t.Errorf("policy not empty after removing every key: len %d, %d buckets", p.len(), p.buckets.Len())
+	}
+}
+
+func TestAdmissionFilterProtectsIncumbents(t *testing.T) {
+	cache := NewMemoryCache(
+		WithMaxEntries(2),
+		WithAdmissionFilter(func(key string, _ int64) bool { return key != "scan" }),
+	)
+	cache.Set("a", "a", time.Minute)
+	cache.Set("b", "b", time.Minute)
+
+	cache.Set("scan", "scan", time.Minute)
+	if actual, loaded := cache.GetOrSet("scan", "scan", time.Minute); loaded || actual != "scan" {
+		t.Fatalf("GetOrSet got (%v, %v), want (scan, false)", actual, loaded)
+	}
+	if _, ok := cache.Get("scan"); ok {
+		t.Fatal("rejected key was stored")
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("incumbent %q was evicted by a rejected set", key)
+		}
+	}
+	if n := cache.Stats().Rejected; n != 2 {
+		t.Errorf("Rejected = %d, want 2", n)
+	}
+
+	// Overwrites of existing keys don't consult the filter, and
+	// admitted keys evict as usual.
+	cache.Set("a", "A", time.Minute)
+	cache.Set("c", "c", time.Minute)
+	if _, ok := cache.Get("b"); ok {
+		t.Error("admitted key did not evict the least recently used entry")
+	}
+	if value, _ := cache.Get("a"); value != "A" {
+		t.Errorf("overwrite of a was rejected")
+	}
+}
+
+// blob returns a value whose entry, under a one-byte key, costs
+// exactly cost bytes.
+func blob(cost int) []byte {
+	return make([]byte, cost-entryOverhead-1)
+}
+
+func TestMaxMemoryEvicts(t *testing.T) {
+	cache := NewMemoryCache(WithMaxMemory(1000))
+	for _, key := range []string{"a", "b", "c", "d"} {
+		cache.Set(key, blob(300), time.Minute)
+	}
+	if _, ok := cache.Get("a"); ok {
+		t.Error("byte budget breach did not evict the oldest entry")
+	}
+	if stats := cache.Stats(); stats.Entries != 3 || stats.Bytes != 900 {
+		t.Errorf("stats = %+v, want 3 entries costing 900 bytes", stats)
+	}
+}
+
+func TestCostFunc(t *testing.T) {
+	type image struct{ pixels int }
+	cache := NewMemoryCache(WithMaxMemory(1000), WithEvictionPolicy(FIFO), WithCost(func(_ string, value any) int64 {
+		if img, ok := value.(image); ok {
+			return int64(img.pixels)
+		}
+		panic("not an image")
+	}))
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, image{pixels: 400}, time.Minute)
+	}
+	if _, ok := cache.Get("a"); ok {
+		t.Error("byte budget breach did not evict the first entry")
+	}
+	if stats := cache.Stats(); stats.Entries != 2 || stats.Bytes != 800 {
+		t.Errorf("stats = %+v, want 2 entries costing 800 bytes", stats)
+	}
+
+	// A panicking cost function falls back to the estimate.
+	cache.Set("s", "text", time.Minute)
+	if got, want := cache.Stats().Bytes, 800+estimateCost("s", "text"); got != want {
+		t.Errorf("Bytes = %d, want %d", got, want)
+	}
+}
+
+func TestEstimateCostOfResponses(t *testing.T) {
+	resp := &cachedResponse{body: make([]byte, 1000)}
+	if got, want := estimateCost("k", resp), int64(entryOverhead+len("k")+1000); got != want {
+		t.Errorf("estimateCost of a cached response = %d, want %d", got, want)
+	}
+}
+
+func TestEntryAndByteLimitsTogether(t *testing.T) {
+	cache := NewMemoryCache(WithMaxEntries(3), WithMaxMemory(1000))
+
+	// Small entries hit the entry limit first.
+	for _, key := range []string{"a", "b", "c", "d"} {
+		cache.Set(key, blob(100), time.Minute)
+	}
+	if stats := cache.Stats(); stats.Entries != 3 || stats.Bytes != 300 {
+		t.Fatalf("after the entry limit, stats = %+v", stats)
+	}
+
+	// A big entry breaches the byte limit, so eviction carries on
+	// past the entry limit until both are satisfied.
+	cache.Set("e", blob(900), time.Minute)
+	if stats := cache.Stats(); stats.Entries != 2 || stats.Bytes != 1000 {
+		t.Fatalf("after the byte limit, stats = %+v", stats)
+	}
+	if _, ok := cache.Get("e"); !ok {
+		t.Fatal("the new entry was evicted")
+	}
+}
+
+func TestOversizedValueIsRejected(t *testing.T) {
+	cache := NewMemoryCache(WithMaxMemory(1000))
+	cache.Set("a", blob(500), time.Minute)
+	cache.Set("b", blob(1001), time.Minute)
+	cache.Set("a", blob(1001), time.Minute)
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("oversized value was stored")
+	}
+	if value, _ := cache.Get("a"); len(value.([]byte)) != 500-entryOverhead-1 {
+		t.Error("oversized overwrite replaced the existing value")
+	}
+	if stats := cache.Stats(); stats.Entries != 1 || stats.Rejected != 2 {
+		t.Errorf("stats = %+v, want 1 entry and 2 rejections", stats)
+	}
+}
+
+func TestSegmentedLRUSurvivesScan(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		opts    []Option
+		survive bool
+	}{
+		{"LRU", nil, false},
+		{"SLRU", []Option{WithSegmentedLRU(0.8)}, true},
+	} {
+		cache := NewMemoryCache(append(tc.opts, WithMaxEntries(10))...)
+		hot := []string{"h0", "h1", "h2", "h3", "h4"}
+		for _, key := range hot {
+			cache.Set(key, key, time.Minute)
+			cache.Get(key)
+		}
+		for i := range 20 {
+			key := "scan" + string(rune('a'+i))
+			cache.Set(key, key, time.Minute)
+		}
+
+		survived := 0
+		for _, key := range hot {
+			if cache.Has(key) {
+				survived++
+			}
+		}
+		if tc.survive && survived != len(hot) {
+			t.Errorf("%s: %d of %d hot keys survived a scan", tc.name, survived, len(hot))
+		}
+		if !tc.survive && survived != 0 {
+			t.Errorf("%s: %d hot keys survived a scan, want none", tc.name, survived)
+		}
+		if n := cache.Len(); n != 10 {
+			t.Errorf("%s: Len = %d, want 10", tc.name, n)
+		}
+	}
+}
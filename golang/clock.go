@@ -0,0 +1,134 @@
+package enigmacache
+
+import (
+	"sync"
+	"time"
+)
+
+// A Clock tells the cache the time and runs its expiration timers.
+// The cache uses the system clock unless it's built WithClock.
+// Timeouts on loaders and callbacks go by it too, so a test can time
+// out a loader by advancing a FakeClock; expiry pacing always goes by
+// the system clock, since it guards against work which is really slow.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f, on its own goroutine or the clock's, once d
+	// has passed, unless the returned Timer is stopped first.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// A Timer is a pending call scheduled by a Clock. Stop cancels it,
+// reporting whether it did so before the call was made; *time.Timer
+// implements it.
+type Timer interface {
+	Stop() bool
+}
+
+// WithClock sets the clock the cache measures TTLs and idle times by,
+// and schedules its expirations with, chiefly so tests can use a
+// FakeClock (see also package clocktest).
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// now returns the current time by the cache's clock.
+func (mc *MemoryCache) now() time.Time {
+	return mc.opts.clock.Now()
+}
+
+// NewTestCache returns a cache built with opts which keeps time by the
+// returned FakeClock, for tests of code using the cache. Nothing
+// expires until the clock is advanced, and then everything due
+// expires before Advance returns, so tests need no sleeps.
+func NewTestCache(opts ...Option) (*MemoryCache, *FakeClock) {
+	clock := NewFakeClock(time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	return NewMemoryCache(append(opts, WithClock(clock))...), clock
+}
+
+// A FakeClock is a Clock which only moves when told to. Its timers
+// fire synchronously, on the goroutine calling Advance.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFakeClock returns a FakeClock reading start.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the clock's current time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc schedules f for when the clock has advanced by d. A
+// timer with d <= 0 fires on the next call to Advance, even Advance(0).
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers which come
+// due in deadline order, each with the clock set to its deadline.
+// Timers scheduled by those which fire run too, if they fall within d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	for {
+		next := -1
+		for i, t := range c.timers {
+			if !t.at.After(target) && (next < 0 || t.at.Before(c.timers[next].at)) {
+				next = i
+			}
+		}
+		if next < 0 {
+			break
+		}
+		t := c.timers[next]
+		c.timers = append(c.timers[:next], c.timers[next+1:]...)
+		if t.at.After(c.now) {
+			c.now = t.at
+		}
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = target
+	c.mu.Unlock()
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	at    time.Time
+	f     func()
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
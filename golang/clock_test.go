@@ -0,0 +1,53 @@
+package enigmacache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func ExampleNewTestCache() {
+	cache, clock := NewTestCache()
+	ttl := time.Minute
+	cache.Set("key", "value", ttl)
+
+	_, found := cache.Get("key")
+	fmt.Println("before:", found, cache.Len())
+	clock.Advance(2 * ttl)
+	_, found = cache.Get("key")
+	fmt.Println("after:", found, cache.Len())
+	// Output:
+	// before: true 1
+	// after: false 0
+}
+
+func TestFakeClockExpiresSynchronously(t *testing.T) {
+	var evicted []string
+	cache, clock := NewTestCache(WithOnEvicted(func(key string, _ any, reason EvictionReason) {
+		evicted = append(evicted, key)
+	}))
+	cache.Set("short", 1, time.Second)
+	cache.Set("long", 2, time.Hour)
+	cache.SetWithIdle("idle", 3, time.Hour, 10*time.Second)
+	cache.Set("refreshed", 4, time.Second)
+	cache.Refresh("refreshed", time.Minute)
+
+	clock.Advance(5 * time.Second)
+	cache.Get("idle") // resets the idle clock
+	clock.Advance(5 * time.Second)
+	if _, ok := cache.Get("idle"); !ok {
+		t.Fatal("idle entry expired while being read")
+	}
+	clock.Advance(time.Minute)
+
+	want := []string{"short", "idle", "refreshed"}
+	if fmt.Sprint(evicted) != fmt.Sprint(want) {
+		t.Fatalf("evicted %v, want %v", evicted, want)
+	}
+	if v, ok := cache.Get("long"); !ok || v != 2 {
+		t.Fatalf(`Get("long") = %v, %v, want 2, true`, v, ok)
+	}
+	if n := cache.DebugStats().Timers; n != 1 {
+		t.Fatalf("%d timers pending, want 1", n)
+	}
+}
@@ -0,0 +1,39 @@
+// Package clocktest provides a fake clock for testing code which uses
+// enigma-cache, so TTLs can be exercised by advancing time rather than
+// sleeping.
+//
+//	clock := clocktest.New()
+//	cache := enigmacache.NewMemoryCache(enigmacache.WithClock(clock))
+//	cache.Set("k", "v", time.Minute)
+//	clock.Advance(time.Minute) // k expires before Advance returns
+//
+// Everything the cache times by its clock follows the fake one:
+// deadlines, idle timeouts, the expiration scheduler, late expiry
+// pacing and the journal's periodic sync. Loader latencies and
+// callback watchdogs are measured in real time, since they time real
+// work.
+package clocktest
+
+import (
+	"time"
+
+	enigmacache "github.com/plathrop/enigma-cache/golang"
+)
+
+// A Clock is an enigmacache.Clock which only moves when Advance is
+// called. Its timers fire synchronously, on the goroutine calling
+// Advance.
+type Clock = enigmacache.FakeClock
+
+// Start is the time a Clock made by New reads at first.
+var Start = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// New returns a Clock reading Start.
+func New() *Clock {
+	return enigmacache.NewFakeClock(Start)
+}
+
+// NewAt returns a Clock reading start.
+func NewAt(start time.Time) *Clock {
+	return enigmacache.NewFakeClock(start)
+}
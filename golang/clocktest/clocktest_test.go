@@ -0,0 +1,63 @@
+package clocktest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	enigmacache "github.com/plathrop/enigma-cache/golang"
+)
+
+func TestClockDrivesExpiry(t *testing.T) {
+	clock := New()
+	cache := enigmacache.NewMemoryCache(enigmacache.WithClock(clock))
+	cache.Set("k", "v", time.Minute)
+
+	clock.Advance(59 * time.Second)
+	if !cache.Has("k") {
+		t.Fatal("k expired early")
+	}
+	clock.Advance(time.Second)
+	if cache.Has("k") || cache.Len() != 0 {
+		t.Error("k wasn't removed at its deadline")
+	}
+	if got := clock.Now(); !got.Equal(Start.Add(time.Minute)) {
+		t.Errorf("Now = %v, want a minute after Start", got)
+	}
+}
+
+func TestLateExpiryPacingFollowsClock(t *testing.T) {
+	clock := New()
+	cache := enigmacache.NewMemoryCache(
+		enigmacache.WithClock(clock),
+		enigmacache.WithLateExpiryPacing(time.Second, 1, time.Second),
+	)
+	for _, key := range []string{"a", "b", "c"} {
+		// Deadlines a minute gone make the expirations fire late, so
+		// they go on the backlog, which is worked off one entry per
+		// second.
+		cache.Set(key, 1, -time.Minute)
+	}
+	clock.Advance(0)
+	waitFor(t, func() bool { return cache.Len() == 2 })
+	for want := 1; want >= 0; want-- {
+		clock.Advance(time.Second)
+		waitFor(t, func() bool { return cache.Len() == want })
+	}
+	clock.Advance(time.Second)
+	if err := cache.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitFor waits for cond, which the backlog's goroutine makes true.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Latencies are counted in buckets growing by a factor of 2^(1/8),
+// about 9%, from histogramMin up to several minutes, and reported as
+// the geometric middle of their bucket, as enigmacache's loader
+// latencies are.
+const (
+	histogramMin       = 10 * time.Nanosecond
+	histogramPerDouble = 8
+	histogramBuckets   = 40 * histogramPerDouble
+)
+
+// A histogram counts latencies. It isn't safe for concurrent use; each
+// worker keeps its own, and they're merged at the end.
+type histogram struct {
+	counts [histogramBuckets]uint64
+	total  uint64
+	max    time.Duration
+}
+
+func (h *histogram) add(d time.Duration) {
+	i := 0
+	if d > histogramMin {
+		i = min(int(math.Log2(float64(d)/float64(histogramMin))*histogramPerDouble), histogramBuckets-1)
+	}
+	h.counts[i]++
+	h.total++
+	h.max = max(h.max, d)
+}
+
+func (h *histogram) merge(other *histogram) {
+	for i, n := range other.counts {
+		h.counts[i] += n
+	}
+	h.total += other.total
+	h.max = max(h.max, other.max)
+}
+
+// quantile estimates the latency below which q of those recorded fall.
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.total)))
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			mid := math.Exp2((float64(i) + 0.5) / histogramPerDouble)
+			return min(time.Duration(mid*float64(histogramMin)), h.max).Round(10 * time.Nanosecond)
+		}
+	}
+	return h.max
+}
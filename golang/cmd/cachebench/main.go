@@ -0,0 +1,206 @@
+// Command cachebench runs a configurable workload against a cache and
+// reports its throughput, latency percentiles and hit ratio, for
+// measuring changes to the cache, or comparing backends, outside the
+// go test benchmarks.
+//
+// Usage:
+//
+//	cachebench [-backend memory|sharded|redis] [-addr localhost:6379] [-shards 16] [-max-entries 0] [-duration 10s] [-workers GOMAXPROCS] [-keys 100000] [-reads 0.9] [-dist uniform|zipf] [-zipf-s 1.1] [-value-size 128] [-ttl 1h] [-prefill] [-read-through]
+//
+// Each worker runs operations back to back for the duration: a Get, or,
+// in 1-reads of them, a Set, of a key chosen from the distribution.
+// With -read-through, a Get which misses is followed by a Set, which
+// counts as part of the operation. Latencies are measured per
+// operation, so they include the cost of reading the clock twice.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	enigmacache "github.com/plathrop/enigma-cache/golang"
+	"github.com/plathrop/enigma-cache/golang/redis"
+)
+
+type config struct {
+	backend    string
+	addr       string
+	shards     int
+	maxEntries int
+	duration   time.Duration
+	workers    int
+	keys       int
+	reads      float64
+	dist       string
+	zipfS      float64
+	valueSize  int
+	ttl        time.Duration
+	prefill    bool
+	readThru   bool
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.backend, "backend", "memory", "cache to run against: memory, sharded or redis")
+	flag.StringVar(&cfg.addr, "addr", "localhost:6379", "address of the Redis server, for -backend redis")
+	flag.IntVar(&cfg.shards, "shards", 16, "number of shards, for -backend sharded")
+	flag.IntVar(&cfg.maxEntries, "max-entries", 0, "most entries the memory cache holds, evicting beyond it (0 means no limit)")
+	flag.DurationVar(&cfg.duration, "duration", 10*time.Second, "how long to run the workload for")
+	flag.IntVar(&cfg.workers, "workers", runtime.GOMAXPROCS(0), "number of goroutines running operations")
+	flag.IntVar(&cfg.keys, "keys", 100000, "number of distinct keys")
+	flag.Float64Var(&cfg.reads, "reads", 0.9, "share of operations which are Gets, from 0 to 1")
+	flag.StringVar(&cfg.dist, "dist", "uniform", "distribution of keys: uniform or zipf")
+	flag.Float64Var(&cfg.zipfS, "zipf-s", 1.1, "skew of the zipf distribution, more than 1")
+	flag.IntVar(&cfg.valueSize, "value-size", 128, "size of the values stored, in bytes")
+	flag.DurationVar(&cfg.ttl, "ttl", time.Hour, "TTL of the values stored")
+	flag.BoolVar(&cfg.prefill, "prefill", true, "store every key before starting")
+	flag.BoolVar(&cfg.readThru, "read-through", false, "follow a Get which misses with a Set")
+	flag.Parse()
+
+	if err := run(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, "cachebench:", err)
+		os.Exit(1)
+	}
+}
+
+func run(cfg config) error {
+	switch {
+	case cfg.workers < 1:
+		return fmt.Errorf("-workers must be at least 1")
+	case cfg.keys < 1:
+		return fmt.Errorf("-keys must be at least 1")
+	case cfg.reads < 0 || cfg.reads > 1:
+		return fmt.Errorf("-reads must be from 0 to 1")
+	case cfg.dist == "zipf" && cfg.zipfS <= 1:
+		return fmt.Errorf("-zipf-s must be more than 1")
+	case cfg.dist != "uniform" && cfg.dist != "zipf":
+		return fmt.Errorf("unknown distribution %q", cfg.dist)
+	}
+	cache, closeCache, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeCache()
+
+	keys := make([]string, cfg.keys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("cachebench:%d", i)
+	}
+	value := make([]byte, cfg.valueSize)
+	if cfg.prefill {
+		for _, key := range keys {
+			cache.Set(key, value, cfg.ttl)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.duration)
+	defer cancel()
+	results := make([]result, cfg.workers)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[w] = work(ctx, cfg, cache, keys, value, uint64(w))
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var total result
+	for _, r := range results {
+		total.merge(r)
+	}
+	report(cfg, total, elapsed)
+	return nil
+}
+
+// open returns the cache the workload runs against, and a func to
+// close it.
+func open(cfg config) (enigmacache.ReadWriter, func(), error) {
+	switch cfg.backend {
+	case "memory", "sharded":
+		opts := []enigmacache.Option{enigmacache.WithMaxEntries(cfg.maxEntries)}
+		if cfg.backend == "sharded" {
+			opts = append(opts, enigmacache.WithShards(cfg.shards))
+		}
+		cache := enigmacache.NewMemoryCache(opts...)
+		return cache, func() { cache.Close() }, nil
+	case "redis":
+		cache := redis.New(cfg.addr, redis.WithPoolSize(cfg.workers))
+		if _, err := cache.Do("PING"); err != nil {
+			cache.Close()
+			return nil, nil, fmt.Errorf("connecting to redis at %s: %w", cfg.addr, err)
+		}
+		return cache, func() { cache.Close() }, nil
+	}
+	return nil, nil, fmt.Errorf("unknown backend %q", cfg.backend)
+}
+
+// A result is what a worker measured.
+type result struct {
+	ops, hits, misses uint64
+	latency           histogram
+}
+
+func (r *result) merge(other result) {
+	r.ops += other.ops
+	r.hits += other.hits
+	r.misses += other.misses
+	r.latency.merge(&other.latency)
+}
+
+// work runs operations until ctx is done.
+func work(ctx context.Context, cfg config, cache enigmacache.ReadWriter, keys []string, value []byte, seed uint64) result {
+	r := rand.New(rand.NewPCG(seed, uint64(time.Now().UnixNano())))
+	next := func() int { return r.IntN(len(keys)) }
+	if cfg.dist == "zipf" {
+		z := rand.NewZipf(r, cfg.zipfS, 1, uint64(len(keys)-1))
+		next = func() int { return int(z.Uint64()) }
+	}
+	var res result
+	for ctx.Err() == nil {
+		key := keys[next()]
+		read := r.Float64() < cfg.reads
+		began := time.Now()
+		if read {
+			if _, ok := cache.Get(key); ok {
+				res.hits++
+			} else {
+				res.misses++
+				if cfg.readThru {
+					cache.Set(key, value, cfg.ttl)
+				}
+			}
+		} else {
+			cache.Set(key, value, cfg.ttl)
+		}
+		res.latency.add(time.Since(began))
+		res.ops++
+	}
+	return res
+}
+
+func report(cfg config, r result, elapsed time.Duration) {
+	dist := cfg.dist
+	if dist == "zipf" {
+		dist = fmt.Sprintf("zipf (s=%g)", cfg.zipfS)
+	}
+	fmt.Printf("backend    %s\n", cfg.backend)
+	fmt.Printf("workload   %.0f%% reads, %s over %d keys, %d-byte values, %d workers\n",
+		100*cfg.reads, dist, cfg.keys, cfg.valueSize, cfg.workers)
+	fmt.Printf("throughput %d ops in %v: %.0f ops/s\n", r.ops, elapsed.Round(time.Millisecond), float64(r.ops)/elapsed.Seconds())
+	if reads := r.hits + r.misses; reads > 0 {
+		fmt.Printf("hit ratio  %.2f%% of %d reads\n", 100*float64(r.hits)/float64(reads), reads)
+	}
+	fmt.Printf("latency    p50 %v  p90 %v  p99 %v  p99.9 %v  max %v\n",
+		r.latency.quantile(0.5), r.latency.quantile(0.9), r.latency.quantile(0.99),
+		r.latency.quantile(0.999), r.latency.max)
+}
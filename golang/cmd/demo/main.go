@@ -0,0 +1,36 @@
+// Command demo exercises an enigma-cache MemoryCache.
+package main
+
+import (
+	"fmt"
+	"time"
+
+	enigmacache "github.com/plathrop/enigma-cache/golang"
+)
+
+func main() {
+	cache := enigmacache.NewMemoryCache()
+
+	fmt.Println("Setting up cache...")
+	cache.Set("UltimateAnswer", 42, time.Until(time.Now().Add(5*time.Minute)))
+	cache.Set("Spock", "Live long and prosper.", time.Until(time.Now().Add(10*time.Second)))
+
+	fmt.Println("Searching for answers...")
+	value, ok := cache.Get("UltimateAnswer")
+	if !ok {
+		fmt.Println("Failed to answer the ultimate question.")
+	} else {
+		fmt.Println("The answer is, of course, ", value, ".")
+	}
+	cache.Expire("UltimateAnswer")
+
+	fmt.Println("Searching for Spock...")
+	time.Sleep(15 * time.Second)
+	value, found := cache.GetOrSet("Spock", "Live long and prosper.", time.Until(time.Now().Add(5*time.Minute)))
+	if found {
+		fmt.Println("Found Spock, that was unexpected!")
+	} else {
+		fmt.Println("Spock not found, releasing Genesis device.")
+		fmt.Println(value)
+	}
+}
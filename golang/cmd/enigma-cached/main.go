@@ -0,0 +1,89 @@
+// Command enigma-cached runs an enigma-cache MemoryCache as a
+// standalone server, serving the HTTP API of package httpserver and,
+// given -memcached-addr, the memcached text protocol as package
+// memcached does.
+//
+// Usage:
+//
+//	enigma-cached [-addr :8080] [-memcached-addr :11211] [-default-ttl 0] [-max-entries 0] [-max-memory 0] [-shards 0] [-max-value-size 1048576]
+//
+// It shuts down cleanly on SIGINT or SIGTERM, finishing the requests
+// in flight.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	enigmacache "github.com/plathrop/enigma-cache/golang"
+	"github.com/plathrop/enigma-cache/golang/httpserver"
+	"github.com/plathrop/enigma-cache/golang/memcached"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to serve the HTTP API on")
+	memcachedAddr := flag.String("memcached-addr", "", "address to serve the memcached protocol on, if any")
+	defaultTTL := flag.Duration("default-ttl", 0, "TTL for values stored without one (0 means they never expire)")
+	maxEntries := flag.Int("max-entries", 0, "most entries to hold, evicting beyond it (0 means no limit)")
+	maxMemory := flag.Int64("max-memory", 0, "most estimated bytes of values to hold, evicting beyond it (0 means no limit)")
+	shards := flag.Int("shards", 0, "number of shards to spread keys across (0 means unsharded)")
+	maxValue := flag.Int64("max-value-size", 1<<20, "biggest value a client may store, in bytes")
+	flag.Parse()
+
+	cache := enigmacache.NewMemoryCache(
+		enigmacache.WithDefaultTTL(*defaultTTL),
+		enigmacache.WithMaxEntries(*maxEntries),
+		enigmacache.WithMaxMemory(*maxMemory),
+		enigmacache.WithShards(*shards),
+	)
+	defer cache.Close()
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           httpserver.New(cache, httpserver.WithMaxValueSize(*maxValue)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var mcd *memcached.Server
+	if *memcachedAddr != "" {
+		mcd = memcached.New(cache, memcached.WithMaxItemSize(int(*maxValue)))
+		go func() {
+			slog.Info("enigma-cached: serving memcached", "addr", *memcachedAddr)
+			if err := mcd.ListenAndServe(*memcachedAddr); !errors.Is(err, memcached.ErrServerClosed) {
+				slog.Error("enigma-cached: serving memcached failed", "err", err)
+				os.Exit(1)
+			}
+		}()
+	}
+	// ListenAndServe returns as soon as Shutdown is called, so wait for
+	// Shutdown to finish the requests in flight before exiting.
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+		if mcd != nil {
+			mcd.Close()
+		}
+		if err := cache.Shutdown(shutdown); err != nil {
+			slog.Error("enigma-cached: shutting down the cache failed", "err", err)
+		}
+	}()
+
+	slog.Info("enigma-cached: serving HTTP", "addr", *addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("enigma-cached: serving HTTP failed", "err", err)
+		os.Exit(1)
+	}
+	<-stopped
+}
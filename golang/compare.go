@@ -0,0 +1,185 @@
+package enigmacache
+
+import (
+	"context"
+	"time"
+)
+
+// CompareAndDelete deletes key if it holds old, reporting whether it
+// did, so a caller can drop a value without clobbering one written
+// since it looked. Values are compared with ==, which panics if they
+// are of the same type and that type isn't comparable; use
+// CompareAndDeleteFunc for those. Like Expire, a successful delete
+// stops the key's expiration timer and reaches any write-through
+// store.
+func (mc *MemoryCache) CompareAndDelete(key string, old any) (deleted bool) {
+	key = mc.normalize(key)
+	return mc.CompareAndDeleteFunc(key, func(value any) bool {
+		return value == old
+	})
+}
+
+// CompareAndDeleteFunc is CompareAndDelete with the comparison left to
+// match, which is passed the key's current value and says whether to
+// delete it. match may be called more than once if the key is written
+// concurrently, and isn't called at all if the key is absent.
+func (mc *MemoryCache) CompareAndDeleteFunc(key string, match func(value any) bool) (deleted bool) {
+	key = mc.normalize(key)
+	for {
+		e, ok := mc.storage.Load(key)
+		if !ok || !e.live(mc.now()) || !match(e.get()) {
+			return false
+		}
+		if mc.storage.CompareAndDelete(key, e) {
+			mc.deleteThrough(key)
+			mc.removed(key, e, ReasonManual)
+			return true
+		}
+	}
+}
+
+// CompareAndSwap sets key to new if it holds old, reporting whether
+// it did, for optimistic concurrency: read a value, work out its
+// replacement, and write it back only if no one else has written the
+// key meanwhile. The entry keeps its deadline, as for Increment. Values
+// are compared with ==, as by CompareAndDelete. An absent key holds
+// nothing, so never matches. A new value the cache's options turn away
+// (see Set) isn't stored, and CompareAndSwap reports false.
+func (mc *MemoryCache) CompareAndSwap(key string, old, new any) (swapped bool) {
+	key = mc.normalize(key)
+	if mc.rejects(new) {
+		return false
+	}
+	for {
+		prev, ok := mc.storage.Load(key)
+		if !ok || !prev.live(mc.now()) || prev.get() != old {
+			return false
+		}
+		e := mc.withValue(prev, key, new)
+		if !mc.fits(e) {
+			return false
+		}
+		if mc.storage.CompareAndSwap(key, prev, e) {
+			mc.stored(key, e, prev)
+			_ = mc.writeThrough(context.Background(), key, new, e.remaining(mc.now()))
+			return true
+		}
+	}
+}
+
+// SetIfAbsent sets key to value only if the key is absent, reporting
+// whether it did. It's GetOrSet for a caller with no use for the value
+// already there, and unlike GetOrSet it doesn't count as a read. An
+// entry past its deadline counts as absent. A value the cache's
+// options turn away (see Set) isn't stored, and SetIfAbsent reports
+// false.
+func (mc *MemoryCache) SetIfAbsent(key string, value any, ttl time.Duration) (stored bool) {
+	key = mc.normalize(key)
+	ttl, ok := mc.valueTTL(key, value, ttl)
+	if !ok || mc.rejects(value) {
+		return false
+	}
+	if _, ok := mc.load(key); ok {
+		return false
+	}
+	e := mc.newEntry(key, value, ttl, 0)
+	if !mc.fits(e) || !mc.admit(key, e.cost) {
+		return false
+	}
+	for {
+		existing, loaded := mc.storage.LoadOrStore(key, e)
+		if !loaded {
+			mc.stored(key, e, nil)
+		} else {
+			if existing.live(mc.now()) {
+				return false
+			}
+			if !mc.storage.CompareAndSwap(key, existing, e) {
+				continue
+			}
+			mc.stored(key, e, existing)
+		}
+		_ = mc.writeThrough(context.Background(), key, value, ttl)
+		return true
+	}
+}
+
+// Replace sets key to value like Set, but only if the key is present,
+// reporting whether it was, so a value deleted or expired meanwhile
+// isn't brought back. A value the cache's options turn away (see Set)
+// isn't stored, and Replace reports false.
+func (mc *MemoryCache) Replace(key string, value any, ttl time.Duration) (replaced bool) {
+	key = mc.normalize(key)
+	ttl, ok := mc.valueTTL(key, value, ttl)
+	if !ok || mc.rejects(value) {
+		return false
+	}
+	for {
+		prev, ok := mc.storage.Load(key)
+		if !ok || !prev.live(mc.now()) {
+			return false
+		}
+		e := mc.newEntry(key, value, ttl, 0)
+		if !mc.fits(e) {
+			return false
+		}
+		mc.checkShortening(key, e, prev)
+		if mc.storage.CompareAndSwap(key, prev, e) {
+			mc.stored(key, e, prev)
+			_ = mc.writeThrough(context.Background(), key, value, ttl)
+			return true
+		}
+	}
+}
+
+// SetIfNewer sets key to value, recording version as its Meta.Version
+// (see GetWithMeta), only if version is greater than the version
+// stored for key, reporting whether it did. An absent key, or one
+// set some other way, counts as version 0. This is last-writer-wins
+// for replication: replicas handed the same writes converge on the
+// value with the highest version, whatever order the writes arrive
+// in. The comparison and the write are one atomic step. A value the
+// cache's options turn away (see Set) isn't stored, and SetIfNewer
+// reports false.
+//
+// A key's version goes when the key does, so a write delayed past the
+// key's expiry or deletion is applied when it arrives.
+func (mc *MemoryCache) SetIfNewer(key string, value any, version uint64, ttl time.Duration) bool {
+	key = mc.normalize(key)
+	ttl, ok := mc.valueTTL(key, value, ttl)
+	if !ok || mc.rejects(value) {
+		return false
+	}
+	e := mc.newEntry(key, value, ttl, 0)
+	e.meta = &Meta{Version: version}
+	if !mc.fits(e) {
+		return false
+	}
+	for {
+		old, ok := mc.storage.Load(key)
+		live := ok && old.live(mc.now())
+		var stored uint64
+		if live && old.meta != nil {
+			stored = old.meta.Version
+		}
+		if version <= stored {
+			return false
+		}
+		if !ok {
+			if !mc.admit(key, e.cost) {
+				return false
+			}
+			if _, loaded := mc.storage.LoadOrStore(key, e); loaded {
+				continue
+			}
+			mc.stored(key, e, nil)
+		} else {
+			if !mc.storage.CompareAndSwap(key, old, e) {
+				continue
+			}
+			mc.stored(key, e, old)
+		}
+		_ = mc.writeThrough(context.Background(), key, value, ttl)
+		return true
+	}
+}
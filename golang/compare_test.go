@@ -0,0 +1,191 @@
+package enigmacache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompareAndDelete(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.Set("key", "old", time.Minute)
+	if cache.CompareAndDelete("key", "other") {
+		t.Fatal("deleted a key holding a different value")
+	}
+	if cache.CompareAndDelete("missing", "old") {
+		t.Fatal("deleted a missing key")
+	}
+	if !cache.CompareAndDelete("key", "old") {
+		t.Fatal("didn't delete a key holding the value")
+	}
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("key survived CompareAndDelete")
+	}
+	clock.Advance(time.Hour)
+	if n := cache.DebugStats().Timers; n != 0 {
+		t.Fatalf("%d timers pending after delete, want 0", n)
+	}
+
+	cache.Set("slice", []int{1}, time.Minute)
+	if !cache.CompareAndDeleteFunc("slice", func(v any) bool { return len(v.([]int)) == 1 }) {
+		t.Fatal("CompareAndDeleteFunc didn't delete a matching value")
+	}
+}
+
+func TestCompareAndDeleteRacesUpdates(t *testing.T) {
+	cache := NewMemoryCache()
+	for range 100 {
+		cache.Set("key", 0, time.Minute)
+		var wg sync.WaitGroup
+		var deleted bool
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			cache.Set("key", 1, time.Minute)
+		}()
+		go func() {
+			defer wg.Done()
+			deleted = cache.CompareAndDelete("key", 0)
+		}()
+		wg.Wait()
+
+		// Either the delete came first and the update then recreated
+		// the key, or the update came first and the delete saw the
+		// new value and left it. Either way the key ends up holding 1.
+		v, ok := cache.Get("key")
+		if !ok || v != 1 {
+			t.Fatalf("deleted = %v, then key holds %v, %v; want 1, true", deleted, v, ok)
+		}
+	}
+}
+
+func TestSetIfNewerHighestVersionWins(t *testing.T) {
+	writes := []struct {
+		version uint64
+		value   string
+	}{{1, "a"}, {2, "b"}, {3, "c"}, {4, "d"}}
+	for _, order := range [][]int{{0, 1, 2, 3}, {3, 2, 1, 0}, {1, 3, 0, 2}, {2, 0, 3, 1}} {
+		cache := NewMemoryCache()
+		applied := 0
+		for _, i := range order {
+			if cache.SetIfNewer("key", writes[i].value, writes[i].version, time.Minute) {
+				applied++
+			}
+		}
+		value, meta, ok := cache.GetWithMeta("key")
+		if !ok || value != "d" || meta.Version != 4 {
+			t.Errorf("order %v: got (%v, version %d, %v), want (d, version 4, true)", order, value, meta.Version, ok)
+		}
+		if order[0] == 3 && applied != 1 {
+			t.Errorf("order %v: applied %d writes after the newest, want only it", order, applied)
+		}
+	}
+
+	cache := NewMemoryCache()
+	if cache.SetIfNewer("key", "zero", 0, time.Minute) {
+		t.Error("version 0 applied over an absent key")
+	}
+	cache.SetIfNewer("key", "v5", 5, time.Minute)
+	if cache.SetIfNewer("key", "again", 5, time.Minute) {
+		t.Error("an equal version applied")
+	}
+	cache.Set("key", "plain", time.Minute)
+	if !cache.SetIfNewer("key", "v1", 1, time.Minute) {
+		t.Error("a versioned write didn't apply over a plain Set")
+	}
+}
+
+func TestSetIfNewerConcurrent(t *testing.T) {
+	cache := NewMemoryCache()
+	var wg sync.WaitGroup
+	for v := uint64(1); v <= 100; v++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.SetIfNewer("key", v, v, time.Minute)
+		}()
+	}
+	wg.Wait()
+	if value, meta, _ := cache.GetWithMeta("key"); value != uint64(100) || meta.Version != 100 {
+		t.Fatalf("got (%v, version %d), want (100, version 100)", value, meta.Version)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.Set("key", 1, time.Minute)
+	if cache.CompareAndSwap("key", 2, 3) || cache.CompareAndSwap("missing", nil, 3) {
+		t.Fatal("swapped a key not holding the old value")
+	}
+	clock.Advance(30 * time.Second)
+	if !cache.CompareAndSwap("key", 1, 2) {
+		t.Fatal("didn't swap a key holding the old value")
+	}
+	if value, _ := cache.Get("key"); value != 2 {
+		t.Errorf("key holds %v, want 2", value)
+	}
+	if remaining, _ := cache.TTL("key"); remaining != 30*time.Second {
+		t.Errorf("TTL = %v after the swap, want the 30s left before it", remaining)
+	}
+	clock.Advance(30 * time.Second)
+	if cache.Has("key") || cache.DebugStats().Timers != 0 {
+		t.Error("swapped entry outlived its deadline")
+	}
+}
+
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.Set("n", 0, time.Minute)
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				for {
+					value, _ := cache.Get("n")
+					if cache.CompareAndSwap("n", value, value.(int)+1) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if value, _ := cache.Get("n"); value != 800 {
+		t.Errorf("n = %v after 800 increments, want 800", value)
+	}
+}
+
+func TestSetIfAbsentAndReplace(t *testing.T) {
+	cache, clock := NewTestCache()
+	if cache.Replace("key", 1, time.Minute) || cache.Has("key") {
+		t.Fatal("Replace stored a missing key")
+	}
+	if !cache.SetIfAbsent("key", 1, time.Minute) {
+		t.Fatal("SetIfAbsent didn't store a missing key")
+	}
+	if cache.SetIfAbsent("key", 2, time.Minute) {
+		t.Fatal("SetIfAbsent overwrote a present key")
+	}
+	if !cache.Replace("key", 3, time.Hour) {
+		t.Fatal("Replace didn't overwrite a present key")
+	}
+	if value, _ := cache.Get("key"); value != 3 {
+		t.Errorf("key holds %v, want 3", value)
+	}
+	if remaining, _ := cache.TTL("key"); remaining != time.Hour {
+		t.Errorf("TTL = %v after Replace, want 1h", remaining)
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 0 {
+		t.Errorf("Stats = %+v; SetIfAbsent and Replace mustn't count as reads", stats)
+	}
+
+	clock.Advance(time.Hour)
+	if cache.Replace("key", 4, time.Minute) {
+		t.Error("Replace brought back an expired key")
+	}
+	if !cache.SetIfAbsent("key", 5, time.Minute) {
+		t.Error("SetIfAbsent didn't replace an expired key")
+	}
+}
@@ -0,0 +1,154 @@
+package enigmacache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+)
+
+// A Compressor compresses values for WithCompression, and decompresses
+// them again. Gzip and Flate are built in; others, such as snappy or
+// zstd, can be plugged in by wrapping a package implementing them.
+// Compressors must be safe for concurrent use.
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	// Gzip compresses with compress/gzip, at the default level.
+	Gzip Compressor = gzipCompressor{}
+	// Flate compresses with compress/flate, at the default level,
+	// which is gzip without its header and checksum: a little
+	// smaller, for values small enough that they matter.
+	Flate Compressor = flateCompressor{}
+)
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	// Writes to a bytes.Buffer can't fail.
+	w.Write(data)
+	w.Close()
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+type flateCompressor struct{}
+
+func (flateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes(), nil
+}
+
+func (flateCompressor) Decompress(data []byte) ([]byte, error) {
+	return io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+}
+
+// WithCompression makes the cache store []byte and string values
+// longer than threshold bytes compressed by c, decompressing them
+// transparently when they're read. Other values, and values which
+// don't shrink, are stored as-is, as are those c fails to compress,
+// which are logged (see WithLogger). A cache
+// bounded WithMaxMemory counts compressed values at their compressed
+// size, unless WithCost says otherwise. A threshold of zero or less,
+// or a nil c, turns compression off.
+//
+// Reads of a compressed value return a fresh copy each time, so
+// compression trades CPU on every read for memory. A value which
+// fails to decompress, which only a broken Compressor should cause,
+// is logged, counted in Stats().DecompressFailures and removed from
+// the cache, and the read goes as a miss.
+func WithCompression(c Compressor, threshold int) Option {
+	return func(o *options) {
+		o.compressor = c
+		o.compressThreshold = threshold
+	}
+}
+
+// WithValueCompression is WithCompression with Gzip.
+func WithValueCompression(threshold int) Option {
+	return WithCompression(Gzip, threshold)
+}
+
+// A compressed holds a value which has been compressed for storage by
+// c. isString records whether it was a string or a []byte.
+type compressed struct {
+	data     []byte
+	c        Compressor
+	isString bool
+}
+
+// pack returns value as it should be stored: compressed, if the
+// cache's options call for it and it's worth it, or unchanged.
+func (mc *MemoryCache) pack(value any) any {
+	threshold, c := mc.opts.compressThreshold, mc.opts.compressor
+	if threshold <= 0 || c == nil {
+		return value
+	}
+	var raw []byte
+	var isString bool
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw, isString = []byte(v), true
+	default:
+		return value
+	}
+	if len(raw) <= threshold {
+		return value
+	}
+
+	data, err := c.Compress(raw)
+	if err != nil {
+		mc.logger().Warn("enigma-cache: compressing a value failed", "err", err)
+		return value
+	}
+	if len(data) >= len(raw) {
+		return value
+	}
+	return compressed{data: bytes.Clone(data), c: c, isString: isString}
+}
+
+// unpack reverses pack. The compressor compressed the value itself,
+// so it can only fail to decompress it if the compressor is broken,
+// or memory has been corrupted.
+func unpack(stored any) (any, error) {
+	s, ok := stored.(compressed)
+	if !ok {
+		return stored, nil
+	}
+	raw, err := s.c.Decompress(s.data)
+	if err != nil {
+		return nil, err
+	}
+	if s.isString {
+		return string(raw), nil
+	}
+	return raw, nil
+}
+
+// unreadable does the bookkeeping for e, key's entry, having failed to
+// decompress with err: it's logged, counted, and dropped from the
+// cache, since no read will ever get its value back.
+func (mc *MemoryCache) unreadable(key string, e *entry, err error) {
+	mc.stats.decompressFailures.Add(1)
+	mc.logger().Error("enigma-cache: stored value failed to decompress", "key", key, "err", err)
+	if mc.storage.CompareAndDelete(key, e) {
+		mc.dropped(key, e, ReasonManual, true)
+	}
+}
@@ -0,0 +1,121 @@
+package enigmacache
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValueCompressionRoundTrip(t *testing.T) {
+	cache := NewMemoryCache(WithValueCompression(64))
+	large := bytes.Repeat([]byte(`{"hello":"world"},`), 100)
+	largeString := strings.Repeat("compressible ", 100)
+
+	cache.Set("bytes", large, time.Minute)
+	cache.Set("string", largeString, time.Minute)
+	if value, _ := cache.Get("bytes"); !bytes.Equal(value.([]byte), large) {
+		t.Error("large []byte did not round-trip")
+	}
+	if value, _ := cache.Get("string"); value != largeString {
+		t.Error("large string did not round-trip")
+	}
+
+	for _, key := range []string{"bytes", "string"} {
+		e, _ := cache.storage.Load(key)
+		c, ok := e.value.(compressed)
+		if !ok {
+			t.Errorf("large %s value was stored uncompressed", key)
+			continue
+		}
+		if len(c.data) >= len(large) {
+			t.Errorf("compressed %s value is %d bytes, no smaller than the original", key, len(c.data))
+		}
+	}
+}
+
+func TestValueCompressionSkipsSmallValues(t *testing.T) {
+	cache := NewMemoryCache(WithValueCompression(64))
+	small := []byte("tiny")
+	cache.Set("small", small, time.Minute)
+	cache.Set("number", 42, time.Minute)
+
+	e, _ := cache.storage.Load("small")
+	if stored, ok := e.value.([]byte); !ok || &stored[0] != &small[0] {
+		t.Error("small value was not stored as-is")
+	}
+	if value, _ := cache.Get("number"); value != 42 {
+		t.Errorf("non-byte value came back as %v", value)
+	}
+}
+
+func TestCompressionCodecs(t *testing.T) {
+	large := strings.Repeat("compressible ", 1000)
+	for name, c := range map[string]Compressor{"gzip": Gzip, "flate": Flate} {
+		cache := NewMemoryCache(WithCompression(c, 64), WithMaxMemory(1<<20))
+		cache.Set("k", large, time.Minute)
+		if value, _ := cache.Get("k"); value != large {
+			t.Errorf("%s: value did not round-trip", name)
+		}
+		if bytes := cache.Stats().Bytes; bytes >= int64(len(large)) {
+			t.Errorf("%s: cache counts %d bytes for a %d-byte value, want its compressed size", name, bytes, len(large))
+		}
+	}
+}
+
+type failingCompressor struct{}
+
+func (failingCompressor) Compress([]byte) ([]byte, error)   { return nil, errors.New("no") }
+func (failingCompressor) Decompress([]byte) ([]byte, error) { return nil, errors.New("no") }
+
+func TestCompressionFailureStoresAsIs(t *testing.T) {
+	cache := NewMemoryCache(WithCompression(failingCompressor{}, 1), WithLogger(quietLogger))
+	cache.Set("k", "a value", time.Minute)
+	if e, _ := cache.storage.Load("k"); e.value != "a value" {
+		t.Errorf("stored %#v, want the value as-is", e.value)
+	}
+}
+
+// corruptingCompressor compresses like Flate, but can't decompress.
+type corruptingCompressor struct{}
+
+func (corruptingCompressor) Compress(data []byte) ([]byte, error) { return Flate.Compress(data) }
+func (corruptingCompressor) Decompress([]byte) ([]byte, error) {
+	return nil, errors.New("corrupt")
+}
+
+func TestDecompressionFailureIsAMiss(t *testing.T) {
+	var evicted []string
+	cache, _ := NewTestCache(
+		WithCompression(corruptingCompressor{}, 1),
+		WithLogger(quietLogger),
+		WithOnEvicted(func(key string, _ any, _ EvictionReason) { evicted = append(evicted, key) }),
+	)
+	value := strings.Repeat("compressible ", 20)
+	cache.Set("k", value, time.Minute)
+	if e, _ := cache.storage.Load("k"); e == nil || e.value == value {
+		t.Fatal("value wasn't stored compressed")
+	}
+
+	if got, ok := cache.Get("k"); ok || got != nil {
+		t.Errorf("Get = (%v, %v), want a miss", got, ok)
+	}
+	if cache.Has("k") {
+		t.Error("the unreadable entry was left in the cache")
+	}
+	stats := cache.Stats()
+	if stats.DecompressFailures != 1 || stats.Hits != 0 || stats.Misses != 1 {
+		t.Errorf("DecompressFailures, Hits, Misses = %d, %d, %d; want 1, 0, 1",
+			stats.DecompressFailures, stats.Hits, stats.Misses)
+	}
+	if len(evicted) != 1 || evicted[0] != "k" {
+		t.Errorf("OnEvicted saw %v, want [k]", evicted)
+	}
+
+	// GetOrSet replaces the unreadable value, as it would a missing one.
+	cache.Set("k", value, time.Minute)
+	if actual, loaded := cache.GetOrSet("k", "other", time.Minute); loaded || actual != "other" {
+		t.Errorf("GetOrSet = (%v, %v), want (other, false)", actual, loaded)
+	}
+}
@@ -0,0 +1,411 @@
+package enigmacache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A LoadSource says where a value returned by a loader-based getter
+// came from.
+type LoadSource int
+
+const (
+	// SourceHit means the value was already in the cache.
+	SourceHit LoadSource = iota
+	// SourceComputed means the loader produced the value (or the
+	// error), either in this call or in a concurrent call for the
+	// same key that this one waited on.
+	SourceComputed
+	// SourceStale means the value is one past its deadline: kept
+	// around by WithStaleIfError for when the loader fails, or served
+	// by WithStaleWhileRevalidate while a background load replaces it.
+	SourceStale
+)
+
+func (s LoadSource) String() string {
+	switch s {
+	case SourceHit:
+		return "hit"
+	case SourceComputed:
+		return "computed"
+	case SourceStale:
+		return "stale"
+	}
+	return "unknown"
+}
+
+// LoadInfo describes how a loader-based getter produced its value.
+type LoadInfo struct {
+	Source LoadSource
+	// Stale is true when the value is past its deadline; this only
+	// happens when Source is SourceStale.
+	Stale bool
+	// Shared is true when the caller didn't run the loader itself but
+	// waited on a concurrent call for the same key.
+	Shared bool
+	// Latency is how long the caller spent waiting on the loader. It
+	// is zero on a hit.
+	Latency time.Duration
+}
+
+// GetOrCompute is GetOrComputeDetailed without the LoadInfo: it
+// returns the value for key, calling loader to produce and store it
+// if it isn't present, with concurrent calls for the same missing key
+// sharing one loader invocation. A loader's error is returned to every
+// caller waiting on it, and isn't cached unless the cache was built
+// WithNegativeTTL, so the next call tries again.
+func (mc *MemoryCache) GetOrCompute(key string, ttl time.Duration, loader func(ctx context.Context) (any, error)) (any, error) {
+	value, _, err := mc.GetOrComputeDetailed(key, ttl, loader)
+	return value, err
+}
+
+// GetOrComputeDetailed returns the value for key, calling loader to
+// produce and store it (with the given ttl) if it isn't present.
+// Concurrent calls for the same missing key share a single loader
+// invocation. Loader errors are returned to every waiting caller. If
+// the cache was built WithStaleWhileRevalidate and the value is past
+// its deadline but within the window, it's returned at once, with
+// info.Stale set, while the loader runs in the background. If the
+// cache was built WithStaleIfError and an expired value for the
+// key is still within its grace period, that value is returned
+// instead, with a nil error and info.Stale set. Otherwise, if the
+// cache was built WithNegativeTTL, the error is cached and returned
+// as-is, as a hit, by calls for the key until the negative TTL runs
+// out.
+func (mc *MemoryCache) GetOrComputeDetailed(key string, ttl time.Duration, loader func(ctx context.Context) (any, error)) (value any, info LoadInfo, err error) {
+	key = mc.normalize(key)
+	return mc.compute(context.Background(), key, ttl, loader)
+}
+
+// GetOrComputeContext is GetOrCompute for a caller with a deadline:
+// if ctx is done before the value is ready, it stops waiting and
+// returns ctx.Err(), leaving the load to finish and store the value
+// for later calls. The loader is given ctx without its cancellation,
+// since other callers may be waiting on the same load, but with its
+// values, so tracing metadata reaches it.
+func (mc *MemoryCache) GetOrComputeContext(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (any, error)) (any, error) {
+	key = mc.normalize(key)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	value, _, err := mc.compute(ctx, key, ttl, loader)
+	return value, err
+}
+
+// compute does the work of GetOrComputeDetailed, giving loader ctx
+// shorn of its cancellation, since other callers may come to share
+// the load. If ctx is done before the load finishes, compute returns
+// ctx.Err() without waiting for it.
+func (mc *MemoryCache) compute(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (any, error)) (value any, info LoadInfo, err error) {
+	if r, ok := mc.result(key); ok {
+		return r.Value, LoadInfo{Source: SourceHit}, r.Err
+	}
+	if mc.closed.Load() {
+		return nil, LoadInfo{}, fmt.Errorf("load %q: %w", key, ErrClosed)
+	}
+	if value, ok := mc.revalidate(ctx, key, ttl, loader); ok {
+		return value, LoadInfo{Source: SourceStale, Stale: true}, nil
+	}
+	mc.missed(key)
+	if ctx.Done() == nil {
+		return mc.computeMissing(ctx, key, ttl, loader)
+	}
+
+	type result struct {
+		value any
+		info  LoadInfo
+		err   error
+	}
+	start := time.Now()
+	done := make(chan result, 1)
+	mc.tasks.start()
+	go func() {
+		defer mc.tasks.done()
+		value, info, err := mc.computeMissing(ctx, key, ttl, loader)
+		done <- result{value, info, err}
+	}()
+	select {
+	case r := <-done:
+		return r.value, r.info, r.err
+	case <-ctx.Done():
+		return nil, LoadInfo{Source: SourceComputed, Latency: time.Since(start)}, ctx.Err()
+	}
+}
+
+// revalidate returns the value stored for key if it's past its
+// deadline but within the cache's stale-while-revalidate window, and
+// starts a load in the background to replace it, unless one is in
+// flight already. It reports false if there's no such value.
+func (mc *MemoryCache) revalidate(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (any, error)) (any, bool) {
+	window := mc.opts.staleRevalidate
+	if window <= 0 {
+		return nil, false
+	}
+	e, ok := mc.storage.Load(key)
+	now := mc.now()
+	if !ok || !e.expired(now) || !now.Before(e.deadline().Add(window)) || e.tombstone() {
+		return nil, false
+	}
+	if _, failed := e.value.(failure); failed {
+		return nil, false
+	}
+	value, ok := mc.serve(key, e)
+	if !ok {
+		return nil, false
+	}
+	ctx = context.WithoutCancel(ctx)
+	mc.tasks.start()
+	go func() {
+		defer mc.tasks.done()
+		_, err, _ := mc.flights.do(key, func() (any, error) {
+			// A load we queued behind may have replaced e already.
+			if current, ok := mc.storage.Load(key); ok && current.live(mc.now()) {
+				return nil, nil
+			}
+			return mc.runLoader(ctx, key, ttl, loader)
+		})
+		if err != nil {
+			mc.logger().Warn("enigma-cache: background revalidation failed", "key", key, "error", err)
+		}
+	}()
+	return value, true
+}
+
+// computeMissing does the work of compute for a key which missed.
+func (mc *MemoryCache) computeMissing(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (any, error)) (value any, info LoadInfo, err error) {
+	start := time.Now()
+	value, err, info.Shared = mc.flights.do(key, func() (any, error) {
+		// Another caller may have stored the result between our miss
+		// and our turn in the flight.
+		if r, ok := mc.result(key); ok {
+			return r.Value, r.Err
+		}
+		return mc.runLoader(context.WithoutCancel(ctx), key, ttl, loader)
+	})
+	info.Latency = time.Since(start)
+	info.Source = SourceComputed
+	if err == nil {
+		return value, info, nil
+	}
+
+	if e, ok := mc.stale(key); ok {
+		if value, ok := mc.read(key, e); ok {
+			info.Source = SourceStale
+			info.Stale = true
+			return value, info, nil
+		}
+	}
+	// A timed-out loader is still running and will store its own
+	// result, so there's no failure to cache.
+	if !errors.Is(err, ErrCallbackTimeout) {
+		mc.setFailure(key, err)
+	}
+	return nil, info, err
+}
+
+// stale returns the entry stored for key if it's past its deadline but
+// within the cache's WithStaleIfError grace period, and so may be
+// served in place of a failed load. An entry merely left unswept, by a
+// long cleanup interval or a closed cache, doesn't count.
+func (mc *MemoryCache) stale(key string) (*entry, bool) {
+	grace := mc.opts.staleGrace
+	if grace <= 0 {
+		return nil, false
+	}
+	e, ok := mc.storage.Load(key)
+	now := mc.now()
+	if !ok || !e.expired(now) || !now.Before(e.deadline().Add(grace)) || e.tombstone() {
+		return nil, false
+	}
+	if _, failed := e.value.(failure); failed {
+		return nil, false
+	}
+	return e, true
+}
+
+// runLoader calls loader and stores the value it returns for key. If
+// the cache was built WithCallbackTimeout, it gives up waiting for the
+// loader after the timeout, leaving it to finish in the background.
+func (mc *MemoryCache) runLoader(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (any, error)) (any, error) {
+	return mc.callLoader(fmt.Sprintf("loader for %q", key), func() (value any, err error) {
+		if perr := mc.recovering("loader", func() {
+			value, err = loader(ctx)
+		}); perr != nil {
+			err = perr
+		}
+		if err != nil {
+			return nil, err
+		}
+		mc.Set(key, value, ttl)
+		return value, nil
+	})
+}
+
+// callLoader runs load, the work of the loader described by what,
+// as a task, applying the cache's callback timeout.
+func (mc *MemoryCache) callLoader(what string, load func() (any, error)) (any, error) {
+	if h := mc.latencies; h != nil {
+		untimed := load
+		load = func() (any, error) {
+			start := time.Now()
+			defer func() { h.add(time.Since(start)) }()
+			return untimed()
+		}
+	}
+	mc.tasks.start()
+	timeout := mc.opts.callbackTimeout
+	if timeout <= 0 {
+		defer mc.tasks.done()
+		return load()
+	}
+	// One timer, on the cache's clock, both gives up on the loader and
+	// counts it as slow, so the two can't disagree.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	timer := mc.opts.clock.AfterFunc(timeout, cancel)
+	defer timer.Stop()
+	if !mc.acquireBackground(ctx) {
+		mc.tasks.done()
+		return nil, fmt.Errorf("%w: %s waited longer than %v to start", ErrCallbackTimeout, what, timeout)
+	}
+	type result struct {
+		value any
+		err   error
+	}
+	done := make(chan result, 1)
+	mc.loaders.Add(1)
+	go func() {
+		defer mc.tasks.done()
+		defer mc.releaseBackground()
+		defer mc.loaders.Add(-1)
+		value, err := load()
+		done <- result{value, err}
+	}()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		mc.slowCallback("loader", timeout)
+		return nil, fmt.Errorf("%w: %s took longer than %v", ErrCallbackTimeout, what, timeout)
+	}
+}
+
+// GetOrComputeGroup returns the value for key, like
+// GetOrComputeDetailed, but de-duplicates loads by group rather than
+// by key: loader fetches a whole batch of keys at once, and
+// concurrent misses in the same group share one call to it. Every
+// key in the map loader returns is stored with the given ttl, and
+// each caller picks its own key's value out of the map. If the map
+// has no entry for key, GetOrComputeGroup returns an error wrapping
+// ErrNotFound. The loader is passed ctx, without its cancellation,
+// since callers other than the one whose ctx it is may be waiting on
+// the result. Loader errors are returned to every waiting caller, and
+// nothing is cached for them.
+func (mc *MemoryCache) GetOrComputeGroup(ctx context.Context, group, key string, ttl time.Duration, loader func(ctx context.Context) (map[string]any, error)) (any, error) {
+	key = mc.normalize(key)
+	if value, ok := mc.Get(key); ok {
+		return value, nil
+	}
+	if mc.closed.Load() {
+		return nil, fmt.Errorf("load %q: %w", key, ErrClosed)
+	}
+	ctx = context.WithoutCancel(ctx)
+	batch, err, _ := mc.groupFlights.do(group, func() (any, error) {
+		return mc.callLoader(fmt.Sprintf("loader for group %q", group), func() (batch any, err error) {
+			var values map[string]any
+			if perr := mc.recovering("loader", func() {
+				values, err = loader(ctx)
+			}); perr != nil {
+				err = perr
+			}
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range values {
+				mc.Set(k, v, ttl)
+			}
+			return values, nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	values := batch.(map[string]any)
+	value, ok := values[key]
+	if !ok && mc.opts.normalizeKey != nil {
+		// The loader may have used keys as they were passed in.
+		for k, v := range values {
+			if mc.normalize(k) == key {
+				value, ok = v, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("group %q: %q: %w", group, key, ErrNotFound)
+	}
+	return value, nil
+}
+
+// A Result is the outcome of loading a key: a value, or the error the
+// loader failed with.
+type Result struct {
+	Value any
+	Err   error
+}
+
+// failure is stored in place of a value to cache a loader's error.
+type failure struct {
+	err error
+}
+
+// WithNegativeTTL makes loader-based getters such as
+// GetOrComputeDetailed cache loader errors for ttl, independently of
+// the TTL successful values are cached for, so a failing backend
+// isn't hit again for every request. A cached error counts as missing
+// for Get and the other plain accessors, and is replaced by any Set.
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.negativeTTL = ttl
+	}
+}
+
+// GetResult returns the cached outcome of loading key: either a value
+// or, for a cache built WithNegativeTTL, a cached loader error. For a
+// key with a tombstone (see WithTombstones), the error is
+// ErrTombstoned.
+func (mc *MemoryCache) GetResult(key string) (Result, bool) {
+	key = mc.normalize(key)
+	if mc.tombstoned(key) {
+		return Result{Err: ErrTombstoned}, true
+	}
+	return mc.result(key)
+}
+
+// result returns the cached outcome for key, counting as an access.
+func (mc *MemoryCache) result(key string) (Result, bool) {
+	e, ok := mc.storage.Load(key)
+	if !ok || e.expired(mc.now()) || e.tombstone() {
+		return Result{}, false
+	}
+	if f, ok := e.value.(failure); ok {
+		mc.accessed(key, e)
+		return Result{Err: f.err}, true
+	}
+	value, ok := mc.read(key, e)
+	if !ok {
+		return Result{}, false
+	}
+	mc.accessed(key, e)
+	return Result{Value: value}, true
+}
+
+// setFailure caches err as the result for key, if the cache does
+// negative caching.
+func (mc *MemoryCache) setFailure(key string, err error) {
+	if ttl := mc.opts.negativeTTL; ttl > 0 {
+		mc.set(key, failure{err}, ttl, 0)
+	}
+}
@@ -0,0 +1,320 @@
+package enigmacache
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errBackend = errors.New("backend down")
+
+func failingLoader(context.Context) (any, error) {
+	return nil, errBackend
+}
+
+func TestGetOrComputeSharesOneLoad(t *testing.T) {
+	cache := NewMemoryCache()
+	release := make(chan struct{})
+	var calls atomic.Int64
+	loader := func(context.Context) (any, error) {
+		calls.Add(1)
+		<-release
+		return "value", nil
+	}
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, err := cache.GetOrCompute("k", time.Minute, loader); err != nil || value != "value" {
+				t.Errorf("GetOrCompute = %v, %v", value, err)
+			}
+		}()
+	}
+	// Give the callers time to pile up on the first one's load.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("loader called %d times, want 1", n)
+	}
+
+	if _, err := cache.GetOrCompute("bad", time.Minute, failingLoader); !errors.Is(err, errBackend) {
+		t.Fatalf("GetOrCompute = %v, want errBackend", err)
+	}
+	if value, err := cache.GetOrCompute("bad", time.Minute, loader); err != nil || value != "value" {
+		t.Errorf("GetOrCompute after a failure = %v, %v; want the error not to be cached", value, err)
+	}
+}
+
+func TestGetOrComputeDetailedSources(t *testing.T) {
+	cache := NewMemoryCache()
+	loader := func(context.Context) (any, error) { return "fresh", nil }
+
+	value, info, err := cache.GetOrComputeDetailed("key", time.Minute, loader)
+	if err != nil || value != "fresh" || info.Source != SourceComputed || info.Stale {
+		t.Fatalf("first call got (%v, %+v, %v), want a non-stale compute", value, info, err)
+	}
+
+	value, info, err = cache.GetOrComputeDetailed("key", time.Minute, failingLoader)
+	if err != nil || value != "fresh" || info.Source != SourceHit || info.Stale {
+		t.Fatalf("second call got (%v, %+v, %v), want a non-stale hit", value, info, err)
+	}
+}
+
+func TestGetOrComputeDetailedServesStaleOnError(t *testing.T) {
+	cache, clock := NewTestCache(WithStaleIfError(time.Minute))
+	cache.Set("key", "old", 10*time.Millisecond)
+	clock.Advance(20 * time.Millisecond)
+
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("Get returned an entry in its stale grace period")
+	}
+
+	value, info, err := cache.GetOrComputeDetailed("key", time.Minute, failingLoader)
+	if err != nil || value != "old" || info.Source != SourceStale || !info.Stale {
+		t.Fatalf("got (%v, %+v, %v), want the stale value", value, info, err)
+	}
+
+	// A successful load replaces the stale value and isn't stale.
+	loader := func(context.Context) (any, error) { return "new", nil }
+	value, info, err = cache.GetOrComputeDetailed("key", time.Minute, loader)
+	if err != nil || value != "new" || info.Stale {
+		t.Fatalf("got (%v, %+v, %v), want a fresh compute", value, info, err)
+	}
+}
+
+func TestGetOrComputeDetailedServesStaleOnlyWithinGrace(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"no grace":   {WithCleanupInterval(time.Hour)},
+		"past grace": {WithCleanupInterval(time.Hour), WithStaleIfError(time.Second)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cache, clock := NewTestCache(opts...)
+			cache.Set("key", "old", time.Second)
+			// The entry is past its deadline, and any grace, but
+			// hasn't been swept yet.
+			clock.Advance(3 * time.Second)
+			value, info, err := cache.GetOrComputeDetailed("key", time.Minute, failingLoader)
+			if err == nil || value != nil || info.Stale {
+				t.Fatalf("got (%v, %+v, %v), want the loader's error", value, info, err)
+			}
+		})
+	}
+}
+
+func TestGetOrComputeDetailedNoStaleWithoutGrace(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.Set("key", "old", 10*time.Millisecond)
+	clock.Advance(20 * time.Millisecond)
+
+	value, info, err := cache.GetOrComputeDetailed("key", time.Minute, failingLoader)
+	if !errors.Is(err, errBackend) || value != nil || info.Stale {
+		t.Fatalf("got (%v, %+v, %v), want the loader error", value, info, err)
+	}
+}
+
+func TestGetOrComputeDetailedCachesErrors(t *testing.T) {
+	cache, clock := NewTestCache(WithNegativeTTL(50 * time.Millisecond))
+	calls := 0
+	loader := func(context.Context) (any, error) {
+		calls++
+		return nil, errBackend
+	}
+
+	for i := 0; i < 3; i++ {
+		_, _, err := cache.GetOrComputeDetailed("key", time.Minute, loader)
+		if err != errBackend {
+			t.Fatalf("call %d: err = %v, want %v", i, err, errBackend)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("loader ran %d times, want 1", calls)
+	}
+	if r, ok := cache.GetResult("key"); !ok || r.Err != errBackend {
+		t.Fatalf("GetResult = %+v, %v", r, ok)
+	}
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("Get saw a cached error as a value")
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	cache.GetOrComputeDetailed("key", time.Minute, loader)
+	if calls != 2 {
+		t.Fatalf("loader ran %d times after the negative TTL, want 2", calls)
+	}
+}
+
+func TestGetOrComputeDetailedNoNegativeCachingByDefault(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.GetOrComputeDetailed("key", time.Minute, failingLoader)
+	if _, ok := cache.GetResult("key"); ok {
+		t.Fatal("error cached without WithNegativeTTL")
+	}
+}
+
+func TestGetOrComputeDetailedTimesOut(t *testing.T) {
+	cache, clock := NewTestCache(WithCallbackTimeout(20*time.Millisecond), WithLogger(quietLogger))
+	started, release := make(chan struct{}), make(chan struct{})
+	loader := func(context.Context) (any, error) {
+		close(started)
+		<-release
+		return "slow", nil
+	}
+
+	errs := make(chan error)
+	go func() {
+		_, _, err := cache.GetOrComputeDetailed("key", time.Minute, loader)
+		errs <- err
+	}()
+	<-started
+	clock.Advance(20 * time.Millisecond)
+	if err := <-errs; !errors.Is(err, ErrCallbackTimeout) {
+		t.Fatalf("err = %v, want ErrCallbackTimeout", err)
+	}
+	// The loader carries on in the background and stores its value.
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := cache.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := cache.Get("key"); !ok || value != "slow" {
+		t.Fatalf("Get = (%v, %v), want (slow, true)", value, ok)
+	}
+	if n := cache.Stats().SlowCallbacks; n != 1 {
+		t.Errorf("SlowCallbacks = %d, want 1", n)
+	}
+}
+
+func TestGetOrComputeGroupLoadsOnce(t *testing.T) {
+	cache := NewMemoryCache()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	loader := func(context.Context) (map[string]any, error) {
+		calls.Add(1)
+		<-release
+		return map[string]any{"a": 1, "b": 2, "c": 3}, nil
+	}
+
+	keys := []string{"a", "b", "c", "a"}
+	got := make([]any, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := cache.GetOrComputeGroup(context.Background(), "batch", key, time.Minute, loader)
+			if err != nil {
+				t.Errorf("%q: %v", key, err)
+			}
+			got[i] = value
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("loader ran %d times, want 1", n)
+	}
+	if !slices.Equal(got, []any{1, 2, 3, 1}) {
+		t.Fatalf("got %v, want [1 2 3 1]", got)
+	}
+	for key, want := range map[string]any{"a": 1, "b": 2, "c": 3} {
+		if value, ok := cache.Get(key); !ok || value != want {
+			t.Errorf("Get(%q) = (%v, %v), want (%v, true)", key, value, ok, want)
+		}
+	}
+}
+
+func TestGetOrComputeGroupMissingKey(t *testing.T) {
+	cache := NewMemoryCache()
+	loader := func(context.Context) (map[string]any, error) {
+		return map[string]any{"a": 1}, nil
+	}
+	if _, err := cache.GetOrComputeGroup(context.Background(), "batch", "z", time.Minute, loader); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestGetOrComputeContextStopsWaiting(t *testing.T) {
+	type traceKey struct{}
+	cache := NewMemoryCache()
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (any, error) {
+		<-release
+		return ctx.Value(traceKey{}), nil
+	}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), traceKey{}, "trace"))
+	cancel()
+	if _, err := cache.GetOrComputeContext(ctx, "k", time.Minute, loader); !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetOrComputeContext with a done ctx returned %v, want context.Canceled", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.WithValue(context.Background(), traceKey{}, "trace"), 10*time.Millisecond)
+	defer cancel()
+	if _, err := cache.GetOrComputeContext(ctx, "k", time.Minute, loader); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetOrComputeContext returned %v, want context.DeadlineExceeded", err)
+	}
+	// The load carries on, and stores its value, with the caller's
+	// ctx values.
+	close(release)
+	if err := cache.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := cache.Get("k"); !ok || value != "trace" {
+		t.Errorf("Get = %v, %v after the abandoned load; want trace, true", value, ok)
+	}
+}
+
+func TestGetOrComputeRevalidatesStale(t *testing.T) {
+	cache, clock := NewTestCache(WithStaleWhileRevalidate(time.Minute))
+	cache.Set("key", "old", time.Second)
+	clock.Advance(2 * time.Second)
+
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("Get returned an entry in its stale-while-revalidate window")
+	}
+	release := make(chan struct{})
+	var calls atomic.Int32
+	loader := func(context.Context) (any, error) {
+		calls.Add(1)
+		<-release
+		return "new", nil
+	}
+	for range 3 {
+		value, info, err := cache.GetOrComputeDetailed("key", time.Second, loader)
+		if err != nil || value != "old" || info.Source != SourceStale || !info.Stale {
+			t.Fatalf("got (%v, %+v, %v), want the stale value at once", value, info, err)
+		}
+	}
+	close(release)
+	if err := cache.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("loader called %d times, want once", n)
+	}
+	if value, err := cache.GetOrCompute("key", time.Second, failingLoader); err != nil || value != "new" {
+		t.Errorf("got (%v, %v) after revalidating, want new", value, err)
+	}
+
+	// A failed revalidation leaves the stale value until the hard TTL.
+	clock.Advance(2 * time.Second)
+	if value, err := cache.GetOrCompute("key", time.Second, failingLoader); err != nil || value != "new" {
+		t.Errorf("got (%v, %v), want the stale value", value, err)
+	}
+	cache.Drain(context.Background())
+	if value, _ := cache.GetOrCompute("key", time.Second, failingLoader); value != "new" {
+		t.Errorf("got %v after a failed revalidation, want the stale value", value)
+	}
+	clock.Advance(time.Minute)
+	if _, err := cache.GetOrCompute("key", time.Second, failingLoader); !errors.Is(err, errBackend) {
+		t.Errorf("got %v past the hard TTL, want the loader's error", err)
+	}
+}
@@ -0,0 +1,184 @@
+package enigmacache
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Increment atomically adds delta to the int64 stored for key and
+// returns the result. A missing key is created holding delta, expiring
+// after ttl; an existing key keeps the deadline it already has. It
+// fails with ErrTypeMismatch if the key holds something other than an
+// int64.
+func (mc *MemoryCache) Increment(key string, delta int64, ttl time.Duration) (int64, error) {
+	_, n, err := mc.increment(mc.normalize(key), delta, ttl, false)
+	return n, err
+}
+
+// Decrement atomically subtracts delta from the int64 stored for key,
+// as Increment adds it: a missing key is created holding -delta.
+func (mc *MemoryCache) Decrement(key string, delta int64, ttl time.Duration) (int64, error) {
+	_, n, err := mc.increment(mc.normalize(key), -delta, ttl, false)
+	return n, err
+}
+
+// IncrementAndRefresh is Increment, except that an existing key's
+// deadline is reset to ttl from now as well, so a counter lives until
+// it's gone ttl without being incremented.
+func (mc *MemoryCache) IncrementAndRefresh(key string, delta int64, ttl time.Duration) (int64, error) {
+	_, n, err := mc.increment(mc.normalize(key), delta, ttl, true)
+	return n, err
+}
+
+// IncrementAndCheck is Increment, also reporting whether this
+// increment took the counter from below threshold to at or above it,
+// for a side effect which should happen once however many callers
+// race past the threshold together: exactly one of them sees crossed.
+// A missing key counts as having been zero. A counter which drops
+// back below threshold (with a negative delta, or by expiring) can
+// cross it again. If the key holds something other than an int64,
+// nothing changes and IncrementAndCheck returns 0, false.
+func (mc *MemoryCache) IncrementAndCheck(key string, delta, threshold int64, ttl time.Duration) (newValue int64, crossed bool) {
+	old, n, err := mc.increment(mc.normalize(key), delta, ttl, false)
+	if err != nil {
+		return 0, false
+	}
+	return n, old < threshold && n >= threshold
+}
+
+// increment does the work of Increment, returning the counter's value
+// before the increment as well as after. With refresh set, an existing
+// counter's deadline is reset to ttl from now.
+func (mc *MemoryCache) increment(key string, delta int64, ttl time.Duration, refresh bool) (int64, int64, error) {
+	if mc.closed.Load() {
+		return 0, 0, fmt.Errorf("increment %q: %w", key, ErrClosed)
+	}
+	for {
+		prev, ok := mc.storage.Load(key)
+		if !ok || !prev.live(mc.now()) {
+			e := mc.newEntry(key, delta, ttl, 0)
+			if !ok {
+				if _, loaded := mc.storage.LoadOrStore(key, e); loaded {
+					continue
+				}
+				mc.stored(key, e, nil)
+			} else {
+				if !mc.storage.CompareAndSwap(key, prev, e) {
+					continue
+				}
+				mc.stored(key, e, prev)
+			}
+			_ = mc.writeThrough(context.Background(), key, delta, ttl)
+			return 0, delta, nil
+		}
+		old, isInt := prev.get().(int64)
+		if !isInt {
+			return 0, 0, fmt.Errorf("increment %q: %w", key, ErrTypeMismatch)
+		}
+		n := old + delta
+		e := mc.withValue(prev, key, n)
+		if refresh {
+			e.expiresAt, e.ttl = mc.deadlineAfter(mc.now(), ttl), ttl
+		}
+		if mc.storage.CompareAndSwap(key, prev, e) {
+			mc.stored(key, e, prev)
+			_ = mc.writeThrough(context.Background(), key, n, e.remaining(mc.now()))
+			return old, n, nil
+		}
+	}
+}
+
+// GetAndReset atomically returns the int64 stored for key and sets it
+// back to zero, for draining a counter kept with Increment: no
+// increment made concurrently is lost between the read and the reset.
+// The entry keeps its deadline. ok is false, and nothing changes, if
+// the key is absent or holds something other than an int64.
+func (mc *MemoryCache) GetAndReset(key string) (n int64, ok bool) {
+	key = mc.normalize(key)
+	for {
+		old, found := mc.storage.Load(key)
+		if !found || !old.live(mc.now()) {
+			return 0, false
+		}
+		n, isInt := old.get().(int64)
+		if !isInt {
+			return 0, false
+		}
+		e := mc.withValue(old, key, int64(0))
+		if mc.storage.CompareAndSwap(key, old, e) {
+			mc.stored(key, e, old)
+			_ = mc.writeThrough(context.Background(), key, int64(0), e.remaining(mc.now()))
+			return n, true
+		}
+	}
+}
+
+// Update atomically replaces the value for key with the result of
+// fn, which is passed the current value and whether the key is
+// present. If fn returns keep true, its value is stored with the
+// given ttl and returned; if keep is false the key is deleted, as
+// Expire would, and Update returns nil. Update works by
+// compare-and-swap rather than by locking the key, so fn may be
+// called more than once if the key changes underneath it; it should
+// have no side effects. A value the cache's options turn away (see
+// Set) fails with ErrRejected, or ErrValueTooLarge if it costs more
+// than the whole WithMaxMemory budget, and a panic in fn with an error
+// wrapping ErrCallbackPanicked.
+func (mc *MemoryCache) Update(key string, fn func(old any, existed bool) (new any, keep bool), ttl time.Duration) (any, error) {
+	key = mc.normalize(key)
+	if mc.closed.Load() {
+		return nil, fmt.Errorf("update %q: %w", key, ErrClosed)
+	}
+	for {
+		old, ok := mc.storage.Load(key)
+		existed := ok && old.live(mc.now())
+		var current any
+		if existed {
+			current = old.get()
+		}
+		var value any
+		var keep bool
+		if err := mc.protect("Update", func() {
+			value, keep = fn(current, existed)
+		}); err != nil {
+			return nil, err
+		}
+
+		if !keep {
+			if !ok {
+				return nil, nil
+			}
+			if mc.storage.CompareAndDelete(key, old) {
+				mc.removed(key, old, ReasonManual)
+				mc.deleteThrough(key)
+				return nil, nil
+			}
+			continue
+		}
+
+		if mc.rejects(value) {
+			return nil, fmt.Errorf("update %q: %w", key, ErrRejected)
+		}
+		e := mc.newEntry(key, value, ttl, 0)
+		if !mc.fits(e) {
+			return nil, fmt.Errorf("update %q: %d bytes: %w", key, e.cost, ErrValueTooLarge)
+		}
+		if !ok && !mc.admit(key, e.cost) {
+			return nil, fmt.Errorf("update %q: %w", key, ErrRejected)
+		}
+		if !ok {
+			if _, loaded := mc.storage.LoadOrStore(key, e); loaded {
+				continue
+			}
+			mc.stored(key, e, nil)
+		} else {
+			if !mc.storage.CompareAndSwap(key, old, e) {
+				continue
+			}
+			mc.stored(key, e, old)
+		}
+		_ = mc.writeThrough(context.Background(), key, value, ttl)
+		return value, nil
+	}
+}
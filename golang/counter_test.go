@@ -0,0 +1,203 @@
+package enigmacache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIncrement(t *testing.T) {
+	cache := NewMemoryCache()
+	for i, want := range []int64{2, 5} {
+		got, err := cache.Increment("n", int64(2+i), time.Minute)
+		if err != nil || got != want {
+			t.Fatalf("Increment = (%d, %v), want %d", got, err, want)
+		}
+	}
+
+	cache.Set("s", "text", time.Minute)
+	if _, err := cache.Increment("s", 1, time.Minute); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("Increment of a string: err = %v", err)
+	}
+}
+
+func TestDecrementAndRefresh(t *testing.T) {
+	cache, clock := NewTestCache()
+	if n, err := cache.Decrement("n", 3, time.Minute); err != nil || n != -3 {
+		t.Fatalf("Decrement of a missing key = (%d, %v), want -3", n, err)
+	}
+	if n, _ := cache.Decrement("n", -5, time.Hour); n != 2 {
+		t.Errorf("Decrement by -5 = %d, want 2", n)
+	}
+	if remaining, _ := cache.TTL("n"); remaining != time.Minute {
+		t.Errorf("TTL = %v after Decrement, want the original 1m", remaining)
+	}
+
+	clock.Advance(30 * time.Second)
+	if n, err := cache.IncrementAndRefresh("n", 1, time.Minute); err != nil || n != 3 {
+		t.Fatalf("IncrementAndRefresh = (%d, %v), want 3", n, err)
+	}
+	if remaining, _ := cache.TTL("n"); remaining != time.Minute {
+		t.Errorf("TTL = %v after IncrementAndRefresh, want 1m", remaining)
+	}
+	clock.Advance(45 * time.Second)
+	if value, ok := cache.Get("n"); !ok || value != int64(3) {
+		t.Errorf("Get = %v, %v past the original deadline; want 3, true", value, ok)
+	}
+}
+
+func TestUpdateConcurrentIncrements(t *testing.T) {
+	cache := NewMemoryCache()
+	add := func(old any, existed bool) (any, bool) {
+		if !existed {
+			return 1, true
+		}
+		return old.(int) + 1, true
+	}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 500 {
+				if _, err := cache.Update("n", add, time.Minute); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if value, _ := cache.Get("n"); value != 4000 {
+		t.Fatalf("n = %v, want 4000", value)
+	}
+}
+
+func TestUpdateConditionalDelete(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.Set("n", 100, time.Minute)
+	// Many goroutines each take one off, deleting the key on reaching
+	// zero; exactly one of them should see it reach zero.
+	var zeroes atomic.Int32
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				var hitZero bool
+				cache.Update("n", func(old any, existed bool) (any, bool) {
+					hitZero = existed && old.(int) == 1
+					if !existed {
+						return nil, false
+					}
+					return old.(int) - 1, !hitZero
+				}, time.Minute)
+				if hitZero {
+					zeroes.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if _, ok := cache.Get("n"); ok {
+		t.Fatal("key survived reaching zero")
+	}
+	if n := zeroes.Load(); n != 1 {
+		t.Fatalf("%d updates took the key to zero, want 1", n)
+	}
+}
+
+func TestUpdateRejected(t *testing.T) {
+	cache := NewMemoryCache(WithRejectNil(true))
+	_, err := cache.Update("key", func(any, bool) (any, bool) { return nil, true }, time.Minute)
+	if !errors.Is(err, ErrRejected) {
+		t.Fatalf("err = %v, want ErrRejected", err)
+	}
+}
+
+func TestGetAndResetLosesNoCounts(t *testing.T) {
+	cache := NewMemoryCache()
+	if _, ok := cache.GetAndReset("counter"); ok {
+		t.Fatal("GetAndReset found a missing key")
+	}
+	cache.Set("string", "x", time.Minute)
+	if _, ok := cache.GetAndReset("string"); ok {
+		t.Fatal("GetAndReset reset a non-counter")
+	}
+
+	const workers, increments = 8, 1000
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range increments {
+				if _, err := cache.Increment("counter", 1, time.Minute); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	var drained int64
+	go func() {
+		defer close(stopped)
+		for {
+			n, _ := cache.GetAndReset("counter")
+			drained += n
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-stopped
+	n, _ := cache.GetAndReset("counter")
+	if total := drained + n; total != workers*increments {
+		t.Fatalf("drained %d counts, want %d", total, workers*increments)
+	}
+	if ttl, ok := cache.TTL("counter"); !ok || ttl <= 50*time.Second {
+		t.Fatalf("counter's TTL = %v, %v after resets, want about a minute", ttl, ok)
+	}
+}
+
+func TestIncrementAndCheckCrossesOnce(t *testing.T) {
+	cache := NewMemoryCache()
+	const threshold = 1000
+	var crossings atomic.Int64
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 500 {
+				n, crossed := cache.IncrementAndCheck("n", 1, threshold, time.Minute)
+				if crossed {
+					crossings.Add(1)
+					if n != threshold {
+						t.Errorf("crossed at %d, want %d", n, threshold)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got := crossings.Load(); got != 1 {
+		t.Errorf("%d increments reported crossing the threshold, want 1", got)
+	}
+
+	if n, crossed := cache.IncrementAndCheck("fresh", 5, 5, time.Minute); n != 5 || !crossed {
+		t.Errorf("IncrementAndCheck of a missing key = %d, %v; want 5, true", n, crossed)
+	}
+	cache.Set("s", "text", time.Minute)
+	if n, crossed := cache.IncrementAndCheck("s", 1, 1, time.Minute); n != 0 || crossed {
+		t.Errorf("IncrementAndCheck of a string = %d, %v; want 0, false", n, crossed)
+	}
+}
@@ -0,0 +1,66 @@
+package enigmacache
+
+import "context"
+
+// closedChan is handed out by Done for keys which aren't present.
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// keyWatchers tracks the callers waiting for a key to leave the
+// cache (Done) or arrive in it (GetWait).
+type keyWatchers struct {
+	gone   waitRegistry
+	stored waitRegistry
+}
+
+// Done returns a channel which is closed when key leaves the cache,
+// for whatever reason: expiry, Expire, eviction, or ExpireAll.
+// Overwriting the key doesn't count as it leaving. If the key isn't
+// present, the channel returned is already closed. Callers waiting on
+// the same key share a channel.
+func (mc *MemoryCache) Done(key string) <-chan struct{} {
+	key = mc.normalize(key)
+	// Checking for the key under the registry's lock means a removal
+	// either happened before we looked, or will find our channel.
+	ch := mc.watchers.gone.channel(key, func() bool {
+		_, ok := mc.load(key)
+		return !ok
+	})
+	if ch == nil {
+		return closedChan
+	}
+	return ch
+}
+
+// GetWait returns the value for key like Get if it's present, and
+// otherwise waits until it's stored, returning the stored value, or
+// until ctx is done, returning false. A key which is stored and
+// removed again before GetWait gets to look at it doesn't end the
+// wait. A wait which ends with ctx leaves nothing behind.
+func (mc *MemoryCache) GetWait(ctx context.Context, key string) (value any, ok bool) {
+	key = mc.normalize(key)
+	var e *entry
+	err := mc.watchers.stored.wait(ctx, key, func() bool {
+		e, ok = mc.load(key)
+		return ok
+	})
+	if err != nil {
+		return nil, false
+	}
+	return mc.serve(key, e)
+}
+
+// removed closes the Done channel for key, which has just been
+// removed from storage, if anyone is waiting on it.
+func (w *keyWatchers) removed(key string) {
+	w.gone.fire(key)
+}
+
+// added wakes any GetWait calls waiting for key, which has just been
+// written to storage.
+func (w *keyWatchers) added(key string) {
+	w.stored.fire(key)
+}
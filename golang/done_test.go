@@ -0,0 +1,76 @@
+package enigmacache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func waitClosed(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("Done channel not closed after %s", what)
+	}
+}
+
+func TestDoneClosesOnExpiry(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.Set("lease", "held", 20*time.Millisecond)
+	a, b := cache.Done("lease"), cache.Done("lease")
+	if a != b {
+		t.Error("callers on the same key got different channels")
+	}
+	select {
+	case <-a:
+		t.Fatal("channel closed while the key was present")
+	default:
+	}
+	clock.Advance(20 * time.Millisecond)
+	waitClosed(t, a, "expiry")
+}
+
+func TestDoneClosesOnExpire(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.Set("lease", "held", time.Minute)
+	done := cache.Done("lease")
+	cache.Set("lease", "renewed", time.Minute)
+	select {
+	case <-done:
+		t.Fatal("overwrite closed the channel")
+	default:
+	}
+	cache.Expire("lease")
+	waitClosed(t, done, "Expire")
+
+	// Once the key has gone, waiting for it returns straight away.
+	waitClosed(t, cache.Done("lease"), "the key was already gone")
+}
+
+func TestGetWaitReturnsLaterWrite(t *testing.T) {
+	cache := NewMemoryCache()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cache.Set("job", "result", time.Minute)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if value, ok := cache.GetWait(ctx, "job"); !ok || value != "result" {
+		t.Fatalf("GetWait = (%v, %v), want (result, true)", value, ok)
+	}
+	// A hit returns straight away.
+	if value, ok := cache.GetWait(ctx, "job"); !ok || value != "result" {
+		t.Fatalf("GetWait on a hit = (%v, %v)", value, ok)
+	}
+}
+
+func TestGetWaitHonorsContext(t *testing.T) {
+	cache := NewMemoryCache()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, ok := cache.GetWait(ctx, "never"); ok {
+		t.Fatal("GetWait found a key nobody set")
+	}
+}
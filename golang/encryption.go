@@ -0,0 +1,145 @@
+package enigmacache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// WithEncryption encrypts the values the cache serializes, in its
+// journal (see WithPersistence) and the snapshots written by
+// SaveBinary, with AES-GCM under key, which must be 16, 24 or 32 bytes
+// long, for AES-128, AES-192 or AES-256. LoadBinary and journal replay
+// decrypt them again. Values in memory aren't encrypted, nor are keys
+// or deadlines anywhere, nor the JSON of Save and ExportNDJSON.
+//
+// Each value is stored with the ID of the key which encrypted it (see
+// KeyID), so keys can be rotated: give the keys being retired as old,
+// and values encrypted under them can still be read, while everything
+// written from then on is encrypted under key. The journal is
+// rewritten under key as soon as it's compacted, which is when the
+// cache is built; a snapshot is once it's reloaded and saved again.
+//
+// WithEncryption panics if a key is of the wrong length, or two keys
+// share an ID.
+func WithEncryption(key []byte, old ...[]byte) Option {
+	s, err := NewSealer(key, old...)
+	if err != nil {
+		panic("enigma-cache: WithEncryption: " + err.Error())
+	}
+	return func(o *options) {
+		o.sealer = s
+	}
+}
+
+// A Sealer encrypts serialized values with AES-GCM, as WithEncryption
+// does, under the first of its keys, and decrypts them under whichever
+// of its keys encrypted them. It's for encrypting values kept outside
+// the cache, as the redis package does. It's safe for concurrent use.
+//
+// A sealed value is the key's ID as a big-endian uint32, then a random
+// 12-byte nonce, then the ciphertext and its tag; the ID is
+// authenticated along with the ciphertext.
+type Sealer struct {
+	id    uint32
+	aeads map[uint32]cipher.AEAD
+}
+
+// NewSealer returns a Sealer encrypting under key and decrypting under
+// key or any of old. It fails if a key is of the wrong length for AES,
+// or two keys share an ID.
+func NewSealer(key []byte, old ...[]byte) (*Sealer, error) {
+	s := &Sealer{id: KeyID(key), aeads: make(map[uint32]cipher.AEAD)}
+	for _, k := range append([][]byte{key}, old...) {
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		id := KeyID(k)
+		if _, ok := s.aeads[id]; ok {
+			return nil, fmt.Errorf("two keys with ID %08x", id)
+		}
+		s.aeads[id] = aead
+	}
+	return s, nil
+}
+
+// KeyID returns the ID stored alongside values encrypted under key:
+// the first four bytes of its SHA-256 hash, which tell keys apart
+// without giving them away.
+func KeyID(key []byte) uint32 {
+	sum := sha256.Sum256(key)
+	return binary.BigEndian.Uint32(sum[:])
+}
+
+// Seal encrypts plaintext.
+func (s *Sealer) Seal(plaintext []byte) []byte {
+	aead := s.aeads[s.id]
+	sealed := binary.BigEndian.AppendUint32(nil, s.id)
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	sealed = append(sealed, nonce...)
+	return aead.Seal(sealed, nonce, plaintext, sealed[:4])
+}
+
+// Open decrypts a value encrypted by Seal, under any of the Sealer's
+// keys. It fails with an error wrapping ErrDecrypt if the value wasn't
+// encrypted under one of them, or has been tampered with.
+func (s *Sealer) Open(sealed []byte) ([]byte, error) {
+	if len(sealed) < 4 {
+		return nil, fmt.Errorf("%w: too short", ErrDecrypt)
+	}
+	id := binary.BigEndian.Uint32(sealed)
+	aead, ok := s.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %08x", ErrDecrypt, id)
+	}
+	if len(sealed) < 4+aead.NonceSize() {
+		return nil, fmt.Errorf("%w: too short", ErrDecrypt)
+	}
+	nonce, ciphertext := sealed[4:4+aead.NonceSize()], sealed[4+aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, sealed[:4])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+	return plaintext, nil
+}
+
+// sealing returns encode, encrypting what it returns if the cache was
+// built WithEncryption.
+func (mc *MemoryCache) sealing(encode func(value any) ([]byte, error)) func(value any) ([]byte, error) {
+	s := mc.opts.sealer
+	if s == nil {
+		return encode
+	}
+	return func(value any) ([]byte, error) {
+		data, err := encode(value)
+		if err != nil {
+			return nil, err
+		}
+		return s.Seal(data), nil
+	}
+}
+
+// opening returns decode, decrypting what it's given first if the
+// cache was built WithEncryption.
+func (mc *MemoryCache) opening(decode func(data []byte) (any, error)) func(data []byte) (any, error) {
+	s := mc.opts.sealer
+	if s == nil {
+		return decode
+	}
+	return func(data []byte) (any, error) {
+		data, err := s.Open(data)
+		if err != nil {
+			return nil, err
+		}
+		return decode(data)
+	}
+}
@@ -0,0 +1,124 @@
+package enigmacache
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 16)
+)
+
+func TestSealer(t *testing.T) {
+	old, err := NewSealer(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := old.Seal([]byte("secret"))
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Error("sealed value holds the plaintext")
+	}
+	if bytes.Equal(sealed, old.Seal([]byte("secret"))) {
+		t.Error("sealing twice gave the same ciphertext")
+	}
+
+	rotated, err := NewSealer(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, err := rotated.Open(sealed); err != nil || string(plaintext) != "secret" {
+		t.Errorf("Open under a retired key = %q, %v; want secret, nil", plaintext, err)
+	}
+	if _, err := old.Open(rotated.Seal([]byte("secret"))); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Open under an unknown key returned %v, want ErrDecrypt", err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := rotated.Open(sealed); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Open of a tampered value returned %v, want ErrDecrypt", err)
+	}
+	if _, err := rotated.Open(sealed[:3]); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Open of a truncated value returned %v, want ErrDecrypt", err)
+	}
+
+	if _, err := NewSealer([]byte("short")); err == nil {
+		t.Error("NewSealer accepted a 5-byte key")
+	}
+	if _, err := NewSealer(oldKey, oldKey); err == nil {
+		t.Error("NewSealer accepted the same key twice")
+	}
+}
+
+func TestEncryptedSnapshot(t *testing.T) {
+	cache, clock := NewTestCache(WithEncryption(oldKey))
+	cache.Set("token", "hunter2", time.Hour)
+	var buf bytes.Buffer
+	if err := cache.SaveBinary(&buf, GobEncode); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("hunter2")) {
+		t.Error("snapshot holds the value in the clear")
+	}
+	snapshot := buf.Bytes()
+
+	rotated := NewMemoryCache(WithClock(clock), WithEncryption(newKey, oldKey))
+	if n, err := rotated.LoadBinary(bytes.NewReader(snapshot), GobDecode); n != 1 || err != nil {
+		t.Fatalf("LoadBinary = %d, %v; want 1, nil", n, err)
+	}
+	if value, _ := rotated.Get("token"); value != "hunter2" {
+		t.Errorf("Get(token) = %v, want hunter2", value)
+	}
+
+	other := NewMemoryCache(WithClock(clock), WithEncryption(newKey))
+	if _, err := other.LoadBinary(bytes.NewReader(snapshot), GobDecode); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("LoadBinary without the key returned %v, want ErrDecrypt", err)
+	}
+	plain := NewMemoryCache(WithClock(clock))
+	if _, err := plain.LoadBinary(bytes.NewReader(snapshot), GobDecode); err == nil {
+		t.Error("LoadBinary of an encrypted snapshot into a plain cache succeeded")
+	}
+}
+
+func TestEncryptedPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.journal")
+	cache, clock := NewTestCache(WithPersistence(path, SyncAlways), WithEncryption(oldKey))
+	cache.Set("ssn", "078-05-1120", time.Hour)
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("078-05-1120")) {
+		t.Error("journal holds the value in the clear")
+	}
+
+	// Replaying under a rotated key reads the old values and rewrites
+	// them under the new one.
+	rotated := NewMemoryCache(WithClock(clock), WithPersistence(path, SyncAlways), WithEncryption(newKey, oldKey))
+	if value, _ := rotated.Get("ssn"); value != "078-05-1120" {
+		t.Errorf("Get(ssn) = %v after a rotated replay, want 078-05-1120", value)
+	}
+	if err := rotated.Close(); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewMemoryCache(WithClock(clock), WithPersistence(path, SyncAlways), WithEncryption(newKey))
+	defer restarted.Close()
+	if value, _ := restarted.Get("ssn"); value != "078-05-1120" {
+		t.Errorf("Get(ssn) = %v after dropping the retired key, want 078-05-1120", value)
+	}
+}
+
+func TestWithEncryptionPanicsOnBadKey(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("WithEncryption accepted a 7-byte key")
+		}
+	}()
+	WithEncryption([]byte("too few"))
+}
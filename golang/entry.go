@@ -0,0 +1,204 @@
+package enigmacache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// An entry is what we actually keep in storage for each key. Entries
+// are never modified in place once stored (apart from access
+// bookkeeping): every write, including a TTL refresh, stores a fresh
+// *entry with a new generation, so a pending expiration can tell
+// whether the entry it was scheduled for is still the live one.
+//
+// Entries aren't pooled for reuse: readers load them from storage
+// without any lock, so one may still be reading an entry after it has
+// been overwritten or removed, and a recycled entry would hand it
+// another key's value.
+type entry struct {
+	// value is the value as stored, which may be compressed; use get
+	// to read it.
+	value any
+	// cost is the estimated memory the entry takes up, in bytes.
+	cost int64
+	// gen is taken from a cache-wide counter when the entry is
+	// written, so it strictly increases across writes to a key.
+	gen uint64
+	// expiresAt is the hard deadline for the entry; reads never move
+	// it.
+	expiresAt time.Time
+	// ttl is the TTL the hard deadline was last set from, before any
+	// jitter, for Touch. In-place updates such as Increment carry it
+	// over.
+	ttl time.Duration
+	// created is the UnixNano time the key was written. Refreshes and
+	// in-place updates such as Increment carry it over.
+	created int64
+	// idle, if non-zero, expires the entry early when it hasn't been
+	// read for that long.
+	idle time.Duration
+	// lastAccess is the UnixNano time of the last read (or the write,
+	// if it has never been read).
+	lastAccess atomic.Int64
+	// accesses counts the reads of the key since it was written.
+	// Refreshes and in-place updates carry it over, as they do
+	// created.
+	accesses atomic.Uint64
+	// expiry is the entry's place in the cache's expiryScheduler, if
+	// it's scheduled. It's guarded by the scheduler's lock.
+	expiry *scheduledExpiry
+	// done, if set, is called once the entry leaves the cache (see
+	// SetWithCancel). TTL refreshes carry it over.
+	done func()
+	// meta is the metadata stored by SetWithMeta, if any. TTL
+	// refreshes carry it over.
+	meta *Meta
+}
+
+func (mc *MemoryCache) newEntry(key string, value any, ttl, idle time.Duration) *entry {
+	now := mc.now()
+	packed := mc.pack(value)
+	e := &entry{
+		value:     packed,
+		cost:      mc.cost(key, value, packed),
+		gen:       mc.generation.Add(1),
+		expiresAt: mc.deadlineAfter(now, ttl),
+		ttl:       ttl,
+		created:   now.UnixNano(),
+		idle:      idle,
+	}
+	e.lastAccess.Store(now.UnixNano())
+	return e
+}
+
+// withTTL returns a copy of the entry, under a new generation, whose
+// hard deadline is ttl from now. Access bookkeeping carries over.
+func (mc *MemoryCache) withTTL(e *entry, ttl time.Duration) *entry {
+	c := mc.withDeadline(e, mc.deadlineAfter(mc.now(), ttl))
+	c.ttl = ttl
+	return c
+}
+
+// withDeadline is withTTL, with the hard deadline given outright. The
+// TTL Touch uses carries over.
+func (mc *MemoryCache) withDeadline(e *entry, expiresAt time.Time) *entry {
+	c := &entry{
+		value:     e.value,
+		cost:      e.cost,
+		gen:       mc.generation.Add(1),
+		expiresAt: expiresAt,
+		ttl:       e.ttl,
+		created:   e.created,
+		idle:      e.idle,
+		done:      e.done,
+		meta:      e.meta,
+	}
+	c.lastAccess.Store(e.lastAccess.Load())
+	c.accesses.Store(e.accesses.Load())
+	return c
+}
+
+// withValue returns a copy of the entry, under a new generation,
+// holding value in place of the old one. The deadlines carry over.
+func (mc *MemoryCache) withValue(e *entry, key string, value any) *entry {
+	packed := mc.pack(value)
+	c := &entry{
+		value:     packed,
+		cost:      mc.cost(key, value, packed),
+		gen:       mc.generation.Add(1),
+		expiresAt: e.expiresAt,
+		ttl:       e.ttl,
+		created:   e.created,
+		idle:      e.idle,
+	}
+	c.lastAccess.Store(e.lastAccess.Load())
+	c.accesses.Store(e.accesses.Load())
+	return c
+}
+
+// get returns the entry's value as it was written, or nil if it was
+// compressed and fails to decompress, for the cache's own use; reads
+// for callers go through read, which deals with the failure.
+func (e *entry) get() any {
+	value, err := unpack(e.value)
+	if err != nil {
+		return nil
+	}
+	return value
+}
+
+// read returns the value of e, key's entry, for handing to a caller,
+// cloned if the cache was built WithGetClone. It reports false if the
+// value fails to decompress (see unreadable), in which case the read
+// should go as a miss.
+func (mc *MemoryCache) read(key string, e *entry) (any, bool) {
+	value, err := unpack(e.value)
+	if err != nil {
+		mc.unreadable(key, e, err)
+		return nil, false
+	}
+	if clone := mc.opts.clone; clone != nil {
+		cloned := value
+		mc.protect("clone", func() { cloned = clone(value) })
+		return cloned, true
+	}
+	return value, true
+}
+
+// serve returns the value of e, key's live entry, for a read, doing
+// the bookkeeping for a hit, or for a miss if the value can't be read.
+func (mc *MemoryCache) serve(key string, e *entry) (any, bool) {
+	value, ok := mc.read(key, e)
+	if !ok {
+		mc.missed(key)
+		return nil, false
+	}
+	mc.accessed(key, e)
+	return value, true
+}
+
+// deadline returns the point at which the entry should be removed:
+// the hard deadline, or the idle deadline if that comes first.
+func (e *entry) deadline() time.Time {
+	if e.idle <= 0 {
+		return e.expiresAt
+	}
+	idleAt := time.Unix(0, e.lastAccess.Load()).Add(e.idle)
+	if idleAt.Before(e.expiresAt) {
+		return idleAt
+	}
+	return e.expiresAt
+}
+
+// remaining returns how long the entry has left before its deadline
+// as of now, or zero if the deadline has passed.
+func (e *entry) remaining(now time.Time) time.Duration {
+	deadline := e.deadline()
+	if deadline.Equal(never) {
+		return NoExpiration
+	}
+	return max(deadline.Sub(now), 0)
+}
+
+// live reports whether the entry holds a value which reads should
+// see: it's within its deadline as of now and isn't a cached loader
+// failure.
+func (e *entry) live(now time.Time) bool {
+	if e.expired(now) {
+		return false
+	}
+	switch e.value.(type) {
+	case failure, tombstone:
+		return false
+	}
+	return true
+}
+
+// expired reports whether the entry's deadline has passed as of now.
+func (e *entry) expired(now time.Time) bool {
+	return !now.Before(e.deadline())
+}
+
+func (e *entry) touch(now time.Time) {
+	e.lastAccess.Store(now.UnixNano())
+}
@@ -0,0 +1,85 @@
+package enigmacache
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the cache. Callers should match them with
+// errors.Is, since they're usually wrapped with more detail:
+//
+//   - GetAs returns ErrNotFound for a missing key, ErrTombstoned for
+//     one deleted from a cache built WithTombstones, and
+//     ErrTypeMismatch when the stored value isn't of the requested
+//     type. GetResult reports ErrTombstoned too.
+//   - GetOrComputeDetailed returns the loader's error unchanged, one
+//     wrapping ErrCallbackPanicked if the loader panicked, or one
+//     wrapping ErrCallbackTimeout if it was too slow.
+//   - Increment returns ErrTypeMismatch when the key holds something
+//     other than an int64.
+//   - GetOrComputeGroup returns ErrNotFound if the loader's batch
+//     doesn't include the key asked for, and otherwise behaves like
+//     GetOrComputeDetailed.
+//   - Update and SetContext return ErrRejected when the cache's
+//     options turn away the new value, and ErrValueTooLarge, which
+//     matches ErrRejected too, when it costs more than the whole
+//     WithMaxMemory budget. ByteCache.Set returns ErrValueTooLarge for
+//     a value bigger than its largest slab class.
+//   - AddToSet returns ErrTypeMismatch when the key holds something
+//     other than a set, and ErrRejected or ErrValueTooLarge when the
+//     cache's options turn away the set.
+//   - Drain, WaitForSize and DoContext return ctx.Err() when their
+//     context is done first.
+//   - Demote returns ErrNoStore for a cache without a write-through
+//     store, and otherwise the store's errors.
+//   - LoadBinary returns ErrSnapshotVersion for a snapshot in a
+//     format version it doesn't know, and ErrCorruptSnapshot for one
+//     which is truncated or fails its checksum, and, for a cache
+//     built WithEncryption, ErrDecrypt for a value which won't
+//     decrypt. Sealer.Open returns ErrDecrypt too.
+//   - WithinShard returns ErrNotSharded on an unsharded cache and
+//     ErrCrossShard when its keys span shards, or else fn's error.
+//   - Once the cache is closed (see Close), the methods which load or
+//     write values and can report errors, such as
+//     GetOrComputeDetailed on a miss, SetContext, Update, Increment,
+//     AddToSet and LoadBinary, return ErrClosed.
+var (
+	ErrNotFound     = errors.New("enigma-cache: key not found")
+	ErrTypeMismatch = errors.New("enigma-cache: value has unexpected type")
+	ErrNotSharded   = errors.New("enigma-cache: cache is not sharded")
+	ErrCrossShard   = errors.New("enigma-cache: keys span more than one shard")
+	ErrRejected     = errors.New("enigma-cache: value rejected")
+	ErrTombstoned   = errors.New("enigma-cache: key was deleted")
+	ErrNoStore      = errors.New("enigma-cache: cache has no write-through store")
+	ErrClosed       = errors.New("enigma-cache: cache is closed")
+
+	// ErrValueTooLarge wraps ErrRejected, being one way of being turned
+	// away.
+	ErrValueTooLarge = fmt.Errorf("%w: value too large", ErrRejected)
+
+	ErrCorruptSnapshot = errors.New("enigma-cache: corrupt snapshot")
+	ErrSnapshotVersion = errors.New("enigma-cache: unsupported snapshot version")
+	ErrDecrypt         = errors.New("enigma-cache: value failed to decrypt")
+
+	ErrCallbackPanicked = errors.New("enigma-cache: callback panicked")
+	ErrCallbackTimeout  = errors.New("enigma-cache: callback timed out")
+)
+
+// GetAs returns the value stored for key as a T. It fails with
+// ErrNotFound if the key isn't present (or ErrTombstoned if it has a
+// tombstone) and ErrTypeMismatch if the value isn't a T.
+func GetAs[T any](mc *MemoryCache, key string) (T, error) {
+	var zero T
+	value, ok := mc.Get(key)
+	if !ok {
+		if mc.tombstoned(key) {
+			return zero, fmt.Errorf("%w: %q", ErrTombstoned, key)
+		}
+		return zero, fmt.Errorf("%w: %q", ErrNotFound, key)
+	}
+	typed, ok := value.(T)
+	if !ok {
+		return zero, fmt.Errorf("%w: %q holds %T, not %T", ErrTypeMismatch, key, value, zero)
+	}
+	return typed, nil
+}
@@ -0,0 +1,40 @@
+package enigmacache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGetAs(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.Set("answer", 42, time.Minute)
+
+	if n, err := GetAs[int](cache, "answer"); err != nil || n != 42 {
+		t.Fatalf("GetAs[int] got (%v, %v), want (42, nil)", n, err)
+	}
+	if _, err := GetAs[string](cache, "answer"); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("GetAs[string] returned %v, want ErrTypeMismatch", err)
+	}
+	if _, err := GetAs[int](cache, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetAs on a missing key returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestValueTooLarge(t *testing.T) {
+	cache := NewMemoryCache(WithMaxMemory(1 << 10))
+	big := make([]byte, 2<<10)
+	if err := cache.SetContext(context.Background(), "k", big, time.Minute); !errors.Is(err, ErrValueTooLarge) || !errors.Is(err, ErrRejected) {
+		t.Errorf("SetContext of an oversized value returned %v, want ErrValueTooLarge", err)
+	}
+	if _, err := cache.Update("k", func(any, bool) (any, bool) { return big, true }, time.Minute); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("Update to an oversized value returned %v, want ErrValueTooLarge", err)
+	}
+	if cache.Has("k") || cache.Stats().Rejected != 2 {
+		t.Errorf("Has = %v and Rejected = %d, want false and 2", cache.Has("k"), cache.Stats().Rejected)
+	}
+	if err := cache.SetContext(context.Background(), "k", big[:10], time.Minute); err != nil {
+		t.Errorf("SetContext of a small value returned %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package enigmacache
+
+import (
+	"sync"
+	"time"
+)
+
+// An EvictionReason says why an entry left the cache.
+type EvictionReason int
+
+const (
+	// ReasonExpired means the entry's TTL (or idle timeout) elapsed.
+	ReasonExpired EvictionReason = iota
+	// ReasonManual means the entry was removed explicitly, e.g. by
+	// Expire or Pop.
+	ReasonManual
+	// ReasonCapacity means the entry was evicted to make room in a
+	// full cache.
+	ReasonCapacity
+	// ReasonCleared means the entry was removed by ExpireAll.
+	ReasonCleared
+	// ReasonDemoted means the entry was moved to the write-through
+	// store by Demote.
+	ReasonDemoted
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case ReasonExpired:
+		return "expired"
+	case ReasonManual:
+		return "manual"
+	case ReasonCapacity:
+		return "capacity"
+	case ReasonCleared:
+		return "cleared"
+	case ReasonDemoted:
+		return "demoted"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the reason as its name, as String gives it.
+func (r EvictionReason) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// An EvictionRecord describes one entry leaving the cache.
+type EvictionRecord struct {
+	Key    string
+	Reason EvictionReason
+	At     time.Time
+}
+
+// WithEvictionLog makes the cache remember the last size entries to
+// leave it, for any reason, readable with RecentEvictions. It's
+// cheap, and handy for telling capacity thrash apart from TTL churn.
+func WithEvictionLog(size int) Option {
+	return func(o *options) {
+		o.evictionLog = size
+	}
+}
+
+// evictionLog is a fixed-size ring of EvictionRecords.
+type evictionLog struct {
+	mu      sync.Mutex
+	records []EvictionRecord
+	// next is the index the next record goes in; once the ring has
+	// wrapped it's also the oldest record.
+	next    int
+	wrapped bool
+}
+
+func newEvictionLog(size int) *evictionLog {
+	return &evictionLog{records: make([]EvictionRecord, size)}
+}
+
+func (l *evictionLog) add(r EvictionRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records[l.next] = r
+	l.next++
+	if l.next == len(l.records) {
+		l.next = 0
+		l.wrapped = true
+	}
+}
+
+// RecentEvictions returns the entries which most recently left the
+// cache, oldest first. It returns nil unless the cache was built
+// WithEvictionLog.
+func (mc *MemoryCache) RecentEvictions() []EvictionRecord {
+	l := mc.evictions
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.wrapped {
+		return append([]EvictionRecord(nil), l.records[:l.next]...)
+	}
+	out := make([]EvictionRecord, 0, len(l.records))
+	out = append(out, l.records[l.next:]...)
+	return append(out, l.records[:l.next]...)
+}
@@ -0,0 +1,53 @@
+package enigmacache
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEvictionLogRecordsCapacityVictims(t *testing.T) {
+	cache := NewMemoryCache(WithMaxEntries(2), WithEvictionLog(3))
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		cache.Set(key, key, time.Minute)
+	}
+
+	var keys []string
+	for _, r := range cache.RecentEvictions() {
+		if r.Reason != ReasonCapacity {
+			t.Errorf("%q evicted for %v, want %v", r.Key, r.Reason, ReasonCapacity)
+		}
+		keys = append(keys, r.Key)
+	}
+	if want := []string{"a", "b", "c"}; !slices.Equal(keys, want) {
+		t.Fatalf("evicted %v, want %v", keys, want)
+	}
+}
+
+func TestEvictionLogWraps(t *testing.T) {
+	cache := NewMemoryCache(WithEvictionLog(2))
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, key, time.Minute)
+		cache.Expire(key)
+	}
+
+	var keys []string
+	for _, r := range cache.RecentEvictions() {
+		if r.Reason != ReasonManual {
+			t.Errorf("%q removed for %v, want %v", r.Key, r.Reason, ReasonManual)
+		}
+		keys = append(keys, r.Key)
+	}
+	if want := []string{"b", "c"}; !slices.Equal(keys, want) {
+		t.Fatalf("log holds %v, want the last two removals %v", keys, want)
+	}
+}
+
+func TestNoEvictionLogByDefault(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.Set("key", "value", time.Minute)
+	cache.Expire("key")
+	if log := cache.RecentEvictions(); log != nil {
+		t.Fatalf("RecentEvictions = %v, want nil", log)
+	}
+}
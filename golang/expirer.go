@@ -0,0 +1,66 @@
+package enigmacache
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// An Expirer is a value which knows when it stops being valid, such
+// as a token with an embedded expiry.
+type Expirer interface {
+	ExpiresAt() time.Time
+}
+
+// ValueTTL, passed as the TTL to Set, SetWithIdle, SetContext or
+// GetOrSet, has the cache work out the key's TTL itself: from the
+// cache's WithTTLFunc function, if it has one, or else from the
+// value's own deadline, in which case the value must be an Expirer. A
+// value whose TTL can't be worked out isn't stored. Any other TTL is
+// used as given, even if the value is an Expirer or the cache has a
+// TTL function: the caller's TTL wins.
+const ValueTTL time.Duration = math.MinInt64
+
+// WithTTLFunc sets the function which decides the TTL for writes
+// passing ValueTTL, and for SetAuto, from the key and value, so the
+// lifetimes of different kinds of value can be set in one place. It
+// takes over from the values' own ExpiresAt methods, though it's free
+// to consult them itself. If fn panics, the value isn't stored, as
+// for one whose TTL can't be worked out (see WithPanicRecovery).
+func WithTTLFunc(fn func(key string, value any) time.Duration) Option {
+	return func(o *options) {
+		o.ttlFunc = fn
+	}
+}
+
+// SetAuto stores value for key with the TTL worked out by the cache,
+// like Set with ValueTTL, and reports whether it was stored. A value
+// whose TTL can't be worked out isn't stored, and neither is one
+// whose TTL has already run out.
+func (mc *MemoryCache) SetAuto(key string, value any) bool {
+	key = mc.normalize(key)
+	ttl, ok := mc.valueTTL(key, value, ValueTTL)
+	if !ok || ttl <= 0 || !mc.set(key, value, ttl, 0) {
+		return false
+	}
+	_ = mc.writeThrough(context.Background(), key, value, ttl)
+	return true
+}
+
+// valueTTL resolves ttl for writing value to key, reporting false if
+// the TTL is ValueTTL but none can be worked out, or if the TTL is
+// over the cache's WithMaxTTL limit and such writes are turned away.
+func (mc *MemoryCache) valueTTL(key string, value any, ttl time.Duration) (time.Duration, bool) {
+	if ttl == ValueTTL {
+		if fn := mc.opts.ttlFunc; fn != nil {
+			if mc.protect("TTL function", func() { ttl = fn(key, value) }) != nil {
+				return 0, false
+			}
+		} else if x, ok := value.(Expirer); ok {
+			ttl = x.ExpiresAt().Sub(mc.now())
+		} else {
+			return 0, false
+		}
+	}
+	return mc.capTTL(ttl)
+}
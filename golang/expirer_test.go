@@ -0,0 +1,96 @@
+package enigmacache
+
+import (
+	"testing"
+	"time"
+)
+
+type token struct {
+	exp time.Time
+}
+
+func (t token) ExpiresAt() time.Time { return t.exp }
+
+func TestSetAutoUsesValueDeadline(t *testing.T) {
+	cache, clock := NewTestCache()
+	if !cache.SetAuto("token", token{clock.Now().Add(50 * time.Millisecond)}) {
+		t.Fatal("SetAuto refused an Expirer")
+	}
+	if ttl, ok := cache.TTL("token"); !ok || ttl != 50*time.Millisecond {
+		t.Fatalf("TTL = (%v, %v), want 50ms", ttl, ok)
+	}
+	clock.Advance(50 * time.Millisecond)
+	if _, ok := cache.Get("token"); ok {
+		t.Fatal("token outlived its own deadline")
+	}
+}
+
+func TestSetAutoRefuses(t *testing.T) {
+	cache := NewMemoryCache()
+	if cache.SetAuto("plain", "value") {
+		t.Error("SetAuto stored a value which isn't an Expirer")
+	}
+	if cache.SetAuto("stale", token{time.Now().Add(-time.Second)}) {
+		t.Error("SetAuto stored an already-expired token")
+	}
+	if cache.Len() != 0 {
+		t.Errorf("Len = %d, want 0", cache.Len())
+	}
+}
+
+func TestValueTTL(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.Set("token", token{time.Now().Add(time.Hour)}, ValueTTL)
+	if ttl, ok := cache.TTL("token"); !ok || ttl < 59*time.Minute {
+		t.Fatalf("TTL = (%v, %v), want about an hour", ttl, ok)
+	}
+
+	// An explicit TTL wins over the value's own deadline.
+	cache.Set("token", token{time.Now().Add(time.Hour)}, time.Minute)
+	if ttl, _ := cache.TTL("token"); ttl > time.Minute {
+		t.Fatalf("TTL = %v, want the explicit minute", ttl)
+	}
+
+	cache.Set("plain", "value", ValueTTL)
+	if _, ok := cache.Get("plain"); ok {
+		t.Fatal("ValueTTL stored a value which isn't an Expirer")
+	}
+}
+
+func TestTTLFunc(t *testing.T) {
+	cache, clock := NewTestCache(WithTTLFunc(func(key string, value any) time.Duration {
+		switch value.(type) {
+		case string:
+			return time.Minute
+		case int:
+			return time.Hour
+		}
+		return 0
+	}))
+	if !cache.SetAuto("name", "value") || !cache.SetAuto("count", 1) {
+		t.Fatal("SetAuto refused a value with a TTL")
+	}
+	if cache.SetAuto("other", 1.5) {
+		t.Fatal("SetAuto stored a value the TTL func gave no TTL")
+	}
+	cache.GetOrSet("counted", 2, ValueTTL)
+	cache.Set("explicit", "value", time.Second)
+
+	clock.Advance(time.Second)
+	if _, ok := cache.Get("explicit"); ok {
+		t.Fatal("the TTL func overrode an explicit TTL")
+	}
+	clock.Advance(time.Minute)
+	if _, ok := cache.Get("name"); ok {
+		t.Fatal("string outlived its minute")
+	}
+	for _, key := range []string{"count", "counted"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Fatalf("int %q expired before its hour", key)
+		}
+	}
+	clock.Advance(time.Hour)
+	if cache.Len() != 0 {
+		t.Fatalf("Len = %d after an hour, want 0", cache.Len())
+	}
+}
@@ -0,0 +1,222 @@
+package enigmacache
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// An expiryScheduler keeps the cache's pending expirations in a heap
+// ordered by when each entry is due to leave storage, with a single
+// timer from the cache's clock armed for the earliest of them, rather
+// than a timer per entry. When the timer fires, everything due is
+// taken off the heap and expired, and the timer is armed again for
+// whatever's next. Entries which leave storage by some other route,
+// including being overwritten, are taken off the heap straight away.
+type expiryScheduler struct {
+	mu      sync.Mutex
+	pending expiryHeap
+	// timer is armed for the earliest pending expiration, at armedAt,
+	// if there is one.
+	timer   Timer
+	armedAt time.Time
+	// closed is set by Close, after which nothing is scheduled.
+	closed bool
+}
+
+// WithCleanupInterval batches the removal of expired entries, running
+// it at most once every d rather than as each entry comes due, for a
+// cache with expirations spread thinly enough that waking up for each
+// costs more than it's worth. Entries past their deadline are treated
+// as missing all the same, so only how long they take up memory is
+// affected: up to d longer than without. A d of zero or less, the
+// default, removes each entry as it comes due.
+func WithCleanupInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.cleanupInterval = d
+	}
+}
+
+// A scheduledExpiry is an entry waiting in the scheduler's heap.
+type scheduledExpiry struct {
+	key string
+	e   *entry
+	at  time.Time
+	// index is the expiry's place in the heap, kept up to date by the
+	// heap so cancel can remove it.
+	index int
+}
+
+type expiryHeap []*scheduledExpiry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x any) {
+	s := x.(*scheduledExpiry)
+	s.index = len(*h)
+	*h = append(*h, s)
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	s := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return s
+}
+
+// schedule arranges for the given entry to be removed once its
+// deadline (plus any stale grace period) passes. Reads may push an
+// idle deadline out after the entry is scheduled, so when it comes
+// due we re-check and reschedule rather than delete an entry that's
+// still in use.
+func (mc *MemoryCache) schedule(key string, e *entry) {
+	if e.deadline().Equal(never) {
+		// It never expires, so there's nothing to schedule.
+		return
+	}
+	s := &mc.expiry
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	x := &scheduledExpiry{key: key, e: e, at: mc.now().Add(mc.untilRemoval(e))}
+	e.expiry = x
+	heap.Push(&s.pending, x)
+	mc.timers.Add(1)
+	if x.index == 0 {
+		mc.arm()
+	}
+}
+
+// cancel stops e's pending expiration. It's called whenever an entry
+// leaves storage by some other route, so we don't keep expirations
+// around for entries nobody can see.
+func (mc *MemoryCache) cancel(e *entry) {
+	s := &mc.expiry
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	x := e.expiry
+	if x == nil {
+		return
+	}
+	e.expiry = nil
+	heap.Remove(&s.pending, x.index)
+	mc.timers.Add(-1)
+	// The timer is left armed even if x was the earliest; it finds
+	// nothing due when it fires and arms itself for what's next.
+}
+
+// arm makes sure the scheduler's timer is set for its earliest pending
+// expiration, replacing it if it's set for later. mc.expiry.mu must be
+// held.
+func (mc *MemoryCache) arm() {
+	s := &mc.expiry
+	if len(s.pending) == 0 {
+		return
+	}
+	at := s.pending[0].at
+	if d := mc.opts.cleanupInterval; d > 0 {
+		// Round up to the next tick of the cleanup interval, counting
+		// from when the cache was built, so expirations due between
+		// ticks share one timer.
+		if since := at.Sub(mc.created); since < NoExpiration-d {
+			at = mc.created.Add((since + d - 1) / d * d)
+		}
+	}
+	if s.timer != nil {
+		if !at.Before(s.armedAt) {
+			return
+		}
+		s.timer.Stop()
+	}
+	s.armedAt = at
+	s.timer = mc.opts.clock.AfterFunc(at.Sub(mc.now()), mc.expireDue)
+}
+
+// expireDue runs when the scheduler's timer fires, expiring the
+// entries which have come due.
+func (mc *MemoryCache) expireDue() {
+	mc.tasks.start()
+	defer mc.tasks.done()
+	s := &mc.expiry
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	now := mc.now()
+	var due []*scheduledExpiry
+	for len(s.pending) > 0 && !s.pending[0].at.After(now) {
+		x := heap.Pop(&s.pending).(*scheduledExpiry)
+		x.e.expiry = nil
+		due = append(due, x)
+	}
+	mc.timers.Add(-int64(len(due)))
+	s.timer = nil
+	mc.arm()
+	s.mu.Unlock()
+
+	for _, x := range due {
+		mc.expireScheduled(x.key, x.e)
+	}
+}
+
+// expireScheduled removes e, which has come due, from key, unless it
+// turns out to be in use after all.
+func (mc *MemoryCache) expireScheduled(key string, e *entry) {
+	// The key may have been overwritten since e was scheduled, in
+	// which case the new generation is scheduled itself and e must
+	// leave it alone.
+	stored, ok := mc.storage.Load(key)
+	if !ok || stored.gen != e.gen {
+		return
+	}
+	until := mc.untilRemoval(e)
+	if until > 0 {
+		mc.schedule(key, e)
+		return
+	}
+	if mc.late(-until) {
+		mc.backlog.push(mc, key, stored)
+		return
+	}
+	if mc.storage.CompareAndDelete(key, stored) {
+		mc.removed(key, stored, ReasonExpired)
+	}
+}
+
+// untilRemoval returns how long until e should leave storage.
+func (mc *MemoryCache) untilRemoval(e *entry) time.Duration {
+	return e.deadline().Add(max(mc.opts.staleGrace, mc.opts.staleRevalidate)).Sub(mc.now())
+}
+
+// stopExpiry stops the cache's background expiration, for Close:
+// entries past their deadline are no longer removed of the cache's own
+// accord, though reads still treat them as missing.
+func (mc *MemoryCache) stopExpiry() {
+	s := &mc.expiry
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	for _, x := range s.pending {
+		x.e.expiry = nil
+	}
+	mc.timers.Add(-int64(len(s.pending)))
+	s.pending = nil
+}
@@ -0,0 +1,147 @@
+package enigmacache
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestExpirySharesOneTimer(t *testing.T) {
+	cache, clock := NewTestCache()
+	for i := range 100 {
+		cache.Set(fmt.Sprint(i), i, time.Duration(100-i)*time.Second)
+	}
+	if n := len(clock.timers); n != 1 {
+		t.Errorf("%d clock timers armed for 100 entries, want 1", n)
+	}
+	if n := cache.DebugStats().Timers; n != 100 {
+		t.Errorf("Timers = %d, want 100", n)
+	}
+	for i := 1; i <= 100; i++ {
+		clock.Advance(time.Second)
+		if n := cache.Len(); n != 100-i {
+			t.Fatalf("Len = %d after %ds, want %d", n, i, 100-i)
+		}
+	}
+	if n := cache.DebugStats().Timers; n != 0 {
+		t.Errorf("Timers = %d once everything expired, want 0", n)
+	}
+}
+
+func TestExpiryOverwriteReschedules(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.Set("k", 1, time.Second)
+	cache.Set("k", 2, time.Minute)
+	if n := cache.DebugStats().Timers; n != 1 {
+		t.Errorf("Timers = %d after overwriting, want 1", n)
+	}
+	clock.Advance(time.Second)
+	if value, ok := cache.Get("k"); !ok || value != 2 {
+		t.Errorf("Get = %v, %v after the old deadline; want 2, true", value, ok)
+	}
+	clock.Advance(time.Minute)
+	if _, ok := cache.Get("k"); ok {
+		t.Error("key survived its new deadline")
+	}
+}
+
+func TestCloseStopsExpiration(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.Set("a", 1, time.Second)
+	cache.Set("b", 2, time.Second)
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+	if n := cache.DebugStats().Timers; n != 0 || len(clock.timers) != 0 {
+		t.Errorf("Timers = %d with %d clock timers after Close, want none", n, len(clock.timers))
+	}
+	clock.Advance(time.Second)
+	if n := cache.DebugStats().StoredEntries; n != 2 {
+		t.Errorf("StoredEntries = %d, want 2: nothing expires after Close", n)
+	}
+	if _, ok := cache.Get("b"); ok {
+		t.Error("Get found an entry past its deadline")
+	}
+	if err := cache.Close(); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+}
+
+func TestReadsIgnoreUnsweptExpiredEntries(t *testing.T) {
+	// With cleanup batched hourly, entries past their deadline stay in
+	// storage, as they would while waiting for the timer.
+	cache, clock := NewTestCache(WithCleanupInterval(time.Hour))
+	for _, key := range []string{"get", "getorset", "refresh"} {
+		cache.Set(key, "old", time.Second)
+	}
+	clock.Advance(time.Second)
+
+	if value, ok := cache.Get("get"); ok {
+		t.Errorf("Get returned expired value %v", value)
+	}
+	if actual, loaded := cache.GetOrSet("getorset", "new", time.Minute); loaded || actual != "new" {
+		t.Errorf("GetOrSet = %v, %v; want new, false", actual, loaded)
+	}
+	if cache.Refresh("refresh", time.Minute) {
+		t.Error("Refresh revived an expired entry")
+	}
+	if _, ok := cache.Get("refresh"); ok {
+		t.Error("expired entry readable after Refresh")
+	}
+}
+
+func TestNoExpiration(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.Set("forever", "x", NoExpiration)
+	if n := cache.DebugStats().Timers; n != 0 {
+		t.Errorf("Timers = %d, want 0", n)
+	}
+	cache.Set("gone", "y", 0)
+	if _, ok := cache.Get("gone"); ok {
+		t.Error("entry set with a zero TTL was readable")
+	}
+	clock.Advance(100 * 365 * 24 * time.Hour)
+	if remaining, ok := cache.TTL("forever"); !ok || remaining != NoExpiration {
+		t.Errorf("TTL = %v, %v after a century; want NoExpiration, true", remaining, ok)
+	}
+
+	var buf bytes.Buffer
+	if err := cache.SaveBinary(&buf, encodeString); err != nil {
+		t.Fatalf("SaveBinary returned %v", err)
+	}
+	dst := NewMemoryCache(WithClock(clock))
+	if n, err := dst.LoadBinary(&buf, decodeString); err != nil || n != 1 {
+		t.Fatalf("LoadBinary got (%d, %v), want (1, nil)", n, err)
+	}
+	if remaining, ok := dst.TTL("forever"); !ok || remaining != NoExpiration {
+		t.Errorf("loaded TTL = %v, %v; want NoExpiration, true", remaining, ok)
+	}
+	if n := dst.DebugStats().Timers; n != 0 {
+		t.Errorf("Timers = %d after loading, want 0", n)
+	}
+
+	capped := NewMemoryCache(WithClock(clock), WithMaxTTL(time.Hour))
+	capped.Set("forever", "x", NoExpiration)
+	if remaining, _ := capped.TTL("forever"); remaining != time.Hour {
+		t.Errorf("TTL under WithMaxTTL = %v, want 1h", remaining)
+	}
+}
+
+func TestCleanupInterval(t *testing.T) {
+	cache, clock := NewTestCache(WithCleanupInterval(10 * time.Second))
+	for i := range 5 {
+		cache.Set(fmt.Sprint(i), i, time.Duration(i+1)*time.Second)
+	}
+	clock.Advance(5 * time.Second)
+	if n := cache.Len(); n != 5 {
+		t.Errorf("Len = %d before the cleanup tick, want all 5 still stored", n)
+	}
+	if _, ok := cache.Get("0"); ok {
+		t.Error("Get returned an entry past its deadline")
+	}
+	clock.Advance(5 * time.Second)
+	if n := cache.Len(); n != 0 {
+		t.Errorf("Len = %d after the cleanup tick, want 0", n)
+	}
+}
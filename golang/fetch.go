@@ -0,0 +1,61 @@
+package enigmacache
+
+import (
+	"context"
+	"time"
+)
+
+// A FetchOutcome says how Fetch came by its result.
+type FetchOutcome int
+
+const (
+	// FetchHit means the result was already in the cache.
+	FetchHit FetchOutcome = iota
+	// FetchComputed means this call ran the loader.
+	FetchComputed
+	// FetchCoalesced means a concurrent call for the same key ran the
+	// loader, and this one waited for its result.
+	FetchCoalesced
+	// FetchStale means the value is one past its deadline, served
+	// because the loader failed (see WithStaleIfError) or while it
+	// runs in the background (see WithStaleWhileRevalidate).
+	FetchStale
+)
+
+func (o FetchOutcome) String() string {
+	switch o {
+	case FetchHit:
+		return "hit"
+	case FetchComputed:
+		return "computed"
+	case FetchCoalesced:
+		return "coalesced"
+	case FetchStale:
+		return "stale"
+	}
+	return "unknown"
+}
+
+// Fetch is GetOrComputeDetailed, for instrumentation: it reports
+// whether the value was a hit, computed by this call, computed by a
+// concurrent call this one waited on, or served stale. If the loader
+// fails, the outcome says which call ran it. ctx is handled as for
+// GetOrComputeContext: the loader is given it without its
+// cancellation, and a caller whose ctx is done stops waiting for the
+// load and gets ctx.Err().
+func (mc *MemoryCache) Fetch(ctx context.Context, key string, loader func(ctx context.Context) (any, error), ttl time.Duration) (value any, outcome FetchOutcome, err error) {
+	key = mc.normalize(key)
+	if err := ctx.Err(); err != nil {
+		return nil, FetchHit, err
+	}
+	value, info, err := mc.compute(ctx, key, ttl, loader)
+	switch {
+	case info.Source == SourceStale:
+		outcome = FetchStale
+	case info.Shared:
+		outcome = FetchCoalesced
+	case info.Source == SourceComputed:
+		outcome = FetchComputed
+	}
+	return value, outcome, err
+}
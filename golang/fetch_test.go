@@ -0,0 +1,65 @@
+package enigmacache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFetchOutcomes(t *testing.T) {
+	cache, clock := NewTestCache(WithStaleIfError(time.Minute))
+	ctx := context.Background()
+	loader := func(context.Context) (any, error) { return "fresh", nil }
+
+	check := func(what string, value any, outcome FetchOutcome, err error, wantValue any, want FetchOutcome) {
+		t.Helper()
+		if value != wantValue || outcome != want {
+			t.Fatalf("%s: got (%v, %v, %v), want (%v, %v)", what, value, outcome, err, wantValue, want)
+		}
+	}
+
+	value, outcome, err := cache.Fetch(ctx, "key", loader, time.Second)
+	check("first fetch", value, outcome, err, "fresh", FetchComputed)
+	value, outcome, err = cache.Fetch(ctx, "key", loader, time.Second)
+	check("second fetch", value, outcome, err, "fresh", FetchHit)
+
+	clock.Advance(2 * time.Second)
+	value, outcome, err = cache.Fetch(ctx, "key", failingLoader, time.Second)
+	check("failed refetch", value, outcome, err, "fresh", FetchStale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One caller runs a slow loader; the rest wait on it.
+	release := make(chan struct{})
+	slow := func(context.Context) (any, error) {
+		<-release
+		return "slow", nil
+	}
+	outcomes := make([]FetchOutcome, 3)
+	var wg sync.WaitGroup
+	for i := range outcomes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, outcomes[i], _ = cache.Fetch(ctx, "other", slow, time.Minute)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	counts := make(map[FetchOutcome]int)
+	for _, o := range outcomes {
+		counts[o]++
+	}
+	if counts[FetchComputed] != 1 || counts[FetchCoalesced] != 2 {
+		t.Fatalf("outcomes = %v, want one computed and two coalesced", outcomes)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := cache.Fetch(cancelled, "key", loader, time.Minute); err != context.Canceled {
+		t.Fatalf("Fetch with a done context err = %v, want context.Canceled", err)
+	}
+}
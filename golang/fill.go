@@ -0,0 +1,37 @@
+package enigmacache
+
+import "math"
+
+// WithFillThreshold arranges for cb to be called when the cache's
+// utilization, its entry count as a fraction of WithMaxEntries, rises
+// to fraction or above. It fires once per upward crossing: it won't
+// fire again until utilization has dropped back below fraction. cb
+// is called synchronously from the write which crossed the threshold,
+// with the current entry count and the maximum. The option has no
+// effect on an unbounded cache.
+func WithFillThreshold(fraction float64, cb func(current, max int64)) Option {
+	return func(o *options) {
+		o.fillFraction = fraction
+		o.onFill = cb
+	}
+}
+
+// checkFill fires the fill threshold callback if the cache has just
+// crossed it, and re-arms it if the cache has dropped back below.
+func (mc *MemoryCache) checkFill() {
+	if mc.opts.onFill == nil || mc.opts.maxEntries <= 0 {
+		return
+	}
+	max := int64(mc.opts.maxEntries)
+	limit := int64(math.Ceil(mc.opts.fillFraction * float64(max)))
+	current := mc.count.Load()
+	if current < limit {
+		mc.filled.Store(false)
+		return
+	}
+	if mc.filled.CompareAndSwap(false, true) {
+		mc.protect("fill threshold", func() {
+			mc.opts.onFill(current, max)
+		})
+	}
+}
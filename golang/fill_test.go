@@ -0,0 +1,50 @@
+package enigmacache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFillThresholdFiresOnUpwardCrossing(t *testing.T) {
+	var fired []int64
+	cache := NewMemoryCache(
+		WithMaxEntries(10),
+		WithFillThreshold(0.9, func(current, max int64) {
+			if max != 10 {
+				t.Errorf("callback got max %d, want 10", max)
+			}
+			fired = append(fired, current)
+		}),
+	)
+
+	for i := 0; i < 8; i++ {
+		cache.Set(fmt.Sprint(i), i, time.Minute)
+	}
+	if len(fired) != 0 {
+		t.Fatalf("fired at %v, below the threshold", fired)
+	}
+
+	// Crossing fires once, however many more writes follow.
+	for i := 8; i < 15; i++ {
+		cache.Set(fmt.Sprint(i), i, time.Minute)
+	}
+	if len(fired) != 1 || fired[0] != 9 {
+		t.Fatalf("fired at %v, want once at 9", fired)
+	}
+
+	// Draining below doesn't fire, but re-arms the callback. Eviction
+	// left keys 5 through 14, so this leaves three.
+	for i := 8; i < 15; i++ {
+		cache.Expire(fmt.Sprint(i))
+	}
+	if len(fired) != 1 {
+		t.Fatalf("fired at %v while draining", fired)
+	}
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		cache.Set(key, key, time.Minute)
+	}
+	if len(fired) != 2 || fired[1] != 9 {
+		t.Fatalf("fired at %v, want a second upward crossing", fired)
+	}
+}
@@ -0,0 +1,96 @@
+package enigmacache
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errFlightPanicked is handed to callers waiting on a flight whose
+// function panicked; the panic itself propagates in the caller that
+// ran it.
+var errFlightPanicked = errors.New("enigma-cache: in-flight call panicked")
+
+// A flightGroup de-duplicates concurrent calls which share a key, in
+// the manner of golang.org/x/sync/singleflight. The zero flightGroup
+// is ready for use.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	// done is closed when the call returns.
+	done chan struct{}
+	val  any
+	err  error
+}
+
+// do runs fn for key, unless a call for key is already in flight, in
+// which case it waits for that call and returns its result instead.
+// The shared result reports whether the result came from another
+// caller's call.
+func (g *flightGroup) do(key string, fn func() (any, error)) (v any, err error, shared bool) {
+	return g.doContext(context.Background(), key, fn)
+}
+
+// doContext is do, except that a caller waiting on another's call
+// gives up when ctx is done, returning ctx.Err(). The call itself
+// carries on for those still waiting.
+func (g *flightGroup) doContext(ctx context.Context, key string, fn func() (any, error)) (v any, err error, shared bool) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.val, c.err, true
+		case <-ctx.Done():
+			return nil, ctx.Err(), true
+		}
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	c := &flightCall{done: make(chan struct{}), err: errFlightPanicked}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.val, c.err = fn()
+	return c.val, c.err, false
+}
+
+// Do runs fn and returns its result, unless a call to Do for the same
+// key is already running, in which case it waits for that call and
+// returns its result instead. Nothing is cached: once the call
+// returns, the next Do for key runs fn again. It's single-flight for
+// work whose result shouldn't be kept, such as idempotent writes.
+// Calls to Do don't coalesce with the cache's own loads of key. A
+// panic in fn is recovered as for loaders (see WithPanicRecovery).
+func (mc *MemoryCache) Do(key string, fn func() (any, error)) (any, error) {
+	key = mc.normalize(key)
+	return mc.DoContext(context.Background(), key, fn)
+}
+
+// DoContext is Do, except that a caller waiting for another's call to
+// fn gives up when ctx is done, returning ctx.Err(), while the call
+// carries on for anyone else waiting. ctx doesn't affect a call the
+// caller makes itself: fn takes no context, so it can't be told to
+// stop.
+func (mc *MemoryCache) DoContext(ctx context.Context, key string, fn func() (any, error)) (any, error) {
+	key = mc.normalize(key)
+	value, err, _ := mc.doFlights.doContext(ctx, key, func() (value any, err error) {
+		if perr := mc.protect("Do", func() {
+			value, err = fn()
+		}); perr != nil {
+			err = perr
+		}
+		return value, err
+	})
+	return value, err
+}
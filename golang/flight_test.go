@@ -0,0 +1,49 @@
+package enigmacache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoCoalescesWithoutCaching(t *testing.T) {
+	cache := NewMemoryCache()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func() (any, error) {
+		calls.Add(1)
+		<-release
+		return "done", nil
+	}
+
+	const n = 50
+	var wg sync.WaitGroup
+	results := make([]any, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = cache.Do("key", fn)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i, r := range results {
+		if r != "done" {
+			t.Errorf("caller %d got %v, want done", i, r)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("fn ran %d times, want 1", n)
+	}
+	if cache.Len() != 0 {
+		t.Fatal("Do cached its result")
+	}
+	cache.Do("key", fn)
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("fn ran %d times in all, want 2", n)
+	}
+}
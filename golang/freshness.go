@@ -0,0 +1,79 @@
+package enigmacache
+
+import "time"
+
+// A Freshness says how an entry stored by SetWithMeta may be used, as
+// an HTTP cache would judge a stored response by its Cache-Control
+// directives (see RFC 5861).
+type Freshness int
+
+const (
+	// Fresh means the value is within its freshness lifetime and may
+	// be served as it is.
+	Fresh Freshness = iota
+	// StaleWhileRevalidate means the value is stale but within its
+	// stale-while-revalidate window: it may be served while it's
+	// revalidated in the background.
+	StaleWhileRevalidate
+	// StaleIfError means the value is stale but within its
+	// stale-if-error window: it must be revalidated, but may be
+	// served if revalidation fails.
+	StaleIfError
+	// MustRevalidate means the value is stale and mustn't be served
+	// until it's revalidated, as it may be, for instance, with a
+	// conditional request using its ETag.
+	MustRevalidate
+)
+
+func (f Freshness) String() string {
+	switch f {
+	case Fresh:
+		return "fresh"
+	case StaleWhileRevalidate:
+		return "stale-while-revalidate"
+	case StaleIfError:
+		return "stale-if-error"
+	case MustRevalidate:
+		return "must-revalidate"
+	}
+	return "unknown"
+}
+
+// GetFreshness returns the value stored for key, like Get, along with
+// its Freshness, judged by the metadata it was stored with (see
+// SetWithMeta). A value stored without a stale window is Fresh for as
+// long as it's in the cache. Once its freshness lifetime is over, a
+// value is StaleWhileRevalidate and then StaleIfError for as long as
+// its Meta allows, and MustRevalidate for the rest of its stay, or
+// for all of it if its Meta has MustRevalidate.
+func (mc *MemoryCache) GetFreshness(key string) (state Freshness, value any, ok bool) {
+	key = mc.normalize(key)
+	e, ok := mc.load(key)
+	if !ok {
+		mc.missed(key)
+		return Fresh, nil, false
+	}
+	if value, ok = mc.serve(key, e); !ok {
+		return Fresh, nil, false
+	}
+	return freshness(e, mc.now()), value, true
+}
+
+// freshness judges e as of now.
+func freshness(e *entry, now time.Time) Freshness {
+	if e.meta == nil {
+		return Fresh
+	}
+	stale := e.expiresAt.Add(-e.meta.staleWindow())
+	switch {
+	case now.Before(stale):
+		return Fresh
+	case e.meta.MustRevalidate:
+		return MustRevalidate
+	case now.Before(stale.Add(e.meta.StaleWhileRevalidate)):
+		return StaleWhileRevalidate
+	case now.Before(stale.Add(e.meta.StaleIfError)):
+		return StaleIfError
+	}
+	return MustRevalidate
+}
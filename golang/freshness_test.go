@@ -0,0 +1,60 @@
+package enigmacache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/plathrop/enigma-cache/golang/httpttl"
+)
+
+func TestGetFreshness(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		cacheControl string
+		age          time.Duration
+		want         Freshness
+		present      bool
+	}{
+		{"fresh", "max-age=60", 30 * time.Second, Fresh, true},
+		{"expired without stale directives", "max-age=60", time.Minute, Fresh, false},
+		{"fresh with directives", "max-age=60, stale-while-revalidate=30", 59 * time.Second, Fresh, true},
+		{"while revalidate", "max-age=60, stale-while-revalidate=30", 75 * time.Second, StaleWhileRevalidate, true},
+		{"past while revalidate", "max-age=60, stale-while-revalidate=30", 90 * time.Second, Fresh, false},
+		{"if error", "max-age=60, stale-if-error=300", 2 * time.Minute, StaleIfError, true},
+		{"revalidate before error", "max-age=60, stale-while-revalidate=30, stale-if-error=300", 75 * time.Second, StaleWhileRevalidate, true},
+		{"error after revalidate", "max-age=60, stale-while-revalidate=30, stale-if-error=300", 2 * time.Minute, StaleIfError, true},
+		{"past if error", "max-age=60, stale-if-error=300", 6 * time.Minute, Fresh, false},
+		{"must-revalidate fresh", "max-age=60, must-revalidate, stale-if-error=300", 30 * time.Second, Fresh, true},
+		{"must-revalidate stale", "max-age=60, must-revalidate, stale-if-error=300", 2 * time.Minute, MustRevalidate, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cache, clock := NewTestCache()
+			h := http.Header{"Cache-Control": {tc.cacheControl}}
+			ttl, _ := httpttl.TTLFromHTTPHeaders(h)
+			var meta Meta
+			meta.StaleWhileRevalidate, meta.StaleIfError, meta.MustRevalidate = httpttl.StaleFromHTTPHeaders(h)
+			cache.SetWithMeta("page", "<html>", meta, ttl)
+			clock.Advance(tc.age)
+
+			state, value, ok := cache.GetFreshness("page")
+			if ok != tc.present || state != tc.want {
+				t.Fatalf("GetFreshness = (%v, %v, %v), want (%v, _, %v)", state, value, ok, tc.want, tc.present)
+			}
+			if ok && value != "<html>" {
+				t.Fatalf("value = %v, want <html>", value)
+			}
+		})
+	}
+}
+
+func TestGetFreshnessWithoutMeta(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.Set("plain", 1, time.Minute)
+	if state, value, ok := cache.GetFreshness("plain"); !ok || state != Fresh || value != 1 {
+		t.Fatalf("GetFreshness = (%v, %v, %v), want (fresh, 1, true)", state, value, ok)
+	}
+	if _, _, ok := cache.GetFreshness("missing"); ok {
+		t.Fatal("missing key reported present")
+	}
+}
@@ -0,0 +1,33 @@
+package enigmacache
+
+import "time"
+
+// An Entry is a handle on one key of a MemoryCache, which can be
+// shared in place of the key itself. It holds only the key and the
+// cache, never the stored value, so it reads through to whatever the
+// key holds at the time and doesn't keep expired values alive.
+type Entry struct {
+	cache *MemoryCache
+	key   string
+}
+
+// Handle returns a handle on key. The key needn't be present.
+func (mc *MemoryCache) Handle(key string) *Entry {
+	key = mc.normalize(key)
+	return &Entry{cache: mc, key: key}
+}
+
+// Key returns the key the handle is on.
+func (h *Entry) Key() string {
+	return h.key
+}
+
+// Value is Get for the handle's key.
+func (h *Entry) Value() (value any, ok bool) {
+	return h.cache.Get(h.key)
+}
+
+// TTL is MemoryCache.TTL for the handle's key.
+func (h *Entry) TTL() (remaining time.Duration, ok bool) {
+	return h.cache.TTL(h.key)
+}
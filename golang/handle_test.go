@@ -0,0 +1,37 @@
+package enigmacache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHandleReadsThrough(t *testing.T) {
+	cache, clock := NewTestCache()
+	h := cache.Handle("key")
+	if _, ok := h.Value(); ok {
+		t.Fatal("handle on an absent key found a value")
+	}
+
+	cache.Set("key", 1, time.Minute)
+	if v, ok := h.Value(); !ok || v != 1 {
+		t.Fatalf("Value() = %v, %v, want 1, true", v, ok)
+	}
+	cache.Set("key", 2, time.Minute)
+	if v, _ := h.Value(); v != 2 {
+		t.Fatalf("after overwrite Value() = %v, want 2", v)
+	}
+
+	clock.Advance(30 * time.Second)
+	cache.Refresh("key", time.Hour)
+	if ttl, ok := h.TTL(); !ok || ttl != time.Hour {
+		t.Fatalf("after Refresh TTL() = %v, %v, want 1h, true", ttl, ok)
+	}
+
+	clock.Advance(2 * time.Hour)
+	if _, ok := h.Value(); ok {
+		t.Fatal("handle found an expired value")
+	}
+	if _, ok := h.TTL(); ok {
+		t.Fatal("handle reported a TTL for an expired key")
+	}
+}
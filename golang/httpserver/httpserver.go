@@ -0,0 +1,210 @@
+// Package httpserver exposes a MemoryCache over HTTP, with JSON values,
+// for running enigma-cache as a standalone cache beside services
+// which can't link it in (see cmd/enigma-cached). The API is:
+//
+//	GET    /cache/{key}  the key's value, or 404
+//	PUT    /cache/{key}  store the JSON request body as the key's value
+//	DELETE /cache/{key}  remove the key: 204, or 404 if it was absent
+//	GET    /stats        the cache's CacheStats, as JSON
+//	POST   /flush        remove every key: 204
+//
+// A PUT takes the TTL from the ttl query parameter or, failing that,
+// the X-Cache-TTL header, either as a Go duration ("90s", "1h30m"), a
+// whole number of seconds, or "never"; without one, the value is
+// stored with the cache's default TTL (see enigmacache.WithDefaultTTL).
+// A GET reports the time its key has left in X-Cache-TTL, in whole
+// seconds, rounded up, or as "never". Errors have a JSON body of the
+// form {"error": "..."}.
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	enigmacache "github.com/plathrop/enigma-cache/golang"
+)
+
+// TTLHeader is the header carrying a TTL, on a PUT, or the time left,
+// on a GET.
+const TTLHeader = "X-Cache-TTL"
+
+// A Handler serves the API for a cache.
+type Handler struct {
+	cache *enigmacache.MemoryCache
+	opts  options
+	mux   *http.ServeMux
+}
+
+type options struct {
+	maxValueSize int64
+}
+
+// An Option configures a Handler.
+type Option func(*options)
+
+// WithMaxValueSize sets the biggest request body a PUT accepts, in
+// bytes; one bigger is turned away with 413 Request Entity Too Large.
+// The default is 1 MiB.
+func WithMaxValueSize(n int64) Option {
+	return func(o *options) {
+		o.maxValueSize = n
+	}
+}
+
+// New returns a Handler serving cache.
+func New(cache *enigmacache.MemoryCache, opts ...Option) *Handler {
+	h := &Handler{cache: cache, opts: options{maxValueSize: 1 << 20}}
+	for _, opt := range opts {
+		opt(&h.opts)
+	}
+	h.mux = http.NewServeMux()
+	h.mux.HandleFunc("GET /cache/{key...}", h.get)
+	h.mux.HandleFunc("PUT /cache/{key...}", h.put)
+	h.mux.HandleFunc("DELETE /cache/{key...}", h.delete)
+	h.mux.HandleFunc("GET /stats", h.stats)
+	h.mux.HandleFunc("POST /flush", h.flush)
+	return h
+}
+
+// ServeHTTP serves the API.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	value, ok := h.cache.Get(key)
+	if !ok {
+		writeError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	body, err := encode(value)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("encoding the value: %v", err))
+		return
+	}
+	if remaining, ok := h.cache.TTL(key); ok {
+		w.Header().Set(TTLHeader, formatTTL(remaining))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// encode returns value as JSON. Values stored through the API are
+// kept as the JSON they were sent as; others, stored by code sharing
+// the cache, are marshaled.
+func encode(value any) ([]byte, error) {
+	if raw, ok := value.(json.RawMessage); ok {
+		return raw, nil
+	}
+	return json.Marshal(value)
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	ttl, ok, err := requestTTL(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.opts.maxValueSize))
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			writeError(w, http.StatusRequestEntityTooLarge, "value too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("reading the value: %v", err))
+		return
+	}
+	if !json.Valid(body) {
+		writeError(w, http.StatusBadRequest, "value isn't valid JSON")
+		return
+	}
+	value := json.RawMessage(body)
+	if ok {
+		h.cache.Set(key, value, ttl)
+	} else {
+		h.cache.SetDefault(key, value)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestTTL returns the TTL a PUT asks for, reporting false if it
+// doesn't ask for one.
+func requestTTL(r *http.Request) (time.Duration, bool, error) {
+	s := r.URL.Query().Get("ttl")
+	if s == "" {
+		s = r.Header.Get(TTLHeader)
+	}
+	if s == "" {
+		return 0, false, nil
+	}
+	ttl, err := parseTTL(s)
+	if err != nil {
+		return 0, false, err
+	}
+	return ttl, true, nil
+}
+
+func parseTTL(s string) (time.Duration, error) {
+	if s == "never" {
+		return enigmacache.NoExpiration, nil
+	}
+	ttl, err := time.ParseDuration(s)
+	if err != nil {
+		seconds, serr := strconv.ParseInt(s, 10, 64)
+		if serr != nil || seconds > int64(enigmacache.NoExpiration/time.Second) {
+			return 0, fmt.Errorf("bad TTL %q: want a duration, a number of seconds or never", s)
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("bad TTL %q: it must be more than zero", s)
+	}
+	return ttl, nil
+}
+
+func formatTTL(remaining time.Duration) string {
+	if remaining == enigmacache.NoExpiration {
+		return "never"
+	}
+	return strconv.FormatInt(int64((remaining+time.Second-1)/time.Second), 10)
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.cache.Expire(r.PathValue("key")); !ok {
+		writeError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) stats(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, h.cache.Stats())
+}
+
+func (h *Handler) flush(w http.ResponseWriter, _ *http.Request) {
+	h.cache.ExpireAll()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		body, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,109 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	enigmacache "github.com/plathrop/enigma-cache/golang"
+)
+
+func do(t *testing.T, h http.Handler, method, target, body string, header ...string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func read(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestHandler(t *testing.T) {
+	cache, _ := enigmacache.NewTestCache(enigmacache.WithDefaultTTL(time.Hour))
+	h := New(cache)
+
+	if resp := do(t, h, "PUT", "/cache/user/42?ttl=90s", `{"name": "Ada"}`); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT = %d %s", resp.StatusCode, read(t, resp))
+	}
+	resp := do(t, h, "GET", "/cache/user/42", "")
+	if body := read(t, resp); resp.StatusCode != http.StatusOK || body != `{"name": "Ada"}` {
+		t.Errorf("GET = %d %s, want the value stored", resp.StatusCode, body)
+	}
+	if ttl := resp.Header.Get(TTLHeader); ttl != "90" {
+		t.Errorf("GET reported a TTL of %q, want 90", ttl)
+	}
+
+	do(t, h, "PUT", "/cache/n", "7", TTLHeader, "120")
+	if remaining, _ := cache.TTL("n"); remaining != 2*time.Minute {
+		t.Errorf("TTL from the header = %v, want 2m", remaining)
+	}
+	do(t, h, "PUT", "/cache/d", "true")
+	if remaining, _ := cache.TTL("d"); remaining != time.Hour {
+		t.Errorf("TTL without one given = %v, want the default 1h", remaining)
+	}
+	do(t, h, "PUT", "/cache/forever?ttl=never", `"x"`)
+	if resp := do(t, h, "GET", "/cache/forever", ""); resp.Header.Get(TTLHeader) != "never" {
+		t.Errorf("GET reported a TTL of %q, want never", resp.Header.Get(TTLHeader))
+	}
+
+	// Values stored by code sharing the cache are marshaled.
+	cache.Set("native", map[string]int{"a": 1}, time.Minute)
+	if body := read(t, do(t, h, "GET", "/cache/native", "")); body != `{"a":1}` {
+		t.Errorf("GET of a native value = %s", body)
+	}
+
+	if resp := do(t, h, "DELETE", "/cache/n", ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE = %d", resp.StatusCode)
+	}
+	if resp := do(t, h, "DELETE", "/cache/n", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second DELETE = %d, want 404", resp.StatusCode)
+	}
+	resp = do(t, h, "GET", "/cache/n", "")
+	var e struct{ Error string }
+	if err := json.Unmarshal([]byte(read(t, resp)), &e); resp.StatusCode != http.StatusNotFound || err != nil || e.Error == "" {
+		t.Errorf("GET of a deleted key = %d, error %q", resp.StatusCode, e.Error)
+	}
+
+	var stats enigmacache.CacheStats
+	if err := json.Unmarshal([]byte(read(t, do(t, h, "GET", "/stats", ""))), &stats); err != nil || stats.Entries != 4 {
+		t.Errorf("stats = %+v, %v; want 4 entries", stats, err)
+	}
+	if resp := do(t, h, "POST", "/flush", ""); resp.StatusCode != http.StatusNoContent || cache.Len() != 0 {
+		t.Errorf("flush = %d, leaving %d entries", resp.StatusCode, cache.Len())
+	}
+}
+
+func TestHandlerRejectsBadPuts(t *testing.T) {
+	cache, _ := enigmacache.NewTestCache()
+	h := New(cache, WithMaxValueSize(8))
+	for _, tc := range []struct {
+		target, body string
+		want         int
+	}{
+		{"/cache/k", "{nope", http.StatusBadRequest},
+		{"/cache/k?ttl=soon", "1", http.StatusBadRequest},
+		{"/cache/k?ttl=0", "1", http.StatusBadRequest},
+		{"/cache/k", `"far too long"`, http.StatusRequestEntityTooLarge},
+	} {
+		if resp := do(t, h, "PUT", tc.target, tc.body); resp.StatusCode != tc.want {
+			t.Errorf("PUT %s %s = %d, want %d", tc.target, tc.body, resp.StatusCode, tc.want)
+		}
+	}
+	if cache.Len() != 0 {
+		t.Errorf("bad PUTs stored %d entries", cache.Len())
+	}
+}
@@ -0,0 +1,128 @@
+// Package httpttl derives cache TTLs from HTTP response headers, for
+// building an HTTP response cache on top of enigma-cache.
+package httpttl
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TTLFromHTTPHeaders returns how long a response with headers h may
+// be cached, following the parts of RFC 9111 which matter to a shared
+// cache:
+//
+//   - no-store, no-cache or private in Cache-Control makes the
+//     response uncacheable. (no-cache allows storing a response which
+//     is revalidated before every use, but a TTL cache can't
+//     revalidate; private responses are for the user's own cache
+//     alone.)
+//   - Otherwise s-maxage, or failing that max-age, gives the TTL in
+//     seconds, less the response's Age.
+//   - Otherwise Expires gives the deadline, measured from Date if the
+//     response has one, or from now if not. An Expires which isn't a
+//     valid HTTP date counts as already passed.
+//
+// cacheable is false if the response must not be cached, if its
+// freshness lifetime has already run out, or if the headers don't
+// give one at all: heuristic freshness isn't supported.
+func TTLFromHTTPHeaders(h http.Header) (ttl time.Duration, cacheable bool) {
+	directives := parseCacheControl(h.Values("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return 0, false
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return 0, false
+	}
+	if _, ok := directives["private"]; ok {
+		return 0, false
+	}
+
+	for _, name := range []string{"s-maxage", "max-age"} {
+		v, ok := directives[name]
+		if !ok {
+			continue
+		}
+		secs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || secs < 0 {
+			// A malformed max-age means the response is stale.
+			return 0, false
+		}
+		return fresh(time.Duration(secs)*time.Second - age(h))
+	}
+
+	if expires := h.Get("Expires"); expires != "" {
+		at, err := http.ParseTime(expires)
+		if err != nil {
+			return 0, false
+		}
+		now := time.Now()
+		if date, err := http.ParseTime(h.Get("Date")); err == nil {
+			now = date
+		}
+		return fresh(at.Sub(now) - age(h))
+	}
+	return 0, false
+}
+
+// StaleFromHTTPHeaders returns how long past its freshness lifetime a
+// response with headers h may still be used, following RFC 5861:
+// whileRevalidate is its stale-while-revalidate, for which the stale
+// response may be served while it's revalidated in the background,
+// and ifError its stale-if-error, for which it may be served in place
+// of an error from the origin. Missing or malformed directives give
+// zero. mustRevalidate is true if Cache-Control has must-revalidate or
+// proxy-revalidate, which forbid serving the response stale at all.
+func StaleFromHTTPHeaders(h http.Header) (whileRevalidate, ifError time.Duration, mustRevalidate bool) {
+	directives := parseCacheControl(h.Values("Cache-Control"))
+	seconds := func(name string) time.Duration {
+		secs, err := strconv.ParseInt(directives[name], 10, 64)
+		if err != nil || secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	_, must := directives["must-revalidate"]
+	_, proxy := directives["proxy-revalidate"]
+	return seconds("stale-while-revalidate"), seconds("stale-if-error"), must || proxy
+}
+
+// fresh turns a remaining freshness lifetime into TTLFromHTTPHeaders'
+// results.
+func fresh(ttl time.Duration) (time.Duration, bool) {
+	if ttl <= 0 {
+		return 0, false
+	}
+	return ttl, true
+}
+
+// age returns the response's Age header, or zero if it has none.
+func age(h http.Header) time.Duration {
+	secs, err := strconv.ParseInt(strings.TrimSpace(h.Get("Age")), 10, 64)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// parseCacheControl splits Cache-Control header values into their
+// directives, keyed by lowercased name. A directive without a value
+// maps to "", and quotes around a value are removed. The first
+// occurrence of a repeated directive wins.
+func parseCacheControl(values []string) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if _, seen := directives[name]; !seen {
+				directives[name] = strings.Trim(strings.TrimSpace(arg), `"`)
+			}
+		}
+	}
+	return directives
+}
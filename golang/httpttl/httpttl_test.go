@@ -0,0 +1,87 @@
+package httpttl
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTTLFromHTTPHeaders(t *testing.T) {
+	const date = "Mon, 02 Jan 2006 15:04:05 GMT"
+	for _, tc := range []struct {
+		name      string
+		headers   map[string][]string
+		ttl       time.Duration
+		cacheable bool
+	}{
+		{"no headers", nil, 0, false},
+		{"max-age", map[string][]string{"Cache-Control": {"public, max-age=60"}}, time.Minute, true},
+		{"quoted and cased", map[string][]string{"Cache-Control": {`Max-Age="60"`}}, time.Minute, true},
+		{"s-maxage wins", map[string][]string{"Cache-Control": {"max-age=60, s-maxage=120"}}, 2 * time.Minute, true},
+		{"shorter s-maxage wins", map[string][]string{"Cache-Control": {"s-maxage=30", "max-age=60"}}, 30 * time.Second, true},
+		{"private", map[string][]string{"Cache-Control": {"private, max-age=60"}}, 0, false},
+		{"private fields", map[string][]string{"Cache-Control": {`max-age=60, private="Set-Cookie"`}}, 0, false},
+		{"split headers", map[string][]string{"Cache-Control": {"public", "max-age=30"}}, 30 * time.Second, true},
+		{"age subtracted", map[string][]string{"Cache-Control": {"max-age=60"}, "Age": {"20"}}, 40 * time.Second, true},
+		{"age exhausts", map[string][]string{"Cache-Control": {"max-age=60"}, "Age": {"60"}}, 0, false},
+		{"max-age zero", map[string][]string{"Cache-Control": {"max-age=0"}}, 0, false},
+		{"bad max-age", map[string][]string{"Cache-Control": {"max-age=soon"}}, 0, false},
+		{"no-store", map[string][]string{"Cache-Control": {"max-age=60, no-store"}}, 0, false},
+		{"no-cache", map[string][]string{"Cache-Control": {"no-cache"}, "Expires": {"Mon, 02 Jan 2006 16:04:05 GMT"}}, 0, false},
+		{"max-age beats expires", map[string][]string{
+			"Cache-Control": {"max-age=10"},
+			"Date":          {date},
+			"Expires":       {"Mon, 02 Jan 2006 16:04:05 GMT"},
+		}, 10 * time.Second, true},
+		{"expires from date", map[string][]string{
+			"Date":    {date},
+			"Expires": {"Mon, 02 Jan 2006 16:04:05 GMT"},
+		}, time.Hour, true},
+		{"expires passed", map[string][]string{
+			"Date":    {date},
+			"Expires": {"Mon, 02 Jan 2006 14:04:05 GMT"},
+		}, 0, false},
+		{"invalid expires", map[string][]string{"Expires": {"0"}}, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ttl, cacheable := TTLFromHTTPHeaders(http.Header(tc.headers))
+			if ttl != tc.ttl || cacheable != tc.cacheable {
+				t.Fatalf("got (%v, %v), want (%v, %v)", ttl, cacheable, tc.ttl, tc.cacheable)
+			}
+		})
+	}
+}
+
+func TestTTLFromHTTPHeadersExpiresWithoutDate(t *testing.T) {
+	h := http.Header{"Expires": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}}
+	ttl, cacheable := TTLFromHTTPHeaders(h)
+	if !cacheable || ttl < 58*time.Minute || ttl > time.Hour {
+		t.Fatalf("got (%v, %v), want about an hour", ttl, cacheable)
+	}
+}
+
+func TestStaleFromHTTPHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		cacheControl    string
+		whileRevalidate time.Duration
+		ifError         time.Duration
+		mustRevalidate  bool
+	}{
+		{"none", "max-age=60", 0, 0, false},
+		{"while revalidate", "max-age=60, stale-while-revalidate=30", 30 * time.Second, 0, false},
+		{"if error", "max-age=60, stale-if-error=600", 0, 10 * time.Minute, false},
+		{"both", "Stale-While-Revalidate=30, stale-if-error=\"600\"", 30 * time.Second, 10 * time.Minute, false},
+		{"malformed", "stale-while-revalidate=soon, stale-if-error=-1", 0, 0, false},
+		{"must-revalidate", "max-age=60, must-revalidate, stale-if-error=600", 0, 10 * time.Minute, true},
+		{"proxy-revalidate", "proxy-revalidate", 0, 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := http.Header{"Cache-Control": {tc.cacheControl}}
+			whileRevalidate, ifError, mustRevalidate := StaleFromHTTPHeaders(h)
+			if whileRevalidate != tc.whileRevalidate || ifError != tc.ifError || mustRevalidate != tc.mustRevalidate {
+				t.Fatalf("got (%v, %v, %v), want (%v, %v, %v)", whileRevalidate, ifError, mustRevalidate, tc.whileRevalidate, tc.ifError, tc.mustRevalidate)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package enigmacache
+
+import (
+	"maps"
+	"slices"
+	"sync"
+)
+
+// WithIndex maintains a secondary index called name over the cache's
+// values, so keys can be looked up by something their values hold
+// with ByIndex. extract returns a value's index key, or ok false to
+// leave it out of the index. The index follows every write and every
+// removal, whether by expiry, eviction or deletion, so it can't drift
+// from the cache the way a reverse map kept by hand does. extract is
+// called on each write and each removal, so it should be cheap; with
+// WithValueCompression it's handed the decompressed value.
+func WithIndex(name string, extract func(value any) (indexKey string, ok bool)) Option {
+	return func(o *options) {
+		if o.indexes == nil {
+			o.indexes = make(map[string]func(any) (string, bool))
+		}
+		o.indexes[name] = extract
+	}
+}
+
+// ByIndex returns the keys whose values the index called name files
+// under indexKey, sorted. It returns nil if there are none, or if the
+// cache has no such index.
+func (mc *MemoryCache) ByIndex(name, indexKey string) []string {
+	if mc.indexes == nil {
+		return nil
+	}
+	return mc.indexes.lookup(name, indexKey)
+}
+
+type indexes struct {
+	extract map[string]func(any) (string, bool)
+
+	mu sync.RWMutex
+	// byKey maps each index's name, then index key, to the keys filed
+	// under it.
+	byKey map[string]map[string]map[string]struct{}
+	// filed maps each key to its index keys, by index name.
+	filed map[string]map[string]string
+}
+
+func newIndexes(extract map[string]func(any) (string, bool)) *indexes {
+	ix := &indexes{
+		extract: extract,
+		byKey:   make(map[string]map[string]map[string]struct{}),
+		filed:   make(map[string]map[string]string),
+	}
+	for name := range extract {
+		ix.byKey[name] = make(map[string]map[string]struct{})
+	}
+	return ix
+}
+
+// indexKeys returns e's index keys, by index name.
+func (ix *indexes) indexKeys(e *entry) map[string]string {
+	switch e.value.(type) {
+	case failure, tombstone:
+		return nil
+	}
+	value := e.get()
+	var keys map[string]string
+	for name, extract := range ix.extract {
+		if indexKey, ok := extract(value); ok {
+			if keys == nil {
+				keys = make(map[string]string, len(ix.extract))
+			}
+			keys[name] = indexKey
+		}
+	}
+	return keys
+}
+
+// stored files key under e's index keys, in place of whatever it was
+// filed under before.
+func (ix *indexes) stored(key string, e *entry) {
+	keys := ix.indexKeys(e)
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.unfile(key)
+	if len(keys) == 0 {
+		return
+	}
+	ix.filed[key] = keys
+	for name, indexKey := range keys {
+		primary := ix.byKey[name][indexKey]
+		if primary == nil {
+			primary = make(map[string]struct{})
+			ix.byKey[name][indexKey] = primary
+		}
+		primary[key] = struct{}{}
+	}
+}
+
+// removed unfiles key, which e has just left. If key has meanwhile
+// been set again to a value filed differently, that filing stands.
+func (ix *indexes) removed(key string, e *entry) {
+	keys := ix.indexKeys(e)
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if maps.Equal(ix.filed[key], keys) {
+		ix.unfile(key)
+	}
+}
+
+// unfile removes key from every index. ix.mu must be held.
+func (ix *indexes) unfile(key string) {
+	for name, indexKey := range ix.filed[key] {
+		primary := ix.byKey[name][indexKey]
+		delete(primary, key)
+		if len(primary) == 0 {
+			delete(ix.byKey[name], indexKey)
+		}
+	}
+	delete(ix.filed, key)
+}
+
+func (ix *indexes) lookup(name, indexKey string) []string {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	primary := ix.byKey[name][indexKey]
+	if len(primary) == 0 {
+		return nil
+	}
+	return slices.Sorted(maps.Keys(primary))
+}
@@ -0,0 +1,59 @@
+package enigmacache
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+type session struct {
+	user string
+}
+
+func sessionUser(value any) (string, bool) {
+	s, ok := value.(session)
+	return s.user, ok
+}
+
+func TestIndexFollowsWritesAndRemovals(t *testing.T) {
+	cache, clock := NewTestCache(WithIndex("user", sessionUser))
+	cache.Set("s1", session{"alice"}, time.Minute)
+	cache.Set("s2", session{"alice"}, time.Hour)
+	cache.Set("s3", session{"bob"}, time.Hour)
+	cache.Set("other", "not a session", time.Hour)
+
+	check := func(user string, want ...string) {
+		t.Helper()
+		if got := cache.ByIndex("user", user); !slices.Equal(got, want) {
+			t.Fatalf("ByIndex(user, %q) = %v, want %v", user, got, want)
+		}
+	}
+	check("alice", "s1", "s2")
+	check("bob", "s3")
+
+	cache.Set("s2", session{"bob"}, time.Hour)
+	check("alice", "s1")
+	check("bob", "s2", "s3")
+
+	clock.Advance(2 * time.Minute)
+	check("alice")
+
+	cache.Expire("s3")
+	check("bob", "s2")
+	cache.Set("s2", "no longer a session", time.Hour)
+	check("bob")
+
+	if got := cache.ByIndex("missing", "bob"); got != nil {
+		t.Fatalf("ByIndex on a missing index = %v, want nil", got)
+	}
+}
+
+func TestIndexFollowsEviction(t *testing.T) {
+	cache := NewMemoryCache(WithMaxEntries(2), WithIndex("user", sessionUser))
+	cache.Set("s1", session{"alice"}, time.Hour)
+	cache.Set("s2", session{"alice"}, time.Hour)
+	cache.Set("s3", session{"alice"}, time.Hour)
+	if got, want := cache.ByIndex("user", "alice"), []string{"s2", "s3"}; !slices.Equal(got, want) {
+		t.Fatalf("ByIndex after eviction = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,98 @@
+package enigmacache
+
+import "time"
+
+// A Reader is the read-only view of a cache, for components which
+// should be able to look things up but not change them.
+type Reader interface {
+	Get(key string) (value any, ok bool)
+	Has(key string) bool
+	TTL(key string) (remaining time.Duration, ok bool)
+	Keys() []string
+	Len() int
+}
+
+// A ReadWriter is a cache which can be both read and written. It holds
+// the core, TTL-aware operations every cache offers, and is what
+// components built on top of a cache (TypedKeyCache, say) should
+// depend on, so that a different implementation can be swapped in
+// without touching them. MemoryCache is the implementation in this
+// package.
+type ReadWriter interface {
+	Reader
+	Set(key string, value any, ttl time.Duration)
+	GetOrSet(key string, value any, ttl time.Duration) (actual any, loaded bool)
+	Expire(key string) (value any, loaded bool)
+	Refresh(key string, ttl time.Duration) (refreshed bool)
+	ExpireAll()
+}
+
+var (
+	_ Reader     = (*MemoryCache)(nil)
+	_ ReadWriter = (*MemoryCache)(nil)
+)
+
+// Has reports whether key is present. Like Peek, it doesn't count as
+// an access.
+func (mc *MemoryCache) Has(key string) bool {
+	key = mc.normalize(key)
+	_, ok := mc.load(key)
+	return ok
+}
+
+// TTL returns how long key has left before it expires, taking any
+// idle timeout into account. The ok result is false if the key isn't
+// present. An entry which never expires, having been set with
+// NoExpiration, has NoExpiration left. It doesn't count as an access.
+func (mc *MemoryCache) TTL(key string) (remaining time.Duration, ok bool) {
+	key = mc.normalize(key)
+	e, ok := mc.load(key)
+	if !ok {
+		return 0, false
+	}
+	return e.remaining(mc.now()), true
+}
+
+// Keys returns the keys present in the cache, in no particular order.
+// For a cache built WithKeyNormalizer or WithCaseInsensitiveKeys, they
+// are in their normalized form. Keys doesn't take a snapshot, so a
+// key written or removed while it runs may or may not be included;
+// Range sees the cache as of a single instant.
+func (mc *MemoryCache) Keys() []string {
+	var keys []string
+	mc.storage.Range(func(key string, e *entry) bool {
+		if e.live(mc.now()) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+// GetAll returns a copy of the cache's live entries, keys mapped to
+// values, as of a single instant (see Save), for small caches read
+// all at once, such as configuration. The map is the caller's to
+// modify; the values are those Get would return. Reads through
+// GetAll aren't counted as hits.
+func (mc *MemoryCache) GetAll() map[string]any {
+	now := mc.now()
+	all := make(map[string]any)
+	for _, entries := range mc.storage.Snapshot() {
+		for key, e := range entries {
+			if !e.live(now) {
+				continue
+			}
+			if value, ok := mc.read(key, e); ok {
+				all[key] = value
+			}
+		}
+	}
+	return all
+}
+
+// Len returns the number of entries in the cache. It's a counter, not
+// a scan, so it includes any entries which have passed their deadline
+// but not yet been removed, such as those held for WithStaleIfError.
+func (mc *MemoryCache) Len() int {
+	return int(mc.count.Load())
+}
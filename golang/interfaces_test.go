@@ -0,0 +1,245 @@
+package enigmacache
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mapReader is a hand-written Reader, standing in for a cache in
+// tests of code which only reads.
+type mapReader map[string]any
+
+func (m mapReader) Get(key string) (any, bool) { v, ok := m[key]; return v, ok }
+func (m mapReader) Has(key string) bool        { _, ok := m[key]; return ok }
+func (m mapReader) TTL(key string) (time.Duration, bool) {
+	if !m.Has(key) {
+		return 0, false
+	}
+	return time.Hour, true
+}
+func (m mapReader) Keys() []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+func (m mapReader) Len() int { return len(m) }
+
+// greeting is a component which only needs to read from a cache.
+func greeting(r Reader, user string) string {
+	if name, ok := r.Get("name:" + user); ok {
+		return "hello, " + name.(string)
+	}
+	return "hello, stranger"
+}
+
+func TestReaderMock(t *testing.T) {
+	r := mapReader{"name:42": "Grey"}
+	if got := greeting(r, "42"); got != "hello, Grey" {
+		t.Errorf("greeting = %q", got)
+	}
+	if got := greeting(r, "7"); got != "hello, stranger" {
+		t.Errorf("greeting = %q", got)
+	}
+}
+
+func TestReaderMethods(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.Set("a", 1, time.Minute)
+	cache.Set("b", 2, time.Minute)
+
+	var r Reader = cache
+	if !r.Has("a") || r.Has("missing") {
+		t.Error("Has is wrong")
+	}
+	if remaining, ok := r.TTL("a"); !ok || remaining <= 50*time.Second {
+		t.Errorf("TTL = (%v, %v), want about a minute", remaining, ok)
+	}
+	if _, ok := r.TTL("missing"); ok {
+		t.Error("TTL found a missing key")
+	}
+	keys := r.Keys()
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"a", "b"}) {
+		t.Errorf("Keys = %v", keys)
+	}
+	if n := r.Len(); n != 2 {
+		t.Errorf("Len = %d, want 2", n)
+	}
+	if greeting(cache, "42") != "hello, stranger" {
+		t.Error("a MemoryCache doesn't work as a Reader")
+	}
+}
+
+// mapCache is a deliberately simple ReadWriter, with lazy expiry and
+// a single lock, standing in for an alternate cache implementation.
+type mapCache struct {
+	mu      sync.Mutex
+	clock   Clock
+	entries map[string]mapCacheEntry
+}
+
+type mapCacheEntry struct {
+	value     any
+	expiresAt time.Time
+}
+
+func newMapCache(clock Clock) *mapCache {
+	return &mapCache{clock: clock, entries: make(map[string]mapCacheEntry)}
+}
+
+// live returns the unexpired entry for key; m.mu must be held.
+func (m *mapCache) live(key string) (mapCacheEntry, bool) {
+	e, ok := m.entries[key]
+	if ok && !m.clock.Now().Before(e.expiresAt) {
+		delete(m.entries, key)
+		return mapCacheEntry{}, false
+	}
+	return e, ok
+}
+
+func (m *mapCache) Get(key string) (any, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.live(key)
+	return e.value, ok
+}
+
+func (m *mapCache) Has(key string) bool {
+	_, ok := m.Get(key)
+	return ok
+}
+
+func (m *mapCache) TTL(key string) (time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.live(key)
+	if !ok {
+		return 0, false
+	}
+	return e.expiresAt.Sub(m.clock.Now()), true
+}
+
+func (m *mapCache) Keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for key := range m.entries {
+		if _, ok := m.live(key); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (m *mapCache) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+func (m *mapCache) Set(key string, value any, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = mapCacheEntry{value, m.clock.Now().Add(ttl)}
+}
+
+func (m *mapCache) GetOrSet(key string, value any, ttl time.Duration) (any, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.live(key); ok {
+		return e.value, true
+	}
+	m.entries[key] = mapCacheEntry{value, m.clock.Now().Add(ttl)}
+	return value, false
+}
+
+func (m *mapCache) Expire(key string) (any, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.live(key)
+	delete(m.entries, key)
+	return e.value, ok
+}
+
+func (m *mapCache) Refresh(key string, ttl time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.live(key)
+	if ok {
+		e.expiresAt = m.clock.Now().Add(ttl)
+		m.entries[key] = e
+	}
+	return ok
+}
+
+func (m *mapCache) ExpireAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.entries)
+}
+
+// TestReadWriterImplementations runs the same checks against each
+// ReadWriter, including through a TypedKeyCache layered on top.
+func TestReadWriterImplementations(t *testing.T) {
+	for name, newCache := range map[string]func(clock Clock) ReadWriter{
+		"MemoryCache": func(clock Clock) ReadWriter { return NewMemoryCache(WithClock(clock)) },
+		"mapCache":    func(clock Clock) ReadWriter { return newMapCache(clock) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			clock := NewFakeClock(time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+			c := newCache(clock)
+			c.Set("a", 1, 30*time.Millisecond)
+			if actual, loaded := c.GetOrSet("a", 2, time.Minute); !loaded || actual != 1 {
+				t.Fatalf("GetOrSet = (%v, %v), want (1, true)", actual, loaded)
+			}
+			if !c.Refresh("a", time.Minute) || c.Refresh("missing", time.Minute) {
+				t.Fatal("Refresh is wrong")
+			}
+			clock.Advance(50 * time.Millisecond)
+			if remaining, ok := c.TTL("a"); !ok || remaining != time.Minute-50*time.Millisecond {
+				t.Fatalf("TTL = (%v, %v) after Refresh, want about a minute", remaining, ok)
+			}
+			if value, loaded := c.Expire("a"); !loaded || value != 1 || c.Has("a") {
+				t.Fatalf("Expire = (%v, %v)", value, loaded)
+			}
+
+			typed := NewTypedKeyCache(c, strconv.Itoa)
+			typed.Set(7, "seven", time.Minute)
+			if value, ok := c.Get("7"); !ok || value != "seven" {
+				t.Fatalf("TypedKeyCache didn't write through to the cache: (%v, %v)", value, ok)
+			}
+			c.ExpireAll()
+			if c.Len() != 0 || len(c.Keys()) != 0 {
+				t.Fatal("ExpireAll left entries behind")
+			}
+		})
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	// Stale entries are kept after their deadline, but aren't live.
+	cache, clock := NewTestCache(WithStaleIfError(time.Hour))
+	cache.Set("a", 1, time.Minute)
+	cache.Set("b", 2, time.Hour)
+	cache.Set("expired", 3, time.Second)
+	clock.Advance(time.Second)
+
+	all := cache.GetAll()
+	if len(all) != 2 || all["a"] != 1 || all["b"] != 2 {
+		t.Fatalf("GetAll = %v, want a and b", all)
+	}
+	all["a"] = 100
+	delete(all, "b")
+	if v, _ := cache.Get("a"); v != 1 || !cache.Has("b") {
+		t.Fatal("modifying GetAll's map changed the cache")
+	}
+	cache.Set("c", 4, time.Minute)
+	if _, ok := all["c"]; ok {
+		t.Fatal("a later write showed up in GetAll's map")
+	}
+}
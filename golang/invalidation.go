@@ -0,0 +1,154 @@
+package enigmacache
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"sync"
+)
+
+// An Invalidation is a message on an InvalidationBus: a key removed
+// from one cache, or, if All is set, a cache cleared of every key, for
+// the other caches on the bus to follow suit.
+type Invalidation struct {
+	// Origin identifies the cache which sent the invalidation, so it
+	// can pass over its own. An invalidation with no origin is
+	// applied by every cache.
+	Origin string `json:"origin,omitempty"`
+	Key    string `json:"key,omitempty"`
+	All    bool   `json:"all,omitempty"`
+}
+
+// An InvalidationBus carries Invalidations between caches, such as
+// the replicas of a service each keeping its own MemoryCache, so that
+// removing a key from one removes it from all of them. The redis
+// package has one built on Redis pub/sub.
+type InvalidationBus interface {
+	// Publish sends inv to every cache subscribed to the bus,
+	// including, as a rule, the sender.
+	Publish(inv Invalidation) error
+	// Subscribe has handle called with each Invalidation published on
+	// the bus, one at a time, until the returned func is called to
+	// unsubscribe.
+	Subscribe(handle func(Invalidation)) (unsubscribe func(), err error)
+}
+
+// WithInvalidationBus keeps the cache's removals in step with the
+// other caches on bus. Every key removed by Expire, and the like of
+// ExpirePrefix and CompareAndDelete, is published on the bus, as is
+// every ExpireAll, and the cache drops its own copies of the keys the
+// others remove, without publishing them again, writing them through
+// to any write-through store or counting them as reads. Expire
+// publishes even a key the cache doesn't hold, since the others may.
+// Keys the cache overwrites aren't published, nor those it expires or
+// evicts of its own accord. Publishing happens on a goroutine of its
+// own, in the order of the removals, and counts as in-flight work for
+// Drain; errors from the bus are logged (see WithLogger) and the
+// invalidation dropped. A cache which can't subscribe carries on
+// without the bus; Close unsubscribes.
+func WithInvalidationBus(bus InvalidationBus) Option {
+	return func(o *options) {
+		o.bus = bus
+	}
+}
+
+// An invalidator connects a cache to its InvalidationBus, queueing
+// invalidations for a single publishing goroutine, which is started
+// when they arrive and exits once it has published them all.
+type invalidator struct {
+	bus    InvalidationBus
+	origin string
+
+	mu          sync.Mutex
+	running     bool
+	queue       []Invalidation
+	unsubscribe func()
+}
+
+// subscribe connects the cache to the bus given WithInvalidationBus.
+func (mc *MemoryCache) subscribe() {
+	inv := &invalidator{
+		bus:    mc.opts.bus,
+		origin: strconv.FormatUint(rand.Uint64(), 36),
+	}
+	unsubscribe, err := inv.bus.Subscribe(func(i Invalidation) {
+		mc.invalidated(inv, i)
+	})
+	if err != nil {
+		mc.logger().Error("enigma-cache: subscribing to the invalidation bus failed", "err", err)
+		return
+	}
+	inv.unsubscribe = unsubscribe
+	mc.invalidator = inv
+}
+
+// invalidate publishes the removal of key, or, if all is set, of every
+// key.
+func (mc *MemoryCache) invalidate(key string, all bool) {
+	inv := mc.invalidator
+	if inv == nil {
+		return
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if inv.unsubscribe == nil {
+		// Closed.
+		return
+	}
+	inv.queue = append(inv.queue, Invalidation{Origin: inv.origin, Key: key, All: all})
+	if !inv.running {
+		inv.running = true
+		mc.tasks.start()
+		go mc.publish()
+	}
+}
+
+// publish publishes the invalidator's queue until it's empty.
+func (mc *MemoryCache) publish() {
+	inv := mc.invalidator
+	defer mc.tasks.done()
+	for {
+		inv.mu.Lock()
+		if len(inv.queue) == 0 {
+			inv.running = false
+			inv.mu.Unlock()
+			return
+		}
+		batch := inv.queue
+		inv.queue = nil
+		inv.mu.Unlock()
+
+		for _, i := range batch {
+			if err := inv.bus.Publish(i); err != nil {
+				mc.logger().Warn("enigma-cache: publishing an invalidation failed", "key", i.Key, "all", i.All, "err", err)
+			}
+		}
+	}
+}
+
+// invalidated applies an invalidation from the bus, unless it's the
+// cache's own.
+func (mc *MemoryCache) invalidated(inv *invalidator, i Invalidation) {
+	if i.Origin != "" && i.Origin == inv.origin {
+		return
+	}
+	if i.All {
+		mc.clear(true)
+		return
+	}
+	// Going through dropped rather than removed keeps the removal off
+	// the bus.
+	if e, ok := mc.storage.LoadAndDelete(i.Key); ok {
+		mc.dropped(i.Key, e, ReasonManual, true)
+	}
+}
+
+// close disconnects the cache from its bus.
+func (inv *invalidator) close() {
+	inv.mu.Lock()
+	unsubscribe := inv.unsubscribe
+	inv.unsubscribe = nil
+	inv.mu.Unlock()
+	if unsubscribe != nil {
+		unsubscribe()
+	}
+}
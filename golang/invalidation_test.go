@@ -0,0 +1,143 @@
+package enigmacache
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// localBus delivers invalidations to its subscribers as they're
+// published, recording them.
+type localBus struct {
+	mu        sync.Mutex
+	handlers  map[int]func(Invalidation)
+	next      int
+	published []Invalidation
+}
+
+func (b *localBus) Publish(inv Invalidation) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.published = append(b.published, inv)
+	for _, handle := range b.handlers {
+		handle(inv)
+	}
+	return nil
+}
+
+func (b *localBus) Subscribe(handle func(Invalidation)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.handlers == nil {
+		b.handlers = make(map[int]func(Invalidation))
+	}
+	id := b.next
+	b.next++
+	b.handlers[id] = handle
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}, nil
+}
+
+func (b *localBus) keys() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var keys []string
+	for _, inv := range b.published {
+		if inv.All {
+			keys = append(keys, "*")
+		} else {
+			keys = append(keys, inv.Key)
+		}
+	}
+	return keys
+}
+
+func TestInvalidationBus(t *testing.T) {
+	bus := &localBus{}
+	a, clock := NewTestCache(WithInvalidationBus(bus))
+	var evicted []string
+	b := NewMemoryCache(WithClock(clock), WithInvalidationBus(bus), WithOnEvicted(func(key string, _ any, reason EvictionReason) {
+		evicted = append(evicted, key+":"+reason.String())
+	}))
+	for _, c := range []*MemoryCache{a, b} {
+		c.Set("x", 1, time.Hour)
+		c.Set("y", 2, time.Hour)
+		c.Set("p:1", 3, time.Hour)
+	}
+
+	a.Expire("x")
+	a.Drain(context.Background())
+	if b.Has("x") {
+		t.Error("peer kept a key expired elsewhere")
+	}
+	if !slices.Equal(evicted, []string{"x:manual"}) {
+		t.Errorf("peer's evictions = %v, want [x:manual]", evicted)
+	}
+
+	// A key the cache doesn't hold still goes out, and a key dropped
+	// for a peer isn't published again.
+	b.Set("z", 4, time.Hour)
+	a.Expire("z")
+	a.ExpirePrefix("p:")
+	a.Drain(context.Background())
+	b.Drain(context.Background())
+	if b.Has("z") || b.Has("p:1") {
+		t.Error("peer kept keys removed elsewhere")
+	}
+	if keys := bus.keys(); !slices.Equal(keys, []string{"x", "z", "p:1"}) {
+		t.Errorf("published %v, want [x z p:1]", keys)
+	}
+
+	// Neither overwrites nor expiries are published.
+	a.Set("y", 5, time.Hour)
+	a.Set("short", 6, time.Second)
+	clock.Advance(time.Second)
+	a.Drain(context.Background())
+	if value, _ := b.Get("y"); value != 2 {
+		t.Errorf("peer's y = %v after an overwrite elsewhere, want 2", value)
+	}
+
+	a.ExpireAll()
+	a.Drain(context.Background())
+	if b.Len() != 0 {
+		t.Errorf("peer has %d keys after ExpireAll elsewhere, want 0", b.Len())
+	}
+
+	// Once closed, the cache neither publishes nor listens.
+	a.Set("w", 7, time.Hour)
+	a.Drain(context.Background())
+	a.Close()
+	b.Expire("w")
+	a.Expire("v")
+	a.Drain(context.Background())
+	b.Drain(context.Background())
+	if !a.Has("w") {
+		t.Error("closed cache dropped a key expired elsewhere")
+	}
+	if keys := bus.keys(); !slices.Equal(keys, []string{"x", "z", "p:1", "*", "w"}) {
+		t.Errorf("published %v, want [x z p:1 * w]", keys)
+	}
+}
+
+type failingBus struct{ localBus }
+
+func (*failingBus) Subscribe(func(Invalidation)) (func(), error) {
+	return nil, errors.New("no bus")
+}
+
+func TestInvalidationBusSubscribeFails(t *testing.T) {
+	bus := &failingBus{}
+	cache, _ := NewTestCache(WithInvalidationBus(bus), WithLogger(quietLogger))
+	cache.Set("k", 1, time.Hour)
+	cache.Expire("k")
+	cache.Drain(context.Background())
+	if keys := bus.keys(); len(keys) != 0 {
+		t.Errorf("published %v from a cache which never subscribed", keys)
+	}
+}
@@ -0,0 +1,118 @@
+package enigmacache
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// SortedRange calls f for each live key and its value in ascending
+// key order, stopping early if f returns false. This gives stable
+// output for dumps and diffs, at a cost: the whole key set is copied
+// and sorted before the first call to f, so it takes O(n log n) time
+// and O(n) memory. The values are those the keys held when
+// SortedRange started; f is free to modify the cache.
+func (mc *MemoryCache) SortedRange(f func(key string, value any) bool) {
+	type item struct {
+		key string
+		e   *entry
+	}
+	var items []item
+	mc.storage.Range(func(key string, e *entry) bool {
+		if e.live(mc.now()) {
+			items = append(items, item{key, e})
+		}
+		return true
+	})
+	slices.SortFunc(items, func(a, b item) int {
+		return cmp.Compare(a.key, b.key)
+	})
+	for _, it := range items {
+		value, ok := mc.read(it.key, it.e)
+		if !ok {
+			continue
+		}
+		if !f(it.key, value) {
+			return
+		}
+	}
+}
+
+// Range calls f for each live key, its value and its deadline, taking
+// idle timeouts into account, in no particular order, stopping early
+// if f returns false. It iterates a consistent snapshot, as Save
+// does: the entries are exactly those present at a single instant,
+// with anything which had passed its deadline by then left out,
+// whether or not it's been swept away yet. An entry which never
+// expires (see NoExpiration) has a zero deadline. f is free to modify
+// the cache, which doesn't change what Range goes on to see. Reads
+// through Range aren't counted as hits.
+func (mc *MemoryCache) Range(f func(key string, value any, expiresAt time.Time) bool) {
+	now := mc.now()
+	for _, entries := range mc.storage.Snapshot() {
+		for key, e := range entries {
+			if !e.live(now) {
+				continue
+			}
+			value, ok := mc.read(key, e)
+			if !ok {
+				continue
+			}
+			var at time.Time
+			if d := e.deadline(); !d.Equal(never) {
+				at = d
+			}
+			if !f(key, value, at) {
+				return
+			}
+		}
+	}
+}
+
+// NextExpiration returns the live key with the soonest deadline, and
+// that deadline, taking idle timeouts into account. The ok result is
+// false if the cache has no live keys. Deadlines aren't kept in any
+// order, so this scans every entry: it takes O(n) time.
+func (mc *MemoryCache) NextExpiration() (key string, at time.Time, ok bool) {
+	mc.storage.Range(func(k string, e *entry) bool {
+		if !e.live(mc.now()) {
+			return true
+		}
+		if d := e.deadline(); !ok || d.Before(at) {
+			key, at, ok = k, d, true
+		}
+		return true
+	})
+	return key, at, ok
+}
+
+// ExpiringWithin returns the live keys which expire in less than d,
+// taking idle timeouts into account, soonest first. It's meant for
+// refreshing keys before they lapse. Like NextExpiration it scans
+// every entry, then sorts the matches: O(n + m log m) time for m
+// matches.
+func (mc *MemoryCache) ExpiringWithin(d time.Duration) []string {
+	type item struct {
+		key string
+		at  time.Time
+	}
+	now := mc.now()
+	var items []item
+	mc.storage.Range(func(key string, e *entry) bool {
+		if e.live(now) && e.remaining(now) < d {
+			items = append(items, item{key, e.deadline()})
+		}
+		return true
+	})
+	slices.SortFunc(items, func(a, b item) int {
+		if c := a.at.Compare(b.at); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.key, b.key)
+	})
+	keys := make([]string, len(items))
+	for i, it := range items {
+		keys[i] = it.key
+	}
+	return keys
+}
@@ -0,0 +1,130 @@
+package enigmacache
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSortedRange(t *testing.T) {
+	for _, cache := range []*MemoryCache{NewMemoryCache(), NewMemoryCache(WithShards(4))} {
+		var want []string
+		for i := 0; i < 50; i++ {
+			want = append(want, fmt.Sprintf("key%02d", i))
+		}
+		for _, i := range rand.Perm(len(want)) {
+			cache.Set(want[i], i, time.Minute)
+		}
+
+		var got []string
+		cache.SortedRange(func(key string, value any) bool {
+			if fmt.Sprintf("key%02d", value) != key {
+				t.Errorf("key %q came with value %v", key, value)
+			}
+			got = append(got, key)
+			return true
+		})
+		if !slices.Equal(got, want) {
+			t.Fatalf("iterated %v, want %v", got, want)
+		}
+
+		n := 0
+		cache.SortedRange(func(string, any) bool {
+			n++
+			return n < 3
+		})
+		if n != 3 {
+			t.Fatalf("iteration continued after f returned false, %d calls", n)
+		}
+	}
+}
+
+func TestNextExpiration(t *testing.T) {
+	cache := NewMemoryCache()
+	if _, _, ok := cache.NextExpiration(); ok {
+		t.Fatal("empty cache reported an expiration")
+	}
+
+	before := time.Now()
+	cache.Set("hour", 1, time.Hour)
+	cache.Set("minute", 2, time.Minute)
+	cache.SetWithIdle("idle", 3, time.Hour, 30*time.Second)
+	cache.Set("day", 4, 24*time.Hour)
+
+	key, at, ok := cache.NextExpiration()
+	if !ok || key != "idle" {
+		t.Fatalf("got (%q, %v), want idle", key, ok)
+	}
+	if at.Before(before.Add(30*time.Second)) || at.After(time.Now().Add(30*time.Second)) {
+		t.Fatalf("deadline %v isn't 30s out", at)
+	}
+
+	cache.Expire("idle")
+	if key, _, _ := cache.NextExpiration(); key != "minute" {
+		t.Fatalf("after expiring idle got %q, want minute", key)
+	}
+}
+
+func TestExpiringWithin(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.Set("hour", 1, time.Hour)
+	cache.Set("minute", 2, time.Minute)
+	cache.SetWithIdle("idle", 3, time.Hour, 30*time.Second)
+	cache.Set("seconds", 4, 10*time.Second)
+	cache.Set("day", 5, 24*time.Hour)
+
+	if got := cache.ExpiringWithin(time.Second); len(got) != 0 {
+		t.Fatalf("ExpiringWithin(1s) = %v, want none", got)
+	}
+	want := []string{"seconds", "idle", "minute"}
+	if got := cache.ExpiringWithin(2 * time.Minute); !slices.Equal(got, want) {
+		t.Fatalf("ExpiringWithin(2m) = %v, want %v", got, want)
+	}
+
+	clock.Advance(20 * time.Second)
+	want = []string{"idle", "minute", "hour"}
+	if got := cache.ExpiringWithin(time.Hour); !slices.Equal(got, want) {
+		t.Fatalf("after 20s ExpiringWithin(1h) = %v, want %v", got, want)
+	}
+}
+
+func TestRange(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithShards(4)}} {
+		cache, clock := NewTestCache(opts...)
+		start := clock.Now()
+		cache.Set("a", 1, time.Minute)
+		cache.Set("b", 2, NoExpiration)
+		cache.Set("gone", 3, time.Second)
+		// Leave the expired entry in storage for Range to skip.
+		cache.Close()
+		clock.Advance(2 * time.Second)
+
+		got := map[string]time.Time{}
+		cache.Range(func(key string, value any, expiresAt time.Time) bool {
+			got[key] = expiresAt
+			// Writes during the range don't show up in it.
+			cache.Set(fmt.Sprint("new", key), value, time.Minute)
+			return true
+		})
+		want := map[string]time.Time{"a": start.Add(time.Minute), "b": {}}
+		if len(got) != len(want) {
+			t.Fatalf("Range saw %v, want %v", got, want)
+		}
+		for key, at := range want {
+			if !got[key].Equal(at) {
+				t.Errorf("Range gave %q deadline %v, want %v", key, got[key], at)
+			}
+		}
+
+		calls := 0
+		cache.Range(func(string, any, time.Time) bool {
+			calls++
+			return false
+		})
+		if calls != 1 {
+			t.Errorf("Range made %d calls after f returned false, want 1", calls)
+		}
+	}
+}
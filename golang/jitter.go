@@ -0,0 +1,56 @@
+package enigmacache
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// WithTTLJitter spreads out expirations by moving each entry's
+// deadline by a random amount of up to fraction of its TTL either
+// way, so a batch of entries written together with the same TTL
+// don't all expire, and send their loads to the backend, at once. A
+// fraction of 0.1 gives an entry set for ten minutes anywhere from
+// nine to eleven. Jitter applies wherever a TTL is given, Refresh
+// included, and before WithMaxTTL, so it never stretches an entry
+// past the limit; TTL reports the jittered time left. Entries stored
+// with NoExpiration, or a TTL of zero or less, are left alone. A
+// fraction below 0 or above 1 is taken as 0 or 1.
+func WithTTLJitter(fraction float64) Option {
+	return func(o *options) {
+		o.ttlJitter = min(max(fraction, 0), 1)
+	}
+}
+
+// A jitterer draws TTL jitter for WithTTLJitter.
+type jitterer struct {
+	fraction float64
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// newJitterer returns a jitterer seeded from rng.
+func newJitterer(fraction float64, rng *rand.Rand) *jitterer {
+	return &jitterer{
+		fraction: fraction,
+		rng:      rand.New(rand.NewPCG(rng.Uint64(), rng.Uint64())),
+	}
+}
+
+// apply returns ttl moved by a random amount of up to j.fraction of it
+// either way.
+func (j *jitterer) apply(ttl time.Duration) time.Duration {
+	if ttl <= 0 || ttl == NoExpiration {
+		return ttl
+	}
+	j.mu.Lock()
+	r := j.rng.Float64()
+	j.mu.Unlock()
+	delta := time.Duration((2*r - 1) * j.fraction * float64(ttl))
+	if delta > 0 && ttl > NoExpiration-1-delta {
+		// Don't overflow into, or past, NoExpiration.
+		return NoExpiration - 1
+	}
+	return max(ttl+delta, 1)
+}
@@ -0,0 +1,55 @@
+package enigmacache
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTTLJitter(t *testing.T) {
+	cache, _ := NewTestCache(WithTTLJitter(0.1), WithRandSource(rand.NewPCG(1, 2)))
+	distinct := map[time.Duration]bool{}
+	for i := range 100 {
+		key := strconv.Itoa(i)
+		cache.Set(key, i, 10*time.Minute)
+		remaining, _ := cache.TTL(key)
+		if remaining < 9*time.Minute || remaining > 11*time.Minute {
+			t.Fatalf("TTL(%s) = %v, want within 10%% of 10m", key, remaining)
+		}
+		distinct[remaining] = true
+	}
+	if len(distinct) < 90 {
+		t.Errorf("only %d distinct TTLs among 100 entries", len(distinct))
+	}
+
+	cache.Set("forever", 1, NoExpiration)
+	if remaining, _ := cache.TTL("forever"); remaining != NoExpiration {
+		t.Errorf("TTL(forever) = %v, want NoExpiration", remaining)
+	}
+}
+
+func TestTTLJitterWithinMaxTTL(t *testing.T) {
+	cache, _ := NewTestCache(WithTTLJitter(0.5), WithMaxTTL(time.Minute))
+	for i := range 100 {
+		key := strconv.Itoa(i)
+		cache.Set(key, i, time.Minute)
+		if remaining, _ := cache.TTL(key); remaining > time.Minute {
+			t.Fatalf("TTL(%s) = %v, past the 1m limit", key, remaining)
+		}
+	}
+}
+
+func TestGetOrSetWithTTLReportsJitteredTTL(t *testing.T) {
+	cache, _ := NewTestCache(WithTTLJitter(0.5), WithRandSource(rand.NewPCG(1, 2)))
+	for i := range 20 {
+		key := strconv.Itoa(i)
+		_, loaded, remaining := cache.GetOrSetWithTTL(key, i, 10*time.Minute)
+		if loaded {
+			t.Fatalf("GetOrSetWithTTL(%s) loaded a missing key", key)
+		}
+		if ttl, _ := cache.TTL(key); remaining != ttl {
+			t.Fatalf("GetOrSetWithTTL(%s) reported %v, but the entry's TTL is %v", key, remaining, ttl)
+		}
+	}
+}
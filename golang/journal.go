@@ -0,0 +1,415 @@
+package enigmacache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A SyncPolicy says how often a cache built WithPersistence makes
+// sure its journal has reached the disk.
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs the journal after every write, so a write
+	// survives a crash as soon as it returns. It's the slowest.
+	SyncAlways SyncPolicy = iota
+	// SyncEverySecond syncs the journal a second after the first
+	// write since it was last synced, so a crash loses at most about
+	// a second of writes.
+	SyncEverySecond
+	// SyncNever leaves syncing to the operating system. Writes still
+	// reach the journal file straight away, so they survive the
+	// process crashing, but not necessarily the machine.
+	SyncNever
+)
+
+// WithPersistence makes the cache keep a journal of its writes in the
+// file at path, and replay it when the cache is built, so its
+// contents survive a restart, as for a cache doubling as a small
+// durable key-value store. Every value stored is logged (through
+// Refresh and the like too), as is every key removed, except by
+// expiry: entries are logged with their deadlines, and replaying
+// skips those which have passed. Idle timeouts aren't kept.
+//
+// The journal is compacted, rewritten to hold just the live entries,
+// when the cache is built and then in the background whenever it has
+// grown to twice its compacted size. Values are encoded with
+// GobEncode, so their concrete types must be registered with
+// gob.Register, unless WithPersistenceCodec says otherwise. Problems
+// with the journal are logged (see WithLogger); a cache which can't
+// replay or open its journal carries on without one. Close syncs and
+// closes the journal.
+func WithPersistence(path string, sync SyncPolicy) Option {
+	return func(o *options) {
+		o.journalPath = path
+		o.journalSync = sync
+	}
+}
+
+// WithPersistenceCodec sets how a cache built WithPersistence encodes
+// values for its journal, and decodes them again, in place of
+// GobEncode and GobDecode.
+func WithPersistenceCodec(encode func(value any) ([]byte, error), decode func(data []byte) (any, error)) Option {
+	return func(o *options) {
+		o.journalEncode = encode
+		o.journalDecode = decode
+	}
+}
+
+// A journal record, framed by its length as a uvarint before it and
+// a big-endian CRC-32 (IEEE) of it after, is journalSet or
+// journalDelete, then the key's length as a uvarint and the key. A
+// set record goes on with the deadline as varint Unix nanoseconds and
+// then the encoded value, which takes up the rest of the record.
+const (
+	journalSet    = 's'
+	journalDelete = 'd'
+	// journalMinCompact is the fewest records written between
+	// compactions.
+	journalMinCompact = 1024
+)
+
+// A journal is the write log of a cache built WithPersistence.
+type journal struct {
+	mc     *MemoryCache
+	path   string
+	sync   SyncPolicy
+	encode func(value any) ([]byte, error)
+	decode func(data []byte) (any, error)
+
+	mu sync.Mutex
+	f  *os.File
+	// written counts records written since the last compaction,
+	// which is due again at compactAt.
+	written, compactAt int
+	compacting         bool
+	// syncTimer is armed, for SyncEverySecond, while there are
+	// writes waiting to be synced.
+	syncTimer Timer
+	closed    bool
+}
+
+// openJournal replays the journal for a cache built WithPersistence,
+// then compacts it and opens it for writing.
+func (mc *MemoryCache) openJournal() {
+	j := &journal{
+		mc:     mc,
+		path:   mc.opts.journalPath,
+		sync:   mc.opts.journalSync,
+		encode: mc.opts.journalEncode,
+		decode: mc.opts.journalDecode,
+	}
+	if j.encode == nil || j.decode == nil {
+		j.encode, j.decode = GobEncode, GobDecode
+	}
+	j.encode, j.decode = mc.sealing(j.encode), mc.opening(j.decode)
+	if err := j.replay(); err != nil {
+		mc.logger().Error("enigma-cache: replaying journal failed", "path", j.path, "err", err)
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.compact(); err != nil {
+		mc.logger().Error("enigma-cache: opening journal failed", "path", j.path, "err", err)
+		return
+	}
+	mc.journal = j
+}
+
+// replay applies the journal's records to the cache. A record cut
+// short or corrupted at the end of the journal, as a crash partway
+// through writing one leaves, ends the replay there; it's lost when
+// the journal is compacted.
+func (j *journal) replay() error {
+	f, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		record, err := readJournalRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			j.mc.logger().Warn("enigma-cache: discarding the end of the journal", "path", j.path, "record", n, "err", err)
+			return nil
+		}
+		if err := j.apply(record); err != nil {
+			return fmt.Errorf("record %d: %w", n, err)
+		}
+	}
+}
+
+func readJournalRecord(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxSnapshotRecord {
+		return nil, fmt.Errorf("%w: record of %d bytes", ErrCorruptSnapshot, size)
+	}
+	record := make([]byte, size+4)
+	if _, err := io.ReadFull(r, record); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	record, sum := record[:size], record[size:]
+	if crc32.ChecksumIEEE(record) != binary.BigEndian.Uint32(sum) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorruptSnapshot)
+	}
+	return record, nil
+}
+
+// apply replays a single record.
+func (j *journal) apply(record []byte) error {
+	mc := j.mc
+	if len(record) == 0 {
+		return fmt.Errorf("%w: empty record", ErrCorruptSnapshot)
+	}
+	op, rest := record[0], record[1:]
+	keyLen, n := binary.Uvarint(rest)
+	if n <= 0 || keyLen > uint64(len(rest)-n) {
+		return fmt.Errorf("%w: bad key length", ErrCorruptSnapshot)
+	}
+	key, rest := string(rest[n:n+int(keyLen)]), rest[n+int(keyLen):]
+	switch op {
+	case journalDelete:
+		if e, ok := mc.storage.LoadAndDelete(key); ok {
+			mc.dropped(key, e, ReasonManual, false)
+		}
+		return nil
+	case journalSet:
+		deadline, n := binary.Varint(rest)
+		if n <= 0 {
+			return fmt.Errorf("%w: bad deadline", ErrCorruptSnapshot)
+		}
+		expiresAt := time.Unix(0, deadline)
+		if !expiresAt.After(mc.now()) {
+			// It's expired since; a later record may yet delete or
+			// replace it, but there's nothing to store.
+			if e, ok := mc.storage.LoadAndDelete(key); ok {
+				mc.dropped(key, e, ReasonExpired, false)
+			}
+			return nil
+		}
+		value, err := j.decode(rest[n:])
+		if err != nil {
+			return err
+		}
+		ttl := expiresAt.Sub(mc.now())
+		if expiresAt.Equal(never) {
+			ttl = NoExpiration
+		}
+		if prev, _ := mc.putEntry(key, mc.newEntry(key, value, ttl, 0)); prev != nil {
+			mc.replaced(prev)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unknown record type %q", ErrCorruptSnapshot, op)
+}
+
+// setRecord returns the record for storing e under key. It fails if
+// the value can't be decompressed or encoded.
+func (j *journal) setRecord(key string, e *entry) ([]byte, error) {
+	value, err := unpack(e.value)
+	if err != nil {
+		return nil, err
+	}
+	data, err := j.encode(value)
+	if err != nil {
+		return nil, err
+	}
+	record := []byte{journalSet}
+	record = binary.AppendUvarint(record, uint64(len(key)))
+	record = append(record, key...)
+	record = binary.AppendVarint(record, e.expiresAt.UnixNano())
+	return append(record, data...), nil
+}
+
+// deleteRecord returns the record for removing key.
+func deleteRecord(key string) []byte {
+	record := []byte{journalDelete}
+	record = binary.AppendUvarint(record, uint64(len(key)))
+	return append(record, key...)
+}
+
+// set logs that key now holds e, unless it's been overwritten or
+// removed already, in which case whatever did that logs it instead.
+// A cached loader failure isn't worth keeping across a restart, so
+// it's logged as a removal of whatever the key held before.
+func (j *journal) set(key string, e *entry) {
+	if e.tombstone() {
+		return
+	}
+	var record []byte
+	if _, failed := e.value.(failure); failed {
+		record = deleteRecord(key)
+	} else {
+		var err error
+		if record, err = j.setRecord(key, e); err != nil {
+			j.mc.logger().Error("enigma-cache: encoding a value for the journal failed", "key", key, "err", err)
+			return
+		}
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if current, ok := j.mc.storage.Load(key); !ok || current != e {
+		return
+	}
+	j.write(record)
+}
+
+// remove logs that key has been removed, unless it's been set again
+// already.
+func (j *journal) remove(key string) {
+	record := deleteRecord(key)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if current, ok := j.mc.storage.Load(key); ok && !current.tombstone() {
+		return
+	}
+	j.write(record)
+}
+
+// write appends record to the journal, syncing it as the sync policy
+// says, and starts a compaction if one is due. j.mu must be held.
+func (j *journal) write(record []byte) {
+	if j.closed {
+		return
+	}
+	if _, err := j.f.Write(frameJournalRecord(record)); err != nil {
+		j.mc.logger().Error("enigma-cache: writing the journal failed", "path", j.path, "err", err)
+		return
+	}
+	switch j.sync {
+	case SyncAlways:
+		if err := j.f.Sync(); err != nil {
+			j.mc.logger().Error("enigma-cache: syncing the journal failed", "path", j.path, "err", err)
+		}
+	case SyncEverySecond:
+		if j.syncTimer == nil {
+			j.syncTimer = j.mc.opts.clock.AfterFunc(time.Second, j.syncPending)
+		}
+	}
+	j.written++
+	if j.written >= j.compactAt && !j.compacting {
+		j.compacting = true
+		j.mc.tasks.start()
+		go func() {
+			defer j.mc.tasks.done()
+			j.mu.Lock()
+			defer j.mu.Unlock()
+			j.compacting = false
+			if j.closed {
+				return
+			}
+			if err := j.compact(); err != nil {
+				j.mc.logger().Error("enigma-cache: compacting the journal failed", "path", j.path, "err", err)
+			}
+		}()
+	}
+}
+
+func frameJournalRecord(record []byte) []byte {
+	frame := binary.AppendUvarint(nil, uint64(len(record)))
+	frame = append(frame, record...)
+	return binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(record))
+}
+
+// syncPending syncs writes waiting under SyncEverySecond.
+func (j *journal) syncPending() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.syncTimer = nil
+	if j.closed {
+		return
+	}
+	if err := j.f.Sync(); err != nil {
+		j.mc.logger().Error("enigma-cache: syncing the journal failed", "path", j.path, "err", err)
+	}
+}
+
+// compact rewrites the journal to hold a set record for each live
+// entry, as of a single instant, leaving out any whose value won't
+// encode, as set does. The new journal is written beside
+// the old one and renamed over it, so a crash partway through leaves
+// the old one in place. j.mu must be held, which holds off writes to
+// the journal meanwhile.
+func (j *journal) compact() (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	w := bufio.NewWriter(tmp)
+	live := 0
+	now := j.mc.now()
+	for _, entries := range j.mc.storage.Snapshot() {
+		for key, e := range entries {
+			if e.tombstone() || !e.live(now) {
+				continue
+			}
+			record, err := j.setRecord(key, e)
+			if err != nil {
+				j.mc.logger().Error("enigma-cache: encoding a value for the journal failed", "key", key, "err", err)
+				continue
+			}
+			if _, err := w.Write(frameJournalRecord(record)); err != nil {
+				return err
+			}
+			live++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		return err
+	}
+	if j.f != nil {
+		j.f.Close()
+	}
+	j.f = tmp
+	j.written = 0
+	j.compactAt = max(journalMinCompact, live)
+	return nil
+}
+
+// close syncs and closes the journal.
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return nil
+	}
+	j.closed = true
+	if j.syncTimer != nil {
+		j.syncTimer.Stop()
+		j.syncTimer = nil
+	}
+	err := j.f.Sync()
+	if cerr := j.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
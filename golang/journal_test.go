@@ -0,0 +1,150 @@
+package enigmacache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPersistenceReplays(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.journal")
+	cache, clock := NewTestCache(WithPersistence(path, SyncAlways))
+	cache.Set("a", "apple", time.Minute)
+	cache.Set("b", "banana", NoExpiration)
+	cache.Set("c", "cherry", time.Minute)
+	cache.Set("gone", "x", time.Minute)
+	cache.Refresh("a", time.Hour)
+	cache.Expire("gone")
+	cache.Set("c", "cranberry", time.Minute)
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close returned %v", err)
+	}
+
+	clock.Advance(30 * time.Minute)
+	restarted := NewMemoryCache(WithClock(clock), WithPersistence(path, SyncAlways))
+	defer restarted.Close()
+	got := restarted.GetAll()
+	if len(got) != 2 || got["a"] != "apple" || got["b"] != "banana" {
+		t.Errorf("replayed %v, want a and b", got)
+	}
+	if remaining, _ := restarted.TTL("a"); remaining != 30*time.Minute {
+		t.Errorf("TTL(a) = %v, want 30m", remaining)
+	}
+	if remaining, _ := restarted.TTL("b"); remaining != NoExpiration {
+		t.Errorf("TTL(b) = %v, want NoExpiration", remaining)
+	}
+}
+
+func TestPersistenceCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.journal")
+	cache := NewMemoryCache(WithPersistence(path, SyncNever))
+	for i := range 10 * journalMinCompact {
+		cache.Set("k", i, time.Hour)
+	}
+	if err := cache.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Uncompacted, the journal would hold 10240 records.
+	if info.Size() > 1<<16 {
+		t.Errorf("journal is %d bytes after compaction", info.Size())
+	}
+	restarted := NewMemoryCache(WithPersistence(path, SyncNever))
+	defer restarted.Close()
+	if value, _ := restarted.Get("k"); value != 10*journalMinCompact-1 {
+		t.Errorf("Get(k) = %v after replay, want %d", value, 10*journalMinCompact-1)
+	}
+}
+
+func TestPersistenceSkipsUnencodableValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.journal")
+	cache := NewMemoryCache(WithPersistence(path, SyncNever), WithLogger(quietLogger))
+	// gob can't encode a func, so this one never reaches the journal,
+	// and mustn't stop compaction either.
+	cache.Set("func", func() {}, time.Hour)
+	for i := range 10 * journalMinCompact {
+		cache.Set("k", i, time.Hour)
+	}
+	if err := cache.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 1<<16 {
+		t.Errorf("journal is %d bytes; compaction didn't run", info.Size())
+	}
+	restarted := NewMemoryCache(WithPersistence(path, SyncNever))
+	defer restarted.Close()
+	if value, _ := restarted.Get("k"); value != 10*journalMinCompact-1 {
+		t.Errorf("Get(k) = %v after restart, want %d", value, 10*journalMinCompact-1)
+	}
+	if restarted.Has("func") {
+		t.Error("unencodable value came back")
+	}
+}
+
+func TestPersistenceLeavesOutLoaderFailures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.journal")
+	var logs bytes.Buffer
+	cache, clock := NewTestCache(
+		WithPersistence(path, SyncAlways),
+		WithNegativeTTL(time.Hour),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+	)
+	cache.Set("k", "stale", time.Second)
+	clock.Advance(time.Second)
+	if _, err := cache.GetOrCompute("k", time.Hour, func(context.Context) (any, error) {
+		return nil, errors.New("backend down")
+	}); err == nil {
+		t.Fatal("GetOrCompute didn't fail")
+	}
+	cache.Close()
+	if logs.Len() != 0 {
+		t.Errorf("journaling a loader failure logged %q", logs.String())
+	}
+	restarted := NewMemoryCache(WithClock(clock), WithPersistence(path, SyncAlways))
+	defer restarted.Close()
+	if restarted.Has("k") {
+		t.Error("key came back after a loader failure replaced it")
+	}
+}
+
+func TestPersistenceDiscardsTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.journal")
+	cache := NewMemoryCache(WithPersistence(path, SyncAlways))
+	cache.Set("a", "apple", time.Hour)
+	cache.Close()
+
+	// A crash partway through writing a record leaves part of it.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(frameJournalRecord([]byte("s\x01bxxxx"))[:5])
+	f.Close()
+
+	restarted := NewMemoryCache(WithPersistence(path, SyncAlways))
+	defer restarted.Close()
+	if value, ok := restarted.Get("a"); !ok || value != "apple" {
+		t.Errorf("Get(a) = %v, %v after replay; want apple, true", value, ok)
+	}
+	restarted.Set("b", "banana", time.Hour)
+	restarted.Close()
+	again := NewMemoryCache(WithPersistence(path, SyncAlways))
+	defer again.Close()
+	if n := again.Len(); n != 2 {
+		t.Errorf("Len = %d after the second replay, want 2", n)
+	}
+}
@@ -0,0 +1,28 @@
+package enigmacache
+
+import "sync"
+
+// keyLockStripes is how many locks Lock shares out among keys.
+const keyLockStripes = 256
+
+// Lock acquires a lock on key and returns the function which releases
+// it, for callers serializing a compound operation on a key, such as
+// reading it, updating some external system and writing it back,
+// which neither CompareAndDeleteFunc nor Update can cover. The lock is
+// advisory: it only excludes other callers of Lock, not the cache's
+// own methods.
+//
+// Keys share a fixed set of locks, so memory use stays bounded, but
+// two keys may turn out to share a lock. Hence a goroutine must never
+// hold more than one key's lock at a time: locking a second key may
+// deadlock even if every goroutine locks keys in the same order.
+func (mc *MemoryCache) Lock(key string) (unlock func()) {
+	key = mc.normalize(key)
+	mu := mc.keyLock(key)
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (mc *MemoryCache) keyLock(key string) *sync.Mutex {
+	return &mc.keyLocks[mc.opts.hasher(key)%keyLockStripes]
+}
@@ -0,0 +1,57 @@
+package enigmacache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockSerializesSameKey(t *testing.T) {
+	cache := NewMemoryCache()
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				unlock := cache.Lock("counter")
+				// A read-modify-write which would lose updates if two
+				// goroutines interleaved.
+				n, _ := cache.Get("counter")
+				if n == nil {
+					n = 0
+				}
+				cache.Set("counter", n.(int)+1, time.Minute)
+				unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if n, _ := cache.Get("counter"); n != 1000 {
+		t.Fatalf("counter = %v, want 1000", n)
+	}
+}
+
+func TestLockLetsOtherKeysProceed(t *testing.T) {
+	cache := NewMemoryCache()
+	other := ""
+	for i := 0; other == ""; i++ {
+		if key := fmt.Sprint("key", i); cache.keyLock(key) != cache.keyLock("held") {
+			other = key
+		}
+	}
+
+	unlock := cache.Lock("held")
+	defer unlock()
+	done := make(chan struct{})
+	go func() {
+		cache.Lock(other)()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Lock(%q) blocked behind Lock(held)", other)
+	}
+}
@@ -0,0 +1,86 @@
+package enigmacache
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// WithLoaderLatencies makes the cache keep track of how long loaders
+// take, readable with LoaderLatencies. Recording a latency costs a
+// couple of atomic adds and no allocation.
+func WithLoaderLatencies() Option {
+	return func(o *options) {
+		o.loaderLatencies = true
+	}
+}
+
+// A LatencySummary describes the distribution of loader latencies.
+// The percentiles are estimates, accurate to within about 5%.
+type LatencySummary struct {
+	// Count is the number of loader calls recorded.
+	Count uint64
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+}
+
+// Latencies are counted in buckets growing by a factor of 2^(1/8),
+// about 9%, from latencyMin up to an hour or so, and reported as the
+// geometric middle of their bucket.
+const (
+	latencyMin       = time.Microsecond
+	latencyPerDouble = 8
+	latencyBuckets   = 32 * latencyPerDouble
+)
+
+type latencyHistogram struct {
+	counts [latencyBuckets]atomic.Uint64
+	total  atomic.Uint64
+}
+
+func (h *latencyHistogram) add(d time.Duration) {
+	i := 0
+	if d > latencyMin {
+		i = min(int(math.Log2(float64(d)/float64(latencyMin))*latencyPerDouble), latencyBuckets-1)
+	}
+	h.counts[i].Add(1)
+	h.total.Add(1)
+}
+
+// quantile estimates the latency below which q of the recorded ones
+// fall.
+func (h *latencyHistogram) quantile(q float64, total uint64) time.Duration {
+	rank := uint64(math.Ceil(q * float64(total)))
+	var seen uint64
+	for i := range h.counts {
+		seen += h.counts[i].Load()
+		if seen >= rank {
+			mid := math.Exp2((float64(i) + 0.5) / latencyPerDouble)
+			return time.Duration(mid * float64(latencyMin))
+		}
+	}
+	return time.Duration(math.Exp2(float64(latencyBuckets)/latencyPerDouble) * float64(latencyMin))
+}
+
+// LoaderLatencies summarizes how long loaders have taken, for a cache
+// built WithLoaderLatencies; without it, the summary is zero. Only
+// loaders run by the cache count, not callers waiting on another's
+// load. A loader which outlives its WithCallbackTimeout is recorded
+// once it finishes.
+func (mc *MemoryCache) LoaderLatencies() LatencySummary {
+	h := mc.latencies
+	if h == nil {
+		return LatencySummary{}
+	}
+	total := h.total.Load()
+	if total == 0 {
+		return LatencySummary{}
+	}
+	return LatencySummary{
+		Count: total,
+		P50:   h.quantile(0.50, total),
+		P95:   h.quantile(0.95, total),
+		P99:   h.quantile(0.99, total),
+	}
+}
@@ -0,0 +1,55 @@
+package enigmacache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogramPercentiles(t *testing.T) {
+	cache := NewMemoryCache(WithLoaderLatencies())
+	// 1ms to 1000ms, evenly.
+	for i := 1; i <= 1000; i++ {
+		cache.latencies.add(time.Duration(i) * time.Millisecond)
+	}
+
+	got := cache.LoaderLatencies()
+	if got.Count != 1000 {
+		t.Fatalf("Count = %d, want 1000", got.Count)
+	}
+	for _, c := range []struct {
+		name      string
+		got, want time.Duration
+	}{
+		{"p50", got.P50, 500 * time.Millisecond},
+		{"p95", got.P95, 950 * time.Millisecond},
+		{"p99", got.P99, 990 * time.Millisecond},
+	} {
+		if off := float64(c.got-c.want) / float64(c.want); off < -0.05 || off > 0.05 {
+			t.Errorf("%s = %v, want %v within 5%%", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestLoaderLatenciesRecordsLoads(t *testing.T) {
+	if (NewMemoryCache().LoaderLatencies() != LatencySummary{}) {
+		t.Fatal("latencies kept without WithLoaderLatencies")
+	}
+
+	cache := NewMemoryCache(WithLoaderLatencies())
+	loader := func(context.Context) (any, error) {
+		time.Sleep(10 * time.Millisecond)
+		return "value", nil
+	}
+	cache.GetOrComputeDetailed("a", time.Minute, loader)
+	cache.GetOrComputeDetailed("a", time.Minute, loader) // a hit
+	cache.GetOrComputeDetailed("b", time.Minute, failingLoader)
+
+	got := cache.LoaderLatencies()
+	if got.Count != 2 {
+		t.Fatalf("Count = %d, want 2", got.Count)
+	}
+	if got.P99 < 9*time.Millisecond {
+		t.Fatalf("P99 = %v, want at least the 10ms loader", got.P99)
+	}
+}
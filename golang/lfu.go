@@ -0,0 +1,115 @@
+package enigmacache
+
+import "container/list"
+
+// lfuPolicy implements WithEvictionPolicy(LFU). Keys are kept in
+// buckets by how often they've been used, so every operation is O(1):
+// a use moves a key to the bucket for one more use, creating it if
+// need be, and the victim is the least recently used key in the
+// lowest bucket. The key inserted last is spared, since the cache
+// evicts after inserting, and a new key, used just once, would
+// otherwise be the victim whenever the cache is full.
+type lfuPolicy struct {
+	// buckets holds *lfuBuckets, fewest uses at the front.
+	buckets *list.List
+	// elements maps each key to its element in its bucket's keys.
+	elements map[string]*list.Element
+	// newest is the key inserted last, if it's still tracked.
+	newest string
+}
+
+// An lfuBucket holds the keys used the same number of times.
+type lfuBucket struct {
+	uses uint64
+	// keys holds *lfuKeys, most recently used at the front.
+	keys *list.List
+}
+
+type lfuKey struct {
+	key    string
+	bucket *list.Element
+}
+
+func newLFUPolicy() *lfuPolicy {
+	return &lfuPolicy{
+		buckets:  list.New(),
+		elements: make(map[string]*list.Element),
+	}
+}
+
+func (p *lfuPolicy) add(key string) {
+	if _, ok := p.elements[key]; ok {
+		p.access(key)
+		return
+	}
+	first := p.buckets.Front()
+	if first == nil || first.Value.(*lfuBucket).uses != 1 {
+		first = p.buckets.PushFront(&lfuBucket{uses: 1, keys: list.New()})
+	}
+	k := &lfuKey{key: key, bucket: first}
+	p.elements[key] = first.Value.(*lfuBucket).keys.PushFront(k)
+	p.newest = key
+}
+
+func (p *lfuPolicy) access(key string) {
+	el, ok := p.elements[key]
+	if !ok {
+		return
+	}
+	k := el.Value.(*lfuKey)
+	from := k.bucket
+	uses := from.Value.(*lfuBucket).uses + 1
+	to := from.Next()
+	if to == nil || to.Value.(*lfuBucket).uses != uses {
+		to = p.buckets.InsertAfter(&lfuBucket{uses: uses, keys: list.New()}, from)
+	}
+	p.unlink(el)
+	k.bucket = to
+	p.elements[key] = to.Value.(*lfuBucket).keys.PushFront(k)
+}
+
+func (p *lfuPolicy) remove(key string) {
+	if el, ok := p.elements[key]; ok {
+		p.unlink(el)
+		delete(p.elements, key)
+		if key == p.newest {
+			p.newest = ""
+		}
+	}
+}
+
+// unlink takes el out of its bucket, dropping the bucket if it's left
+// empty.
+func (p *lfuPolicy) unlink(el *list.Element) {
+	bucket := el.Value.(*lfuKey).bucket
+	b := bucket.Value.(*lfuBucket)
+	b.keys.Remove(el)
+	if b.keys.Len() == 0 {
+		p.buckets.Remove(bucket)
+	}
+}
+
+func (p *lfuPolicy) keys() []string {
+	keys := make([]string, 0, len(p.elements))
+	for bucket := p.buckets.Front(); bucket != nil; bucket = bucket.Next() {
+		for el := bucket.Value.(*lfuBucket).keys.Back(); el != nil; el = el.Prev() {
+			keys = append(keys, el.Value.(*lfuKey).key)
+		}
+	}
+	return keys
+}
+
+func (p *lfuPolicy) len() int {
+	return len(p.elements)
+}
+
+func (p *lfuPolicy) victim() (string, bool) {
+	for bucket := p.buckets.Front(); bucket != nil; bucket = bucket.Next() {
+		for el := bucket.Value.(*lfuBucket).keys.Back(); el != nil; el = el.Prev() {
+			if key := el.Value.(*lfuKey).key; key != p.newest || p.len() == 1 {
+				return key, true
+			}
+		}
+	}
+	return "", false
+}
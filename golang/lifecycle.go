@@ -0,0 +1,84 @@
+package enigmacache
+
+import (
+	"context"
+	"errors"
+)
+
+// WithEvictOnClose makes Close and Shutdown empty the cache, removing
+// every entry with ReasonCleared, as ExpireAll does, so that the
+// WithOnEvicted callback hears about each, and Releasable values and
+// the cancel funcs given to SetWithCancel are released, rather than
+// being left for the garbage collector. The removals aren't journaled
+// or published, so a cache built WithPersistence comes back with its
+// entries, and peers on an invalidation bus keep theirs.
+func WithEvictOnClose() Option {
+	return func(o *options) {
+		o.evictOnClose = true
+	}
+}
+
+// Close shuts the cache down. It flushes the writes queued by
+// WithWriteBehind, syncs and closes the WithPersistence journal,
+// unsubscribes from the WithInvalidationBus bus and stops the cache's
+// timers: its background expiration, so entries past their deadline
+// are no longer removed of its own accord (though reads still treat
+// them as missing), and any samplers started by StartSampling. Then,
+// for a cache built WithEvictOnClose, it removes every entry. Close
+// doesn't wait for work already in flight, such as a running loader;
+// Shutdown does.
+//
+// A closed cache still serves reads of what it holds, and removals,
+// but stores nothing more: writes without an error to return are
+// ignored, as a rejected value is, and the others, as well as loads on
+// a miss, fail with ErrClosed (see the package's errors). Removals
+// aren't journaled or published, though with write-through they still
+// reach the store.
+//
+// Close returns the errors flushing the queue and closing the journal
+// report. It may be called more than once; only the first call does
+// anything.
+func (mc *MemoryCache) Close() error {
+	return mc.shutdown(nil)
+}
+
+// Shutdown is Close, but also waits for the work the cache has in
+// flight, as Drain does, before returning, unless ctx is done first,
+// in which case it returns ctx.Err() along with any other errors. Work
+// which finishes after the cache has closed stores nothing; a loader's
+// value still reaches the callers waiting on it. The queue is flushed
+// with ctx, and Close's work is done whether or not ctx is done.
+func (mc *MemoryCache) Shutdown(ctx context.Context) error {
+	return mc.shutdown(ctx)
+}
+
+// shutdown does the work of Close, and, if ctx isn't nil, Shutdown.
+func (mc *MemoryCache) shutdown(ctx context.Context) error {
+	if !mc.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	// Writes are refused from here on, so the queue can only shrink.
+	var errs []error
+	if mc.behind != nil {
+		flushCtx := ctx
+		if flushCtx == nil {
+			flushCtx = context.Background()
+		}
+		errs = append(errs, mc.Flush(flushCtx))
+	}
+	if mc.journal != nil {
+		errs = append(errs, mc.journal.close())
+	}
+	if mc.invalidator != nil {
+		mc.invalidator.close()
+	}
+	mc.stopExpiry()
+	mc.stopSamplers()
+	if mc.opts.evictOnClose {
+		mc.clear(true)
+	}
+	if ctx != nil {
+		errs = append(errs, mc.tasks.wait(ctx))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,148 @@
+package enigmacache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClosedCacheRefusesWrites(t *testing.T) {
+	cache, _ := NewTestCache()
+	cache.Set("kept", 1, time.Hour)
+	var snapshot bytes.Buffer
+	if err := cache.SaveBinary(&snapshot, GobEncode); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("new", 2, time.Hour)
+	if cache.Has("new") {
+		t.Error("Set stored a value after Close")
+	}
+	if value, ok := cache.Get("kept"); !ok || value != 1 {
+		t.Errorf("Get(kept) = %v, %v after Close; want 1, true", value, ok)
+	}
+	loads := 0
+	loader := func(context.Context) (any, error) {
+		loads++
+		return 3, nil
+	}
+	if value, err := cache.GetOrCompute("kept", time.Hour, loader); err != nil || value != 1 {
+		t.Errorf("GetOrCompute of a held key = %v, %v; want 1, nil", value, err)
+	}
+	ctx := context.Background()
+	for name, err := range map[string]error{
+		"GetOrCompute": func() error { _, err := cache.GetOrCompute("missing", time.Hour, loader); return err }(),
+		"SetContext":   cache.SetContext(ctx, "new", 2, time.Hour),
+		"Update": func() error {
+			_, err := cache.Update("kept", func(any, bool) (any, bool) { return 2, true }, time.Hour)
+			return err
+		}(),
+		"Increment":  func() error { _, err := cache.Increment("n", 1, time.Hour); return err }(),
+		"AddToSet":   cache.AddToSet("set", "x", time.Hour),
+		"LoadBinary": func() error { _, err := cache.LoadBinary(&snapshot, GobDecode); return err }(),
+	} {
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("%s after Close returned %v, want ErrClosed", name, err)
+		}
+	}
+	if loads != 0 {
+		t.Errorf("loader ran %d times after Close", loads)
+	}
+	if _, loaded := cache.Expire("kept"); !loaded || cache.Has("kept") {
+		t.Error("Expire didn't remove a key after Close")
+	}
+}
+
+func TestCloseFlushesWriteBehind(t *testing.T) {
+	l2 := newFakeStore(0)
+	cache, _ := NewTestCache(WithWriteThrough(l2), WithWriteBehind(time.Hour, 0))
+	ctx := context.Background()
+	cache.Set("key", "value", time.Minute)
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, _ := l2.Get(ctx, "key"); !ok || value != "value" {
+		t.Errorf("store holds (%v, %v) after Close, want (value, true)", value, ok)
+	}
+	// With nothing left to flush the queue, deletes go straight
+	// through.
+	cache.Expire("key")
+	if _, ok, _ := l2.Get(ctx, "key"); ok {
+		t.Error("delete after Close didn't reach the store")
+	}
+}
+
+func TestEvictOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.journal")
+	var reasons []EvictionReason
+	cache, clock := NewTestCache(
+		WithPersistence(path, SyncAlways),
+		WithEvictOnClose(),
+		WithOnEvicted(func(_ string, _ any, reason EvictionReason) {
+			reasons = append(reasons, reason)
+		}),
+	)
+	cache.Set("a", 1, time.Hour)
+	cache.Set("b", 2, time.Hour)
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(reasons) != 2 || reasons[0] != ReasonCleared || reasons[1] != ReasonCleared {
+		t.Errorf("OnEvicted saw %v, want two ReasonCleared", reasons)
+	}
+	if n := cache.Len(); n != 0 {
+		t.Errorf("Len = %d after Close, want 0", n)
+	}
+
+	// Emptying the cache on Close isn't journaled.
+	restarted := NewMemoryCache(WithClock(clock), WithPersistence(path, SyncAlways))
+	defer restarted.Close()
+	if n := restarted.Len(); n != 2 {
+		t.Errorf("restarted cache holds %d keys, want 2", n)
+	}
+}
+
+func TestShutdownWaitsForLoaders(t *testing.T) {
+	cache := NewMemoryCache()
+	started, release := make(chan struct{}), make(chan struct{})
+	result := make(chan any)
+	go func() {
+		value, _ := cache.GetOrCompute("slow", time.Hour, func(context.Context) (any, error) {
+			close(started)
+			<-release
+			return "loaded", nil
+		})
+		result <- value
+	}()
+	<-started
+
+	samples, _ := cache.StartSampling(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := cache.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown with a loader running = %v, want DeadlineExceeded", err)
+	}
+	if _, open := <-samples; open {
+		t.Error("sampler still running after Shutdown")
+	}
+
+	close(release)
+	if value := <-result; value != "loaded" {
+		t.Errorf("waiting caller got %v, want loaded", value)
+	}
+	if err := cache.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Has("slow") {
+		t.Error("a load finishing after Shutdown stored its value")
+	}
+	if err := cache.Shutdown(context.Background()); err != nil {
+		t.Errorf("second Shutdown = %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package enigmacache
+
+import (
+	"math"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// LifetimeOverflow is the LifetimeHistogram bucket counting lifetimes
+// longer than the largest bucket bound.
+const LifetimeOverflow time.Duration = math.MaxInt64
+
+// WithLifetimeHistogram makes the cache keep a histogram of how long
+// entries lived, from being written to expiring, readable with
+// LifetimeHistogram. Each bucket is given by its upper bound, and
+// counts the lifetimes longer than the next smaller bound and no
+// longer than its own. Only entries which expired count: one removed
+// early, whether by Expire, eviction or ExpireAll, would skew the
+// picture of your TTLs. Refreshing an entry doesn't restart its
+// lifetime, but overwriting it does.
+func WithLifetimeHistogram(buckets ...time.Duration) Option {
+	return func(o *options) {
+		o.lifetimeBuckets = buckets
+	}
+}
+
+type lifetimeHistogram struct {
+	// bounds are the bucket bounds in ascending order, and counts
+	// their counts, with one more for LifetimeOverflow.
+	bounds []time.Duration
+	counts []atomic.Uint64
+}
+
+func newLifetimeHistogram(buckets []time.Duration) *lifetimeHistogram {
+	bounds := slices.Clone(buckets)
+	slices.Sort(bounds)
+	bounds = slices.Compact(bounds)
+	return &lifetimeHistogram{
+		bounds: bounds,
+		counts: make([]atomic.Uint64, len(bounds)+1),
+	}
+}
+
+func (h *lifetimeHistogram) add(lifetime time.Duration) {
+	i, _ := slices.BinarySearch(h.bounds, lifetime)
+	h.counts[i].Add(1)
+}
+
+// LifetimeHistogram returns how many expired entries lived for each
+// bucketed length of time, keyed by bucket bound, with lifetimes past
+// the largest bound under LifetimeOverflow. It returns nil unless the
+// cache was built WithLifetimeHistogram.
+func (mc *MemoryCache) LifetimeHistogram() map[time.Duration]uint64 {
+	h := mc.lifetimes
+	if h == nil {
+		return nil
+	}
+	counts := make(map[time.Duration]uint64, len(h.counts))
+	for i := range h.counts {
+		bound := LifetimeOverflow
+		if i < len(h.bounds) {
+			bound = h.bounds[i]
+		}
+		counts[bound] = h.counts[i].Load()
+	}
+	return counts
+}
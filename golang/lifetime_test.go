@@ -0,0 +1,33 @@
+package enigmacache
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestLifetimeHistogram(t *testing.T) {
+	if NewMemoryCache().LifetimeHistogram() != nil {
+		t.Fatal("histogram kept without WithLifetimeHistogram")
+	}
+
+	cache, clock := NewTestCache(WithLifetimeHistogram(20*time.Millisecond, 10*time.Millisecond))
+	cache.Set("a", 1, 5*time.Millisecond)
+	cache.Set("b", 2, 5*time.Millisecond)
+	cache.Set("c", 3, 15*time.Millisecond)
+	cache.Set("d", 4, 30*time.Millisecond)
+	cache.Set("refreshed", 5, 5*time.Millisecond)
+	cache.Refresh("refreshed", 15*time.Millisecond)
+	cache.Set("removed", 6, 5*time.Millisecond)
+	cache.Expire("removed")
+	clock.Advance(80 * time.Millisecond)
+
+	want := map[time.Duration]uint64{
+		10 * time.Millisecond: 2,
+		20 * time.Millisecond: 2,
+		LifetimeOverflow:      1,
+	}
+	if got := cache.LifetimeHistogram(); !maps.Equal(got, want) {
+		t.Fatalf("histogram = %v, want %v", got, want)
+	}
+}
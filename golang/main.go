@@ -1,32 +1,473 @@
-package main
+// Package enigmacache is an in-memory cache of string keys to values
+// of any type, each kept until its TTL runs out. Build one with
+// NewMemoryCache, passing Options to configure it.
+package enigmacache
 
 import (
-	"fmt"
+	"context"
+	"path"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // A MemoryCache stores key/value pairs in-memory. Keys are strings.
+//
+// The functions a cache is given, such as OnEvicted callbacks,
+// admission filters, loaders and Release methods, are only ever called
+// with no cache locks held, so they may call back into the cache
+// freely, even to write the key they were called about. (A Set from
+// OnEvicted in a full cache evicts another entry in turn, so such a
+// callback should have some way of stopping.) The one exception is
+// the function passed to WithinShard, which runs with its shard
+// locked and must use the ShardTxn it's given instead.
 type MemoryCache struct {
-	storage sync.Map
+	opts    options
+	storage backend
+	// created is when the cache was built, by its clock.
+	created time.Time
+	// generation is the source of entry generation numbers; see
+	// entry.gen.
+	generation atomic.Uint64
+	// flights de-duplicates concurrent loads of the same key.
+	flights flightGroup
+	// groupFlights de-duplicates loads for GetOrComputeGroup.
+	groupFlights flightGroup
+	// doFlights de-duplicates calls to Do.
+	doFlights flightGroup
+	// keyLocks are the locks handed out by Lock.
+	keyLocks [keyLockStripes]sync.Mutex
+	// tasks counts in-flight work, for Drain.
+	tasks taskTracker
+	// closed is set by Close and Shutdown.
+	closed atomic.Bool
+	// count is the number of entries in storage, including any which
+	// are past their deadline but not yet removed.
+	count atomic.Int64
+	// bytes is the estimated memory taken up by the entries in
+	// storage; see estimateCost.
+	bytes atomic.Int64
+	// expiry holds the entries' pending expirations.
+	expiry expiryScheduler
+	// timers is the number of entries in expiry, kept apart so it can
+	// be read without the scheduler's lock.
+	timers atomic.Int64
+	// loaders and storeWrites count goroutines running loaders and
+	// writes to the write-through store; see GoroutineStats.
+	loaders     atomic.Int64
+	storeWrites atomic.Int64
+	// background holds a token for each goroutine counted against
+	// WithMaxBackgroundGoroutines. It's nil if there's no cap.
+	background chan struct{}
+	// jitter is nil unless the cache was built WithTTLJitter.
+	jitter *jitterer
+	// filled records whether the cache is above its fill threshold;
+	// see WithFillThreshold.
+	filled atomic.Bool
+
+	// policy, if the cache is bounded, tracks keys to choose which to
+	// evict, apart from those in pinned, which it leaves alone (see
+	// Pin). Both are guarded by policyMu.
+	policyMu sync.Mutex
+	policy   evictionPolicy
+	pinned   map[string]struct{}
+	// policyReads is set if the policy needs to hear about reads, as
+	// exact and segmented LRU and LFU do; FIFO ignores them, and approximate
+	// LRU goes by the entry's access time alone, so they skip the
+	// policy lock.
+	policyReads bool
+
+	stats stats
+	// evictions is nil unless the cache was built WithEvictionLog.
+	evictions *evictionLog
+	// tap is nil unless the cache was built WithExpiryTap.
+	tap *expiryTap
+	// invalidator is nil unless the cache was built
+	// WithInvalidationBus, and has subscribed to it.
+	invalidator *invalidator
+	// prefixes is nil unless the cache was built WithPrefixMetrics.
+	prefixes *prefixMetrics
+	// lifetimes is nil unless the cache was built
+	// WithLifetimeHistogram.
+	lifetimes *lifetimeHistogram
+	// journal is nil unless the cache was built WithPersistence.
+	journal *journal
+	// behind is nil unless the cache was built WithWriteBehind.
+	behind *writeBehind
+	// indexes is nil unless the cache was built WithIndex.
+	indexes *indexes
+	// namespaces tracks the keys under the prefixes passed to
+	// Namespace.
+	namespaces namespaces
+	// latencies is nil unless the cache was built
+	// WithLoaderLatencies.
+	latencies *latencyHistogram
+	// callbacks runs OnEvicted for a cache built WithEvictionWorkers.
+	callbacks callbackPool
+	// watchers holds the channels handed out by Done and GetWait.
+	watchers keyWatchers
+	// sizes wakes WaitForSize when the entry count changes.
+	sizes waitRegistry
+	// backlog paces removals of long-overdue entries; see
+	// WithLateExpiryPacing.
+	backlog expiryBacklog
+	// samplers holds the samplers started by StartSampling.
+	samplers samplerSet
+}
+
+func NewMemoryCache(opts ...Option) *MemoryCache {
+	mc := &MemoryCache{}
+	for _, opt := range opts {
+		opt(&mc.opts)
+	}
+	if mc.opts.hasher == nil {
+		mc.opts.hasher = fnv1a
+	}
+	if mc.opts.clock == nil {
+		mc.opts.clock = systemClock{}
+	}
+	mc.created = mc.now()
+	if mc.opts.maxBackground > 0 {
+		mc.background = make(chan struct{}, mc.opts.maxBackground)
+	}
+	if mc.opts.backend != nil {
+		mc.storage = &customBackend{b: mc.opts.backend}
+	} else if mc.opts.shards > 1 {
+		mc.storage = newShardedBackend(mc.opts.shards, mc.opts.hasher)
+	} else {
+		// No need to initialize like we would a standard map; from
+		// the `sync` docs: "The zero Map is empty and ready for use."
+		mc.storage = &syncMapBackend{}
+	}
+	if mc.bounded() {
+		switch {
+		case mc.opts.evictionPolicy == FIFO:
+			mc.policy = newFIFOPolicy()
+		case mc.opts.evictionPolicy == LFU:
+			mc.policy = newLFUPolicy()
+			mc.policyReads = true
+		case mc.opts.protectedFraction > 0:
+			mc.policy = newSLRUPolicy(mc.opts.protectedFraction, mc.opts.maxEntries)
+			mc.policyReads = true
+		case mc.opts.lruSamples > 0:
+			mc.policy = newSampledPolicy(mc.opts.lruSamples, mc.rand(), mc.lastAccess)
+		default:
+			mc.policy = newLRUPolicy()
+			mc.policyReads = true
+		}
+		mc.pinned = make(map[string]struct{})
+	}
+	if mc.opts.ttlJitter > 0 {
+		mc.jitter = newJitterer(mc.opts.ttlJitter, mc.rand())
+	}
+	if mc.opts.evictionLog > 0 {
+		mc.evictions = newEvictionLog(mc.opts.evictionLog)
+	}
+	if mc.opts.tapWriter != nil {
+		mc.tap = newExpiryTap(mc.opts.tapWriter, mc.opts.tapSerializer, mc.opts.tapReasons)
+	}
+	if mc.opts.prefixSeparator != "" {
+		mc.prefixes = newPrefixMetrics(mc.opts.prefixSeparator, mc.opts.maxPrefixes)
+	}
+	if mc.opts.writeThrough != nil && (mc.opts.behindInterval > 0 || mc.opts.behindBatch > 0) {
+		mc.behind = &writeBehind{}
+	}
+	if len(mc.opts.indexes) > 0 {
+		mc.indexes = newIndexes(mc.opts.indexes)
+	}
+	if mc.opts.loaderLatencies {
+		mc.latencies = &latencyHistogram{}
+	}
+	if len(mc.opts.lifetimeBuckets) > 0 {
+		mc.lifetimes = newLifetimeHistogram(mc.opts.lifetimeBuckets)
+	}
+	mc.callbacks.n = mc.opts.evictionWorkers
+	if mc.opts.journalPath != "" {
+		mc.openJournal()
+	}
+	if mc.opts.bus != nil {
+		mc.subscribe()
+	}
+	return mc
+}
+
+// rejects reports whether the cache's options forbid storing value,
+// as they do any value once the cache is closed.
+func (mc *MemoryCache) rejects(value any) bool {
+	return mc.closed.Load() || (mc.opts.rejectNil && isNil(value))
 }
 
-func NewMemoryCache() *MemoryCache {
-	// No need to initialize like we would a standard map; from the
-	// `sync` docs: "The zero Map is empty and ready for use."
-	return &MemoryCache{}
+// load returns the live entry for key. An entry past its deadline
+// which hasn't been removed yet (because it's being kept around for
+// WithStaleIfError, or its timer simply hasn't run) is treated as
+// missing, as is a cached loader failure (see WithNegativeTTL).
+func (mc *MemoryCache) load(key string) (*entry, bool) {
+	e, ok := mc.storage.Load(key)
+	if !ok || !e.live(mc.now()) {
+		return nil, false
+	}
+	return e, true
+}
+
+// accessed does the bookkeeping for a read of e.
+func (mc *MemoryCache) accessed(key string, e *entry) {
+	mc.hit(key, e)
+	if mc.policyReads {
+		mc.policyMu.Lock()
+		mc.policy.access(key)
+		mc.policyMu.Unlock()
+	}
+}
+
+// hit does accessed's bookkeeping short of telling the eviction
+// policy, which GetMany does for all its keys at once.
+func (mc *MemoryCache) hit(key string, e *entry) {
+	mc.stats.hits.Add(1)
+	if mc.prefixes != nil {
+		mc.prefixes.counters(key).hits.Add(1)
+	}
+	e.touch(mc.now())
+	e.accesses.Add(1)
+	mc.record(key)
+	if floor := mc.opts.refreshFloor; floor > 0 && e.expiresAt.Sub(mc.now()) < floor {
+		mc.touch(key, mc.opts.refreshTTL)
+	}
+}
+
+// lastAccess returns the access time of key's entry, if it's stored.
+func (mc *MemoryCache) lastAccess(key string) (int64, bool) {
+	e, ok := mc.storage.Load(key)
+	if !ok {
+		return 0, false
+	}
+	return e.lastAccess.Load(), true
+}
+
+// missed does the bookkeeping for a read which didn't find key.
+func (mc *MemoryCache) missed(key string) {
+	mc.stats.misses.Add(1)
+	mc.record(key)
+	if mc.prefixes != nil {
+		mc.prefixes.counters(key).misses.Add(1)
+	}
+}
+
+// stored does the bookkeeping for e having just been written to
+// storage for key, replacing prev, which is nil if the key was
+// absent. Every path which adds an entry goes through here, apart
+// from TTL refreshes, which replace an entry with a copy of itself.
+func (mc *MemoryCache) stored(key string, e, prev *entry) {
+	mc.storedKeeping(key, e, prev)
+	if prev != nil {
+		mc.replaced(prev)
+	}
+}
+
+// storedKeeping is stored, except that it leaves prev's value to the
+// caller rather than releasing it (see Releasable).
+func (mc *MemoryCache) storedKeeping(key string, e, prev *entry) {
+	if !e.tombstone() {
+		mc.stats.sets.Add(1)
+		if mc.prefixes != nil {
+			mc.prefixes.counters(key).sets.Add(1)
+		}
+	}
+	if prev != nil {
+		mc.cancel(prev)
+		mc.bytes.Add(e.cost - prev.cost)
+	} else {
+		mc.bytes.Add(e.cost)
+		mc.count.Add(1)
+		mc.checkFill()
+		mc.sizes.fire("")
+		mc.namespaces.stored(key)
+	}
+	mc.schedule(key, e)
+	if mc.indexes != nil {
+		mc.indexes.stored(key, e)
+	}
+	mc.watchers.added(key)
+	if mc.journal != nil {
+		mc.journal.set(key, e)
+	}
+	if mc.policy != nil {
+		mc.policyMu.Lock()
+		if _, pinned := mc.pinned[key]; !pinned {
+			if prev != nil {
+				mc.policy.access(key)
+			} else {
+				mc.policy.add(key)
+			}
+		}
+		mc.policyMu.Unlock()
+		mc.evict()
+	}
+}
+
+// removed does the bookkeeping for e having just been deleted from
+// storage for key, for the given reason. Every path which removes an
+// entry goes through here, or through dropped.
+func (mc *MemoryCache) removed(key string, e *entry, reason EvictionReason) {
+	mc.dropped(key, e, reason, true)
+	if reason == ReasonManual && !e.tombstone() {
+		mc.invalidate(key, false)
+	}
+}
+
+// dropped is removed, except that the OnEvicted callback is only
+// called if notify is set.
+func (mc *MemoryCache) dropped(key string, e *entry, reason EvictionReason, notify bool) {
+	mc.cancel(e)
+	mc.bytes.Add(-e.cost)
+	mc.count.Add(-1)
+	mc.checkFill()
+	mc.sizes.fire("")
+	if mc.policy != nil {
+		mc.policyMu.Lock()
+		mc.policy.remove(key)
+		delete(mc.pinned, key)
+		mc.policyMu.Unlock()
+	}
+	if mc.indexes != nil {
+		mc.indexes.removed(key, e)
+	}
+	mc.namespaces.removed(mc, key)
+	if e.tombstone() {
+		// The key itself left when the tombstone went in.
+		return
+	}
+	mc.stats.left[reason].Add(1)
+	if mc.journal != nil && reason != ReasonExpired {
+		mc.journal.remove(key)
+	}
+	if mc.evictions != nil {
+		mc.evictions.add(EvictionRecord{Key: key, Reason: reason, At: mc.now()})
+	}
+	if mc.tap != nil {
+		mc.tapped(key, e, reason)
+	}
+	if mc.lifetimes != nil && reason == ReasonExpired {
+		// An expired entry's life ended at its deadline, however long
+		// after that it was kept around or its timer took to run.
+		mc.lifetimes.add(time.Duration(e.deadline().UnixNano() - e.created))
+	}
+	mc.watchers.removed(key)
+	mc.evicted(key, e, reason, notify)
+	if reason == ReasonManual && mc.opts.tombstoneTTL > 0 {
+		mc.entomb(key)
+	}
 }
 
 // Set unconditionally sets a key in the cache to the given value. The
-// key will be removed after the given ttl has elapsed.
+// key will be removed after the given ttl has elapsed, or never, for a
+// ttl of NoExpiration; a ttl of zero or less removes it. A cache built
+// WithRejectNil ignores nil values, a full cache may turn away a new
+// key (see WithAdmissionFilter), and a value too big for the cache's
+// byte budget is ignored (see WithMaxMemory). Pass ValueTTL to take
+// the TTL from a value which is an Expirer.
 func (mc *MemoryCache) Set(key string, value interface{}, ttl time.Duration) {
-	mc.storage.Store(key, value)
-	time.AfterFunc(ttl, func() {
-		mc.storage.Delete(key)
-	})
-	// The underlying Store operation always succeeds, and the delayed
+	key = mc.normalize(key)
+	mc.SetWithIdle(key, value, ttl, 0)
+}
+
+// SetDefault sets key to value like Set, with the cache's default TTL
+// (see WithDefaultTTL), for callers with no TTL of their own in mind.
+func (mc *MemoryCache) SetDefault(key string, value any) {
+	mc.Set(key, value, mc.defaultTTL())
+}
+
+// defaultTTL returns the TTL SetDefault uses.
+func (mc *MemoryCache) defaultTTL() time.Duration {
+	if ttl := mc.opts.defaultTTL; ttl > 0 {
+		return ttl
+	}
+	return NoExpiration
+}
+
+// SetWithIdle sets a key in the cache to the given value, like Set,
+// but the key is also removed if it goes unread for the idle
+// duration, whichever comes first. Each Get resets the idle clock;
+// the ttl is a hard limit which reads never extend. An idle of zero
+// disables idle expiration.
+func (mc *MemoryCache) SetWithIdle(key string, value any, ttl, idle time.Duration) {
+	key = mc.normalize(key)
+	ttl, ok := mc.valueTTL(key, value, ttl)
+	if ok && mc.set(key, value, ttl, idle) {
+		// Errors from the write-through store can't be reported here;
+		// callers who care use SetContext.
+		_ = mc.writeThrough(context.Background(), key, value, ttl)
+	}
+}
+
+// set stores value for key in memory, reporting whether it did so
+// (it won't if the cache's options reject the value).
+func (mc *MemoryCache) set(key string, value any, ttl, idle time.Duration) bool {
+	prev, ok := mc.put(key, value, ttl, idle)
+	if prev != nil {
+		mc.replaced(prev)
+	}
+	return ok
+}
+
+// put does the work of set, returning the entry it displaced, if any,
+// without releasing its value.
+func (mc *MemoryCache) put(key string, value any, ttl, idle time.Duration) (prev *entry, ok bool) {
+	if mc.rejects(value) {
+		return nil, false
+	}
+	return mc.putEntry(key, mc.newEntry(key, value, ttl, idle))
+}
+
+// putEntry stores e for key, as put does, unless it's too big or the
+// admission filter turns it away.
+func (mc *MemoryCache) putEntry(key string, e *entry) (prev *entry, ok bool) {
+	if !mc.fits(e) {
+		return nil, false
+	}
+	current, ok := mc.storage.Load(key)
+	if !ok && !mc.admit(key, e.cost) {
+		return nil, false
+	}
+	if ok {
+		mc.checkShortening(key, e, current)
+	}
+	prev, _ = mc.storage.Swap(key, e)
+	mc.storedKeeping(key, e, prev)
+	// The underlying Swap operation always succeeds, and the delayed
 	// Delete as well, so there's no need for error tracking here.
-	return
+	return prev, true
+}
+
+// SetAndReturnPrevious sets key like Set and returns the value it
+// displaced, if the key held one. Ownership of a Releasable previous
+// value passes to the caller: the cache doesn't release it, since
+// it's been handed back. If the value is turned away (see Set),
+// nothing is displaced and existed is false.
+func (mc *MemoryCache) SetAndReturnPrevious(key string, value any, ttl time.Duration) (prev any, existed bool) {
+	key = mc.normalize(key)
+	ttl, ok := mc.valueTTL(key, value, ttl)
+	if !ok {
+		return nil, false
+	}
+	old, ok := mc.put(key, value, ttl, 0)
+	if !ok {
+		return nil, false
+	}
+	_ = mc.writeThrough(context.Background(), key, value, ttl)
+	if old == nil {
+		return nil, false
+	}
+	if !old.live(mc.now()) {
+		// The caller never sees an expired value, so it's still ours
+		// to release.
+		mc.replaced(old)
+		return nil, false
+	}
+	// The caller only gets the value back, so a cancel func stored
+	// with it is still ours to call.
+	mc.finished(old)
+	return old.get(), true
 }
 
 // GetOrSet returns the existing value for the key if
@@ -34,70 +475,494 @@ func (mc *MemoryCache) Set(key string, value interface{}, ttl time.Duration) {
 // expiration and returns the given value. The loaded result is true
 // if the value was present, false otherwise.
 func (mc *MemoryCache) GetOrSet(key string, value interface{}, ttl time.Duration) (actual any, loaded bool) {
-	val, loaded := mc.storage.LoadOrStore(key, value)
-	if !loaded {
-		time.AfterFunc(ttl, func() {
-			mc.storage.Delete(key)
-		})
+	key = mc.normalize(key)
+	actual, loaded, _ = mc.GetOrSetWithTTL(key, value, ttl)
+	return actual, loaded
+}
+
+// GetOrSetOK behaves like GetOrSet, but also reports whether the key
+// is present once it returns, which GetOrSet leaves ambiguous: its
+// (nil, false) means a nil value was stored, unless the cache's
+// options turned it away (see WithRejectNil, WithMaxMemory and
+// WithAdmissionFilter), in which case nothing was. present is true if
+// the value was loaded or stored, and false if it was turned away.
+func (mc *MemoryCache) GetOrSetOK(key string, value any, ttl time.Duration) (actual any, loaded, present bool) {
+	key = mc.normalize(key)
+	actual, loaded, present, _ = mc.getOrSet(key, value, ttl)
+	return actual, loaded, present
+}
+
+// GetOrSetWithTTL behaves like GetOrSet, but also reports the TTL in
+// effect for the key. If the value was loaded, remaining is how long
+// the existing entry has left; otherwise it is the TTL which was
+// applied to the newly-stored value, or zero if the value was turned
+// away (see WithRejectNil and WithAdmissionFilter).
+func (mc *MemoryCache) GetOrSetWithTTL(key string, value any, ttl time.Duration) (actual any, loaded bool, remaining time.Duration) {
+	key = mc.normalize(key)
+	actual, loaded, _, remaining = mc.getOrSet(key, value, ttl)
+	return actual, loaded, remaining
+}
+
+// getOrSet implements GetOrSetWithTTL and GetOrSetOK.
+func (mc *MemoryCache) getOrSet(key string, value any, ttl time.Duration) (actual any, loaded, present bool, remaining time.Duration) {
+	if e, ok := mc.load(key); ok {
+		if actual, ok := mc.read(key, e); ok {
+			mc.accessed(key, e)
+			return actual, true, true, e.remaining(mc.now())
+		}
 	}
+	mc.missed(key)
+	ttl, ok := mc.valueTTL(key, value, ttl)
+	if !ok || mc.rejects(value) {
+		return nil, false, false, 0
+	}
+	e := mc.newEntry(key, value, ttl, 0)
+	if !mc.fits(e) || !mc.admit(key, e.cost) {
+		return value, false, false, 0
+	}
+	for {
+		existing, loaded := mc.storage.LoadOrStore(key, e)
+		if !loaded {
+			mc.stored(key, e, nil)
+			_ = mc.writeThrough(context.Background(), key, value, ttl)
+			now := mc.now()
+			return value, false, e.live(now), e.remaining(now)
+		}
+		if existing.live(mc.now()) {
+			if actual, ok := mc.read(key, existing); ok {
+				mc.accessed(key, existing)
+				return actual, true, true, existing.remaining(mc.now())
+			}
+			// The existing value was unreadable, and has been dropped.
+			continue
+		}
+		// The existing entry is past its deadline or a cached failure,
+		// so it counts as missing; replace it, unless someone beat us
+		// to it.
+		if mc.storage.CompareAndSwap(key, existing, e) {
+			mc.stored(key, e, existing)
+			_ = mc.writeThrough(context.Background(), key, value, ttl)
+			now := mc.now()
+			return value, false, e.live(now), e.remaining(now)
+		}
+	}
+}
 
-	return val, loaded
+// GetOrSetLazy behaves like GetOrSet, but only calls factory to build
+// the value on a miss, so hits don't pay for constructing a value
+// that's thrown away. Unlike GetOrComputeDetailed there's no
+// single-flight: concurrent misses may each call factory, though only
+// one of their values is stored, and all of them get that one back.
+// If factory panics (and the cache recovers panics), nothing is
+// stored and loaded is false.
+func (mc *MemoryCache) GetOrSetLazy(key string, factory func() any, ttl time.Duration) (actual any, loaded bool) {
+	key = mc.normalize(key)
+	if e, ok := mc.load(key); ok {
+		if actual, ok := mc.read(key, e); ok {
+			mc.accessed(key, e)
+			return actual, true
+		}
+	}
+	var value any
+	if err := mc.protect("factory", func() { value = factory() }); err != nil {
+		return nil, false
+	}
+	return mc.GetOrSet(key, value, ttl)
+}
+
+// GetOrSetRefreshing behaves like GetOrSet, except that on a hit the
+// existing entry's TTL is also reset to ttl, as with Refresh. A ttl
+// which WithRejectOverMaxTTL turns away is refused outright, hit or
+// miss: nothing is refreshed or stored, and loaded is false.
+func (mc *MemoryCache) GetOrSetRefreshing(key string, value any, ttl time.Duration) (actual any, loaded bool) {
+	key = mc.normalize(key)
+	if _, ok := mc.capTTL(ttl); !ok {
+		return nil, false
+	}
+	for {
+		if e, ok := mc.retime(key, ttl); ok {
+			if actual, ok := mc.read(key, e); ok {
+				mc.accessed(key, e)
+				return actual, true
+			}
+		}
+		if actual, loaded, _ = mc.GetOrSetWithTTL(key, value, ttl); !loaded {
+			return actual, false
+		}
+		// Someone else stored the key since we looked; go round again
+		// to refresh their entry.
+	}
 }
 
 // Get returns the value stored in the cache for the given key, or nil
 // if no value is stored. The ok result is true if the key was found
-// in the cache, false otherwise.
+// in the cache, false otherwise, so it tells a stored nil from a
+// miss.
 func (mc *MemoryCache) Get(key string) (value any, ok bool) {
-	return mc.storage.Load(key)
+	key = mc.normalize(key)
+	e, ok := mc.load(key)
+	if !ok {
+		mc.missed(key)
+		return nil, false
+	}
+	return mc.serve(key, e)
+}
+
+// GetPresent is Get, named to pair with GetOrSetOK: present reports
+// whether key has an entry, whatever its value, so a stored nil, or a
+// nil pointer in an any, comes back as (nil, true) and a miss as
+// (nil, false).
+func (mc *MemoryCache) GetPresent(key string) (value any, present bool) {
+	return mc.Get(key)
+}
+
+// Peek returns the value stored for key like Get, but without counting
+// as an access: it doesn't update the key's last access time, reset
+// its idle clock, or affect which key is evicted next.
+func (mc *MemoryCache) Peek(key string) (value any, ok bool) {
+	key = mc.normalize(key)
+	e, ok := mc.load(key)
+	if !ok {
+		return nil, false
+	}
+	return mc.read(key, e)
+}
+
+// LastAccess returns when key was last read by Get, GetOrSet or
+// similar, or when it was written if it hasn't been read since. The
+// ok result is false if the key isn't present.
+func (mc *MemoryCache) LastAccess(key string) (at time.Time, ok bool) {
+	key = mc.normalize(key)
+	e, ok := mc.load(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, e.lastAccess.Load()), true
+}
+
+// Info describes the entry stored for a key, as reported by
+// EntryInfo.
+type Info struct {
+	// Created is when the key was written. Refreshes, and in-place
+	// updates such as Increment, keep it.
+	Created time.Time
+	// ExpiresAt is the entry's deadline, the idle one if it comes
+	// first (see SetWithIdle), or the zero Time if it doesn't expire.
+	ExpiresAt time.Time
+	// LastAccess is as for LastAccess.
+	LastAccess time.Time
+	// Accesses is the number of times the key has been read, by Get,
+	// GetOrSet and the like, since Created.
+	Accesses uint64
+	// Cost is what the entry counts for against WithMaxMemory: an
+	// estimate of its size in bytes, unless WithCost says otherwise.
+	Cost int64
+	// Version is as for GetWithVersion.
+	Version uint64
+}
+
+// EntryInfo returns information about the entry stored for key, for
+// debugging hot keys and the like, reporting false if the key isn't
+// present. Like Peek, it doesn't count as an access.
+func (mc *MemoryCache) EntryInfo(key string) (info Info, ok bool) {
+	key = mc.normalize(key)
+	e, ok := mc.load(key)
+	if !ok {
+		return Info{}, false
+	}
+	info = Info{
+		Created:    time.Unix(0, e.created),
+		LastAccess: time.Unix(0, e.lastAccess.Load()),
+		Accesses:   e.accesses.Load(),
+		Cost:       e.cost,
+		Version:    e.gen,
+	}
+	if deadline := e.deadline(); !deadline.Equal(never) {
+		info.ExpiresAt = deadline
+	}
+	return info, true
+}
+
+// GetWithVersion behaves like Get, but also returns the generation of
+// the stored entry. Generations strictly increase with every write to
+// a key, which makes them handy for debugging overwrite races.
+func (mc *MemoryCache) GetWithVersion(key string) (value any, version uint64, ok bool) {
+	key = mc.normalize(key)
+	e, ok := mc.load(key)
+	if !ok {
+		mc.missed(key)
+		return nil, 0, false
+	}
+	if value, ok = mc.serve(key, e); !ok {
+		return nil, 0, false
+	}
+	return value, e.gen, true
 }
 
 // Expire immediately removes the given key from the cache, returning
 // the value if it was present, or nil if no value was stored. The
 // loaded result is true if the key was present in the cache, false
-// otherwise.
+// otherwise. The key is also deleted from any write-through store.
 func (mc *MemoryCache) Expire(key string) (value any, loaded bool) {
-	return mc.storage.LoadAndDelete(key)
+	key = mc.normalize(key)
+	value, _, loaded = mc.Pop(key)
+	return value, loaded
+}
+
+// Pop removes the given key from the cache like Expire, and also
+// returns how much of its TTL the entry had left.
+func (mc *MemoryCache) Pop(key string) (value any, remaining time.Duration, ok bool) {
+	key = mc.normalize(key)
+	mc.deleteThrough(key)
+	return mc.pop(key)
+}
+
+// pop does the in-memory work of Pop.
+func (mc *MemoryCache) pop(key string) (value any, remaining time.Duration, ok bool) {
+	e, ok := mc.storage.LoadAndDelete(key)
+	if !ok {
+		// The other caches on the bus may hold the key all the same.
+		mc.invalidate(key, false)
+		return nil, 0, false
+	}
+	mc.removed(key, e, ReasonManual)
+	if !e.live(mc.now()) {
+		return nil, 0, false
+	}
+	return e.get(), e.remaining(mc.now()), true
 }
 
 // Refresh sets the TTL for the given key, if it is present, returning
 // true if the key was present (and thus updated), false otherwise.
+// An idle timeout set with SetWithIdle is kept.
 func (mc *MemoryCache) Refresh(key string, ttl time.Duration) (refreshed bool) {
-	value, ok := mc.Get(key)
-	if ok {
-		mc.Set(key, value, ttl)
-		return true
+	key = mc.normalize(key)
+	return mc.touch(key, ttl)
+}
+
+// RefreshOrSet resets the TTL of key to ttl if it is present, as
+// Refresh does, and otherwise stores value for it with that TTL, as
+// GetOrSet does. The created result reports whether value was stored;
+// it's false if an existing entry was refreshed, or if the cache's
+// options turned value away. A ttl which WithRejectOverMaxTTL turns
+// away is refused outright, and the existing entry left as it was.
+func (mc *MemoryCache) RefreshOrSet(key string, value any, ttl time.Duration) (created bool) {
+	key = mc.normalize(key)
+	if _, ok := mc.capTTL(ttl); !ok {
+		return false
+	}
+	for {
+		if mc.touch(key, ttl) {
+			return false
+		}
+		_, loaded, remaining := mc.GetOrSetWithTTL(key, value, ttl)
+		if !loaded {
+			return remaining > 0
+		}
+		// Someone else stored the key since we looked; go round again
+		// to refresh their entry.
 	}
-	return false
 }
 
-// ExpireAll expires all the cache entries, resulting in an empty cache.
-func (mc *MemoryCache) ExpireAll() {
-	mc.storage.Clear()
+// RefreshMany sets the TTL for each of the given keys which is
+// present, returning how many were refreshed. Missing keys are
+// skipped.
+func (mc *MemoryCache) RefreshMany(keys []string, ttl time.Duration) int {
+	refreshed := 0
+	for _, key := range keys {
+		if mc.touch(mc.normalize(key), ttl) {
+			refreshed++
+		}
+	}
+	return refreshed
 }
 
-func main() {
-	cache := NewMemoryCache()
+// Touch resets the TTL of the given key, if it is present, to the TTL
+// it was last given, by Set, Refresh or the like, so it lives as long
+// again from now, returning true if the key was present. An entry
+// with no expiration is left as it is.
+func (mc *MemoryCache) Touch(key string) (touched bool) {
+	key = mc.normalize(key)
+	_, ok := mc.redate(key, func(old *entry) (*entry, bool) {
+		if old.ttl == NoExpiration {
+			return nil, true
+		}
+		return mc.withTTL(old, old.ttl), true
+	})
+	return ok
+}
+
+// AddTTL moves the deadline of the given key, if it is present, d
+// later, or earlier if d is negative, returning true if the key was
+// present. An entry with no expiration is left as it is. The time the
+// entry has left is capped as for Refresh (see WithMaxTTL), or, with
+// WithRejectOverMaxTTL, a longer one is turned away, reporting false.
+// If the deadline ends up in the past, the entry expires.
+func (mc *MemoryCache) AddTTL(key string, d time.Duration) (extended bool) {
+	key = mc.normalize(key)
+	_, ok := mc.redate(key, func(old *entry) (*entry, bool) {
+		if old.expiresAt.Equal(never) {
+			return nil, true
+		}
+		expiresAt := old.expiresAt.Add(d)
+		if expiresAt.After(never) {
+			expiresAt = never
+		}
+		now := mc.now()
+		remaining := expiresAt.Sub(now)
+		ttl, ok := mc.capTTL(remaining)
+		if !ok {
+			return nil, false
+		}
+		if ttl != remaining {
+			expiresAt = now.Add(ttl)
+		}
+		return mc.withDeadline(old, expiresAt), true
+	})
+	return ok
+}
 
-	fmt.Println("Setting up cache...")
-	cache.Set("UltimateAnswer", 42, time.Until(time.Now().Add(5*time.Minute)))
-	cache.Set("Spock", "Live long and prosper.", time.Until(time.Now().Add(10*time.Second)))
+// touch resets the deadline of the entry for key to ttl from now,
+// reporting whether the key was present. The entry is swapped for a
+// copy with the new deadline and the old entry's timer is cancelled,
+// so this doesn't leave an orphaned timer behind. If the key is
+// concurrently overwritten or removed we retry against whatever is
+// there now.
+func (mc *MemoryCache) touch(key string, ttl time.Duration) bool {
+	_, ok := mc.retime(key, ttl)
+	return ok
+}
 
-	fmt.Println("Searching for answers...")
-	value, ok := cache.Get("UltimateAnswer")
+// retime does the work of touch, returning the entry which replaced
+// the old one.
+func (mc *MemoryCache) retime(key string, ttl time.Duration) (*entry, bool) {
+	ttl, ok := mc.capTTL(ttl)
 	if !ok {
-		fmt.Println("Failed to answer the ultimate question.")
-	} else {
-		fmt.Println("The answer is, of course, ", value, ".")
+		return nil, false
 	}
-	cache.Expire("UltimateAnswer")
+	return mc.redate(key, func(old *entry) (*entry, bool) {
+		return mc.withTTL(old, ttl), true
+	})
+}
 
-	fmt.Println("Searching for Spock...")
-	time.Sleep(15 * time.Second)
-	value, found := cache.GetOrSet("Spock", "Live long and prosper.", time.Until(time.Now().Add(5*time.Minute)))
-	if found {
-		fmt.Println("Found Spock, that was unexpected!")
-	} else {
-		fmt.Println("Spock not found, releasing Genesis device.")
-		fmt.Println(value)
+// redate swaps the live entry for key for the copy of it with a new
+// deadline which next returns, retrying as touch does, and returns the
+// copy. If next returns nil, the entry is left as it is; if it returns
+// false, redate does too.
+func (mc *MemoryCache) redate(key string, next func(old *entry) (*entry, bool)) (*entry, bool) {
+	for {
+		old, ok := mc.storage.Load(key)
+		if !ok {
+			return nil, false
+		}
+		if !old.live(mc.now()) {
+			return nil, false
+		}
+		e, ok := next(old)
+		if !ok || e == nil {
+			return old, ok
+		}
+		if mc.storage.CompareAndSwap(key, old, e) {
+			mc.cancel(old)
+			mc.schedule(key, e)
+			if mc.journal != nil {
+				mc.journal.set(key, e)
+			}
+			return e, true
+		}
 	}
 }
+
+// ExpireAll expires all the cache entries, resulting in an empty cache.
+func (mc *MemoryCache) ExpireAll() {
+	mc.ExpireAllWithOptions(true)
+}
+
+// ExpireAllWithOptions is ExpireAll, except that the OnEvicted
+// callback is only called for the removed entries if fireCallbacks is
+// set, for quick resets, in tests say, where the callbacks are just
+// noise. Either way the cache's bookkeeping is brought up to date,
+// Releasable values are released and SetWithCancel's cancel funcs are
+// called, since skipping those would leak.
+func (mc *MemoryCache) ExpireAllWithOptions(fireCallbacks bool) {
+	mc.clear(fireCallbacks)
+	mc.invalidate("", true)
+}
+
+// clear does the in-memory work of ExpireAllWithOptions.
+func (mc *MemoryCache) clear(fireCallbacks bool) {
+	mc.storage.Range(func(key string, e *entry) bool {
+		if mc.storage.CompareAndDelete(key, e) {
+			mc.dropped(key, e, ReasonCleared, fireCallbacks)
+		}
+		return true
+	})
+}
+
+// ExpirePrefix removes every key starting with prefix, as Expire
+// would, and returns how many live keys it removed. It scans every
+// key; ExpireNamespace doesn't, for a prefix passed to Namespace.
+func (mc *MemoryCache) ExpirePrefix(prefix string) int {
+	prefix = mc.normalize(prefix)
+	return mc.expireWhere(func(key string, _ *entry) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// ExpireMatch removes every key matching the glob pattern, as Expire
+// would, and returns how many live keys it removed. Patterns use
+// path.Match syntax: * matches any run of characters other than /, ?
+// matches any one character other than /, and [...] matches a
+// character class. A malformed pattern matches nothing. Like
+// ExpirePrefix, this scans every key, taking O(n) time; use
+// ExpirePrefix when a prefix is all you need, as its matching is
+// cheaper.
+func (mc *MemoryCache) ExpireMatch(pattern string) int {
+	pattern = mc.normalize(pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0
+	}
+	return mc.expireWhere(func(key string, _ *entry) bool {
+		matched, _ := path.Match(pattern, key)
+		return matched
+	})
+}
+
+// ExpireWhere removes every live entry for which pred returns true,
+// as Expire would, and returns how many it removed: it's for clearing
+// a subset of the cache, such as a logged-out tenant's keys or values
+// from before a schema change. It works through the cache a shard at
+// a time (see WithShards) and calls pred without holding any lock, so
+// neither a slow predicate nor a large cache stalls other callers.
+// pred may call back into the cache. An entry which expires, or is
+// overwritten, after pred has seen it is left as it is, counted only
+// if ExpireWhere removed it.
+func (mc *MemoryCache) ExpireWhere(pred func(key string, value any) bool) int {
+	return mc.expireWhere(func(key string, e *entry) bool {
+		if !e.live(mc.now()) {
+			return false
+		}
+		value, ok := mc.read(key, e)
+		return ok && pred(key, value)
+	})
+}
+
+// expireWhere removes every key for which match returns true, given
+// the key and its entry, returning how many live keys it removed.
+func (mc *MemoryCache) expireWhere(match func(key string, e *entry) bool) int {
+	n := 0
+	mc.storage.Range(func(key string, e *entry) bool {
+		if !match(key, e) {
+			return true
+		}
+		mc.deleteThrough(key)
+		if mc.storage.CompareAndDelete(key, e) {
+			mc.removed(key, e, ReasonManual)
+			if e.live(mc.now()) {
+				n++
+			}
+		}
+		return true
+	})
+	return n
+}
@@ -0,0 +1,568 @@
+package enigmacache
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetWithIdleSurvivesWhileAccessed(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.SetWithIdle("key", "value", 300*time.Millisecond, 100*time.Millisecond)
+
+	// Reading more often than the idle timeout keeps the key alive
+	// right up to the hard TTL.
+	for i := 0; i < 5; i++ {
+		clock.Advance(50 * time.Millisecond)
+		if _, ok := cache.Get("key"); !ok {
+			t.Fatalf("key expired after %d reads, before its hard TTL", i)
+		}
+	}
+
+	clock.Advance(150 * time.Millisecond)
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("key outlived its hard TTL")
+	}
+}
+
+func TestSetWithIdleExpiresWhenUntouched(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.SetWithIdle("key", "value", time.Minute, 50*time.Millisecond)
+
+	clock.Advance(100 * time.Millisecond)
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("untouched key survived its idle timeout")
+	}
+}
+
+func TestGetOrSetWithTTLStored(t *testing.T) {
+	cache, _ := NewTestCache()
+	actual, loaded, remaining := cache.GetOrSetWithTTL("key", "value", time.Minute)
+	if loaded || actual != "value" {
+		t.Fatalf("got (%v, %v), want (value, false)", actual, loaded)
+	}
+	if remaining != time.Minute {
+		t.Fatalf("remaining = %v, want the applied TTL %v", remaining, time.Minute)
+	}
+}
+
+func TestGetOrSetWithTTLLoaded(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.Set("key", "value", time.Minute)
+	clock.Advance(20 * time.Millisecond)
+
+	actual, loaded, remaining := cache.GetOrSetWithTTL("key", "other", time.Hour)
+	if !loaded || actual != "value" {
+		t.Fatalf("got (%v, %v), want (value, true)", actual, loaded)
+	}
+	if want := time.Minute - 20*time.Millisecond; remaining != want {
+		t.Fatalf("remaining = %v, want %v", remaining, want)
+	}
+}
+
+func TestSetAndReturnPrevious(t *testing.T) {
+	cache, clock := NewTestCache()
+	if prev, existed := cache.SetAndReturnPrevious("key", "first", 20*time.Millisecond); existed || prev != nil {
+		t.Fatalf("first insert got (%v, %v), want (nil, false)", prev, existed)
+	}
+	prev, existed := cache.SetAndReturnPrevious("key", "second", time.Minute)
+	if !existed || prev != "first" {
+		t.Fatalf("overwrite got (%v, %v), want (first, true)", prev, existed)
+	}
+	// The first value's timer mustn't remove the second.
+	clock.Advance(50 * time.Millisecond)
+	if value, ok := cache.Get("key"); !ok || value != "second" {
+		t.Fatalf("Get = (%v, %v), want (second, true)", value, ok)
+	}
+}
+
+func TestSetAndReturnPreviousHandsOverReleasable(t *testing.T) {
+	cache := NewMemoryCache()
+	first, second := &resource{}, &resource{}
+	cache.Set("key", first, time.Minute)
+	prev, _ := cache.SetAndReturnPrevious("key", second, time.Minute)
+	if prev != first || first.released.Load() != 0 {
+		t.Fatalf("got %v with %d releases, want the first value unreleased", prev, first.released.Load())
+	}
+	cache.Expire("key")
+	if n := second.released.Load(); n != 1 {
+		t.Fatalf("second value released %d times, want 1", n)
+	}
+}
+
+func TestGetOrSetLazy(t *testing.T) {
+	cache := NewMemoryCache()
+	calls := 0
+	factory := func() any {
+		calls++
+		return "value"
+	}
+	for i, want := range []bool{false, true} {
+		actual, loaded := cache.GetOrSetLazy("key", factory, time.Minute)
+		if actual != "value" || loaded != want {
+			t.Fatalf("call %d: got (%v, %v), want (value, %v)", i, actual, loaded, want)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("factory ran %d times, want 1", calls)
+	}
+}
+
+func BenchmarkSet(b *testing.B) {
+	cache := NewMemoryCache()
+	b.ReportAllocs()
+	for b.Loop() {
+		cache.Set("key", "value", time.Hour)
+	}
+}
+
+func BenchmarkSetParallel(b *testing.B) {
+	cache := NewMemoryCache()
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Set(keys[i%len(keys)], "value", time.Hour)
+			i++
+		}
+	})
+}
+
+func BenchmarkGetOrSetHit(b *testing.B) {
+	cache := NewMemoryCache()
+	cache.Set("key", make([]byte, 4096), time.Hour)
+	b.ReportAllocs()
+	for b.Loop() {
+		cache.GetOrSet("key", make([]byte, 4096), time.Hour)
+	}
+}
+
+func BenchmarkGetOrSetLazyHit(b *testing.B) {
+	cache := NewMemoryCache()
+	cache.Set("key", make([]byte, 4096), time.Hour)
+	factory := func() any { return make([]byte, 4096) }
+	b.ReportAllocs()
+	for b.Loop() {
+		cache.GetOrSetLazy("key", factory, time.Hour)
+	}
+}
+
+func TestGetOrSetRefreshingHitRefreshesTTL(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.Set("key", "value", 50*time.Millisecond)
+
+	actual, loaded := cache.GetOrSetRefreshing("key", "other", time.Minute)
+	if !loaded || actual != "value" {
+		t.Fatalf("got (%v, %v), want (value, true)", actual, loaded)
+	}
+	// The old entry's timer must have been cancelled, or it would
+	// remove the refreshed entry here.
+	clock.Advance(100 * time.Millisecond)
+	if value, ok := cache.Get("key"); !ok || value != "value" {
+		t.Fatalf("Get = (%v, %v) after the original TTL, want (value, true)", value, ok)
+	}
+}
+
+func TestGetOrSetRefreshingMissStores(t *testing.T) {
+	cache, clock := NewTestCache()
+	actual, loaded := cache.GetOrSetRefreshing("key", "value", 50*time.Millisecond)
+	if loaded || actual != "value" {
+		t.Fatalf("got (%v, %v), want (value, false)", actual, loaded)
+	}
+	if value, ok := cache.Get("key"); !ok || value != "value" {
+		t.Fatalf("Get = (%v, %v), want (value, true)", value, ok)
+	}
+	clock.Advance(100 * time.Millisecond)
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("stored key outlived its TTL")
+	}
+}
+
+func TestRefreshOrSetExtendsExisting(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.Set("key", "value", 50*time.Millisecond)
+	if cache.RefreshOrSet("key", "other", 150*time.Millisecond) {
+		t.Fatal("RefreshOrSet created a key which was present")
+	}
+
+	// The original timer must not fire, and the new one must.
+	clock.Advance(100 * time.Millisecond)
+	if value, ok := cache.Get("key"); !ok || value != "value" {
+		t.Fatalf("Get = (%v, %v) after the original TTL, want (value, true)", value, ok)
+	}
+	clock.Advance(100 * time.Millisecond)
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("refreshed key outlived its new TTL")
+	}
+}
+
+func TestRefreshOrSetCreatesMissing(t *testing.T) {
+	cache, clock := NewTestCache()
+	if !cache.RefreshOrSet("key", "value", 50*time.Millisecond) {
+		t.Fatal("RefreshOrSet didn't report creating a missing key")
+	}
+	if value, ok := cache.Get("key"); !ok || value != "value" {
+		t.Fatalf("Get = (%v, %v), want (value, true)", value, ok)
+	}
+	clock.Advance(100 * time.Millisecond)
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("created key outlived its TTL")
+	}
+}
+
+func TestGetWithVersionIncreases(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.Set("key", 1, time.Minute)
+	_, first, _ := cache.GetWithVersion("key")
+	cache.Set("key", 2, time.Minute)
+	value, second, ok := cache.GetWithVersion("key")
+	if !ok || value != 2 {
+		t.Fatalf("got (%v, %v), want (2, true)", value, ok)
+	}
+	if second <= first {
+		t.Fatalf("version did not increase: %d then %d", first, second)
+	}
+}
+
+func TestStaleTimerDoesNotDeleteNewGeneration(t *testing.T) {
+	cache, clock := NewTestCache()
+	for i := 0; i < 100; i++ {
+		cache.Set("key", i, 10*time.Millisecond)
+	}
+	cache.Set("key", "current", time.Minute)
+
+	// Give every short-lived generation's timer a chance to fire.
+	clock.Advance(50 * time.Millisecond)
+	if value, ok := cache.Get("key"); !ok || value != "current" {
+		t.Fatalf("got (%v, %v), want (current, true)", value, ok)
+	}
+}
+
+func TestRefreshMany(t *testing.T) {
+	cache, clock := NewTestCache()
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, key, 50*time.Millisecond)
+	}
+
+	if n := cache.RefreshMany([]string{"a", "b", "missing"}, time.Minute); n != 2 {
+		t.Fatalf("refreshed %d keys, want 2", n)
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	for _, key := range []string{"a", "b"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("refreshed key %q expired on its original TTL", key)
+		}
+	}
+	if _, ok := cache.Get("c"); ok {
+		t.Error("unrefreshed key outlived its TTL")
+	}
+}
+
+func TestTouchAndAddTTL(t *testing.T) {
+	cache, clock := NewTestCache(WithMaxTTL(2 * time.Hour))
+	cache.Set("key", "value", time.Minute)
+	cache.SetWithMeta("meta", "value", Meta{ETag: `"v1"`}, time.Minute)
+
+	clock.Advance(40 * time.Second)
+	if !cache.Touch("key") || !cache.Touch("meta") {
+		t.Fatal("Touch reported a present key missing")
+	}
+	if remaining, _ := cache.TTL("key"); remaining != time.Minute {
+		t.Errorf("TTL after Touch = %v, want the original 1m", remaining)
+	}
+	if _, meta, _ := cache.GetWithMeta("meta"); meta.ETag != `"v1"` {
+		t.Errorf("Touch lost the entry's metadata: %+v", meta)
+	}
+
+	if !cache.AddTTL("key", 30*time.Second) {
+		t.Fatal("AddTTL reported a present key missing")
+	}
+	if remaining, _ := cache.TTL("key"); remaining != 90*time.Second {
+		t.Errorf("TTL after AddTTL(30s) = %v, want 1m30s", remaining)
+	}
+	// Touch goes back to the TTL last given, not the extended one.
+	cache.Touch("key")
+	if remaining, _ := cache.TTL("key"); remaining != time.Minute {
+		t.Errorf("TTL after AddTTL then Touch = %v, want 1m", remaining)
+	}
+	cache.AddTTL("key", 3*time.Hour)
+	if remaining, _ := cache.TTL("key"); remaining != 2*time.Hour {
+		t.Errorf("TTL after AddTTL(3h) = %v, want the 2h cap", remaining)
+	}
+	cache.AddTTL("key", -3*time.Hour)
+	clock.Advance(0)
+	if cache.Has("key") {
+		t.Error("AddTTL into the past left the key live")
+	}
+
+	if cache.Touch("missing") || cache.AddTTL("missing", time.Minute) {
+		t.Error("Touch or AddTTL reported a missing key present")
+	}
+	if n := cache.DebugStats().Timers; n != 1 {
+		t.Errorf("%d timers pending, want 1 for meta", n)
+	}
+
+	unbounded, _ := NewTestCache()
+	unbounded.Set("forever", "value", NoExpiration)
+	for _, ok := range []bool{unbounded.Touch("forever"), unbounded.AddTTL("forever", time.Minute)} {
+		if !ok {
+			t.Error("Touch or AddTTL reported a key without expiration missing")
+		}
+	}
+	if remaining, _ := unbounded.TTL("forever"); remaining != NoExpiration {
+		t.Errorf("TTL of a key without expiration = %v after Touch and AddTTL, want NoExpiration", remaining)
+	}
+}
+
+func TestEntryInfo(t *testing.T) {
+	cache, clock := NewTestCache()
+	start := clock.Now()
+	cache.Set("hot", "value", time.Hour)
+	cache.Set("forever", int64(1), NoExpiration)
+	clock.Advance(time.Minute)
+	for range 3 {
+		cache.Get("hot")
+	}
+	cache.Peek("hot")
+	cache.Refresh("hot", 2*time.Hour)
+	cache.Get("forever")
+	cache.Increment("forever", 1, NoExpiration)
+
+	info, ok := cache.EntryInfo("hot")
+	if !ok {
+		t.Fatal("EntryInfo reported a present key missing")
+	}
+	want := Info{
+		Created:    start,
+		ExpiresAt:  start.Add(time.Minute + 2*time.Hour),
+		LastAccess: start.Add(time.Minute),
+		Accesses:   3,
+		Cost:       info.Cost,
+		Version:    info.Version,
+	}
+	if !info.Created.Equal(want.Created) || !info.ExpiresAt.Equal(want.ExpiresAt) ||
+		!info.LastAccess.Equal(want.LastAccess) || info.Accesses != want.Accesses {
+		t.Errorf("EntryInfo(hot) = %+v, want %+v", info, want)
+	}
+	if info.Cost <= 0 {
+		t.Errorf("EntryInfo(hot).Cost = %d, want more than zero", info.Cost)
+	}
+	if again, _ := cache.EntryInfo("hot"); again.Accesses != 3 {
+		t.Error("EntryInfo counted as an access")
+	}
+
+	info, _ = cache.EntryInfo("forever")
+	if !info.ExpiresAt.IsZero() || info.Accesses != 1 || !info.Created.Equal(start) {
+		t.Errorf("EntryInfo(forever) = %+v, want no expiry, 1 access and the original creation time", info)
+	}
+	if _, ok := cache.EntryInfo("missing"); ok {
+		t.Error("EntryInfo reported a missing key present")
+	}
+}
+
+func TestPop(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.Set("key", "value", time.Minute)
+	clock.Advance(20 * time.Millisecond)
+
+	value, remaining, ok := cache.Pop("key")
+	if !ok || value != "value" {
+		t.Fatalf("got (%v, %v), want (value, true)", value, ok)
+	}
+	if want := time.Minute - 20*time.Millisecond; remaining != want {
+		t.Fatalf("remaining = %v, want %v", remaining, want)
+	}
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("Pop left the key in the cache")
+	}
+	if _, _, ok := cache.Pop("key"); ok {
+		t.Fatal("second Pop found the key")
+	}
+}
+
+func TestLastAccess(t *testing.T) {
+	cache, clock := NewTestCache()
+	if _, ok := cache.LastAccess("key"); ok {
+		t.Fatal("LastAccess found a missing key")
+	}
+
+	cache.Set("key", "value", time.Minute)
+	written, ok := cache.LastAccess("key")
+	if !ok {
+		t.Fatal("LastAccess did not find the key")
+	}
+
+	clock.Advance(5 * time.Millisecond)
+	cache.Peek("key")
+	if at, _ := cache.LastAccess("key"); !at.Equal(written) {
+		t.Fatalf("Peek moved the last access from %v to %v", written, at)
+	}
+
+	cache.Get("key")
+	read, _ := cache.LastAccess("key")
+	if !read.After(written) {
+		t.Fatalf("Get did not advance the last access past %v", written)
+	}
+
+	clock.Advance(5 * time.Millisecond)
+	cache.GetOrSet("key", "other", time.Minute)
+	if at, _ := cache.LastAccess("key"); !at.After(read) {
+		t.Fatalf("GetOrSet did not advance the last access past %v", read)
+	}
+}
+
+func TestExpireMatch(t *testing.T) {
+	keys := []string{
+		"user:1:session:a",
+		"user:2:session:b",
+		"user:22:session:c",
+		"user:1:profile",
+		"group:1:session:d",
+	}
+	for _, tc := range []struct {
+		pattern string
+		removed []string
+	}{
+		{"user:*:session:*", []string{"user:1:session:a", "user:2:session:b", "user:22:session:c"}},
+		{"user:?:session:?", []string{"user:1:session:a", "user:2:session:b"}},
+		{"*:1:*", []string{"user:1:session:a", "user:1:profile", "group:1:session:d"}},
+		{"nothing*", nil},
+		{"[", nil},
+	} {
+		cache := NewMemoryCache()
+		for _, key := range keys {
+			cache.Set(key, key, time.Minute)
+		}
+		if n := cache.ExpireMatch(tc.pattern); n != len(tc.removed) {
+			t.Errorf("%q: removed %d keys, want %d", tc.pattern, n, len(tc.removed))
+		}
+		for _, key := range keys {
+			_, ok := cache.Get(key)
+			if want := !slices.Contains(tc.removed, key); ok != want {
+				t.Errorf("%q: key %q present = %v, want %v", tc.pattern, key, ok, want)
+			}
+		}
+	}
+}
+
+func TestExpireWhere(t *testing.T) {
+	type record struct {
+		tenant  string
+		version int
+	}
+	for _, shards := range []int{0, 4} {
+		var reasons []EvictionReason
+		cache := NewMemoryCache(WithShards(shards), WithOnEvicted(func(_ string, _ any, reason EvictionReason) {
+			reasons = append(reasons, reason)
+		}))
+		cache.Set("acme:1", record{"acme", 1}, time.Minute)
+		cache.Set("acme:2", record{"acme", 2}, time.Minute)
+		cache.Set("globex:1", record{"globex", 1}, time.Minute)
+		cache.Set("globex:2", record{"globex", 2}, time.Minute)
+
+		// By value: drop everything from before version 2.
+		n := cache.ExpireWhere(func(_ string, value any) bool {
+			return value.(record).version < 2
+		})
+		if n != 2 {
+			t.Errorf("shards=%d: value predicate removed %d, want 2", shards, n)
+		}
+		keys := cache.Keys()
+		slices.Sort(keys)
+		if !slices.Equal(keys, []string{"acme:2", "globex:2"}) {
+			t.Errorf("shards=%d: left %v, want [acme:2 globex:2]", shards, keys)
+		}
+
+		// By key: drop a tenant.
+		if n := cache.ExpireWhere(func(key string, _ any) bool {
+			return strings.HasPrefix(key, "acme:")
+		}); n != 1 {
+			t.Errorf("shards=%d: key predicate removed %d, want 1", shards, n)
+		}
+		if keys := cache.Keys(); !slices.Equal(keys, []string{"globex:2"}) {
+			t.Errorf("shards=%d: left %v, want [globex:2]", shards, keys)
+		}
+		if !slices.Equal(reasons, []EvictionReason{ReasonManual, ReasonManual, ReasonManual}) {
+			t.Errorf("shards=%d: OnEvicted reasons %v, want three ReasonManual", shards, reasons)
+		}
+	}
+}
+
+func TestExpireWhereSkipsExpired(t *testing.T) {
+	cache, clock := NewTestCache(WithStaleIfError(time.Hour))
+	cache.Set("old", 1, time.Second)
+	cache.Set("new", 2, time.Minute)
+	clock.Advance(time.Second)
+
+	var seen []string
+	n := cache.ExpireWhere(func(key string, _ any) bool {
+		seen = append(seen, key)
+		return true
+	})
+	if n != 1 || !slices.Equal(seen, []string{"new"}) {
+		t.Fatalf("removed %d after seeing %v, want 1 after seeing [new]", n, seen)
+	}
+}
+
+func TestExpirePrefix(t *testing.T) {
+	cache := NewMemoryCache()
+	for _, key := range []string{"a:1", "a:2", "b:1"} {
+		cache.Set(key, key, time.Minute)
+	}
+	if n := cache.ExpirePrefix("a:"); n != 2 {
+		t.Fatalf("removed %d keys, want 2", n)
+	}
+	if keys := cache.Keys(); !slices.Equal(keys, []string{"b:1"}) {
+		t.Fatalf("left %v, want [b:1]", keys)
+	}
+}
+
+func TestGetOrSetOKTellsStoredNilFromMiss(t *testing.T) {
+	var nilPtr *int
+	for _, tc := range []struct {
+		name    string
+		opts    []Option
+		value   any
+		present bool
+	}{
+		{"untyped nil", nil, nil, true},
+		{"typed nil", nil, nilPtr, true},
+		{"rejected nil", []Option{WithRejectNil(true)}, nil, false},
+		{"rejected typed nil", []Option{WithRejectNil(true)}, nilPtr, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := NewMemoryCache(tc.opts...)
+			if value, present := cache.GetPresent("k"); value != nil || present {
+				t.Fatalf("GetPresent before storing = (%v, %v), want (nil, false)", value, present)
+			}
+			actual, loaded, present := cache.GetOrSetOK("k", tc.value, time.Minute)
+			if loaded || present != tc.present || (present && actual != tc.value) {
+				t.Fatalf("GetOrSetOK = (%v, %v, %v), want (%v, false, %v)", actual, loaded, present, tc.value, tc.present)
+			}
+			var want any
+			if tc.present {
+				want = tc.value
+			}
+			if value, present := cache.GetPresent("k"); present != tc.present || value != want {
+				t.Fatalf("GetPresent = (%v, %v), want (%v, %v)", value, present, want, tc.present)
+			}
+			if _, ok := cache.Get("k"); ok != tc.present {
+				t.Fatalf("Get ok = %v, want %v", ok, tc.present)
+			}
+			actual, loaded, present = cache.GetOrSetOK("k", 1, time.Minute)
+			if tc.present && (!loaded || !present || actual != tc.value) {
+				t.Fatalf("second GetOrSetOK = (%v, %v, %v), want (%v, true, true)", actual, loaded, present, tc.value)
+			}
+			if !tc.present && (loaded || !present || actual != 1) {
+				t.Fatalf("second GetOrSetOK = (%v, %v, %v), want (1, false, true)", actual, loaded, present)
+			}
+		})
+	}
+}
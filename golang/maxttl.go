@@ -0,0 +1,79 @@
+package enigmacache
+
+import (
+	"math"
+	"time"
+)
+
+// NoExpiration, passed as the TTL to Set and the other writes taking
+// one, stores an entry which never expires, though it can still be
+// evicted from a bounded cache, time out under an idle TTL, or be
+// shortened by WithMaxTTL. TTL reports NoExpiration as the time such
+// an entry has left. A TTL of zero or less, by contrast, means an
+// entry has already expired: writing one replaces the key's value
+// with one no read sees, and the key is removed as expired.
+const NoExpiration time.Duration = math.MaxInt64
+
+// never is the deadline of an entry stored with NoExpiration: the
+// latest time UnixNano can represent, so it survives being exported.
+var never = time.Unix(0, math.MaxInt64)
+
+// deadlineAfter returns the hard deadline for an entry written at now
+// with ttl, jittered by WithTTLJitter and within the WithMaxTTL limit.
+func (mc *MemoryCache) deadlineAfter(now time.Time, ttl time.Duration) time.Time {
+	if mc.jitter != nil {
+		ttl = mc.jitter.apply(ttl)
+	}
+	ttl = mc.clampTTL(ttl)
+	if ttl == NoExpiration {
+		return never
+	}
+	return now.Add(ttl)
+}
+
+// WithMaxTTL caps the TTL of every entry at d, whatever the caller
+// asks for, so no entry can outlive a global policy: a longer TTL,
+// NoExpiration included, given to Set, GetOrSet, Refresh and the rest
+// is cut down to d. With WithRejectOverMaxTTL, such writes are turned
+// away instead. Since a TTL of zero or less already means an entry
+// expires straight away, those are left as they are.
+func WithMaxTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.maxTTL = d
+	}
+}
+
+// WithRejectOverMaxTTL makes a cache built WithMaxTTL turn away
+// writes asking for a longer TTL, rather than shortening it, counting
+// them in Stats().Rejected. Set ignores them, SetContext returns
+// ErrRejected, GetOrSet stores nothing, and Refresh leaves the entry
+// be and returns false. Writes
+// which have no way to report being turned away, such as Increment,
+// ReplaceAll and Import, are capped instead.
+func WithRejectOverMaxTTL() Option {
+	return func(o *options) {
+		o.rejectOverMaxTTL = true
+	}
+}
+
+// capTTL applies the cache's WithMaxTTL limit to ttl, reporting false
+// if a write asking for ttl should be turned away.
+func (mc *MemoryCache) capTTL(ttl time.Duration) (time.Duration, bool) {
+	limit := mc.opts.maxTTL
+	if limit <= 0 || ttl <= limit {
+		return ttl, true
+	}
+	if mc.opts.rejectOverMaxTTL {
+		mc.stats.rejected.Add(1)
+		return 0, false
+	}
+	return limit, true
+}
+
+// clampTTL is capTTL for writes which can't be turned away.
+func (mc *MemoryCache) clampTTL(ttl time.Duration) time.Duration {
+	if limit := mc.opts.maxTTL; limit > 0 {
+		return min(ttl, limit)
+	}
+	return ttl
+}
@@ -0,0 +1,110 @@
+package enigmacache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaxTTLClamps(t *testing.T) {
+	cache, _ := NewTestCache(WithMaxTTL(time.Minute))
+	cache.Set("long", 1, time.Hour)
+	cache.Set("short", 2, time.Second)
+	cache.GetOrSet("getorset", 3, time.Hour)
+	cache.Set("refreshed", 4, time.Second)
+	cache.Refresh("refreshed", time.Hour)
+	if _, err := cache.Increment("counter", 1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]time.Duration{
+		"long":      time.Minute,
+		"short":     time.Second,
+		"getorset":  time.Minute,
+		"refreshed": time.Minute,
+		"counter":   time.Minute,
+	} {
+		if got, ok := cache.TTL(key); !ok || got != want {
+			t.Errorf("TTL(%q) = %v, %v, want %v", key, got, ok, want)
+		}
+	}
+
+	// A TTL of zero or less still means the entry expires at once.
+	cache.Set("zero", 5, 0)
+	cache.Set("negative", 6, -time.Second)
+	for _, key := range []string{"zero", "negative"} {
+		if _, ok := cache.Get(key); ok {
+			t.Errorf("%q was stored with a non-positive TTL", key)
+		}
+	}
+}
+
+func TestMaxTTLRejects(t *testing.T) {
+	cache, _ := NewTestCache(WithMaxTTL(time.Minute), WithRejectOverMaxTTL())
+	cache.Set("long", 1, time.Hour)
+	if _, ok := cache.Get("long"); ok {
+		t.Fatal("Set stored a TTL over the limit")
+	}
+	if _, loaded := cache.GetOrSet("getorset", 2, time.Hour); loaded || cache.Len() != 0 {
+		t.Fatal("GetOrSet stored a TTL over the limit")
+	}
+	cache.Set("ok", 3, time.Minute)
+	if cache.Refresh("ok", time.Hour) {
+		t.Fatal("Refresh accepted a TTL over the limit")
+	}
+	if got, _ := cache.TTL("ok"); got != time.Minute {
+		t.Fatalf("TTL after the refused Refresh = %v, want 1m", got)
+	}
+	if n := cache.Stats().Rejected; n != 3 {
+		t.Fatalf("Rejected = %d, want 3", n)
+	}
+}
+
+// returnsSoon fails t if fn hasn't returned within a few seconds.
+func returnsSoon(t *testing.T, what string, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s didn't return", what)
+	}
+}
+
+func TestGetOrSetRefreshingRejectsOverMaxTTL(t *testing.T) {
+	cache, _ := NewTestCache(WithMaxTTL(time.Minute), WithRejectOverMaxTTL())
+	cache.Set("k", 1, 30*time.Second)
+	returnsSoon(t, "GetOrSetRefreshing", func() {
+		if actual, loaded := cache.GetOrSetRefreshing("k", 2, 2*time.Minute); loaded || actual != nil {
+			t.Errorf("GetOrSetRefreshing over the limit = (%v, %v), want (nil, false)", actual, loaded)
+		}
+		if _, loaded := cache.GetOrSetRefreshing("missing", 2, 2*time.Minute); loaded || cache.Has("missing") {
+			t.Error("GetOrSetRefreshing stored a TTL over the limit")
+		}
+	})
+	if got, _ := cache.TTL("k"); got != 30*time.Second {
+		t.Errorf("TTL after the refused refresh = %v, want 30s", got)
+	}
+}
+
+func TestRefreshOrSetRejectsOverMaxTTL(t *testing.T) {
+	cache, _ := NewTestCache(WithMaxTTL(time.Minute), WithRejectOverMaxTTL())
+	cache.Set("k", 1, 30*time.Second)
+	returnsSoon(t, "RefreshOrSet", func() {
+		if cache.RefreshOrSet("k", 2, 2*time.Minute) {
+			t.Error("RefreshOrSet over the limit reported creating a present key")
+		}
+		if cache.RefreshOrSet("missing", 2, 2*time.Minute) || cache.Has("missing") {
+			t.Error("RefreshOrSet stored a TTL over the limit")
+		}
+	})
+	if value, _ := cache.Get("k"); value != 1 {
+		t.Errorf("Get(k) = %v after the refused RefreshOrSet, want 1", value)
+	}
+	if got, _ := cache.TTL("k"); got != 30*time.Second {
+		t.Errorf("TTL after the refused refresh = %v, want 30s", got)
+	}
+}
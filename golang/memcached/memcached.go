@@ -0,0 +1,532 @@
+// Package memcached serves a MemoryCache over the memcached text
+// protocol, so existing memcached clients can use enigma-cache
+// unchanged (see cmd/enigma-cached). It speaks get, gets, set, add,
+// replace, delete, touch, flush_all, stats, version and quit; the
+// commands it doesn't know, such as cas and incr, get ERROR.
+//
+// Values are stored as Items, keeping the client's flags with the
+// data. A key holding a []byte or string, stored by code sharing the
+// cache, is served with flags of zero; one holding anything else
+// reads as missing.
+package memcached
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	enigmacache "github.com/plathrop/enigma-cache/golang"
+)
+
+// An Item is a value stored through the protocol.
+type Item struct {
+	Flags uint32
+	Data  []byte
+}
+
+// maxRelativeExptime is the largest exptime clients mean as seconds
+// from now; larger ones are Unix times.
+const maxRelativeExptime = 30 * 24 * 60 * 60
+
+// maxKeyLength is memcached's limit on the length of a key.
+const maxKeyLength = 250
+
+// maxLineLength is memcached's limit on the length of a command line.
+// A client sending a longer one gets CLIENT_ERROR and is disconnected.
+const maxLineLength = 2048
+
+// A Server serves a cache to memcached clients.
+type Server struct {
+	cache   *enigmacache.MemoryCache
+	opts    options
+	started time.Time
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	// flushes holds the timers of delayed flush_alls, for Close to
+	// stop.
+	flushes map[*time.Timer]struct{}
+	closed  bool
+	wg      sync.WaitGroup
+
+	current, total atomic.Int64
+}
+
+type options struct {
+	maxItemSize int
+	logger      *slog.Logger
+}
+
+// An Option configures a Server.
+type Option func(*options)
+
+// WithMaxItemSize sets the biggest value a client may store, in bytes;
+// a bigger one gets SERVER_ERROR. The default is 1 MiB, as for
+// memcached.
+func WithMaxItemSize(n int) Option {
+	return func(o *options) {
+		o.maxItemSize = n
+	}
+}
+
+// WithLogger sets where the server logs connections failing. The
+// default is slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("memcached: server closed")
+
+// New returns a Server for cache.
+func New(cache *enigmacache.MemoryCache, opts ...Option) *Server {
+	s := &Server{
+		cache:   cache,
+		opts:    options{maxItemSize: 1 << 20, logger: slog.Default()},
+		started: time.Now(),
+		conns:   make(map[net.Conn]struct{}),
+		flushes: make(map[*time.Timer]struct{}),
+	}
+	for _, opt := range opts {
+		opt(&s.opts)
+	}
+	return s
+}
+
+// ListenAndServe listens on the TCP address addr and serves clients
+// connecting to it, as Serve does.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve serves clients connecting to ln, each on a goroutine of its
+// own, until Close is called, when it returns ErrServerClosed.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.listener = ln
+	s.mu.Unlock()
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(nc) {
+			nc.Close()
+			return ErrServerClosed
+		}
+		go s.serve(nc)
+	}
+}
+
+// track adds nc to the open connections, reporting false if the
+// server has been closed.
+func (s *Server) track(nc net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[nc] = struct{}{}
+	s.wg.Add(1)
+	s.current.Add(1)
+	s.total.Add(1)
+	return true
+}
+
+// Close stops the server listening and closes its connections, waiting
+// for their goroutines to finish. Delayed flush_alls which haven't run
+// yet are called off.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for nc := range s.conns {
+		nc.Close()
+	}
+	for t := range s.flushes {
+		t.Stop()
+	}
+	clear(s.flushes)
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// serve runs a client's commands until it quits or the connection
+// fails.
+func (s *Server) serve(nc net.Conn) {
+	defer func() {
+		nc.Close()
+		s.mu.Lock()
+		delete(s.conns, nc)
+		s.mu.Unlock()
+		s.current.Add(-1)
+		s.wg.Done()
+	}()
+	c := &conn{s: s, r: bufio.NewReaderSize(nc, maxLineLength), w: bufio.NewWriter(nc)}
+	for {
+		// The reader's buffer bounds the line, so a client can't make
+		// us hold an endless one.
+		line, err := c.r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			if c.reply("CLIENT_ERROR line too long") == nil {
+				c.w.Flush()
+			}
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.opts.logger.Warn("enigma-cache: memcached connection failed", "remote", nc.RemoteAddr(), "err", err)
+			}
+			return
+		}
+		quit, err := c.run(strings.Fields(string(line)))
+		if err == nil {
+			err = c.w.Flush()
+		}
+		if quit || err != nil {
+			return
+		}
+	}
+}
+
+// A conn is a client connection.
+type conn struct {
+	s *Server
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// A clientError is reported to the client as CLIENT_ERROR; any other
+// error ends the connection.
+type clientError string
+
+func (e clientError) Error() string { return string(e) }
+
+// run runs a command, reporting whether the client has quit.
+func (c *conn) run(args []string) (quit bool, err error) {
+	if len(args) == 0 {
+		return false, c.reply("ERROR")
+	}
+	switch args[0] {
+	case "get", "gets":
+		err = c.get(args[1:], args[0] == "gets")
+	case "set", "add", "replace":
+		err = c.store(args)
+	case "delete":
+		err = c.delete(args[1:])
+	case "touch":
+		err = c.touch(args[1:])
+	case "flush_all":
+		err = c.flushAll(args[1:])
+	case "stats":
+		err = c.stats(args[1:])
+	case "version":
+		err = c.reply("VERSION enigma-cache")
+	case "quit":
+		return true, nil
+	default:
+		err = c.reply("ERROR")
+	}
+	if e, ok := err.(clientError); ok {
+		return false, c.reply("CLIENT_ERROR " + string(e))
+	}
+	return false, err
+}
+
+func (c *conn) reply(line string) error {
+	_, err := c.w.WriteString(line + "\r\n")
+	return err
+}
+
+// noreply strips a trailing noreply from args, reporting whether
+// there was one.
+func noreply(args []string) ([]string, bool) {
+	if n := len(args); n > 0 && args[n-1] == "noreply" {
+		return args[:n-1], true
+	}
+	return args, false
+}
+
+func checkKey(key string) error {
+	if len(key) > maxKeyLength {
+		return clientError("key too long")
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return clientError("bad key")
+		}
+	}
+	return nil
+}
+
+// parseExptime turns an exptime into a TTL: zero means never, up to
+// thirty days is seconds from now, beyond that a Unix time, and a
+// negative one means already expired.
+func parseExptime(arg string) (time.Duration, error) {
+	exptime, err := strconv.ParseInt(arg, 10, 64)
+	switch {
+	case err != nil:
+		return 0, clientError("bad exptime")
+	case exptime == 0:
+		return enigmacache.NoExpiration, nil
+	case exptime < 0:
+		return 0, nil
+	case exptime <= maxRelativeExptime:
+		return time.Duration(exptime) * time.Second, nil
+	}
+	return max(time.Until(time.Unix(exptime, 0)), 0), nil
+}
+
+// item returns the Item a stored value reads as.
+func item(value any) (Item, bool) {
+	switch v := value.(type) {
+	case Item:
+		return v, true
+	case []byte:
+		return Item{Data: v}, true
+	case string:
+		return Item{Data: []byte(v)}, true
+	}
+	return Item{}, false
+}
+
+func (c *conn) get(keys []string, cas bool) error {
+	if len(keys) == 0 {
+		return c.reply("ERROR")
+	}
+	for _, key := range keys {
+		if err := checkKey(key); err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		value, ok := c.s.cache.Get(key)
+		if !ok {
+			continue
+		}
+		it, ok := item(value)
+		if !ok {
+			continue
+		}
+		if cas {
+			// There's no cas command to use it with, so any unique
+			// value will do.
+			fmt.Fprintf(c.w, "VALUE %s %d %d 0\r\n", key, it.Flags, len(it.Data))
+		} else {
+			fmt.Fprintf(c.w, "VALUE %s %d %d\r\n", key, it.Flags, len(it.Data))
+		}
+		c.w.Write(it.Data)
+		c.w.WriteString("\r\n")
+	}
+	return c.reply("END")
+}
+
+// store runs set, add and replace: <command> <key> <flags> <exptime>
+// <bytes> [noreply], followed by the data.
+func (c *conn) store(args []string) error {
+	args, quiet := noreply(args)
+	if len(args) != 5 {
+		return c.reply("ERROR")
+	}
+	size, err := strconv.Atoi(args[4])
+	if err != nil || size < 0 {
+		return clientError("bad data chunk")
+	}
+	if size > c.s.opts.maxItemSize {
+		// Skip the data, so the connection stays in step.
+		if _, err := io.CopyN(io.Discard, c.r, int64(size)+2); err != nil {
+			return err
+		}
+		return c.reply("SERVER_ERROR object too large for cache")
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+	if string(data[size:]) != "\r\n" {
+		return clientError("bad data chunk")
+	}
+	key := args[1]
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	flags, err := strconv.ParseUint(args[2], 10, 32)
+	if err != nil {
+		return clientError("bad command line format")
+	}
+	ttl, err := parseExptime(args[3])
+	if err != nil {
+		return err
+	}
+	it := Item{Flags: uint32(flags), Data: data[:size]}
+	stored := true
+	switch args[0] {
+	case "set":
+		c.s.cache.Set(key, it, ttl)
+	case "add":
+		stored = c.s.cache.SetIfAbsent(key, it, ttl)
+	case "replace":
+		stored = c.s.cache.Replace(key, it, ttl)
+	}
+	if quiet {
+		return nil
+	}
+	if !stored {
+		return c.reply("NOT_STORED")
+	}
+	return c.reply("STORED")
+}
+
+// delete runs delete <key> [noreply].
+func (c *conn) delete(args []string) error {
+	args, quiet := noreply(args)
+	if len(args) != 1 {
+		return c.reply("ERROR")
+	}
+	if err := checkKey(args[0]); err != nil {
+		return err
+	}
+	_, deleted := c.s.cache.Expire(args[0])
+	switch {
+	case quiet:
+		return nil
+	case !deleted:
+		return c.reply("NOT_FOUND")
+	}
+	return c.reply("DELETED")
+}
+
+// touch runs touch <key> <exptime> [noreply].
+func (c *conn) touch(args []string) error {
+	args, quiet := noreply(args)
+	if len(args) != 2 {
+		return c.reply("ERROR")
+	}
+	if err := checkKey(args[0]); err != nil {
+		return err
+	}
+	ttl, err := parseExptime(args[1])
+	if err != nil {
+		return err
+	}
+	touched := c.s.cache.Refresh(args[0], ttl)
+	switch {
+	case quiet:
+		return nil
+	case !touched:
+		return c.reply("NOT_FOUND")
+	}
+	return c.reply("TOUCHED")
+}
+
+// flushAll runs flush_all [delay] [noreply].
+func (c *conn) flushAll(args []string) error {
+	args, quiet := noreply(args)
+	switch len(args) {
+	case 0:
+		c.s.cache.ExpireAll()
+	case 1:
+		delay, err := strconv.Atoi(args[0])
+		if err != nil || delay < 0 {
+			return clientError("bad delay")
+		}
+		if delay == 0 {
+			c.s.cache.ExpireAll()
+		} else {
+			c.s.flushAfter(time.Duration(delay) * time.Second)
+		}
+	default:
+		return c.reply("ERROR")
+	}
+	if quiet {
+		return nil
+	}
+	return c.reply("OK")
+}
+
+// flushAfter empties the cache after d, unless the server is closed
+// first.
+func (s *Server) flushAfter(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		s.mu.Lock()
+		_, pending := s.flushes[t]
+		delete(s.flushes, t)
+		s.mu.Unlock()
+		if pending {
+			s.cache.ExpireAll()
+		}
+	})
+	s.flushes[t] = struct{}{}
+}
+
+// stats runs stats, with no arguments; the stats subcommands aren't
+// supported.
+func (c *conn) stats(args []string) error {
+	if len(args) != 0 {
+		return c.reply("ERROR")
+	}
+	st := c.s.cache.Stats()
+	now := time.Now()
+	for _, stat := range []struct {
+		name  string
+		value any
+	}{
+		{"pid", os.Getpid()},
+		{"uptime", int64(now.Sub(c.s.started) / time.Second)},
+		{"time", now.Unix()},
+		{"version", "enigma-cache"},
+		{"curr_connections", c.s.current.Load()},
+		{"total_connections", c.s.total.Load()},
+		{"curr_items", st.Entries},
+		{"bytes", st.Bytes},
+		{"cmd_get", st.Hits + st.Misses},
+		{"cmd_set", st.Sets},
+		{"get_hits", st.Hits},
+		{"get_misses", st.Misses},
+		{"evictions", st.Evicted},
+	} {
+		fmt.Fprintf(c.w, "STAT %s %v\r\n", stat.name, stat.value)
+	}
+	return c.reply("END")
+}
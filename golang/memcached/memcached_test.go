@@ -0,0 +1,169 @@
+package memcached
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	enigmacache "github.com/plathrop/enigma-cache/golang"
+)
+
+// client is a connection to a test server.
+type client struct {
+	t  *testing.T
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+func newServer(t *testing.T, opts ...Option) (*enigmacache.MemoryCache, *client) {
+	t.Helper()
+	cache, _ := enigmacache.NewTestCache()
+	return cache, serve(t, New(cache, opts...))
+}
+
+// serve starts s, returning a client connected to it.
+func serve(t *testing.T, s *Server) *client {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ln) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != ErrServerClosed {
+			t.Errorf("Serve returned %v, want ErrServerClosed", err)
+		}
+	})
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	return &client{t: t, nc: nc, r: bufio.NewReader(nc)}
+}
+
+// send sends lines, then reads n lines of reply.
+func (c *client) send(n int, lines ...string) string {
+	c.t.Helper()
+	for _, line := range lines {
+		if _, err := io.WriteString(c.nc, line+"\r\n"); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	c.nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var reply []string
+	for range n {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("reading the reply to %q: %v", lines, err)
+		}
+		reply = append(reply, strings.TrimSuffix(line, "\r\n"))
+	}
+	return strings.Join(reply, "\n")
+}
+
+func TestProtocol(t *testing.T) {
+	cache, c := newServer(t)
+	for _, tc := range []struct {
+		lines []string
+		n     int
+		want  string
+	}{
+		{[]string{"set a 5 0 5", "apple"}, 1, "STORED"},
+		{[]string{"get a"}, 3, "VALUE a 5 5\napple\nEND"},
+		{[]string{"set b 0 60 6 noreply", "banana", "get a b missing"}, 5, "VALUE a 5 5\napple\nVALUE b 0 6\nbanana\nEND"},
+		{[]string{"gets b"}, 3, "VALUE b 0 6 0\nbanana\nEND"},
+		{[]string{"add a 0 0 1", "x"}, 1, "NOT_STORED"},
+		{[]string{"add c 0 0 1", "x"}, 1, "STORED"},
+		{[]string{"replace missing 0 0 1", "x"}, 1, "NOT_STORED"},
+		{[]string{"replace c 0 0 1", "y"}, 1, "STORED"},
+		{[]string{"touch b 120"}, 1, "TOUCHED"},
+		{[]string{"touch missing 120"}, 1, "NOT_FOUND"},
+		{[]string{"delete a"}, 1, "DELETED"},
+		{[]string{"delete a"}, 1, "NOT_FOUND"},
+		{[]string{"get a"}, 1, "END"},
+		{[]string{"incr c 1"}, 1, "ERROR"},
+		{[]string{"get " + strings.Repeat("k", 251)}, 1, "CLIENT_ERROR key too long"},
+		{[]string{"set k 0 soon 1", "x"}, 1, "CLIENT_ERROR bad exptime"},
+		{[]string{"version"}, 1, "VERSION enigma-cache"},
+	} {
+		if got := c.send(tc.n, tc.lines...); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.lines, got, tc.want)
+		}
+	}
+	if remaining, _ := cache.TTL("b"); remaining != 2*time.Minute {
+		t.Errorf("TTL(b) = %v after touch, want 2m", remaining)
+	}
+	if remaining, _ := cache.TTL("c"); remaining != enigmacache.NoExpiration {
+		t.Errorf("TTL(c) = %v, want NoExpiration for an exptime of 0", remaining)
+	}
+
+	// Values stored by code sharing the cache are served too, if
+	// they're bytes.
+	cache.Set("native", "text", time.Minute)
+	cache.Set("number", 7, time.Minute)
+	if got := c.send(3, "get native number"); got != "VALUE native 0 4\ntext\nEND" {
+		t.Errorf("get of native values = %q", got)
+	}
+
+	stats := c.send(14, "stats")
+	if !strings.Contains(stats, "STAT curr_items 4\n") || !strings.HasSuffix(stats, "END") {
+		t.Errorf("stats = %q, want 4 items", stats)
+	}
+	if got := c.send(1, "flush_all"); got != "OK" || cache.Len() != 0 {
+		t.Errorf("flush_all = %q, leaving %d entries", got, cache.Len())
+	}
+	io.WriteString(c.nc, "quit\r\n")
+	if _, err := c.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("read after quit returned %v, want EOF", err)
+	}
+}
+
+func TestItemTooLarge(t *testing.T) {
+	cache, c := newServer(t, WithMaxItemSize(4))
+	data := strings.Repeat("x", 10)
+	if got := c.send(1, fmt.Sprintf("set k 0 0 %d", len(data)), data); got != "SERVER_ERROR object too large for cache" {
+		t.Errorf("set of a big value = %q", got)
+	}
+	// The data was skipped, so the connection is still in step.
+	if got := c.send(1, "set k 0 0 4", "xxxx"); got != "STORED" || !cache.Has("k") {
+		t.Errorf("set after a big value = %q", got)
+	}
+}
+
+func TestLineTooLong(t *testing.T) {
+	_, c := newServer(t)
+	if got := c.send(1, "get "+strings.Repeat("k", maxLineLength)); got != "CLIENT_ERROR line too long" {
+		t.Errorf("an overlong line got %q", got)
+	}
+	// The rest of the line is left unread, so the close may come as
+	// a reset rather than EOF.
+	if line, err := c.r.ReadString('\n'); err == nil {
+		t.Errorf("connection still open after an overlong line, sending %q", line)
+	}
+}
+
+func TestCloseCancelsDelayedFlush(t *testing.T) {
+	cache, _ := enigmacache.NewTestCache()
+	s := New(cache)
+	c := serve(t, s)
+	if got := c.send(1, "flush_all 60"); got != "OK" {
+		t.Fatalf("flush_all 60 = %q", got)
+	}
+	s.mu.Lock()
+	pending := len(s.flushes)
+	s.mu.Unlock()
+	if pending != 1 {
+		t.Fatalf("%d delayed flushes pending, want 1", pending)
+	}
+	s.Close()
+	if len(s.flushes) != 0 {
+		t.Errorf("%d delayed flushes left after Close", len(s.flushes))
+	}
+}
@@ -0,0 +1,102 @@
+package enigmacache
+
+import (
+	"context"
+	"time"
+)
+
+// Meta is metadata stored alongside a value by SetWithMeta.
+type Meta struct {
+	// ETag identifies the version of the value, as an HTTP entity
+	// tag does, for GetIfNoneMatch.
+	ETag string
+
+	// StaleWhileRevalidate and StaleIfError keep the value past its
+	// freshness lifetime, for serving stale as RFC 5861 allows (see
+	// GetFreshness and httpttl.StaleFromHTTPHeaders). MustRevalidate
+	// forbids serving it stale at all.
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	MustRevalidate       bool
+
+	// Version is the source version of the value, as stored by
+	// SetIfNewer.
+	Version uint64
+}
+
+// staleWindow returns how long past its freshness lifetime an entry
+// with meta is kept.
+func (meta *Meta) staleWindow() time.Duration {
+	return max(meta.StaleWhileRevalidate, meta.StaleIfError, 0)
+}
+
+// SetWithMeta sets key like Set, storing meta with the value.
+// Refreshing the entry's TTL keeps its metadata; any other write to
+// the key replaces it, with none unless it too is a SetWithMeta.
+//
+// If meta has a stale window, ttl is the value's freshness lifetime,
+// and the entry is kept for the longer of StaleWhileRevalidate and
+// StaleIfError beyond it, to the end of which TTL reports it as
+// having left. Plain reads such as Get return it until then, fresh or
+// not; GetFreshness tells the two apart. A Refresh of such an entry
+// resets its whole life, stale window included.
+func (mc *MemoryCache) SetWithMeta(key string, value any, meta Meta, ttl time.Duration) {
+	key = mc.normalize(key)
+	ttl, ok := mc.valueTTL(key, value, ttl)
+	if !ok || mc.rejects(value) {
+		return
+	}
+	if ttl != NoExpiration {
+		ttl += meta.staleWindow()
+	}
+	e := mc.newEntry(key, value, ttl, 0)
+	e.meta = &meta
+	prev, ok := mc.putEntry(key, e)
+	if !ok {
+		return
+	}
+	if prev != nil {
+		mc.replaced(prev)
+	}
+	_ = mc.writeThrough(context.Background(), key, value, ttl)
+}
+
+// GetWithMeta returns the value stored for key, like Get, along with
+// the metadata stored by SetWithMeta, which is zero if there is none.
+func (mc *MemoryCache) GetWithMeta(key string) (value any, meta Meta, ok bool) {
+	key = mc.normalize(key)
+	e, ok := mc.load(key)
+	if !ok {
+		mc.missed(key)
+		return nil, Meta{}, false
+	}
+	if value, ok = mc.serve(key, e); !ok {
+		return nil, Meta{}, false
+	}
+	if e.meta != nil {
+		meta = *e.meta
+	}
+	return value, meta, true
+}
+
+// GetIfNoneMatch answers a conditional read, as for an HTTP request
+// with If-None-Match: if key is present and its ETag (see
+// SetWithMeta) is etag, notModified is true and no value is returned,
+// so the caller can reply 304 Not Modified. Otherwise it behaves like
+// Get. An empty etag never matches.
+func (mc *MemoryCache) GetIfNoneMatch(key, etag string) (value any, notModified, ok bool) {
+	key = mc.normalize(key)
+	e, ok := mc.load(key)
+	if !ok {
+		mc.missed(key)
+		return nil, false, false
+	}
+	if etag != "" && e.meta != nil && e.meta.ETag == etag {
+		mc.accessed(key, e)
+		return nil, true, true
+	}
+	if value, ok = mc.serve(key, e); !ok {
+		return nil, false, false
+	}
+	return value, false, true
+}
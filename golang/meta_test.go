@@ -0,0 +1,31 @@
+package enigmacache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetIfNoneMatch(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.SetWithMeta("page", "<html>", Meta{ETag: `"v1"`}, time.Minute)
+
+	if v, notModified, ok := cache.GetIfNoneMatch("page", `"v1"`); !ok || !notModified || v != nil {
+		t.Fatalf("matching ETag got (%v, %v, %v), want (nil, true, true)", v, notModified, ok)
+	}
+	if v, notModified, ok := cache.GetIfNoneMatch("page", `"v0"`); !ok || notModified || v != "<html>" {
+		t.Fatalf("stale ETag got (%v, %v, %v), want (<html>, false, true)", v, notModified, ok)
+	}
+	if v, notModified, ok := cache.GetIfNoneMatch("missing", `"v1"`); ok || notModified || v != nil {
+		t.Fatalf("missing key got (%v, %v, %v), want (nil, false, false)", v, notModified, ok)
+	}
+
+	// A refresh keeps the ETag; a plain Set drops it.
+	cache.Refresh("page", time.Hour)
+	if _, meta, _ := cache.GetWithMeta("page"); meta.ETag != `"v1"` {
+		t.Fatalf("ETag after Refresh = %q, want \"v1\"", meta.ETag)
+	}
+	cache.Set("page", "<html>v2", time.Minute)
+	if _, notModified, _ := cache.GetIfNoneMatch("page", `"v1"`); notModified {
+		t.Fatal("ETag survived an overwrite by Set")
+	}
+}
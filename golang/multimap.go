@@ -0,0 +1,165 @@
+package enigmacache
+
+import (
+	"fmt"
+	"time"
+)
+
+// A memberSet is the value stored for a key used as a set by
+// AddToSet. Like entries, sets are never modified once stored: each
+// change stores a new one.
+type memberSet struct {
+	// members are in the order they were first added.
+	members []member
+}
+
+type member struct {
+	value     any
+	expiresAt time.Time
+}
+
+// live returns the members which haven't expired as of now.
+func (s *memberSet) live(now time.Time) []member {
+	var live []member
+	for _, m := range s.members {
+		if now.Before(m.expiresAt) {
+			live = append(live, m)
+		}
+	}
+	return live
+}
+
+// AddToSet adds value to the set stored for key, creating the set if
+// the key is absent. value expires from the set after ttl, unless
+// it's added again first, which renews it; each member expires on its
+// own, and the key leaves the cache when its last member does.
+// Members are compared with ==, so they must be comparable. AddToSet
+// returns an error wrapping ErrTypeMismatch if the key holds a value
+// not stored by AddToSet, and one wrapping ErrRejected if the cache's
+// options turn away the set (ErrValueTooLarge if it has outgrown the
+// WithMaxMemory budget). Sets aren't written through to a Store.
+//
+// Each change copies the set, so adding to or removing from a set of
+// n members takes O(n) time.
+func (mc *MemoryCache) AddToSet(key string, value any, ttl time.Duration) error {
+	key = mc.normalize(key)
+	if mc.closed.Load() {
+		return fmt.Errorf("add to set %q: %w", key, ErrClosed)
+	}
+	return mc.updateSet(key, "add to set", func(members []member) []member {
+		expiresAt := mc.deadlineAfter(mc.now(), ttl)
+		updated := make([]member, 0, len(members)+1)
+		added := false
+		for _, m := range members {
+			if m.value == value {
+				m.expiresAt = expiresAt
+				added = true
+			}
+			updated = append(updated, m)
+		}
+		if !added {
+			updated = append(updated, member{value, expiresAt})
+		}
+		return updated
+	})
+}
+
+// RemoveFromSet removes value from the set stored for key, reporting
+// whether it was a member. Removing the last member removes the key.
+func (mc *MemoryCache) RemoveFromSet(key string, value any) (removed bool) {
+	key = mc.normalize(key)
+	err := mc.updateSet(key, "remove from set", func(members []member) []member {
+		removed = false
+		updated := make([]member, 0, len(members))
+		for _, m := range members {
+			if m.value == value {
+				removed = true
+				continue
+			}
+			updated = append(updated, m)
+		}
+		return updated
+	})
+	return removed && err == nil
+}
+
+// GetSet returns the members of the set stored for key, in the order
+// they were first added. The ok result is false if the key is absent,
+// or holds something other than a set.
+func (mc *MemoryCache) GetSet(key string) (members []any, ok bool) {
+	key = mc.normalize(key)
+	e, ok := mc.load(key)
+	var live []member
+	if ok {
+		if s, isSet := e.get().(*memberSet); isSet {
+			live = s.live(mc.now())
+		}
+	}
+	if len(live) == 0 {
+		mc.missed(key)
+		return nil, false
+	}
+	mc.accessed(key, e)
+	members = make([]any, len(live))
+	for i, m := range live {
+		members[i] = m.value
+	}
+	return members, true
+}
+
+// updateSet replaces the set stored for key with fn's changes to its
+// live members, storing it for as long as its longest-lived member, or
+// deleting the key if no members are left. Like Update, it works by
+// compare-and-swap, so fn may be called more than once.
+func (mc *MemoryCache) updateSet(key, what string, fn func(members []member) []member) error {
+	for {
+		now := mc.now()
+		old, ok := mc.storage.Load(key)
+		var members []member
+		if ok && old.live(now) {
+			s, isSet := old.get().(*memberSet)
+			if !isSet {
+				return fmt.Errorf("%s %q: %w", what, key, ErrTypeMismatch)
+			}
+			members = s.live(now)
+		}
+		members = fn(members)
+
+		if len(members) == 0 {
+			if !ok {
+				return nil
+			}
+			if mc.storage.CompareAndDelete(key, old) {
+				mc.removed(key, old, ReasonManual)
+				return nil
+			}
+			continue
+		}
+
+		last := members[0].expiresAt
+		for _, m := range members[1:] {
+			if m.expiresAt.After(last) {
+				last = m.expiresAt
+			}
+		}
+		e := mc.newEntry(key, &memberSet{members}, last.Sub(now), 0)
+		if !mc.fits(e) {
+			return fmt.Errorf("%s %q: %d bytes: %w", what, key, e.cost, ErrValueTooLarge)
+		}
+		if !ok && !mc.admit(key, e.cost) {
+			return fmt.Errorf("%s %q: %w", what, key, ErrRejected)
+		}
+		if !ok {
+			if _, loaded := mc.storage.LoadOrStore(key, e); loaded {
+				continue
+			}
+			mc.stored(key, e, nil)
+		} else {
+			if !mc.storage.CompareAndSwap(key, old, e) {
+				continue
+			}
+			mc.stored(key, e, old)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,69 @@
+package enigmacache
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSetMembersExpireIndependently(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.AddToSet("term", "doc1", time.Minute)
+	cache.AddToSet("term", "doc2", time.Hour)
+	cache.AddToSet("term", "doc3", 2*time.Minute)
+
+	members, ok := cache.GetSet("term")
+	if want := "[doc1 doc2 doc3]"; !ok || fmt.Sprint(members) != want {
+		t.Fatalf("GetSet = %v, %v, want %s, true", members, ok, want)
+	}
+
+	clock.Advance(90 * time.Second)
+	cache.AddToSet("term", "doc3", 2*time.Minute) // renews doc3
+	clock.Advance(time.Minute)
+	if members, _ := cache.GetSet("term"); fmt.Sprint(members) != "[doc2 doc3]" {
+		t.Fatalf("after doc1 expired GetSet = %v, want [doc2 doc3]", members)
+	}
+
+	if !cache.RemoveFromSet("term", "doc2") {
+		t.Fatal("RemoveFromSet didn't find doc2")
+	}
+	if cache.RemoveFromSet("term", "doc2") {
+		t.Fatal("RemoveFromSet found doc2 twice")
+	}
+	if members, _ := cache.GetSet("term"); fmt.Sprint(members) != "[doc3]" {
+		t.Fatalf("after removing doc2 GetSet = %v, want [doc3]", members)
+	}
+}
+
+func TestSetKeyLeavesWithLastMember(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.AddToSet("expiring", 1, time.Minute)
+	cache.AddToSet("expiring", 2, 2*time.Minute)
+	cache.AddToSet("removed", 1, time.Hour)
+
+	clock.Advance(time.Minute)
+	if !cache.Has("expiring") {
+		t.Fatal("set left with a member remaining")
+	}
+	clock.Advance(time.Minute)
+	if cache.Has("expiring") {
+		t.Fatal("set outlived its last member")
+	}
+
+	cache.RemoveFromSet("removed", 1)
+	if _, ok := cache.GetSet("removed"); ok || cache.Len() != 0 {
+		t.Fatalf("key survived removal of its last member, Len = %d", cache.Len())
+	}
+}
+
+func TestAddToSetTypeMismatch(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.Set("plain", "value", time.Minute)
+	if err := cache.AddToSet("plain", 1, time.Minute); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("AddToSet on a plain value = %v, want ErrTypeMismatch", err)
+	}
+	if _, ok := cache.GetSet("plain"); ok {
+		t.Fatal("GetSet returned a plain value")
+	}
+}
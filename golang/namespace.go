@@ -0,0 +1,214 @@
+package enigmacache
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A NamespacedCache is a view of a MemoryCache holding just the keys
+// under a prefix, such as one tenant's, which it adds to every key it's
+// given and strips from those it returns. It's made by Namespace.
+// ExpireAll clears the namespace alone, leaving the rest of the cache
+// be.
+type NamespacedCache struct {
+	mc     *MemoryCache
+	prefix string
+}
+
+var _ ReadWriter = (*NamespacedCache)(nil)
+
+// Namespace returns a view of the cache whose keys are scoped by
+// prefix, and starts keeping track of the keys under prefix, so that
+// the view's Keys, Len and ExpireAll, and ExpireNamespace, go straight
+// to them rather than scanning the whole cache. Tracking costs each
+// write of a new key a lookup for every prefix of it, and lasts as
+// long as the cache; calling Namespace again for the same prefix
+// shares it. The prefix is used as it is, so include any separator:
+// "tenant42:", not "tenant42".
+func (mc *MemoryCache) Namespace(prefix string) *NamespacedCache {
+	prefix = mc.normalize(prefix)
+	mc.namespaces.track(mc, prefix)
+	return &NamespacedCache{mc: mc, prefix: prefix}
+}
+
+// ExpireNamespace removes every key under prefix, as Expire would, and
+// returns how many live keys it removed. For a prefix passed to
+// Namespace, it works from the keys tracked for it; for any other, it
+// scans the cache, as ExpirePrefix does.
+func (mc *MemoryCache) ExpireNamespace(prefix string) int {
+	prefix = mc.normalize(prefix)
+	keys, ok := mc.namespaces.keys(prefix)
+	if !ok {
+		return mc.ExpirePrefix(prefix)
+	}
+	n := 0
+	for _, key := range keys {
+		e, ok := mc.storage.Load(key)
+		if !ok {
+			continue
+		}
+		mc.deleteThrough(key)
+		if mc.storage.CompareAndDelete(key, e) {
+			mc.removed(key, e, ReasonManual)
+			if e.live(mc.now()) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Prefix returns the namespace's prefix.
+func (c *NamespacedCache) Prefix() string { return c.prefix }
+
+// Cache returns the underlying cache.
+func (c *NamespacedCache) Cache() *MemoryCache { return c.mc }
+
+// Get is MemoryCache.Get, within the namespace.
+func (c *NamespacedCache) Get(key string) (value any, ok bool) {
+	return c.mc.Get(c.prefix + key)
+}
+
+// Has is MemoryCache.Has, within the namespace.
+func (c *NamespacedCache) Has(key string) bool {
+	return c.mc.Has(c.prefix + key)
+}
+
+// TTL is MemoryCache.TTL, within the namespace.
+func (c *NamespacedCache) TTL(key string) (remaining time.Duration, ok bool) {
+	return c.mc.TTL(c.prefix + key)
+}
+
+// Keys returns the keys present in the namespace, without its prefix,
+// in no particular order.
+func (c *NamespacedCache) Keys() []string {
+	tracked, _ := c.mc.namespaces.keys(c.prefix)
+	var keys []string
+	now := c.mc.now()
+	for _, key := range tracked {
+		if e, ok := c.mc.storage.Load(key); ok && e.live(now) {
+			keys = append(keys, key[len(c.prefix):])
+		}
+	}
+	return keys
+}
+
+// Len returns the number of entries in the namespace.
+func (c *NamespacedCache) Len() int {
+	return len(c.Keys())
+}
+
+// Set is MemoryCache.Set, within the namespace.
+func (c *NamespacedCache) Set(key string, value any, ttl time.Duration) {
+	c.mc.Set(c.prefix+key, value, ttl)
+}
+
+// GetOrSet is MemoryCache.GetOrSet, within the namespace.
+func (c *NamespacedCache) GetOrSet(key string, value any, ttl time.Duration) (actual any, loaded bool) {
+	return c.mc.GetOrSet(c.prefix+key, value, ttl)
+}
+
+// Expire is MemoryCache.Expire, within the namespace.
+func (c *NamespacedCache) Expire(key string) (value any, loaded bool) {
+	return c.mc.Expire(c.prefix + key)
+}
+
+// Refresh is MemoryCache.Refresh, within the namespace.
+func (c *NamespacedCache) Refresh(key string, ttl time.Duration) (refreshed bool) {
+	return c.mc.Refresh(c.prefix+key, ttl)
+}
+
+// ExpireAll removes every key in the namespace; see ExpireNamespace.
+func (c *NamespacedCache) ExpireAll() {
+	c.mc.ExpireNamespace(c.prefix)
+}
+
+// namespaces tracks the keys stored under each prefix passed to
+// Namespace.
+type namespaces struct {
+	// tracked is the number of prefixes tracked, so writes can skip
+	// the lock while there are none.
+	tracked atomic.Int32
+
+	mu sync.RWMutex
+	// byPrefix maps each prefix to the keys in storage under it.
+	byPrefix map[string]map[string]struct{}
+	// longest is the length of the longest prefix.
+	longest int
+}
+
+// track starts tracking prefix, if it isn't tracked already, filing
+// the keys already under it.
+func (ns *namespaces) track(mc *MemoryCache, prefix string) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if _, ok := ns.byPrefix[prefix]; ok {
+		return
+	}
+	if ns.byPrefix == nil {
+		ns.byPrefix = make(map[string]map[string]struct{})
+	}
+	keys := make(map[string]struct{})
+	ns.byPrefix[prefix] = keys
+	ns.longest = max(ns.longest, len(prefix))
+	// Writes from here on file themselves, waiting on ns.mu until
+	// the keys already stored are filed.
+	ns.tracked.Add(1)
+	mc.storage.Range(func(key string, _ *entry) bool {
+		if strings.HasPrefix(key, prefix) {
+			keys[key] = struct{}{}
+		}
+		return true
+	})
+}
+
+// stored files key, which has just gone into storage, under each
+// tracked prefix of it.
+func (ns *namespaces) stored(key string) {
+	if ns.tracked.Load() == 0 {
+		return
+	}
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	for i := min(len(key), ns.longest); i >= 0; i-- {
+		if keys, ok := ns.byPrefix[key[:i]]; ok {
+			keys[key] = struct{}{}
+		}
+	}
+}
+
+// removed unfiles key, which has just left storage, unless it's been
+// stored again since.
+func (ns *namespaces) removed(mc *MemoryCache, key string) {
+	if ns.tracked.Load() == 0 {
+		return
+	}
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if _, ok := mc.storage.Load(key); ok {
+		return
+	}
+	for i := min(len(key), ns.longest); i >= 0; i-- {
+		if keys, ok := ns.byPrefix[key[:i]]; ok {
+			delete(keys, key)
+		}
+	}
+}
+
+// keys returns the keys filed under prefix, reporting false if it
+// isn't tracked.
+func (ns *namespaces) keys(prefix string) ([]string, bool) {
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+	filed, ok := ns.byPrefix[prefix]
+	if !ok {
+		return nil, false
+	}
+	keys := make([]string, 0, len(filed))
+	for key := range filed {
+		keys = append(keys, key)
+	}
+	return keys, true
+}
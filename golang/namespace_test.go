@@ -0,0 +1,77 @@
+package enigmacache
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNamespace(t *testing.T) {
+	cache, _ := NewTestCache()
+	cache.Set("t1:early", 0, time.Hour)
+	t1 := cache.Namespace("t1:")
+	t2 := cache.Namespace("t2:")
+
+	t1.Set("a", 1, time.Hour)
+	t1.Set("b", 2, time.Hour)
+	t2.Set("a", 3, time.Hour)
+	cache.Set("other", 4, time.Hour)
+
+	if value, ok := t1.Get("a"); !ok || value != 1 {
+		t.Errorf("t1.Get(a) = %v, %v; want 1, true", value, ok)
+	}
+	if value, ok := cache.Get("t2:a"); !ok || value != 3 {
+		t.Errorf("Get(t2:a) = %v, %v; want 3, true", value, ok)
+	}
+	keys := t1.Keys()
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"a", "b", "early"}) || t1.Len() != 3 {
+		t.Errorf("t1.Keys() = %v, Len = %d; want [a b early], 3", keys, t1.Len())
+	}
+
+	t1.Expire("b")
+	if n := cache.ExpireNamespace("t1:"); n != 2 {
+		t.Errorf("ExpireNamespace removed %d keys, want 2", n)
+	}
+	if t1.Len() != 0 || cache.Has("t1:a") {
+		t.Error("ExpireNamespace left keys in the namespace")
+	}
+	if !t2.Has("a") || !cache.Has("other") {
+		t.Error("ExpireNamespace removed keys outside the namespace")
+	}
+
+	// The namespace goes on tracking keys written after it's cleared.
+	t1.Set("c", 5, time.Hour)
+	if keys := t1.Keys(); !slices.Equal(keys, []string{"c"}) {
+		t.Errorf("t1.Keys() = %v after a new write, want [c]", keys)
+	}
+	t2.ExpireAll()
+	if t2.Len() != 0 || !t1.Has("c") {
+		t.Error("ExpireAll cleared the wrong keys")
+	}
+}
+
+func TestNamespaceNested(t *testing.T) {
+	cache, _ := NewTestCache()
+	outer := cache.Namespace("a:")
+	inner := cache.Namespace("a:b:")
+	inner.Set("k", 1, time.Hour)
+	outer.Set("k", 2, time.Hour)
+
+	if outer.Len() != 2 || inner.Len() != 1 {
+		t.Errorf("outer has %d keys and inner %d, want 2 and 1", outer.Len(), inner.Len())
+	}
+	outer.ExpireAll()
+	if inner.Len() != 0 {
+		t.Error("clearing the outer namespace left keys in the inner one")
+	}
+}
+
+func TestExpireNamespaceUntracked(t *testing.T) {
+	cache, _ := NewTestCache()
+	cache.Set("x:1", 1, time.Hour)
+	cache.Set("y:1", 2, time.Hour)
+	if n := cache.ExpireNamespace("x:"); n != 1 || cache.Has("x:1") || !cache.Has("y:1") {
+		t.Errorf("ExpireNamespace of an untracked prefix removed %d keys, want just x:1", n)
+	}
+}
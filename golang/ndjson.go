@@ -0,0 +1,167 @@
+package enigmacache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"time"
+)
+
+// ndjsonEntry is the shape of each line written by ExportNDJSON.
+type ndjsonEntry struct {
+	Key        string    `json:"key"`
+	Value      any       `json:"value"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	LastAccess time.Time `json:"lastAccess,omitzero"`
+}
+
+// ExportNDJSON writes the cache's live entries to w as newline-
+// delimited JSON, one {"key":...,"value":...,"expiresAt":...,
+// "lastAccess":...} object per line. Entries are encoded as they're
+// visited, so the export never holds the whole cache's values in
+// memory, and like any iteration it isn't a consistent snapshot.
+// Values must be encodable with encoding/json.
+//
+// For a bounded cache, entries are written in eviction order, next
+// victim first, which ImportNDJSON relies on to rebuild the eviction
+// policy's bookkeeping; this takes a copy of the key list. Otherwise
+// they're written in no particular order.
+//
+// The lastAccess field was added after the format's first version.
+// Files without it still import, with each entry treated as read at
+// the moment it was imported.
+func (mc *MemoryCache) ExportNDJSON(w io.Writer) error {
+	export := mc.ndjsonExporter(w)
+
+	if mc.policy != nil {
+		mc.policyMu.Lock()
+		// Pinned keys are never evicted, so they come last.
+		keys := append(mc.policy.keys(), slices.Sorted(maps.Keys(mc.pinned))...)
+		mc.policyMu.Unlock()
+		for _, key := range keys {
+			if e, ok := mc.storage.Load(key); ok {
+				if err := export(key, e); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	var err error
+	mc.storage.Range(func(key string, e *entry) bool {
+		err = export(key, e)
+		return err == nil
+	})
+	return err
+}
+
+// Save writes a consistent snapshot of the cache's live entries to w,
+// in the format of ExportNDJSON, for backups of a busy cache: the
+// entries written are exactly those present at a single instant,
+// while the cache keeps serving reads and writes for the whole time
+// it takes to encode them. Entries are written in no particular
+// order, so unlike a bounded cache's export, the snapshot doesn't
+// record which entries are next to be evicted.
+//
+// A sharded cache (see WithShards) takes the snapshot by marking each
+// shard copy-on-write, which holds up nothing but the next write to
+// each shard, which copies its map of entry pointers. The default
+// backend can't be frozen that way, so every writer waits while Save
+// copies all of its entry pointers, though not while it encodes them;
+// build a large, busy cache that is saved WithShards.
+func (mc *MemoryCache) Save(w io.Writer) error {
+	export := mc.ndjsonExporter(w)
+	for _, entries := range mc.storage.Snapshot() {
+		for key, e := range entries {
+			if err := export(key, e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ndjsonExporter returns a function which writes an entry to w as a
+// line of NDJSON, if it's live.
+func (mc *MemoryCache) ndjsonExporter(w io.Writer) func(key string, e *entry) error {
+	enc := json.NewEncoder(w)
+	return func(key string, e *entry) error {
+		if !e.live(mc.now()) {
+			return nil
+		}
+		value, err := unpack(e.value)
+		if err != nil {
+			mc.unreadable(key, e, err)
+			return nil
+		}
+		err = enc.Encode(ndjsonEntry{
+			Key:        key,
+			Value:      value,
+			ExpiresAt:  e.deadline(),
+			LastAccess: time.Unix(0, e.lastAccess.Load()),
+		})
+		if err != nil {
+			return fmt.Errorf("enigma-cache: exporting %q: %w", key, err)
+		}
+		return nil
+	}
+}
+
+// ImportNDJSON reads entries in the format written by ExportNDJSON
+// from r and sets each with the TTL remaining until its expiresAt,
+// skipping any which have already expired. It returns how many it
+// set. Each entry keeps its recorded last access time, and since a
+// bounded cache's export is in eviction order, importing one into a
+// bounded cache leaves the same keys up for eviction first. Values
+// come back as encoding/json decodes them into an any: numbers as
+// float64, objects as map[string]any, and so on. On a malformed line,
+// ImportNDJSON stops and returns the count so far with an error.
+func (mc *MemoryCache) ImportNDJSON(r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	imported := 0
+	for line := 1; ; line++ {
+		var in ndjsonEntry
+		if err := dec.Decode(&in); err != nil {
+			if errors.Is(err, io.EOF) {
+				return imported, nil
+			}
+			return imported, fmt.Errorf("enigma-cache: importing entry %d: %w", line, err)
+		}
+		if mc.restore(in.Key, in.Value, in.ExpiresAt, in.LastAccess) {
+			imported++
+		}
+	}
+}
+
+// restore sets key to an entry read back from an export, with the TTL
+// remaining until expiresAt and, unless it's zero, lastAccess as its
+// last access time, reporting whether it was set: it isn't if it has
+// expired, or the cache's options turn it away.
+func (mc *MemoryCache) restore(key string, value any, expiresAt, lastAccess time.Time) bool {
+	key = mc.normalize(key)
+	ttl := expiresAt.Sub(mc.now())
+	if expiresAt.Equal(never) {
+		ttl = NoExpiration
+	}
+	if ttl <= 0 || mc.rejects(value) {
+		return false
+	}
+	e := mc.newEntry(key, value, ttl, 0)
+	if !lastAccess.IsZero() {
+		e.lastAccess.Store(lastAccess.UnixNano())
+	}
+	prev, ok := mc.putEntry(key, e)
+	if prev != nil {
+		mc.replaced(prev)
+	}
+	if !ok {
+		return false
+	}
+	_ = mc.writeThrough(context.Background(), key, value, ttl)
+	return true
+}
@@ -0,0 +1,165 @@
+package enigmacache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNDJSONRoundTrip(t *testing.T) {
+	src := NewMemoryCache()
+	src.Set("string", "value", time.Minute)
+	src.Set("number", 42, time.Minute)
+	src.Set("object", map[string]any{"a": true}, time.Minute)
+
+	var buf bytes.Buffer
+	if err := src.ExportNDJSON(&buf); err != nil {
+		t.Fatalf("ExportNDJSON returned %v", err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 3 {
+		t.Fatalf("exported %d lines, want 3:\n%s", lines, buf.String())
+	}
+
+	dst := NewMemoryCache()
+	n, err := dst.ImportNDJSON(&buf)
+	if err != nil || n != 3 {
+		t.Fatalf("ImportNDJSON got (%d, %v), want (3, nil)", n, err)
+	}
+	if value, _ := dst.Get("string"); value != "value" {
+		t.Errorf("string came back as %v", value)
+	}
+	if value, _ := dst.Get("number"); value != float64(42) {
+		t.Errorf("number came back as %#v", value)
+	}
+	if value, _ := dst.Get("object"); value.(map[string]any)["a"] != true {
+		t.Errorf("object came back as %v", value)
+	}
+	_, _, remaining := dst.GetOrSetWithTTL("string", nil, 0)
+	if remaining <= 50*time.Second || remaining > time.Minute {
+		t.Errorf("imported TTL is %v, want about a minute", remaining)
+	}
+}
+
+func TestImportNDJSONSkipsExpired(t *testing.T) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.Encode(ndjsonEntry{Key: "live", Value: 1, ExpiresAt: time.Now().Add(time.Minute)})
+	enc.Encode(ndjsonEntry{Key: "dead", Value: 2, ExpiresAt: time.Now().Add(-time.Minute)})
+
+	cache := NewMemoryCache()
+	n, err := cache.ImportNDJSON(&buf)
+	if err != nil || n != 1 {
+		t.Fatalf("ImportNDJSON got (%d, %v), want (1, nil)", n, err)
+	}
+	if _, ok := cache.Get("dead"); ok {
+		t.Error("expired entry was imported")
+	}
+}
+
+func TestImportNDJSONMalformed(t *testing.T) {
+	in := `{"key":"a","value":1,"expiresAt":"2999-01-01T00:00:00Z"}` + "\n{not json\n"
+	n, err := NewMemoryCache().ImportNDJSON(strings.NewReader(in))
+	if err == nil || n != 1 {
+		t.Fatalf("ImportNDJSON got (%d, %v), want 1 and an error", n, err)
+	}
+}
+
+func TestNDJSONKeepsEvictionOrder(t *testing.T) {
+	src := NewMemoryCache(WithMaxEntries(3))
+	for _, key := range []string{"a", "b", "c"} {
+		src.Set(key, key, time.Minute)
+	}
+	// Leave b as the least recently used, then c, then a.
+	src.Get("a")
+	src.Get("c")
+	src.Get("a")
+	lastRead, _ := src.LastAccess("a")
+
+	var buf bytes.Buffer
+	if err := src.ExportNDJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewMemoryCache(WithMaxEntries(3))
+	if _, err := dst.ImportNDJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if at, _ := dst.LastAccess("a"); !at.Equal(lastRead) {
+		t.Errorf("LastAccess(a) = %v after import, want %v", at, lastRead)
+	}
+
+	dst.Set("d", "d", time.Minute)
+	if _, ok := dst.Peek("b"); ok {
+		t.Fatal("least recently used key b survived an eviction after import")
+	}
+	dst.Set("e", "e", time.Minute)
+	if _, ok := dst.Peek("c"); ok {
+		t.Fatal("key c survived the second eviction after import")
+	}
+	if _, ok := dst.Peek("a"); !ok {
+		t.Fatal("most recently used key a was evicted")
+	}
+}
+
+func TestSaveIsConsistentUnderWrites(t *testing.T) {
+	const keys = 64
+	for _, cache := range []*MemoryCache{NewMemoryCache(), NewMemoryCache(WithShards(8))} {
+		for i := range keys {
+			cache.Set(fmt.Sprint(i), i, time.Hour)
+		}
+		// A single writer sets key v%keys to v for increasing v, so at
+		// any instant the cache holds keys consecutive values.
+		stop := make(chan struct{})
+		written := make(chan int)
+		go func() {
+			v := keys
+			for {
+				select {
+				case <-stop:
+					written <- v - 1
+					return
+				default:
+				}
+				cache.Set(fmt.Sprint(v%keys), v, time.Hour)
+				v++
+			}
+		}()
+
+		for range 20 {
+			var buf bytes.Buffer
+			if err := cache.Save(&buf); err != nil {
+				t.Fatal(err)
+			}
+			var values []int
+			dec := json.NewDecoder(&buf)
+			for dec.More() {
+				var line struct {
+					Key   string
+					Value int
+				}
+				if err := dec.Decode(&line); err != nil {
+					t.Fatal(err)
+				}
+				if fmt.Sprint(line.Value%keys) != line.Key {
+					t.Fatalf("snapshot has %q = %d", line.Key, line.Value)
+				}
+				values = append(values, line.Value)
+			}
+			slices.Sort(values)
+			if len(values) != keys || values[keys-1]-values[0] != keys-1 {
+				t.Fatalf("snapshot of %d values from %d to %d isn't from a single instant", len(values), values[0], values[len(values)-1])
+			}
+		}
+
+		close(stop)
+		last := <-written
+		for v := last - keys + 1; v <= last; v++ {
+			if got, _ := cache.Get(fmt.Sprint(v % keys)); got != v {
+				t.Fatalf("after saving, key %d = %v, want %d", v%keys, got, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package enigmacache
+
+import "strings"
+
+// WithKeyNormalizer makes the cache pass every key it's given through
+// normalize before using it, so keys which normalize alike name the
+// same entry. It covers every method taking a key, a list of keys, or
+// a prefix or pattern (see ExpirePrefix and ExpireMatch), so the cache
+// only ever holds normalized keys: Keys, Range, callbacks such as
+// OnEvicted, and the rest report keys in their normalized form, not as
+// they were passed in. normalize must be idempotent, since a key may
+// pass through it more than once. If it panics, the key is used as
+// given (see WithPanicRecovery).
+func WithKeyNormalizer(normalize func(key string) string) Option {
+	return func(o *options) {
+		o.normalizeKey = normalize
+	}
+}
+
+// WithCaseInsensitiveKeys makes keys case-insensitive when on is
+// true, by normalizing them to lower case (see WithKeyNormalizer), so
+// Get("Host") and Get("host") find the same entry, and Keys reports
+// it as "host".
+func WithCaseInsensitiveKeys(on bool) Option {
+	return func(o *options) {
+		if on {
+			o.normalizeKey = strings.ToLower
+		} else {
+			o.normalizeKey = nil
+		}
+	}
+}
+
+// normalize returns key as the cache stores it. If the normalizer
+// panics, the key is used as given.
+func (mc *MemoryCache) normalize(key string) string {
+	fn := mc.opts.normalizeKey
+	if fn == nil {
+		return key
+	}
+	normalized := key
+	mc.protect("key normalizer", func() {
+		normalized = fn(key)
+	})
+	return normalized
+}
@@ -0,0 +1,57 @@
+package enigmacache
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCaseInsensitiveKeys(t *testing.T) {
+	mc := NewMemoryCache(WithCaseInsensitiveKeys(true))
+	mc.Set("Host", "example.com", time.Minute)
+
+	for _, key := range []string{"Host", "host", "HOST"} {
+		if got, ok := mc.Get(key); !ok || got != "example.com" {
+			t.Errorf("Get(%q) = %v, %v; want example.com, true", key, got, ok)
+		}
+	}
+	mc.Set("HOST", "example.org", time.Minute)
+	if got, _ := mc.Get("host"); got != "example.org" {
+		t.Errorf("Get after Set(HOST) = %v; want example.org", got)
+	}
+	if got := mc.Keys(); !slices.Equal(got, []string{"host"}) {
+		t.Errorf("Keys() = %q; want [host]", got)
+	}
+	if _, ok := mc.Expire("hOsT"); !ok {
+		t.Error("Expire(hOsT) didn't find the key")
+	}
+	if mc.Has("host") {
+		t.Error("key still present after Expire")
+	}
+}
+
+func TestCaseInsensitiveExpirePrefix(t *testing.T) {
+	mc := NewMemoryCache(WithCaseInsensitiveKeys(true))
+	mc.Set("User:1", 1, time.Minute)
+	mc.Set("user:2", 2, time.Minute)
+	mc.Set("Session:1", 3, time.Minute)
+
+	if n := mc.ExpirePrefix("USER:"); n != 2 {
+		t.Errorf("ExpirePrefix(USER:) = %d; want 2", n)
+	}
+	if got := mc.Keys(); !slices.Equal(got, []string{"session:1"}) {
+		t.Errorf("Keys() = %q; want [session:1]", got)
+	}
+}
+
+func TestCaseSensitiveByDefault(t *testing.T) {
+	for _, mc := range []*MemoryCache{
+		NewMemoryCache(),
+		NewMemoryCache(WithCaseInsensitiveKeys(false)),
+	} {
+		mc.Set("Host", 1, time.Minute)
+		if _, ok := mc.Get("host"); ok {
+			t.Error("Get(host) found Host in a case-sensitive cache")
+		}
+	}
+}
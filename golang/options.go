@@ -0,0 +1,244 @@
+package enigmacache
+
+import (
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"reflect"
+	"time"
+)
+
+// An Option configures a MemoryCache at construction time.
+type Option func(*options)
+
+type options struct {
+	rejectNil        bool
+	staleGrace       time.Duration
+	staleRevalidate  time.Duration
+	negativeTTL      time.Duration
+	tombstoneTTL     time.Duration
+	defaultTTL       time.Duration
+	maxTTL           time.Duration
+	rejectOverMaxTTL bool
+	ttlShortening    TTLShorteningPolicy
+	shards           int
+	backend          Backend
+	journalPath      string
+	journalSync      SyncPolicy
+	journalEncode    func(value any) ([]byte, error)
+	journalDecode    func(data []byte) (any, error)
+	sealer           *Sealer
+	hasher           func(string) uint64
+	normalizeKey     func(string) string
+	randSource       rand.Source
+	ttlJitter        float64
+	maxBackground    int
+	clock            Clock
+	ttlFunc          func(key string, value any) time.Duration
+
+	writeThrough   Store
+	behindInterval time.Duration
+	behindBatch    int
+	bus            InvalidationBus
+
+	maxEntries        int
+	maxBytes          int64
+	cost              func(key string, value any) int64
+	lruSamples        int
+	protectedFraction float64
+	evictionPolicy    EvictionPolicy
+	admit             func(key string, cost int64) bool
+	admission         AdmissionPolicy
+
+	indexes map[string]func(any) (string, bool)
+
+	evictionLog     int
+	tapWriter       io.Writer
+	tapSerializer   Serializer
+	tapReasons      []EvictionReason
+	loaderLatencies bool
+	prefixSeparator string
+	maxPrefixes     int
+	lateness        time.Duration
+	lateBatch       int
+	lateInterval    time.Duration
+	cleanupInterval time.Duration
+	lifetimeBuckets []time.Duration
+
+	compressThreshold int
+	compressor        Compressor
+
+	refreshFloor time.Duration
+	refreshTTL   time.Duration
+
+	clone func(any) any
+
+	fillFraction float64
+	onFill       func(current, max int64)
+
+	evictionWorkers int
+	evictOnClose    bool
+	onEvicted       func(key string, value any, version uint64, reason EvictionReason)
+	logger          *slog.Logger
+	failFast        bool
+	callbackTimeout time.Duration
+}
+
+// WithRejectNil makes the cache refuse to store nil values when
+// reject is true. Both an untyped nil and a typed nil (a nil pointer,
+// map, slice, channel, func, or interface held in an any) count as
+// nil. A rejected Set is a no-op, leaving any existing value in
+// place, and a rejected GetOrSet on a missing key stores nothing and
+// reports loaded as false. The upshot is that a key reported present
+// always holds a real value.
+func WithRejectNil(reject bool) Option {
+	return func(o *options) {
+		o.rejectNil = reject
+	}
+}
+
+// WithDefaultTTL sets the TTL SetDefault stores entries with. It's
+// NoExpiration unless d is more than zero.
+func WithDefaultTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.defaultTTL = d
+	}
+}
+
+// WithStaleIfError keeps entries around for grace past their deadline
+// so that loader-based getters such as GetOrComputeDetailed can serve
+// the stale value if the loader fails. Entries in their grace period
+// are otherwise treated as missing: Get won't return them, and
+// GetOrSet will replace them.
+func WithStaleIfError(grace time.Duration) Option {
+	return func(o *options) {
+		o.staleGrace = grace
+	}
+}
+
+// WithStaleWhileRevalidate gives entries a soft and a hard TTL: the
+// TTL they're stored with, and window beyond it. Between the two,
+// loader-based getters such as GetOrCompute return the stale value
+// straight away, with info.Stale set, and call the loader in the
+// background to replace it, one load at a time for each key; a load
+// which fails is logged, and leaves the stale value in place. Past
+// the hard TTL, the entry is gone and the next call waits on the
+// loader as for any other miss. Entries in their window are otherwise
+// treated as missing, as with WithStaleIfError.
+func WithStaleWhileRevalidate(window time.Duration) Option {
+	return func(o *options) {
+		o.staleRevalidate = window
+	}
+}
+
+// WithShards spreads the cache's keys across n independently-locked
+// shards instead of keeping them in a single sync.Map. This suits
+// write-heavy workloads with many distinct keys, and caches snapshotted
+// by Save while busy, which a sharded cache doesn't hold writers up
+// for; see the README. An n of 1 or less leaves the cache unsharded.
+func WithShards(n int) Option {
+	return func(o *options) {
+		o.shards = n
+	}
+}
+
+// WithHasher sets the function used to hash keys wherever the cache
+// needs to, such as to pick a key's shard. The default is 64-bit
+// FNV-1a, which is unseeded and so deterministic across runs.
+func WithHasher(hash func(key string) uint64) Option {
+	return func(o *options) {
+		o.hasher = hash
+	}
+}
+
+// WithMaxEntries bounds the cache to n entries. Once it's full, each
+// new key evicts the least recently used one, or whichever one the
+// cache's EvictionPolicy picks. An n of zero or less
+// leaves the cache unbounded.
+func WithMaxEntries(n int) Option {
+	return func(o *options) {
+		o.maxEntries = n
+	}
+}
+
+// WithMaxMemory bounds the cache's estimated memory use to n bytes.
+// Once it's over budget, writes evict entries, as the cache's
+// EvictionPolicy chooses, until it's back under. This can be combined
+// with WithMaxEntries, in which case eviction continues until both
+// limits are satisfied. A single value whose own cost exceeds n is
+// never stored (Set leaves the key as it was, and SetContext and
+// Update return ErrValueTooLarge) and is counted in Stats().Rejected.
+// An n of zero or less leaves memory unbounded.
+//
+// Costs are estimates: []byte and string values count their length,
+// and other values the size of their type, not what they point to.
+// WithCost replaces the estimate for values it knows better.
+func WithMaxMemory(n int64) Option {
+	return func(o *options) {
+		o.maxBytes = n
+	}
+}
+
+// WithCost sets the function which gives the cost, in bytes, of
+// holding value for key, as counted against WithMaxMemory's budget
+// and reported in Stats().Bytes, in place of the cache's own
+// estimate. It's given the value as written, before any compression,
+// and should be cheap, since it's called on every write. A negative
+// cost counts as zero, and if the function panics the cache's estimate
+// is used instead.
+func WithCost(fn func(key string, value any) int64) Option {
+	return func(o *options) {
+		o.cost = fn
+	}
+}
+
+// WithAdmissionFilter sets a predicate consulted before a new key is
+// inserted into a full cache (see WithMaxEntries and WithMaxMemory),
+// with the key and its entry's estimated cost in bytes. If it returns
+// false the write is skipped, rather than evicting an existing entry
+// to make room, and counted in Stats().Rejected. Overwrites of
+// existing keys are always admitted.
+func WithAdmissionFilter(admit func(key string, cost int64) bool) Option {
+	return func(o *options) {
+		o.admit = admit
+	}
+}
+
+// WithAccessRefresh makes reads keep hot keys alive without resetting
+// their TTL on every access: a Get (or GetOrSet hit) which finds an
+// entry with less than floor of its TTL remaining resets its TTL to
+// ttl, as Refresh would, while a read of a fresher entry leaves it
+// alone. This churns timers far less than fully sliding expiration.
+func WithAccessRefresh(floor, ttl time.Duration) Option {
+	return func(o *options) {
+		o.refreshFloor = floor
+		o.refreshTTL = ttl
+	}
+}
+
+// WithGetClone makes reads return clone(value) instead of the stored
+// value itself, so a caller mutating what it got back (a map or a
+// slice, say) can't corrupt the copy other readers see. It applies to
+// everything which hands out a stored value: the Get and GetOrSet
+// families, SortedRange, loader hits, and shard transactions. clone
+// must return a deep enough copy for the caller's purposes; the
+// default is no cloning, which costs nothing. If clone panics, the
+// stored value is returned uncloned (see WithPanicRecovery).
+func WithGetClone(clone func(any) any) Option {
+	return func(o *options) {
+		o.clone = clone
+	}
+}
+
+// isNil reports whether value is nil, including typed nils.
+func isNil(value any) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Pointer, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
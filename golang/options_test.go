@@ -0,0 +1,110 @@
+package enigmacache
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestNilStoredByDefault(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.Set("key", nil, time.Minute)
+	if value, ok := cache.Get("key"); !ok || value != nil {
+		t.Fatalf("got (%v, %v), want (nil, true)", value, ok)
+	}
+}
+
+func TestWithRejectNil(t *testing.T) {
+	cache := NewMemoryCache(WithRejectNil(true))
+
+	cache.Set("key", nil, time.Minute)
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("nil value was stored")
+	}
+
+	var typed *int
+	cache.Set("typed", typed, time.Minute)
+	if _, ok := cache.Get("typed"); ok {
+		t.Fatal("typed nil value was stored")
+	}
+
+	if actual, loaded := cache.GetOrSet("key", nil, time.Minute); loaded || actual != nil {
+		t.Fatalf("GetOrSet got (%v, %v), want (nil, false)", actual, loaded)
+	}
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("GetOrSet stored a nil value")
+	}
+
+	cache.Set("key", "value", time.Minute)
+	cache.Set("key", nil, time.Minute)
+	if value, ok := cache.Get("key"); !ok || value != "value" {
+		t.Fatalf("got (%v, %v), want the existing value kept", value, ok)
+	}
+	if actual, loaded := cache.GetOrSet("key", nil, time.Minute); !loaded || actual != "value" {
+		t.Fatalf("GetOrSet got (%v, %v), want (value, true)", actual, loaded)
+	}
+}
+
+func TestWithAccessRefresh(t *testing.T) {
+	cache, clock := NewTestCache(WithAccessRefresh(50*time.Millisecond, time.Minute))
+	cache.Set("key", "value", 100*time.Millisecond)
+
+	// Well above the floor, a read leaves the TTL alone.
+	_, version, _ := cache.GetWithVersion("key")
+	if _, after, _ := cache.GetWithVersion("key"); after != version {
+		t.Fatal("a read above the floor refreshed the key")
+	}
+
+	// Below the floor, a read resets the TTL.
+	clock.Advance(60 * time.Millisecond)
+	cache.Get("key")
+	_, _, remaining := cache.GetOrSetWithTTL("key", nil, 0)
+	if remaining != time.Minute {
+		t.Fatalf("remaining = %v after a read below the floor, want a minute", remaining)
+	}
+	clock.Advance(60 * time.Millisecond)
+	if _, ok := cache.Get("key"); !ok {
+		t.Fatal("key expired on its original TTL")
+	}
+}
+
+func TestGetClone(t *testing.T) {
+	cloneMap := func(v any) any { return maps.Clone(v.(map[string]int)) }
+	for _, tc := range []struct {
+		name    string
+		opts    []Option
+		corrupt bool
+	}{
+		{"off", nil, true},
+		{"on", []Option{WithGetClone(cloneMap)}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := NewMemoryCache(tc.opts...)
+			cache.Set("counts", map[string]int{"a": 1}, time.Minute)
+
+			got, _ := cache.Get("counts")
+			got.(map[string]int)["a"] = 99
+			got, _ = cache.GetOrSet("counts", nil, time.Minute)
+			got.(map[string]int)["b"] = 2
+
+			stored, _ := cache.storage.Load("counts")
+			if corrupt := !maps.Equal(stored.get().(map[string]int), map[string]int{"a": 1}); corrupt != tc.corrupt {
+				t.Fatalf("cached map is %v; corrupted = %v, want %v", stored.get(), corrupt, tc.corrupt)
+			}
+		})
+	}
+}
+
+func TestDefaultTTL(t *testing.T) {
+	cache, _ := NewTestCache(WithDefaultTTL(time.Minute))
+	cache.SetDefault("k", 1)
+	if remaining, ok := cache.TTL("k"); !ok || remaining != time.Minute {
+		t.Errorf("TTL = %v, %v; want 1m, true", remaining, ok)
+	}
+
+	cache, _ = NewTestCache()
+	cache.SetDefault("k", 1)
+	if remaining, _ := cache.TTL("k"); remaining != NoExpiration {
+		t.Errorf("TTL = %v without a default TTL, want NoExpiration", remaining)
+	}
+}
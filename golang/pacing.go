@@ -0,0 +1,88 @@
+package enigmacache
+
+import (
+	"sync"
+	"time"
+)
+
+// WithLateExpiryPacing protects against a thundering herd of removals
+// when timers fire late all at once, as they do when a laptop wakes
+// from sleep or a paused container resumes. An entry whose expiration
+// fires more than lateness after it was due is put on a backlog
+// rather than removed straight away, and the backlog is worked off
+// batch entries per interval, so their removals and OnEvicted
+// callbacks are spread out instead of landing in one burst. Entries
+// on the backlog are already past their deadline, so reads treat them
+// as missing meanwhile. Pacing is off by default.
+func WithLateExpiryPacing(lateness time.Duration, batch int, interval time.Duration) Option {
+	return func(o *options) {
+		o.lateness = lateness
+		o.lateBatch = batch
+		o.lateInterval = interval
+	}
+}
+
+// late reports whether an expiration firing overdue past its entry's
+// removal time should go on the backlog.
+func (mc *MemoryCache) late(overdue time.Duration) bool {
+	o := &mc.opts
+	return o.lateness > 0 && o.lateBatch > 0 && o.lateInterval > 0 && overdue > o.lateness
+}
+
+// An expiryBacklog holds overdue entries waiting to be removed. A
+// single goroutine works it off while it's non-empty. The zero
+// expiryBacklog is ready for use.
+type expiryBacklog struct {
+	mu      sync.Mutex
+	pending []pendingExpiry
+	running bool
+}
+
+type pendingExpiry struct {
+	key string
+	e   *entry
+}
+
+// push adds the entry e for key to the backlog, starting a goroutine
+// to work it off if there isn't one.
+func (b *expiryBacklog) push(mc *MemoryCache, key string, e *entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(b.pending, pendingExpiry{key, e})
+	if !b.running {
+		b.running = true
+		mc.tasks.start()
+		go b.work(mc)
+	}
+}
+
+// work removes a batch of entries per interval, by the cache's clock,
+// until the backlog is empty.
+func (b *expiryBacklog) work(mc *MemoryCache) {
+	defer mc.tasks.done()
+	for {
+		b.mu.Lock()
+		n := min(len(b.pending), mc.opts.lateBatch)
+		batch := b.pending[:n:n]
+		b.pending = b.pending[n:]
+		if n == 0 {
+			b.running = false
+			b.pending = nil
+		}
+		b.mu.Unlock()
+		if n == 0 {
+			return
+		}
+
+		tick := make(chan struct{})
+		mc.opts.clock.AfterFunc(mc.opts.lateInterval, func() { close(tick) })
+		for _, p := range batch {
+			// The entry may have been overwritten or removed while it
+			// waited, in which case there's nothing left to do.
+			if mc.storage.CompareAndDelete(p.key, p.e) {
+				mc.removed(p.key, p.e, ReasonExpired)
+			}
+		}
+		<-tick
+	}
+}
@@ -0,0 +1,52 @@
+package enigmacache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLateExpiryPacing(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		fired []time.Time
+	)
+	cache := NewMemoryCache(
+		WithLateExpiryPacing(time.Second, 10, 10*time.Millisecond),
+		WithOnEvicted(func(string, any, EvictionReason) {
+			mu.Lock()
+			defer mu.Unlock()
+			fired = append(fired, time.Now())
+		}),
+	)
+	// Deadlines a minute in the past are what a cache sees after
+	// waking from a long sleep: every timer fires at once, overdue.
+	for i := range 100 {
+		cache.Set(fmt.Sprint(i), i, -time.Minute)
+	}
+	if _, ok := cache.Get("0"); ok {
+		t.Fatal("overdue entry readable while on the backlog")
+	}
+
+	// Let the timers fire and fill the backlog before draining it.
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cache.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(fired) != 100 {
+		t.Fatalf("%d callbacks fired, want 100", len(fired))
+	}
+	// Ten batches, ten milliseconds apart.
+	if spread := fired[len(fired)-1].Sub(fired[0]); spread < 80*time.Millisecond {
+		t.Fatalf("callbacks spread over %v, want at least 80ms", spread)
+	}
+	if n := cache.Len(); n != 0 {
+		t.Fatalf("Len = %d after the backlog drained, want 0", n)
+	}
+}
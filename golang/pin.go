@@ -0,0 +1,73 @@
+package enigmacache
+
+import "time"
+
+// Pin shields key from capacity eviction (see WithMaxEntries and
+// WithMaxMemory), reporting whether the key was present to pin. A
+// pinned entry still counts towards the cache's limits, and still
+// expires and can be removed as usual; once it's gone, the pin goes
+// with it. Overwriting a pinned key keeps it pinned. When every entry
+// in a full cache is pinned, there's nothing to evict, so writes of
+// new keys are turned away as if by the admission filter: Set ignores
+// them, and Update returns ErrRejected. Pinning has no effect on an
+// unbounded cache.
+func (mc *MemoryCache) Pin(key string) bool {
+	key = mc.normalize(key)
+	if _, ok := mc.load(key); !ok {
+		return false
+	}
+	if mc.policy == nil {
+		return true
+	}
+	mc.policyMu.Lock()
+	defer mc.policyMu.Unlock()
+	// The key may have been removed since we looked, and its pin
+	// mustn't outlive it.
+	if _, ok := mc.storage.Load(key); !ok {
+		return false
+	}
+	mc.pinned[key] = struct{}{}
+	mc.policy.remove(key)
+	return true
+}
+
+// Unpin makes key evictable again, reporting whether it was pinned.
+func (mc *MemoryCache) Unpin(key string) bool {
+	key = mc.normalize(key)
+	if mc.policy == nil {
+		return false
+	}
+	mc.policyMu.Lock()
+	defer mc.policyMu.Unlock()
+	if _, ok := mc.pinned[key]; !ok {
+		return false
+	}
+	delete(mc.pinned, key)
+	if _, ok := mc.storage.Load(key); ok {
+		mc.policy.add(key)
+	}
+	return true
+}
+
+// SetPinned sets key like Set and pins it (see Pin), without the
+// window in which a separate Pin call could lose the key to eviction.
+func (mc *MemoryCache) SetPinned(key string, value any, ttl time.Duration) {
+	key = mc.normalize(key)
+	if mc.policy == nil {
+		mc.Set(key, value, ttl)
+		return
+	}
+	mc.policyMu.Lock()
+	mc.pinned[key] = struct{}{}
+	mc.policyMu.Unlock()
+	mc.Set(key, value, ttl)
+
+	mc.policyMu.Lock()
+	defer mc.policyMu.Unlock()
+	if _, ok := mc.storage.Load(key); !ok {
+		delete(mc.pinned, key)
+		return
+	}
+	// Overwriting a key already in the policy leaves it there.
+	mc.policy.remove(key)
+}
@@ -0,0 +1,131 @@
+package enigmacache
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPinnedEntriesSurviveEviction(t *testing.T) {
+	cache := NewMemoryCache(WithMaxEntries(3))
+	cache.SetPinned("config", 1, time.Minute)
+	cache.Set("bootstrap", 2, time.Minute)
+	if !cache.Pin("bootstrap") {
+		t.Fatal("Pin of a present key returned false")
+	}
+	if cache.Pin("missing") {
+		t.Fatal("Pin of a missing key returned true")
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		cache.Set(key, key, time.Minute)
+	}
+
+	for _, key := range []string{"config", "bootstrap", "d"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("%q was evicted", key)
+		}
+	}
+	if n := cache.Len(); n != 3 {
+		t.Fatalf("Len = %d, want 3", n)
+	}
+
+	// Once unpinned, a key is evictable again.
+	if !cache.Unpin("bootstrap") || cache.Unpin("bootstrap") {
+		t.Fatal("Unpin didn't report the pin exactly once")
+	}
+	cache.Get("d")
+	cache.Set("e", "e", time.Minute)
+	if _, ok := cache.Get("bootstrap"); ok {
+		t.Fatal("unpinned key survived eviction")
+	}
+
+	// Pinned entries still go when they're expired.
+	cache.Expire("config")
+	cache.Set("config", 3, time.Minute)
+	cache.Set("f", "f", time.Minute)
+	cache.Set("g", "g", time.Minute)
+	cache.Set("h", "h", time.Minute)
+	if _, ok := cache.Get("config"); ok {
+		t.Fatal("pin outlived the key it was set on")
+	}
+}
+
+func TestSetPinnedPinsAnExistingKey(t *testing.T) {
+	cache := NewMemoryCache(WithMaxEntries(2))
+	cache.Set("a", 1, time.Minute)
+	cache.SetPinned("a", 2, time.Minute)
+	cache.Set("b", 3, time.Minute)
+	cache.Set("c", 4, time.Minute)
+	if v, ok := cache.Get("a"); !ok || v != 2 {
+		t.Errorf("Get(a) = %v, %v after overflowing the cache; want 2, true", v, ok)
+	}
+	if cache.Has("b") {
+		t.Error("b survived, so a was the one evicted")
+	}
+}
+
+func TestFullyPinnedCacheTurnsAwayNewKeys(t *testing.T) {
+	cache := NewMemoryCache(WithMaxEntries(2))
+	cache.SetPinned("a", 1, time.Minute)
+	cache.SetPinned("b", 2, time.Minute)
+
+	cache.Set("c", 3, time.Minute)
+	if _, ok := cache.Get("c"); ok {
+		t.Fatal("Set stored a key past a fully pinned capacity")
+	}
+	if _, err := cache.Update("c", func(any, bool) (any, bool) { return 3, true }, time.Minute); err == nil {
+		t.Fatal("Update stored a key past a fully pinned capacity")
+	}
+	if cache.Len() != 2 || cache.Stats().Rejected != 2 {
+		t.Fatalf("Len = %d, Rejected = %d, want 2 and 2", cache.Len(), cache.Stats().Rejected)
+	}
+
+	// Overwriting a pinned key is fine.
+	cache.Set("a", 4, time.Minute)
+	if v, _ := cache.Get("a"); v != 4 {
+		t.Fatalf("Get(a) = %v after overwrite, want 4", v)
+	}
+}
+
+func TestTrySet(t *testing.T) {
+	cache := NewMemoryCache(WithMaxEntries(2))
+	cache.SetPinned("a", "a", time.Minute)
+	cache.Set("b", "b", time.Minute)
+	if !cache.TrySet("c", "c", time.Minute) {
+		t.Fatal("TrySet failed with an evictable entry to make room")
+	}
+	if cache.Has("b") || !cache.Has("c") {
+		t.Fatal("TrySet didn't evict b to make room for c")
+	}
+
+	cache.Pin("c")
+	if cache.TrySet("d", "d", time.Minute) {
+		t.Fatal("TrySet succeeded on a cache full of pinned entries")
+	}
+	if cache.Has("d") || cache.Len() != 2 {
+		t.Fatalf("failed TrySet changed the cache, Len = %d", cache.Len())
+	}
+	if !cache.TrySet("c", "c2", time.Minute) {
+		t.Fatal("TrySet couldn't overwrite a key in a full cache")
+	}
+}
+
+func TestTrySetByteBudget(t *testing.T) {
+	value := strings.Repeat("x", 400)
+	cache := NewMemoryCache(WithMaxMemory(1500))
+	cache.SetPinned("a", value, time.Minute)
+	cache.SetPinned("b", value, time.Minute)
+	cache.Set("c", value, time.Minute)
+
+	// Evicting c would leave too little room beside the pinned
+	// entries for a value this big.
+	if cache.TrySet("d", value+value, time.Minute) {
+		t.Fatal("TrySet succeeded with too little unpinned space")
+	}
+	if !cache.Has("c") {
+		t.Fatal("failed TrySet evicted c")
+	}
+	if !cache.TrySet("e", value, time.Minute) || cache.Has("c") {
+		t.Fatal("TrySet didn't evict c to make room for e")
+	}
+}
@@ -0,0 +1,103 @@
+package enigmacache
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// PrefixOther is the PrefixStats key counting operations on prefixes
+// beyond the limit set by WithMaxPrefixes.
+const PrefixOther = "(other)"
+
+// defaultMaxPrefixes is how many prefixes WithPrefixMetrics tracks
+// unless WithMaxPrefixes says otherwise.
+const defaultMaxPrefixes = 100
+
+// WithPrefixMetrics makes the cache count hits, misses and sets per
+// key prefix, readable with PrefixStats, for billing or rate limiting
+// tenants who each own a prefix. A key's prefix is the part before
+// the first separator; a key without one is its own prefix. Only the
+// first prefixes seen, up to WithMaxPrefixes (100 by default), get
+// their own counters; the rest share PrefixOther's.
+func WithPrefixMetrics(separator string) Option {
+	return func(o *options) {
+		o.prefixSeparator = separator
+	}
+}
+
+// WithMaxPrefixes sets how many prefixes WithPrefixMetrics tracks
+// individually.
+func WithMaxPrefixes(n int) Option {
+	return func(o *options) {
+		o.maxPrefixes = n
+	}
+}
+
+type prefixCounters struct {
+	hits, misses, sets atomic.Uint64
+}
+
+type prefixMetrics struct {
+	separator string
+	max       int
+	other     prefixCounters
+	mu        sync.RWMutex
+	prefixes  map[string]*prefixCounters
+}
+
+func newPrefixMetrics(separator string, max int) *prefixMetrics {
+	if max <= 0 {
+		max = defaultMaxPrefixes
+	}
+	return &prefixMetrics{
+		separator: separator,
+		max:       max,
+		prefixes:  make(map[string]*prefixCounters),
+	}
+}
+
+// counters returns the counters for key's prefix.
+func (m *prefixMetrics) counters(key string) *prefixCounters {
+	prefix, _, _ := strings.Cut(key, m.separator)
+	m.mu.RLock()
+	c, ok := m.prefixes[prefix]
+	m.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.prefixes[prefix]; ok {
+		return c
+	}
+	if len(m.prefixes) >= m.max {
+		return &m.other
+	}
+	c = &prefixCounters{}
+	m.prefixes[prefix] = c
+	return c
+}
+
+// PrefixStats returns the per-prefix counters kept for a cache built
+// WithPrefixMetrics, keyed by prefix, with PrefixOther for the
+// overflow. Only Hits, Misses and Sets are filled in. It returns nil
+// for a cache without prefix metrics.
+func (mc *MemoryCache) PrefixStats() map[string]CacheStats {
+	m := mc.prefixes
+	if m == nil {
+		return nil
+	}
+	snapshot := func(c *prefixCounters) CacheStats {
+		return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Sets: c.sets.Load()}
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stats := make(map[string]CacheStats, len(m.prefixes)+1)
+	for prefix, c := range m.prefixes {
+		stats[prefix] = snapshot(c)
+	}
+	stats[PrefixOther] = snapshot(&m.other)
+	return stats
+}
@@ -0,0 +1,36 @@
+package enigmacache
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestPrefixStats(t *testing.T) {
+	if NewMemoryCache().PrefixStats() != nil {
+		t.Fatal("prefix stats kept without WithPrefixMetrics")
+	}
+
+	cache := NewMemoryCache(WithPrefixMetrics(":"), WithMaxPrefixes(2))
+	cache.Set("acme:a", 1, time.Minute)
+	cache.Set("acme:b", 2, time.Minute)
+	cache.Get("acme:a")
+	cache.Get("acme:missing")
+	cache.Set("globex:a", 3, time.Minute)
+	cache.Get("globex:a")
+	// Past the limit of two prefixes, the rest share a bucket.
+	cache.Set("initech:a", 4, time.Minute)
+	cache.Get("umbrella:a")
+
+	want := map[string]CacheStats{
+		"acme":      {Hits: 1, Misses: 1, Sets: 2},
+		"globex":    {Hits: 1, Sets: 1},
+		PrefixOther: {Misses: 1, Sets: 1},
+	}
+	if got := cache.PrefixStats(); !maps.Equal(got, want) {
+		t.Fatalf("PrefixStats = %v, want %v", got, want)
+	}
+	if stats := cache.Stats(); stats.Hits != 2 || stats.Misses != 2 || stats.Sets != 4 {
+		t.Fatalf("Stats = %+v, want 2 hits, 2 misses, 4 sets", stats)
+	}
+}
@@ -0,0 +1,78 @@
+// Package promexporter reports enigma-cache statistics to Prometheus,
+// as a prometheus.Collector, so that a cache's hits, misses,
+// evictions, entry count and memory use show up in /metrics:
+//
+//	prometheus.MustRegister(promexporter.New(cache, "app", prometheus.Labels{"cache": "sessions"}))
+//
+// Each cache gets a Collector of its own. Several caches can share a
+// registry, and so a namespace, as long as their labels tell them
+// apart.
+package promexporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	enigmacache "github.com/plathrop/enigma-cache/golang"
+)
+
+// A Collector collects the statistics of a cache, reading them from
+// its Stats each time it's scraped.
+type Collector struct {
+	cache *enigmacache.MemoryCache
+
+	hits, misses, sets, rejected *prometheus.Desc
+	evictions                    *prometheus.Desc
+	entries, bytes               *prometheus.Desc
+}
+
+// New returns a Collector for cache whose metric names start with
+// namespace and an underscore, or have no prefix if namespace is
+// empty, and whose metrics all carry labels.
+func New(cache *enigmacache.MemoryCache, namespace string, labels prometheus.Labels) *Collector {
+	desc := func(name, help string, variable ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, variable, labels)
+	}
+	return &Collector{
+		cache:     cache,
+		hits:      desc("hits_total", "Reads which found their key."),
+		misses:    desc("misses_total", "Reads which didn't find their key."),
+		sets:      desc("sets_total", "Writes stored."),
+		rejected:  desc("rejected_total", "Writes turned away."),
+		evictions: desc("evictions_total", "Entries which left the cache, by reason.", "reason"),
+		entries:   desc("entries", "Entries in the cache."),
+		bytes:     desc("bytes", "Estimated memory used by the cache's entries."),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.hits, c.misses, c.sets, c.rejected, c.evictions, c.entries, c.bytes} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.cache.Stats()
+	counter := func(d *prometheus.Desc, n uint64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(n), labels...)
+	}
+	counter(c.hits, s.Hits)
+	counter(c.misses, s.Misses)
+	counter(c.sets, s.Sets)
+	counter(c.rejected, s.Rejected)
+	for _, left := range []struct {
+		reason enigmacache.EvictionReason
+		n      uint64
+	}{
+		{enigmacache.ReasonExpired, s.Expired},
+		{enigmacache.ReasonManual, s.Removed},
+		{enigmacache.ReasonCapacity, s.Evicted},
+		{enigmacache.ReasonCleared, s.Cleared},
+		{enigmacache.ReasonDemoted, s.Demoted},
+	} {
+		counter(c.evictions, left.n, left.reason.String())
+	}
+	ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(s.Entries))
+	ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(s.Bytes))
+}
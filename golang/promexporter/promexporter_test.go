@@ -0,0 +1,58 @@
+package promexporter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	enigmacache "github.com/plathrop/enigma-cache/golang"
+)
+
+func TestCollector(t *testing.T) {
+	sessions := enigmacache.NewMemoryCache()
+	sessions.Set("a", 1, time.Minute)
+	sessions.Get("a")
+	sessions.Get("b")
+	sessions.Expire("a")
+	pages := enigmacache.NewMemoryCache()
+	pages.Set("p", "page", time.Minute)
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(
+		New(sessions, "app", prometheus.Labels{"cache": "sessions"}),
+		New(pages, "app", prometheus.Labels{"cache": "pages"}),
+	)
+
+	want := `
+# HELP app_hits_total Reads which found their key.
+# TYPE app_hits_total counter
+app_hits_total{cache="pages"} 0
+app_hits_total{cache="sessions"} 1
+# HELP app_misses_total Reads which didn't find their key.
+# TYPE app_misses_total counter
+app_misses_total{cache="pages"} 0
+app_misses_total{cache="sessions"} 1
+# HELP app_evictions_total Entries which left the cache, by reason.
+# TYPE app_evictions_total counter
+app_evictions_total{cache="pages",reason="capacity"} 0
+app_evictions_total{cache="pages",reason="cleared"} 0
+app_evictions_total{cache="pages",reason="demoted"} 0
+app_evictions_total{cache="pages",reason="expired"} 0
+app_evictions_total{cache="pages",reason="manual"} 0
+app_evictions_total{cache="sessions",reason="capacity"} 0
+app_evictions_total{cache="sessions",reason="cleared"} 0
+app_evictions_total{cache="sessions",reason="demoted"} 0
+app_evictions_total{cache="sessions",reason="expired"} 0
+app_evictions_total{cache="sessions",reason="manual"} 1
+# HELP app_entries Entries in the cache.
+# TYPE app_entries gauge
+app_entries{cache="pages"} 1
+app_entries{cache="sessions"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "app_hits_total", "app_misses_total", "app_evictions_total", "app_entries"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,26 @@
+package enigmacache
+
+import "math/rand/v2"
+
+// WithRandSource sets the source of randomness for the cache's
+// randomized decisions, such as which keys WithApproxLRU samples and
+// how far WithTTLJitter moves deadlines, so tests can seed it and
+// count on exactly the same choices each run. The cache only draws
+// from src with a lock held, so it needn't be safe for concurrent
+// use. By default each cache has its own randomly seeded source,
+// rather than sharing the global one.
+func WithRandSource(src rand.Source) Option {
+	return func(o *options) {
+		o.randSource = src
+	}
+}
+
+// rand returns a generator drawing from the cache's source of
+// randomness (see WithRandSource).
+func (mc *MemoryCache) rand() *rand.Rand {
+	src := mc.opts.randSource
+	if src == nil {
+		src = rand.NewPCG(rand.Uint64(), rand.Uint64())
+	}
+	return rand.New(src)
+}
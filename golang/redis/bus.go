@@ -0,0 +1,192 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	enigmacache "github.com/plathrop/enigma-cache/golang"
+)
+
+// The delays before resubscribing after a subscription's connection is
+// lost: the first, doubling with each failure up to the last.
+const (
+	minResubscribeDelay = 100 * time.Millisecond
+	maxResubscribeDelay = 10 * time.Second
+)
+
+// A Bus is an enigmacache.InvalidationBus over Redis pub/sub, for
+// keeping the MemoryCaches of a service's replicas in step (see
+// enigmacache.WithInvalidationBus). Invalidations are published on a
+// channel as JSON, through a pool of connections as for a Cache, and
+// each subscriber listens on a connection of its own.
+//
+// Redis doesn't keep messages for subscribers which aren't listening,
+// so a subscriber whose connection is lost misses whatever is
+// published until it has resubscribed. Having resubscribed, it's
+// handed an invalidation of every key, with no origin, so the cache
+// drops whatever it might have missed the removal of.
+type Bus struct {
+	c       *Cache
+	channel string
+}
+
+var _ enigmacache.InvalidationBus = (*Bus)(nil)
+
+// NewBus returns a Bus on channel of the Redis server at addr, a host
+// and port. The options are those for a Cache; WithKeyPrefix and
+// WithCodec don't apply. Connections are made as they're needed.
+func NewBus(addr, channel string, opts ...Option) *Bus {
+	return &Bus{c: New(addr, opts...), channel: channel}
+}
+
+// Close closes the connections the bus publishes through. Its
+// subscriptions carry on until they're unsubscribed.
+func (b *Bus) Close() error {
+	return b.c.Close()
+}
+
+// Publish publishes inv on the bus's channel.
+func (b *Bus) Publish(inv enigmacache.Invalidation) error {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	_, err = b.c.Do("PUBLISH", b.channel, string(data))
+	return err
+}
+
+// Subscribe subscribes to the bus's channel on a connection of its
+// own, calling handle with each invalidation published on it until
+// the returned func is called, which mustn't be from handle. Messages
+// which aren't invalidations are logged and skipped.
+func (b *Bus) Subscribe(handle func(enigmacache.Invalidation)) (unsubscribe func(), err error) {
+	s := &subscription{b: b, handle: handle, stop: make(chan struct{}), done: make(chan struct{})}
+	cn, err := b.subscribe()
+	if err != nil {
+		return nil, err
+	}
+	s.cn = cn
+	go s.listen()
+	return s.unsubscribe, nil
+}
+
+// subscribe dials a connection and subscribes it to the channel.
+func (b *Bus) subscribe() (*conn, error) {
+	cn, err := b.c.pool.dial()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cn.do(b.c.opts.timeout, "SUBSCRIBE", b.channel); err != nil {
+		cn.nc.Close()
+		return nil, err
+	}
+	// Messages come whenever they're published.
+	cn.nc.SetDeadline(time.Time{})
+	return cn, nil
+}
+
+// A subscription is a connection subscribed to a Bus's channel, and
+// the goroutine reading its messages.
+type subscription struct {
+	b      *Bus
+	handle func(enigmacache.Invalidation)
+	// stop is closed to unsubscribe, and done by the goroutine as it
+	// exits.
+	stop, done chan struct{}
+
+	mu      sync.Mutex
+	cn      *conn
+	stopped bool
+}
+
+func (s *subscription) unsubscribe() {
+	s.mu.Lock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.stop)
+		s.cn.nc.Close()
+	}
+	s.mu.Unlock()
+	<-s.done
+}
+
+// listen reads messages until the subscription is stopped,
+// resubscribing if the connection is lost.
+func (s *subscription) listen() {
+	defer close(s.done)
+	logger := s.b.c.opts.logger
+	s.mu.Lock()
+	cn := s.cn
+	s.mu.Unlock()
+	for {
+		reply, err := cn.read()
+		if err != nil {
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+			logger.Warn("enigma-cache: redis subscription lost", "channel", s.b.channel, "err", err)
+			cn.nc.Close()
+			if cn = s.resubscribe(); cn == nil {
+				return
+			}
+			s.handle(enigmacache.Invalidation{All: true})
+			continue
+		}
+		inv, err := decodeMessage(reply)
+		if err != nil {
+			logger.Error("enigma-cache: bad message on the invalidation channel", "channel", s.b.channel, "err", err)
+			continue
+		}
+		s.handle(inv)
+	}
+}
+
+// resubscribe subscribes afresh, trying until it succeeds or the
+// subscription is stopped, in which case it returns nil.
+func (s *subscription) resubscribe() *conn {
+	delay := minResubscribeDelay
+	for {
+		select {
+		case <-s.stop:
+			return nil
+		case <-time.After(delay):
+		}
+		cn, err := s.b.subscribe()
+		if err != nil {
+			s.b.c.opts.logger.Warn("enigma-cache: redis resubscribe failed", "channel", s.b.channel, "err", err)
+			delay = min(2*delay, maxResubscribeDelay)
+			continue
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.stopped {
+			cn.nc.Close()
+			return nil
+		}
+		s.cn = cn
+		return cn
+	}
+}
+
+// decodeMessage returns the invalidation in a message pushed to a
+// subscriber: an array of "message", the channel and the payload.
+func decodeMessage(reply any) (enigmacache.Invalidation, error) {
+	var inv enigmacache.Invalidation
+	items, ok := reply.([]any)
+	if !ok || len(items) != 3 {
+		return inv, fmt.Errorf("unexpected reply %v", reply)
+	}
+	kind, _ := items[0].([]byte)
+	payload, ok := items[2].([]byte)
+	if string(kind) != "message" || !ok {
+		return inv, fmt.Errorf("unexpected reply %q", kind)
+	}
+	if err := json.Unmarshal(payload, &inv); err != nil {
+		return inv, err
+	}
+	return inv, nil
+}
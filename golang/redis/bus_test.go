@@ -0,0 +1,99 @@
+package redis
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	enigmacache "github.com/plathrop/enigma-cache/golang"
+)
+
+// eventually fails the test unless cond comes true within a few
+// seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBus(t *testing.T) {
+	s := newFakeServer(t)
+	bus := NewBus(s.ln.Addr().String(), "invalidations", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	t.Cleanup(func() { bus.Close() })
+	a := enigmacache.NewMemoryCache(enigmacache.WithInvalidationBus(bus))
+	b := enigmacache.NewMemoryCache(enigmacache.WithInvalidationBus(bus))
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	for _, c := range []*enigmacache.MemoryCache{a, b} {
+		c.Set("x", 1, time.Hour)
+		c.Set("y", 2, time.Hour)
+	}
+
+	a.Expire("x")
+	a.Drain(context.Background())
+	eventually(t, "the peer to drop x", func() bool { return !b.Has("x") })
+	if !a.Has("y") || !b.Has("y") {
+		t.Error("a key other than the one expired was dropped")
+	}
+
+	b.Set("z", 3, time.Hour)
+	a.ExpireAll()
+	eventually(t, "the peer to clear", func() bool { return b.Len() == 0 })
+
+	// A subscriber which loses its connection resubscribes, and clears
+	// its cache in case it missed anything meanwhile.
+	a.Set("w", 4, time.Hour)
+	b.Set("w", 4, time.Hour)
+	s.dropSubscribers()
+	eventually(t, "the caches to clear", func() bool { return a.Len() == 0 && b.Len() == 0 })
+	eventually(t, "the caches to resubscribe", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.subs["invalidations"]) == 2
+	})
+	b.Set("v", 5, time.Hour)
+	a.Expire("v")
+	eventually(t, "the peer to drop v", func() bool { return !b.Has("v") })
+}
+
+func TestBusUnsubscribe(t *testing.T) {
+	s := newFakeServer(t)
+	bus := NewBus(s.ln.Addr().String(), "invalidations")
+	t.Cleanup(func() { bus.Close() })
+	got := make(chan enigmacache.Invalidation, 1)
+	unsubscribe, err := bus.Subscribe(func(inv enigmacache.Invalidation) { got <- inv })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Publish(enigmacache.Invalidation{Origin: "o", Key: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	if inv := <-got; inv != (enigmacache.Invalidation{Origin: "o", Key: "k"}) {
+		t.Errorf("received %+v, want {o k false}", inv)
+	}
+	unsubscribe()
+	unsubscribe()
+	eventually(t, "the server to drop the subscriber", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.subs["invalidations"]) == 0
+	})
+}
+
+func TestBusSubscribeFails(t *testing.T) {
+	s := newFakeServer(t)
+	addr := s.ln.Addr().String()
+	s.ln.Close()
+	bus := NewBus(addr, "invalidations", WithDialTimeout(time.Second))
+	if _, err := bus.Subscribe(func(enigmacache.Invalidation) {}); err == nil {
+		t.Error("Subscribe succeeded with the server down")
+	}
+}
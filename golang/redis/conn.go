@@ -0,0 +1,180 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// An Error is an error reply from the server. The connection it came
+// over is still good.
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// errClosed is returned by commands run after Close.
+var errClosed = errors.New("redis: cache is closed")
+
+// A conn is a connection to the server speaking RESP2.
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+	w  *bufio.Writer
+}
+
+// do sends a command and reads its reply: a string for a simple
+// string, an int64, a []byte for a bulk string, nil for a null, an
+// []any for an array, or an Error returned as the error.
+func (c *conn) do(timeout time.Duration, args ...string) (any, error) {
+	if timeout > 0 {
+		c.nc.SetDeadline(time.Now().Add(timeout))
+	}
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+func (c *conn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, Error(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", body)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: malformed array length %q", body)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			// An error inside an array doesn't spoil the connection;
+			// it's kept as the item.
+			item, err := c.read()
+			var rerr Error
+			if errors.As(err, &rerr) {
+				item = rerr
+			} else if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
+
+// A pool holds up to size connections, idle ones kept for reuse.
+type pool struct {
+	dial  func() (*conn, error)
+	idle  chan *conn
+	slots chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newPool(size int, dial func() (*conn, error)) *pool {
+	return &pool{
+		dial:  dial,
+		idle:  make(chan *conn, size),
+		slots: make(chan struct{}, size),
+	}
+}
+
+// get returns an idle connection, or dials a new one, waiting for one
+// of the pool's slots if they're all in use.
+func (p *pool) get() (*conn, error) {
+	p.slots <- struct{}{}
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		<-p.slots
+		return nil, errClosed
+	}
+	select {
+	case c := <-p.idle:
+		return c, nil
+	default:
+	}
+	c, err := p.dial()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return c, nil
+}
+
+// put returns c to the pool after a command which failed with err, if
+// any. A connection which failed other than with an error reply may
+// be out of step with the server, so it's closed rather than reused.
+func (p *pool) put(c *conn, err error) {
+	defer func() { <-p.slots }()
+	var rerr Error
+	if err != nil && !errors.As(err, &rerr) {
+		c.nc.Close()
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		c.nc.Close()
+		return
+	}
+	p.idle <- c
+}
+
+// close closes the idle connections, and those in use as they're put
+// back.
+func (p *pool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	for {
+		select {
+		case c := <-p.idle:
+			c.nc.Close()
+		default:
+			return nil
+		}
+	}
+}
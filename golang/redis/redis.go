@@ -0,0 +1,458 @@
+// Package redis is an enigma-cache ReadWriter backed by Redis, for
+// sharing a cache between instances of a service. Code written
+// against enigmacache.ReadWriter can switch between a MemoryCache and
+// a redis Cache without changes. It also has a Bus, for keeping the
+// MemoryCaches of several instances in step through Redis pub/sub.
+//
+// It speaks the Redis protocol itself, over a pool of connections,
+// and needs Redis 6.2 or later for GETDEL.
+package redis
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	enigmacache "github.com/plathrop/enigma-cache/golang"
+)
+
+// A Codec turns values into bytes for storing in Redis, and back.
+type Codec interface {
+	Encode(value any) ([]byte, error)
+	Decode(data []byte) (any, error)
+}
+
+// JSONCodec is the default Codec. Values come back as encoding/json
+// decodes them into an any: numbers as float64, objects as
+// map[string]any and so on. Use a Codec of your own to get back the
+// types you stored.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(value any) ([]byte, error) { return json.Marshal(value) }
+
+func (jsonCodec) Decode(data []byte) (any, error) {
+	var value any
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+// A Cache is an enigmacache.ReadWriter storing its entries in Redis.
+// It's safe for concurrent use. The ReadWriter methods have nowhere to
+// return errors talking to Redis, so they're logged (see WithLogger),
+// and a read which fails reports a miss.
+type Cache struct {
+	opts options
+	pool *pool
+}
+
+var _ enigmacache.ReadWriter = (*Cache)(nil)
+
+type options struct {
+	poolSize    int
+	dialTimeout time.Duration
+	timeout     time.Duration
+	password    string
+	db          int
+	prefix      string
+	codec       Codec
+	sealer      *enigmacache.Sealer
+	logger      *slog.Logger
+}
+
+// An Option configures a Cache.
+type Option func(*options)
+
+// WithPoolSize sets the most connections the cache keeps open to
+// Redis at once. Commands wait for a connection when they're all in
+// use. The default is 10.
+func WithPoolSize(n int) Option {
+	return func(o *options) {
+		o.poolSize = n
+	}
+}
+
+// WithDialTimeout sets how long connecting to Redis may take. The
+// default is 5 seconds.
+func WithDialTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.dialTimeout = d
+	}
+}
+
+// WithTimeout sets how long a command may take, from being sent to
+// its reply being read. The default is 3 seconds; zero means no limit.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithPassword has each connection authenticate with password.
+func WithPassword(password string) Option {
+	return func(o *options) {
+		o.password = password
+	}
+}
+
+// WithDB has each connection select database db.
+func WithDB(db int) Option {
+	return func(o *options) {
+		o.db = db
+	}
+}
+
+// WithKeyPrefix prefixes every key the cache stores with prefix, so
+// several caches can share a database. Keys, Len and ExpireAll only
+// see the keys with the cache's prefix; without one, they cover the
+// whole database, and ExpireAll flushes it.
+func WithKeyPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithCodec sets how values are encoded for Redis. The default is
+// JSONCodec.
+func WithCodec(c Codec) Option {
+	return func(o *options) {
+		o.codec = c
+	}
+}
+
+// WithEncryption encrypts values, once the codec has encoded them,
+// with AES-GCM under key, as enigmacache.WithEncryption does, so what
+// Redis holds, and writes to disk, can't be read without the key. Keys
+// aren't encrypted. Values encrypted under the retired keys in old can
+// still be read, so keys can be rotated; a value which won't decrypt
+// is logged and reads as a miss. WithEncryption panics if a key is of
+// the wrong length for AES, or two keys share an ID.
+func WithEncryption(key []byte, old ...[]byte) Option {
+	s, err := enigmacache.NewSealer(key, old...)
+	if err != nil {
+		panic("enigma-cache: redis.WithEncryption: " + err.Error())
+	}
+	return func(o *options) {
+		o.sealer = s
+	}
+}
+
+// sealedCodec encrypts what codec encodes.
+type sealedCodec struct {
+	codec  Codec
+	sealer *enigmacache.Sealer
+}
+
+func (c sealedCodec) Encode(value any) ([]byte, error) {
+	data, err := c.codec.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return c.sealer.Seal(data), nil
+}
+
+func (c sealedCodec) Decode(data []byte) (any, error) {
+	data, err := c.sealer.Open(data)
+	if err != nil {
+		return nil, err
+	}
+	return c.codec.Decode(data)
+}
+
+// WithLogger sets where errors talking to Redis are logged. The
+// default is slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// New returns a Cache talking to the Redis server at addr, a host and
+// port. Connections are made as they're needed, so New doesn't fail
+// if the server is down.
+func New(addr string, opts ...Option) *Cache {
+	c := &Cache{opts: options{
+		poolSize:    10,
+		dialTimeout: 5 * time.Second,
+		timeout:     3 * time.Second,
+		codec:       JSONCodec,
+		logger:      slog.Default(),
+	}}
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+	if c.opts.sealer != nil {
+		c.opts.codec = sealedCodec{codec: c.opts.codec, sealer: c.opts.sealer}
+	}
+	c.pool = newPool(max(c.opts.poolSize, 1), func() (*conn, error) {
+		return c.dial(addr)
+	})
+	return c
+}
+
+func (c *Cache) dial(addr string) (*conn, error) {
+	nc, err := net.DialTimeout("tcp", addr, c.opts.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	var setup [][]string
+	if c.opts.password != "" {
+		setup = append(setup, []string{"AUTH", c.opts.password})
+	}
+	if c.opts.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.opts.db)})
+	}
+	for _, args := range setup {
+		if _, err := cn.do(c.opts.timeout, args...); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// Close closes the cache's connections. Commands run afterwards fail.
+func (c *Cache) Close() error {
+	return c.pool.close()
+}
+
+// Do runs a command on one of the cache's connections, for whatever
+// the ReadWriter methods don't cover, and returns its reply: a string,
+// int64, []byte, nil or []any. An error reply is returned as an Error.
+// Keys aren't given the cache's prefix.
+func (c *Cache) Do(args ...string) (any, error) {
+	cn, err := c.pool.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := cn.do(c.opts.timeout, args...)
+	c.pool.put(cn, err)
+	return reply, err
+}
+
+// do is Do, logging any error.
+func (c *Cache) do(args ...string) (any, error) {
+	reply, err := c.Do(args...)
+	if err != nil {
+		c.opts.logger.Error("enigma-cache: redis command failed", "command", args[0], "err", err)
+	}
+	return reply, err
+}
+
+func (c *Cache) key(key string) string {
+	return c.opts.prefix + key
+}
+
+// decode decodes a bulk reply, reporting false for a null or a value
+// which won't decode.
+func (c *Cache) decode(reply any) (any, bool) {
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, false
+	}
+	value, err := c.opts.codec.Decode(data)
+	if err != nil {
+		c.opts.logger.Error("enigma-cache: decoding a value from redis failed", "err", err)
+		return nil, false
+	}
+	return value, true
+}
+
+// expiry returns the SET arguments giving a key ttl to live, rounded
+// up to a whole millisecond, and false if ttl means the key is
+// already expired.
+func expiry(ttl time.Duration) ([]string, bool) {
+	switch {
+	case ttl == enigmacache.NoExpiration:
+		return nil, true
+	case ttl <= 0:
+		return nil, false
+	}
+	ms := (ttl + time.Millisecond - 1) / time.Millisecond
+	return []string{"PX", strconv.FormatInt(int64(ms), 10)}, true
+}
+
+// Get returns the value stored for key, if it's present.
+func (c *Cache) Get(key string) (value any, ok bool) {
+	reply, err := c.do("GET", c.key(key))
+	if err != nil {
+		return nil, false
+	}
+	return c.decode(reply)
+}
+
+// Has reports whether key is present.
+func (c *Cache) Has(key string) bool {
+	n, _ := c.do("EXISTS", c.key(key))
+	return n == int64(1)
+}
+
+// TTL returns how long key has left before it expires, or
+// enigmacache.NoExpiration if it never does.
+func (c *Cache) TTL(key string) (remaining time.Duration, ok bool) {
+	reply, err := c.do("PTTL", c.key(key))
+	ms, _ := reply.(int64)
+	switch {
+	case err != nil || ms == -2:
+		return 0, false
+	case ms == -1:
+		return enigmacache.NoExpiration, true
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// Keys returns the keys present, without the cache's prefix, in no
+// particular order. It scans the database, so it's slow in a big one.
+func (c *Cache) Keys() []string {
+	var keys []string
+	c.scan(func(key string) { keys = append(keys, key[len(c.opts.prefix):]) })
+	return keys
+}
+
+// Len returns the number of keys present. With a key prefix, it
+// scans the database, as Keys does.
+func (c *Cache) Len() int {
+	if c.opts.prefix == "" {
+		n, _ := c.do("DBSIZE")
+		size, _ := n.(int64)
+		return int(size)
+	}
+	count := 0
+	c.scan(func(string) { count++ })
+	return count
+}
+
+// scan calls f for each key with the cache's prefix.
+func (c *Cache) scan(f func(key string)) {
+	cursor := "0"
+	for {
+		reply, err := c.do("SCAN", cursor, "MATCH", escapeGlob(c.opts.prefix)+"*", "COUNT", "1000")
+		items, _ := reply.([]any)
+		if err != nil || len(items) != 2 {
+			return
+		}
+		next, _ := items[0].([]byte)
+		keys, _ := items[1].([]any)
+		for _, key := range keys {
+			if key, ok := key.([]byte); ok {
+				f(string(key))
+			}
+		}
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			return
+		}
+	}
+}
+
+// escapeGlob escapes the characters SCAN's MATCH pattern treats
+// specially.
+func escapeGlob(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b = append(b, '\\')
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
+
+// Set stores value for key, to be removed after ttl, or never, for a
+// ttl of enigmacache.NoExpiration. A ttl of zero or less removes the
+// key.
+func (c *Cache) Set(key string, value any, ttl time.Duration) {
+	px, ok := expiry(ttl)
+	if !ok {
+		c.do("DEL", c.key(key))
+		return
+	}
+	data, err := c.opts.codec.Encode(value)
+	if err != nil {
+		c.opts.logger.Error("enigma-cache: encoding a value for redis failed", "key", key, "err", err)
+		return
+	}
+	c.do(append([]string{"SET", c.key(key), string(data)}, px...)...)
+}
+
+// GetOrSet returns the value stored for key, if it's present, and
+// otherwise stores value with the given ttl and returns it. The loaded
+// result reports whether the value was present.
+func (c *Cache) GetOrSet(key string, value any, ttl time.Duration) (actual any, loaded bool) {
+	px, ok := expiry(ttl)
+	data, err := c.opts.codec.Encode(value)
+	if err != nil {
+		c.opts.logger.Error("enigma-cache: encoding a value for redis failed", "key", key, "err", err)
+		ok = false
+	}
+	for {
+		if ok {
+			reply, err := c.do(append([]string{"SET", c.key(key), string(data), "NX"}, px...)...)
+			if err != nil {
+				return value, false
+			}
+			if reply != nil {
+				return value, false
+			}
+		}
+		reply, err := c.do("GET", c.key(key))
+		if err != nil || (reply == nil && !ok) {
+			return value, false
+		}
+		if reply != nil {
+			if actual, decoded := c.decode(reply); decoded {
+				return actual, true
+			}
+			return value, false
+		}
+		// The key expired between the SET and the GET; go round again
+		// to store ours.
+	}
+}
+
+// Expire removes key, returning the value it held, if any.
+func (c *Cache) Expire(key string) (value any, loaded bool) {
+	reply, err := c.do("GETDEL", c.key(key))
+	if err != nil {
+		return nil, false
+	}
+	return c.decode(reply)
+}
+
+// Refresh sets the TTL of key, if it's present, reporting whether it
+// was. A ttl of zero or less removes it.
+func (c *Cache) Refresh(key string, ttl time.Duration) (refreshed bool) {
+	if ttl == enigmacache.NoExpiration {
+		// PERSIST reports 0 for a key without a TTL, so check it's
+		// there first.
+		if !c.Has(key) {
+			return false
+		}
+		c.do("PERSIST", c.key(key))
+		return true
+	}
+	ms := max((ttl+time.Millisecond-1)/time.Millisecond, 0)
+	reply, _ := c.do("PEXPIRE", c.key(key), strconv.FormatInt(int64(ms), 10))
+	return reply == int64(1)
+}
+
+// ExpireAll removes every key with the cache's prefix or, without
+// one, flushes the database.
+func (c *Cache) ExpireAll() {
+	if c.opts.prefix == "" {
+		c.do("FLUSHDB")
+		return
+	}
+	var keys []string
+	c.scan(func(key string) { keys = append(keys, key) })
+	for len(keys) > 0 {
+		n := min(len(keys), 1000)
+		c.do(append([]string{"DEL"}, keys[:n]...)...)
+		keys = keys[n:]
+	}
+}
@@ -0,0 +1,379 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	enigmacache "github.com/plathrop/enigma-cache/golang"
+)
+
+// fakeServer speaks enough of the Redis protocol for the cache. Its
+// keys expire only when told to, through expire.
+type fakeServer struct {
+	ln net.Listener
+
+	mu    sync.Mutex
+	data  map[string]string
+	ttls  map[string]time.Duration
+	conns int
+	// subs holds the connections subscribed to each channel.
+	subs map[string][]net.Conn
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{ln: ln, data: map[string]string{}, ttls: map[string]time.Duration{}, subs: map[string][]net.Conn{}}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+			go s.serve(nc)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) expire(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+	delete(s.ttls, key)
+}
+
+// dropSubscribers closes the subscribed connections.
+func (s *fakeServer) dropSubscribers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for channel, conns := range s.subs {
+		for _, nc := range conns {
+			nc.Close()
+		}
+		delete(s.subs, channel)
+	}
+}
+
+func (s *fakeServer) serve(nc net.Conn) {
+	defer func() {
+		nc.Close()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for channel, conns := range s.subs {
+			s.subs[channel] = slices.DeleteFunc(conns, func(c net.Conn) bool { return c == nc })
+		}
+	}()
+	r := bufio.NewReader(nc)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		// Replies are written with s.mu held, so they can't be
+		// interleaved with messages published to the connection.
+		s.mu.Lock()
+		var reply string
+		if strings.ToUpper(args[0]) == "SUBSCRIBE" {
+			s.subs[args[1]] = append(s.subs[args[1]], nc)
+			reply = fmt.Sprintf("*3\r\n%s%s%s", bulk("subscribe"), bulk(args[1]), integer(1))
+		} else {
+			reply = s.run(args)
+		}
+		_, err = io.WriteString(nc, reply)
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func bulk(s string) string { return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s) }
+
+func integer(n int) string { return fmt.Sprintf(":%d\r\n", n) }
+
+// run runs a command. s.mu is held.
+func (s *fakeServer) run(args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "GET", "GETDEL":
+		value, ok := s.data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		if args[0] == "GETDEL" {
+			delete(s.data, args[1])
+			delete(s.ttls, args[1])
+		}
+		return bulk(value)
+	case "SET":
+		key := args[1]
+		var ttl time.Duration
+		for i := 3; i < len(args); i++ {
+			switch args[i] {
+			case "NX":
+				if _, ok := s.data[key]; ok {
+					return "$-1\r\n"
+				}
+			case "PX":
+				ms, _ := strconv.Atoi(args[i+1])
+				ttl = time.Duration(ms) * time.Millisecond
+				i++
+			}
+		}
+		s.data[key] = args[2]
+		if ttl > 0 {
+			s.ttls[key] = ttl
+		} else {
+			delete(s.ttls, key)
+		}
+		return "+OK\r\n"
+	case "DEL":
+		n := 0
+		for _, key := range args[1:] {
+			if _, ok := s.data[key]; ok {
+				n++
+			}
+			delete(s.data, key)
+			delete(s.ttls, key)
+		}
+		return integer(n)
+	case "EXISTS":
+		_, ok := s.data[args[1]]
+		if ok {
+			return integer(1)
+		}
+		return integer(0)
+	case "PTTL":
+		if _, ok := s.data[args[1]]; !ok {
+			return integer(-2)
+		}
+		if ttl, ok := s.ttls[args[1]]; ok {
+			return integer(int(ttl / time.Millisecond))
+		}
+		return integer(-1)
+	case "PEXPIRE":
+		if _, ok := s.data[args[1]]; !ok {
+			return integer(0)
+		}
+		ms, _ := strconv.Atoi(args[2])
+		if ms <= 0 {
+			delete(s.data, args[1])
+			delete(s.ttls, args[1])
+		} else {
+			s.ttls[args[1]] = time.Duration(ms) * time.Millisecond
+		}
+		return integer(1)
+	case "PERSIST":
+		if _, ok := s.ttls[args[1]]; !ok {
+			return integer(0)
+		}
+		delete(s.ttls, args[1])
+		return integer(1)
+	case "SCAN":
+		prefix := strings.TrimSuffix(args[3], "*")
+		var keys []string
+		for key := range s.data {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, bulk(key))
+			}
+		}
+		return fmt.Sprintf("*2\r\n%s*%d\r\n%s", bulk("0"), len(keys), strings.Join(keys, ""))
+	case "DBSIZE":
+		return integer(len(s.data))
+	case "PUBLISH":
+		conns := s.subs[args[1]]
+		message := fmt.Sprintf("*3\r\n%s%s%s", bulk("message"), bulk(args[1]), bulk(args[2]))
+		for _, nc := range conns {
+			io.WriteString(nc, message)
+		}
+		return integer(len(conns))
+	case "FLUSHDB":
+		clear(s.data)
+		clear(s.ttls)
+		return "+OK\r\n"
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func newCache(t *testing.T, opts ...Option) (*Cache, *fakeServer) {
+	s := newFakeServer(t)
+	c := New(s.ln.Addr().String(), opts...)
+	t.Cleanup(func() { c.Close() })
+	return c, s
+}
+
+func TestReadWriter(t *testing.T) {
+	var cache enigmacache.ReadWriter
+	cache, s := newCache(t, WithKeyPrefix("app:"))
+	s.mu.Lock()
+	s.data["other"] = "1"
+	s.mu.Unlock()
+
+	cache.Set("a", "apple", time.Minute)
+	cache.Set("n", 42, enigmacache.NoExpiration)
+	if value, ok := cache.Get("a"); !ok || value != "apple" {
+		t.Errorf("Get(a) = %v, %v; want apple, true", value, ok)
+	}
+	if value, ok := cache.Get("n"); !ok || value != 42.0 {
+		t.Errorf("Get(n) = %v, %v; want 42, true", value, ok)
+	}
+	if _, ok := s.data["app:a"]; !ok {
+		t.Error("key wasn't prefixed in redis")
+	}
+	if remaining, ok := cache.TTL("a"); !ok || remaining != time.Minute {
+		t.Errorf("TTL(a) = %v, %v; want 1m, true", remaining, ok)
+	}
+	if remaining, ok := cache.TTL("n"); !ok || remaining != enigmacache.NoExpiration {
+		t.Errorf("TTL(n) = %v, %v; want NoExpiration, true", remaining, ok)
+	}
+	keys := cache.Keys()
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"a", "n"}) || cache.Len() != 2 {
+		t.Errorf("Keys = %v, Len = %d; want [a n], 2", keys, cache.Len())
+	}
+
+	if actual, loaded := cache.GetOrSet("a", "avocado", time.Minute); !loaded || actual != "apple" {
+		t.Errorf("GetOrSet(a) = %v, %v; want apple, true", actual, loaded)
+	}
+	if actual, loaded := cache.GetOrSet("b", "banana", time.Minute); loaded || actual != "banana" {
+		t.Errorf("GetOrSet(b) = %v, %v; want banana, false", actual, loaded)
+	}
+
+	if !cache.Refresh("a", time.Hour) || cache.Refresh("missing", time.Hour) {
+		t.Error("Refresh reported the wrong keys present")
+	}
+	if remaining, _ := cache.TTL("a"); remaining != time.Hour {
+		t.Errorf("TTL(a) = %v after Refresh, want 1h", remaining)
+	}
+	if !cache.Refresh("a", enigmacache.NoExpiration) {
+		t.Error("Refresh(a, NoExpiration) = false")
+	}
+	if remaining, _ := cache.TTL("a"); remaining != enigmacache.NoExpiration {
+		t.Errorf("TTL(a) = %v after persisting, want NoExpiration", remaining)
+	}
+
+	s.expire("app:b")
+	if cache.Has("b") {
+		t.Error("expired key is present")
+	}
+	if value, loaded := cache.Expire("a"); !loaded || value != "apple" {
+		t.Errorf("Expire(a) = %v, %v; want apple, true", value, loaded)
+	}
+	cache.Set("n", 0, 0)
+	if cache.Has("a") || cache.Has("n") {
+		t.Error("removed keys are present")
+	}
+
+	cache.Set("c", "cherry", time.Minute)
+	cache.ExpireAll()
+	if cache.Len() != 0 {
+		t.Errorf("Len = %d after ExpireAll, want 0", cache.Len())
+	}
+	if _, ok := s.data["other"]; !ok {
+		t.Error("ExpireAll removed a key without the cache's prefix")
+	}
+}
+
+func TestPoolReusesConnections(t *testing.T) {
+	cache, s := newCache(t, WithPoolSize(2))
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.Set(strconv.Itoa(i), i, time.Minute)
+		}()
+	}
+	wg.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns > 2 || len(s.data) != 50 {
+		t.Errorf("%d connections made for %d keys, want at most 2 for 50", s.conns, len(s.data))
+	}
+}
+
+func TestErrorReplies(t *testing.T) {
+	var logged strings.Builder
+	cache, _ := newCache(t, WithLogger(slog.New(slog.NewTextHandler(&logged, nil))))
+	if _, err := cache.Do("BOGUS"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("Do(BOGUS) returned %v, want an unknown command error", err)
+	}
+	// The connection survives an error reply.
+	cache.Set("k", "v", time.Minute)
+	if value, ok := cache.Get("k"); !ok || value != "v" {
+		t.Errorf("Get after an error reply = %v, %v; want v, true", value, ok)
+	}
+
+	cache.Close()
+	if _, ok := cache.Get("k"); ok {
+		t.Error("Get succeeded after Close")
+	}
+	if !strings.Contains(logged.String(), "closed") {
+		t.Errorf("log %q doesn't mention the cache being closed", logged.String())
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	oldKey, newKey := strings.Repeat("o", 32), strings.Repeat("n", 32)
+	cache, s := newCache(t, WithEncryption([]byte(oldKey)))
+	cache.Set("token", "hunter2", time.Minute)
+	s.mu.Lock()
+	stored := s.data["token"]
+	s.mu.Unlock()
+	if strings.Contains(stored, "hunter2") {
+		t.Errorf("Redis holds %q, the value in the clear", stored)
+	}
+
+	rotated := New(s.ln.Addr().String(), WithEncryption([]byte(newKey), []byte(oldKey)))
+	defer rotated.Close()
+	if value, ok := rotated.Get("token"); !ok || value != "hunter2" {
+		t.Errorf("Get under a rotated key = %v, %v; want hunter2, true", value, ok)
+	}
+
+	var logged strings.Builder
+	other := New(s.ln.Addr().String(), WithEncryption([]byte(newKey)), WithLogger(slog.New(slog.NewTextHandler(&logged, nil))))
+	defer other.Close()
+	if _, ok := other.Get("token"); ok {
+		t.Error("Get without the key that encrypted the value succeeded")
+	}
+	if !strings.Contains(logged.String(), "decrypt") {
+		t.Errorf("log %q doesn't mention the value failing to decrypt", logged.String())
+	}
+}
@@ -0,0 +1,92 @@
+package enigmacache
+
+import "time"
+
+// A WarmEntry is a value to store, with its TTL, for ReplaceAll.
+type WarmEntry struct {
+	Value any
+	TTL   time.Duration
+}
+
+// ReplaceAll swaps the cache's entire contents for entries in one
+// step, for blue/green rebuilds: a reader sees either the old
+// contents or the new, never a mixture of the two (except over a
+// Backend; see WithBackend). Old entries whose keys aren't among the
+// new ones then leave the cache as ExpireAll would have removed them,
+// with ReasonCleared; those whose keys are count as overwritten, as by
+// Set, so neither the OnEvicted callback nor Done hears of them.
+// Each entry is vetted as Set would vet it: its TTL may be ValueTTL,
+// and entries the cache's options would turn away (see WithRejectNil,
+// WithRejectOverMaxTTL and WithMaxMemory), or whose new key its
+// admission filter turns away, are skipped. A bounded cache given
+// more than fits evicts the excess afterwards. The write-through
+// store, if any, is left alone.
+func (mc *MemoryCache) ReplaceAll(entries map[string]WarmEntry) {
+	fresh := make(map[string]*entry, len(entries))
+	for key, w := range entries {
+		key = mc.normalize(key)
+		ttl, ok := mc.valueTTL(key, w.Value, w.TTL)
+		if !ok || mc.rejects(w.Value) {
+			continue
+		}
+		e := mc.newEntry(key, w.Value, ttl, 0)
+		if !mc.fits(e) {
+			continue
+		}
+		if current, ok := mc.storage.Load(key); ok {
+			mc.checkShortening(key, e, current)
+		} else if !mc.admit(key, e.cost) {
+			continue
+		}
+		fresh[key] = e
+	}
+
+	old := mc.storage.Replace(fresh)
+	for key, e := range old {
+		if _, ok := fresh[key]; !ok {
+			mc.removed(key, e, ReasonCleared)
+		}
+	}
+	for key, e := range fresh {
+		mc.stored(key, e, old[key])
+	}
+}
+
+// MapValues rewrites every live entry's value in place, for migrating
+// the cache to a new value format without losing TTLs. fn is passed
+// each key and its value; if it returns keep true, its new value
+// replaces the old one with the same deadlines, and otherwise the key
+// is deleted, as Expire would. A new value which the cache's options
+// turn away (see Set) deletes the key too. The write-through store,
+// if any, is left alone.
+//
+// Each entry is replaced by compare-and-swap, so readers and writers
+// carry on throughout: a reader sees each key's old value or its new
+// one. A key written while MapValues is working on it has fn applied
+// again to the new value, so fn may see a key more than once, while
+// keys added after MapValues has passed them aren't seen at all.
+func (mc *MemoryCache) MapValues(fn func(key string, old any) (new any, keep bool)) {
+	mc.storage.Range(func(key string, e *entry) bool {
+		for old, ok := e, true; ok; old, ok = mc.storage.Load(key) {
+			if !old.live(mc.now()) {
+				break
+			}
+			value, keep := fn(key, old.get())
+			if keep && !mc.rejects(value) {
+				replacement := mc.withValue(old, key, value)
+				if mc.fits(replacement) {
+					if mc.storage.CompareAndSwap(key, old, replacement) {
+						mc.stored(key, replacement, old)
+						break
+					}
+					continue
+				}
+			}
+			if mc.storage.CompareAndDelete(key, old) {
+				mc.removed(key, old, ReasonManual)
+				break
+			}
+		}
+		return true
+	})
+}
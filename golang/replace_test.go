@@ -0,0 +1,196 @@
+package enigmacache
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func generation(prefix string, n int) map[string]WarmEntry {
+	entries := make(map[string]WarmEntry, n)
+	for i := range n {
+		entries[fmt.Sprintf("%s%d", prefix, i)] = WarmEntry{Value: prefix, TTL: time.Minute}
+	}
+	return entries
+}
+
+func TestReplaceAllIsAtomic(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"syncmap": nil,
+		"sharded": {WithShards(8)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var evicted atomic.Int32
+			cache := NewMemoryCache(append(opts, WithOnEvicted(func(string, any, EvictionReason) {
+				evicted.Add(1)
+			}))...)
+			cache.ReplaceAll(generation("blue", 50))
+
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			for range 4 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						keys := cache.Keys()
+						if len(keys) != 50 {
+							t.Errorf("saw %d keys, want 50", len(keys))
+							return
+						}
+						color := strings.TrimRight(keys[0], "0123456789")
+						for _, key := range keys {
+							if !strings.HasPrefix(key, color) {
+								t.Errorf("saw %q alongside %q", key, keys[0])
+								return
+							}
+						}
+					}
+				}()
+			}
+			for i := range 20 {
+				if i%2 == 0 {
+					cache.ReplaceAll(generation("green", 50))
+				} else {
+					cache.ReplaceAll(generation("blue", 50))
+				}
+			}
+			close(stop)
+			wg.Wait()
+
+			if n := evicted.Load(); n != 20*50 {
+				t.Errorf("OnEvicted fired %d times, want %d", n, 20*50)
+			}
+			if info := cache.DebugStats(); info.StoredEntries != 50 || info.Timers != 50 || cache.Len() != 50 {
+				t.Errorf("after the last swap: Len %d, %+v", cache.Len(), info)
+			}
+			if value, _ := cache.Get("blue0"); value != "blue" {
+				t.Errorf("Get(blue0) = %v, want blue", value)
+			}
+		})
+	}
+}
+
+func TestReplaceAllOverwritesLikeSet(t *testing.T) {
+	var evicted []string
+	cache, _ := NewTestCache(
+		WithMaxEntries(3),
+		WithMaxTTL(time.Hour),
+		WithRejectOverMaxTTL(),
+		WithTTLFunc(func(string, any) time.Duration { return time.Minute }),
+		WithAdmissionFilter(func(key string, _ int64) bool { return key != "turned away" }),
+		WithOnEvicted(func(key string, _ any, reason EvictionReason) {
+			evicted = append(evicted, fmt.Sprintf("%s:%v", key, reason))
+		}),
+	)
+	cache.Set("kept", 1, time.Hour)
+	cache.Set("dropped", 2, time.Hour)
+	cache.Set("other", 3, time.Hour)
+	done := cache.Done("kept")
+
+	cache.ReplaceAll(map[string]WarmEntry{
+		"kept":        {Value: 10, TTL: ValueTTL},
+		"too long":    {Value: 20, TTL: 2 * time.Hour},
+		"turned away": {Value: 30, TTL: time.Hour},
+	})
+
+	select {
+	case <-done:
+		t.Error("Done fired for a key ReplaceAll overwrote")
+	default:
+	}
+	sort.Strings(evicted)
+	if want := []string{"dropped:" + ReasonCleared.String(), "other:" + ReasonCleared.String()}; !slices.Equal(evicted, want) {
+		t.Errorf("OnEvicted saw %v, want %v", evicted, want)
+	}
+	if keys := cache.Keys(); len(keys) != 1 || keys[0] != "kept" {
+		t.Errorf("Keys = %v after ReplaceAll, want [kept]", keys)
+	}
+	if ttl, _ := cache.TTL("kept"); ttl != time.Minute {
+		t.Errorf("TTL(kept) = %v, want the TTL function's 1m", ttl)
+	}
+}
+
+func TestMapValuesPreservesTTLs(t *testing.T) {
+	for _, cache := range []*MemoryCache{NewMemoryCache(), NewMemoryCache(WithShards(4))} {
+		for i := range 20 {
+			cache.Set(fmt.Sprint(i), i, time.Duration(i+1)*time.Minute)
+		}
+		before := make(map[string]time.Duration)
+		for _, key := range cache.Keys() {
+			before[key], _ = cache.TTL(key)
+		}
+
+		cache.MapValues(func(key string, old any) (any, bool) {
+			n := old.(int)
+			return strconv.Itoa(n), n%2 == 0
+		})
+
+		if n := cache.Len(); n != 10 {
+			t.Fatalf("Len = %d after dropping odd values, want 10", n)
+		}
+		for i := range 20 {
+			key := fmt.Sprint(i)
+			v, ok := cache.Get(key)
+			if i%2 == 1 {
+				if ok {
+					t.Errorf("%q = %v survived, want deleted", key, v)
+				}
+				continue
+			}
+			if v != strconv.Itoa(i) {
+				t.Errorf("%q = %#v, want %q", key, v, strconv.Itoa(i))
+			}
+			if ttl, _ := cache.TTL(key); ttl > before[key] || ttl < before[key]-time.Second {
+				t.Errorf("%q TTL went from %v to %v", key, before[key], ttl)
+			}
+		}
+	}
+}
+
+func TestMapValuesUnderConcurrentReads(t *testing.T) {
+	cache := NewMemoryCache()
+	for i := range 1000 {
+		cache.Set(fmt.Sprint(i), i, time.Minute)
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i = (i + 1) % 1000 {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				switch v, _ := cache.Get(fmt.Sprint(i)); v.(type) {
+				case int, string:
+				default:
+					t.Errorf("read %#v mid-migration", v)
+					return
+				}
+			}
+		}()
+	}
+	cache.MapValues(func(_ string, old any) (any, bool) {
+		return strconv.Itoa(old.(int)), true
+	})
+	close(done)
+	wg.Wait()
+	if n := cache.Len(); n != 1000 {
+		t.Fatalf("Len = %d after MapValues, want 1000", n)
+	}
+}
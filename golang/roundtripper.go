@@ -0,0 +1,170 @@
+package enigmacache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/plathrop/enigma-cache/golang/httpttl"
+)
+
+// NewCachingRoundTripper returns an http.RoundTripper which caches
+// responses to GET requests in c, passing requests on to base (or
+// http.DefaultTransport if base is nil) only when it has no fresh
+// response. Responses are keyed by URL and cached for as long as
+// their headers allow (see httpttl.TTLFromHTTPHeaders); only 200
+// responses with a freshness lifetime are cached, so no-store,
+// no-cache and private responses never are. Requests carrying
+// Cache-Control no-store or no-cache, or a Range header, bypass the
+// cache. A request whose If-None-Match matches the cached response's
+// ETag is answered 304 Not Modified from the cache.
+//
+// The round tripper is a shared cache, so it keeps one user's
+// responses from another's: a response with a Vary header is cached
+// apart for each combination of the request headers it names, one
+// with Vary: * isn't cached at all, and a request carrying
+// Authorization or Cookie is only answered from, and its response
+// only stored in, the cache if the response is marked public or has
+// an s-maxage.
+//
+// The cached copy holds the whole body, which is read in full before
+// the first response is returned.
+func NewCachingRoundTripper(c *MemoryCache, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &cachingRoundTripper{cache: c, base: base}
+}
+
+type cachingRoundTripper struct {
+	cache *MemoryCache
+	base  http.RoundTripper
+}
+
+// A cachedResponse is what a cachingRoundTripper stores for a URL.
+type cachedResponse struct {
+	header http.Header
+	body   []byte
+	// shared is set if the response may be given to requests carrying
+	// credentials.
+	shared bool
+}
+
+func (c *cachedResponse) cacheCost() int64 {
+	return int64(len(c.body))
+}
+
+// A cachedVary is stored under a URL's key in place of a response
+// whose Vary header names request headers; the responses are stored
+// under keys which add those headers' values (see varyKey).
+type cachedVary struct {
+	names []string
+}
+
+func (t *cachingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !cacheableRequest(req) {
+		return t.base.RoundTrip(req)
+	}
+	key := "http:" + req.URL.String()
+	credentials := req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != ""
+	value, meta, ok := t.cache.GetWithMeta(key)
+	if vary, isVary := value.(*cachedVary); ok && isVary {
+		value, meta, ok = t.cache.GetWithMeta(varyKey(key, vary.names, req.Header))
+	}
+	if cached, isResponse := value.(*cachedResponse); ok && isResponse && (cached.shared || !credentials) {
+		if etag := req.Header.Get("If-None-Match"); etag != "" && meta.ETag == etag {
+			return response(req, http.StatusNotModified, http.Header{"Etag": {etag}}, nil), nil
+		}
+		return response(req, http.StatusOK, cached.header.Clone(), cached.body), nil
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	ttl, cacheable := httpttl.TTLFromHTTPHeaders(resp.Header)
+	shared := hasDirective(resp.Header, "public", "s-maxage")
+	if !cacheable || (credentials && !shared) {
+		return resp, nil
+	}
+	var names []string
+	for _, value := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name == "*" {
+				return resp, nil
+			} else if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		slices.Sort(names)
+		names = slices.Compact(names)
+		t.cache.Set(key, &cachedVary{names: names}, ttl)
+		key = varyKey(key, names, req.Header)
+	}
+	cached := &cachedResponse{header: resp.Header.Clone(), body: body, shared: shared}
+	t.cache.SetWithMeta(key, cached, Meta{ETag: resp.Header.Get("ETag")}, ttl)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// varyKey returns the key under which the response to a request with
+// header h is stored, for a URL stored under key whose responses vary
+// on the named headers.
+func varyKey(key string, names []string, h http.Header) string {
+	var b strings.Builder
+	b.WriteString(key)
+	for _, name := range names {
+		// A NUL can't appear in a URL or a header, so keys can't run
+		// into one another.
+		fmt.Fprintf(&b, "\x00%s:%s", name, strings.Join(h.Values(name), ","))
+	}
+	return b.String()
+}
+
+// cacheableRequest reports whether req may be answered from the
+// cache.
+func cacheableRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return false
+	}
+	return !hasDirective(req.Header, "no-store", "no-cache")
+}
+
+// hasDirective reports whether h's Cache-Control has any of the named
+// directives, with or without a value.
+func hasDirective(h http.Header, names ...string) bool {
+	for _, value := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(directive, "=")
+			if slices.Contains(names, strings.ToLower(strings.TrimSpace(name))) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// response builds a response to req from the cache.
+func response(req *http.Request, status int, header http.Header, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,175 @@
+package enigmacache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCachingRoundTripperServesRepeatsFromCache(t *testing.T) {
+	var hits atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/cached":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("ETag", `"v1"`)
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		io.WriteString(w, "body of "+r.URL.Path)
+	}))
+	defer origin.Close()
+	client := &http.Client{Transport: NewCachingRoundTripper(NewMemoryCache(), nil)}
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := client.Get(origin.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	for range 3 {
+		if status, body := get("/cached"); status != http.StatusOK || body != "body of /cached" {
+			t.Fatalf("GET /cached = %d %q", status, body)
+		}
+	}
+	if n := hits.Swap(0); n != 1 {
+		t.Fatalf("origin saw %d requests for a cacheable URL, want 1", n)
+	}
+
+	get("/no-store")
+	get("/no-store")
+	if n := hits.Swap(0); n != 2 {
+		t.Fatalf("origin saw %d requests for a no-store URL, want 2", n)
+	}
+
+	resp, err := client.Post(origin.URL+"/cached", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if n := hits.Swap(0); n != 1 {
+		t.Fatalf("a POST was answered from the cache")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, origin.URL+"/cached", nil)
+	req.Header.Set("If-None-Match", `"v1"`)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified || hits.Load() != 0 {
+		t.Fatalf("conditional GET = %d after %d origin requests, want 304 from the cache", resp.StatusCode, hits.Load())
+	}
+}
+
+func TestCachingRoundTripperKeepsUsersApart(t *testing.T) {
+	var hits atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+		case "/vary-star":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "*")
+		case "/private":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/public":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		case "/s-maxage":
+			w.Header().Set("Cache-Control", "s-maxage=60")
+		}
+		io.WriteString(w, r.URL.Path+" for "+r.Header.Get("Accept-Language")+r.Header.Get("Authorization")+r.Header.Get("Cookie"))
+	}))
+	defer origin.Close()
+	client := &http.Client{Transport: NewCachingRoundTripper(NewMemoryCache(), nil)}
+
+	get := func(path string, header ...string) string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, origin.URL+path, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	t.Run("vary", func(t *testing.T) {
+		hits.Store(0)
+		for range 2 {
+			if body := get("/vary", "Accept-Language", "en"); body != "/vary for en" {
+				t.Fatalf("English GET = %q", body)
+			}
+			if body := get("/vary", "Accept-Language", "fr"); body != "/vary for fr" {
+				t.Fatalf("French GET = %q", body)
+			}
+		}
+		if n := hits.Load(); n != 2 {
+			t.Errorf("origin saw %d requests, want one per language", n)
+		}
+	})
+
+	t.Run("vary star", func(t *testing.T) {
+		hits.Store(0)
+		get("/vary-star")
+		get("/vary-star")
+		if n := hits.Load(); n != 2 {
+			t.Errorf("origin saw %d requests for a Vary: * URL, want 2", n)
+		}
+	})
+
+	for _, credential := range []string{"Authorization", "Cookie"} {
+		t.Run(credential, func(t *testing.T) {
+			client.Transport = NewCachingRoundTripper(NewMemoryCache(), nil)
+			hits.Store(0)
+			// A response to one user isn't stored, nor is a response
+			// stored for anonymous requests given to them.
+			if body := get("/private", credential, "alice"); body != "/private for alice" {
+				t.Fatalf("alice's GET = %q", body)
+			}
+			if body := get("/private", credential, "bob"); body != "/private for bob" {
+				t.Errorf("bob got %q", body)
+			}
+			get("/private")
+			if body := get("/private", credential, "bob"); body != "/private for bob" {
+				t.Errorf("bob got %q after an anonymous GET", body)
+			}
+			if n := hits.Load(); n != 4 {
+				t.Errorf("origin saw %d requests, want 4", n)
+			}
+			hits.Store(0)
+
+			// Responses marked for sharing are cached all the same.
+			for _, path := range []string{"/public", "/s-maxage"} {
+				get(path, credential, "alice")
+				if body := get(path, credential, "bob"); body != path+" for alice" {
+					t.Errorf("bob got %q for shared %s", body, path)
+				}
+			}
+			if n := hits.Load(); n != 2 {
+				t.Errorf("origin saw %d requests for shared responses, want 2", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package enigmacache
+
+import (
+	"sync"
+	"time"
+)
+
+// A StatsSample is one reading taken by StartSampling.
+type StatsSample struct {
+	// At is when the sample was taken, by the cache's clock, and
+	// Elapsed how long it covers: the time since the previous sample
+	// was delivered, or since sampling started.
+	At      time.Time
+	Elapsed time.Duration
+	// Delta holds how much each counter grew over Elapsed. Its
+	// Entries and Bytes, which are gauges rather than counters, are
+	// zero, as are its Uptime and rates.
+	Delta CacheStats
+	// Current holds the counters and gauges as of At.
+	Current CacheStats
+}
+
+// StartSampling takes a StatsSample of the cache every interval, by
+// the cache's clock, and delivers it on the returned channel, so a
+// metrics pipeline wanting deltas needn't diff snapshots itself.
+// Counters are taken modulo 2^64, so a delta stays correct across a
+// counter wrapping around. A sample which finds the channel still
+// holding the previous one is dropped, and the next sample's delta
+// covers both. Calling stop ends sampling and closes the channel; it
+// may be called more than once. Closing the cache stops it too, and
+// on a closed cache the channel is closed from the start.
+func (mc *MemoryCache) StartSampling(interval time.Duration) (samples <-chan StatsSample, stop func()) {
+	mc.samplers.mu.Lock()
+	defer mc.samplers.mu.Unlock()
+	if mc.closed.Load() {
+		ch := make(chan StatsSample)
+		close(ch)
+		return ch, func() {}
+	}
+	s := &sampler{
+		mc:       mc,
+		interval: interval,
+		ch:       make(chan StatsSample, 1),
+		at:       mc.now(),
+		resets:   mc.stats.resets.Load(),
+		last:     mc.Stats(),
+	}
+	s.mu.Lock()
+	s.timer = mc.opts.clock.AfterFunc(interval, s.sample)
+	s.mu.Unlock()
+	if mc.samplers.running == nil {
+		mc.samplers.running = make(map[*sampler]struct{})
+	}
+	mc.samplers.running[s] = struct{}{}
+	return s.ch, s.stop
+}
+
+// A samplerSet holds a cache's running samplers, for Close to stop.
+type samplerSet struct {
+	mu      sync.Mutex
+	running map[*sampler]struct{}
+}
+
+// stopSamplers stops every sampler still running.
+func (mc *MemoryCache) stopSamplers() {
+	mc.samplers.mu.Lock()
+	running := mc.samplers.running
+	mc.samplers.running = nil
+	mc.samplers.mu.Unlock()
+	for s := range running {
+		s.stop()
+	}
+}
+
+type sampler struct {
+	mc       *MemoryCache
+	interval time.Duration
+	ch       chan StatsSample
+
+	mu      sync.Mutex
+	stopped bool
+	timer   Timer
+	// at and last are the time and stats of the last sample
+	// delivered, and resets the count of ResetStats calls as of then.
+	at     time.Time
+	resets uint64
+	last   CacheStats
+}
+
+func (s *sampler) sample() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	now, current := s.mc.now(), s.mc.Stats()
+	last := s.last
+	// Read after the stats, so a reset partway through them is seen.
+	resets := s.mc.stats.resets.Load()
+	if resets != s.resets {
+		// The counters were reset since the last sample, so they've
+		// counted up from zero.
+		last = CacheStats{}
+	}
+	sample := StatsSample{
+		At:      now,
+		Elapsed: now.Sub(s.at),
+		Delta: CacheStats{
+			Hits:               current.Hits - last.Hits,
+			Misses:             current.Misses - last.Misses,
+			Sets:               current.Sets - last.Sets,
+			Rejected:           current.Rejected - last.Rejected,
+			CallbackPanics:     current.CallbackPanics - last.CallbackPanics,
+			SlowCallbacks:      current.SlowCallbacks - last.SlowCallbacks,
+			ShortenedTTLs:      current.ShortenedTTLs - last.ShortenedTTLs,
+			TapDropped:         current.TapDropped - last.TapDropped,
+			DecompressFailures: current.DecompressFailures - last.DecompressFailures,
+			Expired:            current.Expired - last.Expired,
+			Removed:            current.Removed - last.Removed,
+			Evicted:            current.Evicted - last.Evicted,
+			Cleared:            current.Cleared - last.Cleared,
+			Demoted:            current.Demoted - last.Demoted,
+		},
+		Current: current,
+	}
+	select {
+	case s.ch <- sample:
+		s.at, s.resets, s.last = now, resets, current
+	default:
+	}
+	s.timer = s.mc.opts.clock.AfterFunc(s.interval, s.sample)
+}
+
+func (s *sampler) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	s.timer.Stop()
+	close(s.ch)
+
+	m := &s.mc.samplers
+	m.mu.Lock()
+	delete(m.running, s)
+	m.mu.Unlock()
+}
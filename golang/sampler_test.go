@@ -0,0 +1,46 @@
+package enigmacache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStartSamplingDeltas(t *testing.T) {
+	cache, clock := NewTestCache()
+	samples, stop := cache.StartSampling(time.Second)
+
+	cache.Set("a", 1, time.Hour)
+	cache.Get("a")
+	cache.Get("b")
+	clock.Advance(time.Second)
+	first := <-samples
+	if want := (CacheStats{Hits: 1, Misses: 1, Sets: 1}); first.Delta != want {
+		t.Fatalf("first delta = %+v, want %+v", first.Delta, want)
+	}
+	if first.Current.Entries != 1 || first.Elapsed != time.Second {
+		t.Fatalf("first sample = %+v, want 1 entry over 1s", first)
+	}
+
+	cache.Get("a")
+	cache.Get("a")
+	clock.Advance(time.Second)
+	// Nobody has taken the second sample yet, so the third is dropped
+	// and the fourth covers its span too.
+	cache.Set("b", 2, time.Hour)
+	clock.Advance(time.Second)
+	cache.Set("c", 3, time.Hour)
+	if second := <-samples; second.Delta != (CacheStats{Hits: 2}) {
+		t.Fatalf("second delta = %+v, want 2 hits", second.Delta)
+	}
+	clock.Advance(time.Second)
+	fourth := <-samples
+	if want := (CacheStats{Sets: 2}); fourth.Delta != want || fourth.Elapsed != 2*time.Second {
+		t.Fatalf("fourth sample = %+v, want a delta of %+v over 2s", fourth, want)
+	}
+
+	stop()
+	stop()
+	if _, ok := <-samples; ok {
+		t.Fatal("samples still open after stop")
+	}
+}
@@ -0,0 +1,185 @@
+package enigmacache
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// A ShardTxn reads and writes keys within a single shard on behalf of
+// WithinShard. Reads see the transaction's own writes. A ShardTxn is
+// only valid until the function it was passed to returns.
+type ShardTxn interface {
+	Get(key string) (value any, ok bool)
+	Set(key string, value any, ttl time.Duration)
+	Delete(key string) (deleted bool)
+}
+
+// WithinShard calls fn with the shard holding keys locked, so the
+// reads and writes fn makes through txn are atomic with respect to
+// every other operation on that shard: no reader sees some of the
+// writes without the others. Writes are buffered and applied together
+// only if fn returns nil; otherwise they're discarded and fn's error
+// is returned.
+//
+// This only works if every key involved lives in one shard. Pass the
+// keys fn will touch; WithinShard returns ErrCrossShard if they span
+// shards (as does any use of txn with a key from another shard), and
+// ErrNotSharded if the cache wasn't built WithShards. To keep related
+// keys together, give them a common hash tag and build the cache
+// WithHasher(HashTag(nil)).
+//
+// fn must not call back into the cache; the shard is locked.
+func (mc *MemoryCache) WithinShard(keys []string, fn func(txn ShardTxn) error) error {
+	sb, ok := mc.storage.(*shardedBackend)
+	if !ok {
+		return ErrNotSharded
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	t := &shardTxn{mc: mc, sb: sb, shard: sb.shardIndex(mc.normalize(keys[0]))}
+	for _, key := range keys[1:] {
+		if sb.shardIndex(mc.normalize(key)) != t.shard {
+			return ErrCrossShard
+		}
+	}
+
+	sh := &sb.shards[t.shard]
+	sh.lock()
+	t.entries = sh.writable()
+	err := fn(t)
+	if err == nil {
+		err = t.err
+	}
+	var done []func()
+	if err == nil {
+		done = t.commit()
+	}
+	sh.mu.Unlock()
+
+	// Bookkeeping can touch other shards (eviction, say), so it waits
+	// until we've let go of this one.
+	for _, f := range done {
+		f()
+	}
+	return err
+}
+
+type shardTxn struct {
+	mc      *MemoryCache
+	sb      *shardedBackend
+	shard   int
+	entries map[string]*entry
+	// writes holds the buffered writes by key, with a nil entry for a
+	// delete; order is the order keys were first written in.
+	writes map[string]*entry
+	order  []string
+	err    error
+}
+
+// check records an error if key belongs to another shard.
+func (t *shardTxn) check(key string) bool {
+	if t.sb.shardIndex(key) != t.shard {
+		t.err = ErrCrossShard
+		return false
+	}
+	return true
+}
+
+func (t *shardTxn) lookup(key string) *entry {
+	if e, ok := t.writes[key]; ok {
+		return e
+	}
+	e := t.entries[key]
+	if e == nil || !e.live(t.mc.now()) {
+		return nil
+	}
+	return e
+}
+
+func (t *shardTxn) write(key string, e *entry) {
+	if t.writes == nil {
+		t.writes = make(map[string]*entry)
+	}
+	if _, ok := t.writes[key]; !ok {
+		t.order = append(t.order, key)
+	}
+	t.writes[key] = e
+}
+
+func (t *shardTxn) Get(key string) (any, bool) {
+	key = t.mc.normalize(key)
+	if !t.check(key) {
+		return nil, false
+	}
+	e := t.lookup(key)
+	if e == nil {
+		return nil, false
+	}
+	return t.mc.read(key, e)
+}
+
+func (t *shardTxn) Set(key string, value any, ttl time.Duration) {
+	key = t.mc.normalize(key)
+	if !t.check(key) || t.mc.rejects(value) {
+		return
+	}
+	t.write(key, t.mc.newEntry(key, value, ttl, 0))
+}
+
+func (t *shardTxn) Delete(key string) bool {
+	key = t.mc.normalize(key)
+	if !t.check(key) || t.lookup(key) == nil {
+		return false
+	}
+	t.write(key, nil)
+	return true
+}
+
+// commit applies the buffered writes to the shard, which must be
+// locked, and returns the bookkeeping to run once it's unlocked.
+func (t *shardTxn) commit() []func() {
+	mc := t.mc
+	var done []func()
+	for _, key := range t.order {
+		e := t.writes[key]
+		prev := t.entries[key]
+		if e == nil {
+			if prev == nil {
+				continue
+			}
+			delete(t.entries, key)
+			done = append(done, func() {
+				mc.removed(key, prev, ReasonManual)
+				mc.deleteThrough(key)
+			})
+			continue
+		}
+		t.entries[key] = e
+		done = append(done, func() {
+			mc.stored(key, e, prev)
+			_ = mc.writeThrough(context.Background(), key, e.get(), e.remaining(mc.now()))
+		})
+	}
+	return done
+}
+
+// HashTag wraps a key hash so that keys containing a hash tag, a
+// non-empty substring between the first "{" and the next "}", are
+// hashed on the tag alone. Keys sharing a tag, like "{user42}:profile"
+// and "{user42}:prefs", then always land in the same shard. A nil hash
+// wraps the default hash.
+func HashTag(hash func(key string) uint64) func(key string) uint64 {
+	if hash == nil {
+		hash = fnv1a
+	}
+	return func(key string) uint64 {
+		if open := strings.IndexByte(key, '{'); open >= 0 {
+			if n := strings.IndexByte(key[open+1:], '}'); n > 0 {
+				return hash(key[open+1 : open+1+n])
+			}
+		}
+		return hash(key)
+	}
+}
@@ -0,0 +1,124 @@
+package enigmacache
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithinShardIsAtomic(t *testing.T) {
+	cache := NewMemoryCache(WithShards(16), WithHasher(HashTag(nil)))
+	keys := []string{"{pair}:a", "{pair}:b"}
+	cache.WithinShard(keys, func(txn ShardTxn) error {
+		txn.Set(keys[0], 0, time.Minute)
+		txn.Set(keys[1], 0, time.Minute)
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 1000; i++ {
+			cache.WithinShard(keys, func(txn ShardTxn) error {
+				txn.Set(keys[0], i, time.Minute)
+				txn.Set(keys[1], i, time.Minute)
+				return nil
+			})
+		}
+		close(stop)
+	}()
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				cache.WithinShard(keys, func(txn ShardTxn) error {
+					a, _ := txn.Get(keys[0])
+					b, _ := txn.Get(keys[1])
+					if a != b {
+						t.Errorf("observed a half-updated pair: %v, %v", a, b)
+					}
+					return nil
+				})
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWithinShardDiscardsOnError(t *testing.T) {
+	cache := NewMemoryCache(WithShards(4))
+	cache.Set("key", "old", time.Minute)
+	errAbort := errors.New("abort")
+
+	err := cache.WithinShard([]string{"key"}, func(txn ShardTxn) error {
+		txn.Set("key", "new", time.Minute)
+		if value, _ := txn.Get("key"); value != "new" {
+			t.Errorf("txn did not see its own write, got %v", value)
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("WithinShard returned %v, want fn's error", err)
+	}
+	if value, _ := cache.Get("key"); value != "old" {
+		t.Fatalf("aborted write was applied, key holds %v", value)
+	}
+
+	err = cache.WithinShard([]string{"key"}, func(txn ShardTxn) error {
+		if !txn.Delete("key") {
+			t.Error("Delete did not find the key")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithinShard returned %v", err)
+	}
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("committed delete was not applied")
+	}
+}
+
+func TestWithinShardErrors(t *testing.T) {
+	if err := NewMemoryCache().WithinShard([]string{"a"}, func(ShardTxn) error { return nil }); !errors.Is(err, ErrNotSharded) {
+		t.Errorf("unsharded cache returned %v, want ErrNotSharded", err)
+	}
+
+	hash := func(key string) uint64 { return uint64(key[0] - '0') }
+	cache := NewMemoryCache(WithShards(4), WithHasher(hash))
+	if err := cache.WithinShard([]string{"0a", "1a"}, func(ShardTxn) error { return nil }); !errors.Is(err, ErrCrossShard) {
+		t.Errorf("cross-shard keys returned %v, want ErrCrossShard", err)
+	}
+	err := cache.WithinShard([]string{"0a"}, func(txn ShardTxn) error {
+		txn.Set("0b", 1, time.Minute)
+		txn.Set("1b", 1, time.Minute)
+		return nil
+	})
+	if !errors.Is(err, ErrCrossShard) {
+		t.Errorf("cross-shard write returned %v, want ErrCrossShard", err)
+	}
+	if _, ok := cache.Get("0b"); ok {
+		t.Error("write from a failed transaction was applied")
+	}
+}
+
+func TestHashTag(t *testing.T) {
+	hash := HashTag(nil)
+	if hash("{user}:a") != hash("{user}:b") {
+		t.Error("keys with the same tag hashed differently")
+	}
+	if hash("{}:a") == hash("{}:b") {
+		t.Error("an empty tag should hash the whole key")
+	}
+	if hash("plain") != fnv1a("plain") {
+		t.Error("an untagged key should hash as usual")
+	}
+}
@@ -0,0 +1,45 @@
+package enigmacache
+
+// A TTLShorteningPolicy says what a cache does when a write would
+// bring a key's deadline forward: overwriting it with a TTL shorter
+// than the time it has left.
+type TTLShorteningPolicy int
+
+const (
+	// AllowShorterTTL lets the write shorten the key's life. It's the
+	// default.
+	AllowShorterTTL TTLShorteningPolicy = iota
+	// KeepLongerTTL stores the new value under the old deadline.
+	KeepLongerTTL
+	// WarnShorterTTL lets the write shorten the key's life, but logs
+	// a warning.
+	WarnShorterTTL
+)
+
+// WithTTLShorteningPolicy sets what Set and its variants do when they
+// would shorten a key's remaining life, to catch careless overwrites.
+// Under KeepLongerTTL and WarnShorterTTL, each such write is counted
+// in Stats().ShortenedTTLs, whether the deadline is kept or not.
+// Idle timeouts are unaffected, as are writes by Update, Increment,
+// SetIfNewer and the loader-based getters.
+func WithTTLShorteningPolicy(p TTLShorteningPolicy) Option {
+	return func(o *options) {
+		o.ttlShortening = p
+	}
+}
+
+// checkShortening applies the cache's TTLShorteningPolicy to e, about
+// to overwrite prev for key. e mustn't have been stored yet.
+func (mc *MemoryCache) checkShortening(key string, e, prev *entry) {
+	policy := mc.opts.ttlShortening
+	if policy == AllowShorterTTL || !prev.live(mc.now()) || !e.expiresAt.Before(prev.expiresAt) {
+		return
+	}
+	mc.stats.shortenedTTLs.Add(1)
+	if policy == KeepLongerTTL {
+		e.expiresAt, e.ttl = prev.expiresAt, prev.ttl
+		return
+	}
+	mc.logger().Warn("enigma-cache: overwrite shortened TTL", "key", key,
+		"remaining", prev.expiresAt.Sub(mc.now()), "ttl", e.expiresAt.Sub(mc.now()))
+}
@@ -0,0 +1,58 @@
+package enigmacache
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTTLShorteningPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy TTLShorteningPolicy
+		ttl    time.Duration
+		warned bool
+	}{
+		{"allow", AllowShorterTTL, time.Minute, false},
+		{"keep", KeepLongerTTL, time.Hour, false},
+		{"warn", WarnShorterTTL, time.Minute, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			cache, _ := NewTestCache(
+				WithTTLShorteningPolicy(tc.policy),
+				WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+			)
+			cache.Set("key", 1, time.Hour)
+			cache.Set("key", 2, time.Minute)
+
+			if v, _ := cache.Get("key"); v != 2 {
+				t.Fatalf("value = %v, want 2", v)
+			}
+			if ttl, _ := cache.TTL("key"); ttl != tc.ttl {
+				t.Fatalf("TTL = %v, want %v", ttl, tc.ttl)
+			}
+			if warned := strings.Contains(logs.String(), "shortened TTL"); warned != tc.warned {
+				t.Fatalf("warned = %v, want %v; logs:\n%s", warned, tc.warned, &logs)
+			}
+			want := uint64(1)
+			if tc.policy == AllowShorterTTL {
+				want = 0
+			}
+			if n := cache.Stats().ShortenedTTLs; n != want {
+				t.Fatalf("ShortenedTTLs = %d, want %d", n, want)
+			}
+
+			// Lengthening a TTL is never a problem.
+			cache.Set("key", 3, 2*time.Hour)
+			if ttl, _ := cache.TTL("key"); ttl != 2*time.Hour {
+				t.Fatalf("after lengthening TTL = %v, want 2h", ttl)
+			}
+			if n := cache.Stats().ShortenedTTLs; n != want {
+				t.Fatalf("lengthening counted as shortening, ShortenedTTLs = %d", n)
+			}
+		})
+	}
+}
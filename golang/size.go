@@ -0,0 +1,16 @@
+package enigmacache
+
+import "context"
+
+// WaitForSize blocks until Len is between atLeast and atMost
+// inclusive, returning nil, or until ctx is done, returning
+// ctx.Err(). It's for gating readiness on a warmed cache (atMost
+// math.MaxInt) or waiting for one to drain (atLeast 0). It's woken by
+// each change in size rather than polling, but a size which is in
+// range only briefly may be missed.
+func (mc *MemoryCache) WaitForSize(ctx context.Context, atLeast, atMost int) error {
+	return mc.sizes.wait(ctx, "", func() bool {
+		n := mc.Len()
+		return atLeast <= n && n <= atMost
+	})
+}
@@ -0,0 +1,51 @@
+package enigmacache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestWaitForSize(t *testing.T) {
+	cache := NewMemoryCache()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	go func() {
+		for i := range 10 {
+			time.Sleep(time.Millisecond)
+			cache.Set(fmt.Sprint(i), i, time.Minute)
+		}
+	}()
+	if err := cache.WaitForSize(ctx, 10, math.MaxInt); err != nil {
+		t.Fatalf("waiting to fill: %v", err)
+	}
+	if n := cache.Len(); n != 10 {
+		t.Fatalf("WaitForSize returned with Len = %d, want 10", n)
+	}
+
+	go func() {
+		for i := range 10 {
+			time.Sleep(time.Millisecond)
+			cache.Expire(fmt.Sprint(i))
+		}
+	}()
+	if err := cache.WaitForSize(ctx, 0, 3); err != nil {
+		t.Fatalf("waiting to drain: %v", err)
+	}
+	if n := cache.Len(); n > 3 {
+		t.Fatalf("WaitForSize returned with Len = %d, want at most 3", n)
+	}
+}
+
+func TestWaitForSizeTimesOut(t *testing.T) {
+	cache := NewMemoryCache()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := cache.WaitForSize(ctx, 1, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitForSize on an empty cache = %v, want DeadlineExceeded", err)
+	}
+}
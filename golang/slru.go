@@ -0,0 +1,126 @@
+package enigmacache
+
+import "container/list"
+
+// WithSegmentedLRU makes a bounded cache (see WithMaxEntries and
+// WithMaxMemory) evict by segmented LRU, which keeps a burst of new
+// keys, such as a scan, from flushing out the established hot set. A
+// new key starts out on probation, and is promoted to the protected
+// segment when it's read or overwritten; eviction takes the least
+// recently used probationary key first, and only touches the protected
+// segment once probation is empty. The protected segment holds at
+// most protectedFraction (0.8 is typical) of WithMaxEntries' limit,
+// or of the keys in the cache if it's only bounded by memory; when it
+// outgrows that, its least recently used key is demoted back to
+// probation. A protectedFraction of zero or less keeps plain LRU. It
+// takes precedence over WithApproxLRU, and has no effect under
+// WithEvictionPolicy(FIFO) or WithEvictionPolicy(LFU).
+func WithSegmentedLRU(protectedFraction float64) Option {
+	return func(o *options) {
+		o.protectedFraction = protectedFraction
+	}
+}
+
+// slruPolicy implements WithSegmentedLRU with two LRU lists.
+type slruPolicy struct {
+	fraction float64
+	// capacity is the entry limit set by WithMaxEntries, or zero if
+	// there isn't one.
+	capacity int
+	// probation and protected hold *slruKeys, most recently used at
+	// the front.
+	probation, protected *list.List
+	elements             map[string]*list.Element
+}
+
+type slruKey struct {
+	key       string
+	protected bool
+}
+
+func newSLRUPolicy(protectedFraction float64, capacity int) *slruPolicy {
+	return &slruPolicy{
+		fraction:  protectedFraction,
+		capacity:  capacity,
+		probation: list.New(),
+		protected: list.New(),
+		elements:  make(map[string]*list.Element),
+	}
+}
+
+func (p *slruPolicy) add(key string) {
+	if _, ok := p.elements[key]; ok {
+		p.access(key)
+		return
+	}
+	p.elements[key] = p.probation.PushFront(&slruKey{key: key})
+}
+
+func (p *slruPolicy) access(key string) {
+	el, ok := p.elements[key]
+	if !ok {
+		return
+	}
+	k := el.Value.(*slruKey)
+	if k.protected {
+		p.protected.MoveToFront(el)
+		return
+	}
+	p.probation.Remove(el)
+	k.protected = true
+	p.elements[key] = p.protected.PushFront(k)
+	for p.protected.Len() > p.protectedMax() {
+		demoted := p.protected.Remove(p.protected.Back()).(*slruKey)
+		demoted.protected = false
+		p.elements[demoted.key] = p.probation.PushFront(demoted)
+	}
+}
+
+// protectedMax returns how many keys the protected segment may hold:
+// its fraction of the entry limit, or failing that of the keys
+// tracked, but always at least one.
+func (p *slruPolicy) protectedMax() int {
+	n := p.capacity
+	if n <= 0 {
+		n = p.len()
+	}
+	return max(1, int(p.fraction*float64(n)))
+}
+
+func (p *slruPolicy) remove(key string) {
+	el, ok := p.elements[key]
+	if !ok {
+		return
+	}
+	if el.Value.(*slruKey).protected {
+		p.protected.Remove(el)
+	} else {
+		p.probation.Remove(el)
+	}
+	delete(p.elements, key)
+}
+
+func (p *slruPolicy) keys() []string {
+	keys := make([]string, 0, p.len())
+	for _, l := range []*list.List{p.probation, p.protected} {
+		for el := l.Back(); el != nil; el = el.Prev() {
+			keys = append(keys, el.Value.(*slruKey).key)
+		}
+	}
+	return keys
+}
+
+func (p *slruPolicy) len() int {
+	return p.probation.Len() + p.protected.Len()
+}
+
+func (p *slruPolicy) victim() (string, bool) {
+	el := p.probation.Back()
+	if el == nil {
+		el = p.protected.Back()
+	}
+	if el == nil {
+		return "", false
+	}
+	return el.Value.(*slruKey).key, true
+}
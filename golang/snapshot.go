@@ -0,0 +1,256 @@
+package enigmacache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The binary snapshot format written by SaveBinary is a header of
+// snapshotMagic and a big-endian uint16 format version, then one
+// record per entry, each preceded by its length as a uvarint, then a
+// zero length to end the records, then a big-endian CRC-32 (IEEE) of
+// everything between the header and itself. A record is the key's
+// length as a uvarint, the key, the deadline and last access time as
+// varint Unix nanoseconds, and then the encoded value, which takes up
+// the rest of the record.
+const (
+	snapshotMagic   = "ENGC"
+	snapshotVersion = 1
+	// maxSnapshotRecord bounds the records LoadBinary accepts, so a
+	// corrupt length can't make it allocate without limit.
+	maxSnapshotRecord = 1 << 30
+)
+
+// SaveBinary writes a consistent snapshot of the cache's live entries
+// to w, as Save does, but in a compact binary format with a format
+// version and a checksum, so LoadBinary can tell a snapshot written
+// by an incompatible version, or one truncated or corrupted in
+// transit, from a good one. encode turns each value into bytes;
+// LoadBinary is given its inverse. For a cache built WithEncryption,
+// the bytes are then encrypted.
+func (mc *MemoryCache) SaveBinary(w io.Writer, encode func(value any) ([]byte, error)) error {
+	encode = mc.sealing(encode)
+	bw := bufio.NewWriter(w)
+	bw.WriteString(snapshotMagic)
+	binary.Write(bw, binary.BigEndian, uint16(snapshotVersion))
+
+	crc := crc32.NewIEEE()
+	payload := io.MultiWriter(bw, crc)
+	var record []byte
+	for _, entries := range mc.storage.Snapshot() {
+		for key, e := range entries {
+			if !e.live(mc.now()) {
+				continue
+			}
+			raw, err := unpack(e.value)
+			if err != nil {
+				mc.unreadable(key, e, err)
+				continue
+			}
+			value, err := encode(raw)
+			if err != nil {
+				return fmt.Errorf("enigma-cache: saving %q: %w", key, err)
+			}
+			record = binary.AppendUvarint(record[:0], uint64(len(key)))
+			record = append(record, key...)
+			record = binary.AppendVarint(record, e.deadline().UnixNano())
+			record = binary.AppendVarint(record, e.lastAccess.Load())
+			record = append(record, value...)
+			payload.Write(binary.AppendUvarint(nil, uint64(len(record))))
+			payload.Write(record)
+		}
+	}
+	payload.Write([]byte{0})
+	binary.Write(bw, binary.BigEndian, crc.Sum32())
+	return bw.Flush()
+}
+
+// LoadBinary reads a snapshot written by SaveBinary from r and sets
+// each of its entries with the TTL remaining until its deadline,
+// skipping any which have expired, as ImportNDJSON does, returning
+// how many it set. decode turns the bytes SaveBinary's encode made
+// back into a value.
+//
+// The whole snapshot is read and its checksum checked before any of
+// it is loaded, so a bad one leaves the cache untouched: LoadBinary
+// returns an error wrapping ErrSnapshotVersion for a snapshot in a
+// format version it doesn't know, and one wrapping ErrCorruptSnapshot
+// for anything else which isn't a whole, intact snapshot. If decode
+// fails, or, for a cache built WithEncryption, a value won't decrypt,
+// LoadBinary stops there, returning the count so far and the error.
+func (mc *MemoryCache) LoadBinary(r io.Reader, decode func(data []byte) (any, error)) (int, error) {
+	if mc.closed.Load() {
+		return 0, fmt.Errorf("load snapshot: %w", ErrClosed)
+	}
+	decode = mc.opening(decode)
+	br := bufio.NewReader(r)
+	var header [len(snapshotMagic) + 2]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return 0, fmt.Errorf("%w: reading header: %w", ErrCorruptSnapshot, unexpectedEOF(err))
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return 0, fmt.Errorf("%w: not a snapshot", ErrCorruptSnapshot)
+	}
+	if v := binary.BigEndian.Uint16(header[len(snapshotMagic):]); v != snapshotVersion {
+		return 0, fmt.Errorf("%w: version %d, want %d", ErrSnapshotVersion, v, snapshotVersion)
+	}
+
+	type snapshotEntry struct {
+		key                   string
+		expiresAt, lastAccess time.Time
+		value                 []byte
+	}
+	var entries []snapshotEntry
+	cr := &crcReader{r: br, crc: crc32.NewIEEE()}
+	for n := 1; ; n++ {
+		size, err := binary.ReadUvarint(cr)
+		if err != nil {
+			return 0, fmt.Errorf("%w: reading record %d: %w", ErrCorruptSnapshot, n, unexpectedEOF(err))
+		}
+		if size == 0 {
+			break
+		}
+		if size > maxSnapshotRecord {
+			return 0, fmt.Errorf("%w: record %d claims %d bytes", ErrCorruptSnapshot, n, size)
+		}
+		record := make([]byte, size)
+		if _, err := io.ReadFull(cr, record); err != nil {
+			return 0, fmt.Errorf("%w: reading record %d: %w", ErrCorruptSnapshot, n, unexpectedEOF(err))
+		}
+		rr := bytes.NewReader(record)
+		keyLen, err := binary.ReadUvarint(rr)
+		if err != nil || keyLen > uint64(rr.Len()) {
+			return 0, fmt.Errorf("%w: record %d has a bad key", ErrCorruptSnapshot, n)
+		}
+		key := make([]byte, keyLen)
+		rr.Read(key)
+		expiresAt, err := binary.ReadVarint(rr)
+		if err != nil {
+			return 0, fmt.Errorf("%w: record %d has a bad deadline", ErrCorruptSnapshot, n)
+		}
+		lastAccess, err := binary.ReadVarint(rr)
+		if err != nil {
+			return 0, fmt.Errorf("%w: record %d has a bad access time", ErrCorruptSnapshot, n)
+		}
+		e := snapshotEntry{key: string(key), expiresAt: time.Unix(0, expiresAt), value: record[len(record)-rr.Len():]}
+		if lastAccess != 0 {
+			e.lastAccess = time.Unix(0, lastAccess)
+		}
+		entries = append(entries, e)
+	}
+	var sum [4]byte
+	if _, err := io.ReadFull(br, sum[:]); err != nil {
+		return 0, fmt.Errorf("%w: reading checksum: %w", ErrCorruptSnapshot, unexpectedEOF(err))
+	}
+	if binary.BigEndian.Uint32(sum[:]) != cr.crc.Sum32() {
+		return 0, fmt.Errorf("%w: checksum mismatch", ErrCorruptSnapshot)
+	}
+
+	loaded := 0
+	for _, e := range entries {
+		value, err := decode(e.value)
+		if err != nil {
+			return loaded, fmt.Errorf("enigma-cache: loading %q: %w", e.key, err)
+		}
+		if mc.restore(e.key, value, e.expiresAt, e.lastAccess) {
+			loaded++
+		}
+	}
+	return loaded, nil
+}
+
+// SaveBinaryFile writes a snapshot to the file at path, as SaveBinary
+// does, so a restarted process can warm its cache with
+// LoadBinaryFile. The snapshot is written to a temporary file in the
+// same directory, then renamed over path, so a crash partway through
+// leaves any earlier snapshot in place.
+func (mc *MemoryCache) SaveBinaryFile(path string, encode func(value any) ([]byte, error)) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if err := mc.SaveBinary(f, encode); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadBinaryFile loads the snapshot in the file at path, as LoadBinary
+// does. Entries whose deadlines passed while the snapshot sat on disk
+// are skipped.
+func (mc *MemoryCache) LoadBinaryFile(path string, decode func(data []byte) (any, error)) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return mc.LoadBinary(f, decode)
+}
+
+// GobEncode encodes a value with encoding/gob, for SaveBinary when
+// the cache holds values of assorted types. Each concrete type stored
+// must be registered with gob.Register, by the loading process too.
+func GobEncode(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode decodes a value encoded by GobEncode, for LoadBinary.
+func GobDecode(data []byte) (any, error) {
+	var value any
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	return value, err
+}
+
+// A crcReader reads from r, adding what it reads to crc.
+type crcReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (c *crcReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.crc.Write(p[:n])
+	return n, err
+}
+
+func (c *crcReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+// unexpectedEOF turns io.EOF, which means a snapshot ended early when
+// it's met partway through, into io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
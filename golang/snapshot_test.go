@@ -0,0 +1,159 @@
+package enigmacache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func encodeString(value any) ([]byte, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a string", value)
+	}
+	return []byte(s), nil
+}
+
+func decodeString(data []byte) (any, error) { return string(data), nil }
+
+// binarySnapshot returns a snapshot of a cache holding a few strings.
+func binarySnapshot(t *testing.T) []byte {
+	t.Helper()
+	src := NewMemoryCache()
+	src.Set("a", "apple", time.Minute)
+	src.Set("b", "", time.Minute)
+	src.Set("c", "cherry", time.Hour)
+	var buf bytes.Buffer
+	if err := src.SaveBinary(&buf, encodeString); err != nil {
+		t.Fatalf("SaveBinary returned %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBinarySnapshotRoundTrip(t *testing.T) {
+	dst := NewMemoryCache()
+	n, err := dst.LoadBinary(bytes.NewReader(binarySnapshot(t)), decodeString)
+	if err != nil || n != 3 {
+		t.Fatalf("LoadBinary got (%d, %v), want (3, nil)", n, err)
+	}
+	for key, want := range map[string]string{"a": "apple", "b": "", "c": "cherry"} {
+		if value, ok := dst.Get(key); !ok || value != want {
+			t.Errorf("Get(%q) = %v, %v; want %q, true", key, value, ok, want)
+		}
+	}
+	_, _, remaining := dst.GetOrSetWithTTL("c", nil, 0)
+	if remaining <= 59*time.Minute || remaining > time.Hour {
+		t.Errorf("loaded TTL is %v, want about an hour", remaining)
+	}
+}
+
+func TestLoadBinarySkipsExpired(t *testing.T) {
+	var buf bytes.Buffer
+	src, clock := NewTestCache()
+	src.Set("short", "x", time.Minute)
+	src.Set("long", "y", time.Hour)
+	src.SaveBinary(&buf, encodeString)
+
+	dst := NewMemoryCache(WithClock(clock))
+	clock.Advance(30 * time.Minute)
+	n, err := dst.LoadBinary(&buf, decodeString)
+	if err != nil || n != 1 {
+		t.Fatalf("LoadBinary got (%d, %v), want (1, nil)", n, err)
+	}
+	if _, ok := dst.Get("short"); ok {
+		t.Error("expired entry was loaded")
+	}
+}
+
+func TestLoadBinaryRejectsTruncated(t *testing.T) {
+	data := binarySnapshot(t)
+	for _, n := range []int{0, 3, 6, len(data) / 2, len(data) - 1} {
+		dst := NewMemoryCache()
+		_, err := dst.LoadBinary(bytes.NewReader(data[:n]), decodeString)
+		if !errors.Is(err, ErrCorruptSnapshot) || !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("LoadBinary of %d of %d bytes returned %v, want ErrCorruptSnapshot", n, len(data), err)
+		}
+		if dst.Len() != 0 {
+			t.Errorf("LoadBinary of %d of %d bytes loaded %d entries", n, len(data), dst.Len())
+		}
+	}
+}
+
+func TestLoadBinaryRejectsBadChecksum(t *testing.T) {
+	data := binarySnapshot(t)
+	// Change the last byte of the last value, which leaves the records
+	// readable but not matching the checksum.
+	data[len(data)-6] ^= 1
+	dst := NewMemoryCache()
+	if _, err := dst.LoadBinary(bytes.NewReader(data), decodeString); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Fatalf("LoadBinary returned %v, want ErrCorruptSnapshot", err)
+	}
+	if dst.Len() != 0 {
+		t.Errorf("LoadBinary of a corrupt snapshot loaded %d entries", dst.Len())
+	}
+}
+
+func TestLoadBinaryRejectsOtherVersions(t *testing.T) {
+	data := binarySnapshot(t)
+	binary.BigEndian.PutUint16(data[len(snapshotMagic):], snapshotVersion+1)
+	if _, err := NewMemoryCache().LoadBinary(bytes.NewReader(data), decodeString); !errors.Is(err, ErrSnapshotVersion) {
+		t.Errorf("LoadBinary returned %v, want ErrSnapshotVersion", err)
+	}
+
+	data = binarySnapshot(t)
+	copy(data, "JSON")
+	if _, err := NewMemoryCache().LoadBinary(bytes.NewReader(data), decodeString); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("LoadBinary of a file with the wrong magic returned %v, want ErrCorruptSnapshot", err)
+	}
+}
+
+func TestLoadBinaryReturnsDecodeErrors(t *testing.T) {
+	failed := errors.New("bad value")
+	_, err := NewMemoryCache().LoadBinary(bytes.NewReader(binarySnapshot(t)), func([]byte) (any, error) {
+		return nil, failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("LoadBinary returned %v, want the decoder's error", err)
+	}
+}
+
+func TestBinarySnapshotFile(t *testing.T) {
+	type point struct{ X, Y int }
+	gob.Register(point{})
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	src, clock := NewTestCache()
+	src.Set("s", "text", time.Hour)
+	src.Set("p", point{1, 2}, time.Hour)
+	src.Set("short", 7, time.Minute)
+	if err := src.SaveBinaryFile(path, GobEncode); err != nil {
+		t.Fatalf("SaveBinaryFile returned %v", err)
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+
+	clock.Advance(30 * time.Minute)
+	dst := NewMemoryCache(WithClock(clock))
+	n, err := dst.LoadBinaryFile(path, GobDecode)
+	if err != nil || n != 2 {
+		t.Fatalf("LoadBinaryFile got (%d, %v), want (2, nil)", n, err)
+	}
+	if value, _ := dst.Get("p"); value != (point{1, 2}) {
+		t.Errorf("Get(p) = %v, want {1 2}", value)
+	}
+	if remaining, _ := dst.TTL("s"); remaining != 30*time.Minute {
+		t.Errorf("TTL(s) = %v, want 30m", remaining)
+	}
+
+	if _, err := dst.LoadBinaryFile(path+".missing", GobDecode); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadBinaryFile of a missing file returned %v, want os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,215 @@
+package enigmacache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats is a point-in-time copy of a cache's counters.
+type CacheStats struct {
+	// Hits and Misses count reads which found a key and which didn't,
+	// across Get, GetOrSet and the loader-based getters, and Sets
+	// counts writes stored.
+	Hits   uint64
+	Misses uint64
+	Sets   uint64
+	// Entries is the number of entries in the cache, and Bytes their
+	// estimated memory use (see WithMaxMemory). Both include entries
+	// past their deadline which haven't been removed yet.
+	Entries int64
+	Bytes   int64
+	// Rejected counts writes turned away by the admission filter or
+	// for being bigger than the whole byte budget.
+	Rejected uint64
+	// CallbackPanics counts panics recovered from user-supplied
+	// callbacks; see WithPanicRecovery.
+	CallbackPanics uint64
+	// SlowCallbacks counts user-supplied callbacks which ran past the
+	// timeout set by WithCallbackTimeout.
+	SlowCallbacks uint64
+	// ShortenedTTLs counts overwrites which would have shortened a
+	// key's remaining life; see WithTTLShorteningPolicy.
+	ShortenedTTLs uint64
+	// TapDropped counts entries the expiry tap couldn't keep up with;
+	// see WithExpiryTap.
+	TapDropped uint64
+	// DecompressFailures counts reads of values stored compressed
+	// which failed to decompress; see WithCompression.
+	DecompressFailures uint64
+	// Expired, Removed, Evicted, Cleared and Demoted count entries
+	// which left the cache, by EvictionReason: ReasonExpired,
+	// ReasonManual, ReasonCapacity, ReasonCleared and ReasonDemoted
+	// respectively. Overwrites don't count.
+	Expired uint64
+	Removed uint64
+	Evicted uint64
+	Cleared uint64
+	Demoted uint64
+	// Uptime is how long the cache has existed, by its clock, and
+	// HitsPerSecond and SetsPerSecond are Hits and Sets averaged
+	// over the time since the counters were last reset (see
+	// ResetStats), or over Uptime if they never have been.
+	Uptime        time.Duration
+	HitsPerSecond float64
+	SetsPerSecond float64
+}
+
+type stats struct {
+	hits               atomic.Uint64
+	misses             atomic.Uint64
+	sets               atomic.Uint64
+	rejected           atomic.Uint64
+	callbackPanics     atomic.Uint64
+	slowCallbacks      atomic.Uint64
+	shortenedTTLs      atomic.Uint64
+	tapDropped         atomic.Uint64
+	decompressFailures atomic.Uint64
+	// left counts entries leaving the cache, indexed by
+	// EvictionReason.
+	left [ReasonDemoted + 1]atomic.Uint64
+	// resets counts calls to ResetStats, and resetAt is the UnixNano
+	// time of the last, by the cache's clock.
+	resets  atomic.Uint64
+	resetAt atomic.Int64
+}
+
+// reset zeroes the counters.
+func (s *stats) reset() {
+	for _, c := range []*atomic.Uint64{
+		&s.hits, &s.misses, &s.sets, &s.rejected, &s.callbackPanics,
+		&s.slowCallbacks, &s.shortenedTTLs, &s.tapDropped,
+		&s.decompressFailures,
+	} {
+		c.Store(0)
+	}
+	for i := range s.left {
+		s.left[i].Store(0)
+	}
+}
+
+// Stats returns the cache's counters.
+func (mc *MemoryCache) Stats() CacheStats {
+	uptime := mc.Uptime()
+	stats := CacheStats{
+		Hits:               mc.stats.hits.Load(),
+		Misses:             mc.stats.misses.Load(),
+		Sets:               mc.stats.sets.Load(),
+		Entries:            mc.count.Load(),
+		Bytes:              mc.bytes.Load(),
+		Rejected:           mc.stats.rejected.Load(),
+		CallbackPanics:     mc.stats.callbackPanics.Load(),
+		SlowCallbacks:      mc.stats.slowCallbacks.Load(),
+		ShortenedTTLs:      mc.stats.shortenedTTLs.Load(),
+		TapDropped:         mc.stats.tapDropped.Load(),
+		DecompressFailures: mc.stats.decompressFailures.Load(),
+		Expired:            mc.stats.left[ReasonExpired].Load(),
+		Removed:            mc.stats.left[ReasonManual].Load(),
+		Evicted:            mc.stats.left[ReasonCapacity].Load(),
+		Cleared:            mc.stats.left[ReasonCleared].Load(),
+		Demoted:            mc.stats.left[ReasonDemoted].Load(),
+		Uptime:             uptime,
+	}
+	counting := uptime
+	if at := mc.stats.resetAt.Load(); at != 0 {
+		counting = mc.now().Sub(time.Unix(0, at))
+	}
+	if secs := counting.Seconds(); secs > 0 {
+		stats.HitsPerSecond = float64(stats.Hits) / secs
+		stats.SetsPerSecond = float64(stats.Sets) / secs
+	}
+	return stats
+}
+
+// ResetStats sets the counters Stats reports back to zero, as if the
+// cache had just been created, for measuring from a known point. The
+// gauges, Entries and Bytes, are unaffected, as is Uptime. Counters
+// are zeroed one at a time, so a write racing with ResetStats may be
+// counted in some and not others. A sampler (see StartSampling)
+// running across the reset reports the counts since it as its next
+// delta.
+func (mc *MemoryCache) ResetStats() {
+	mc.stats.resets.Add(1)
+	mc.stats.resetAt.Store(mc.now().UnixNano())
+	mc.stats.reset()
+}
+
+// Uptime returns how long it's been since the cache was created, by
+// its clock.
+func (mc *MemoryCache) Uptime() time.Duration {
+	return mc.now().Sub(mc.created)
+}
+
+// DebugInfo describes the cache's internal bookkeeping, to help pin
+// down where memory is going.
+type DebugInfo struct {
+	// LiveEntries is the number of entries readers can see, and
+	// StoredEntries the number actually held, including any past
+	// their deadline which haven't been removed yet.
+	LiveEntries   int
+	StoredEntries int
+	// Timers is the number of entries scheduled to expire, all of
+	// which share a single timer.
+	Timers int64
+	// Bytes is the estimated memory retained by stored entries.
+	Bytes int64
+	// PolicyEntries is the number of keys the eviction policy tracks;
+	// it's zero for an unbounded cache, which has no policy.
+	PolicyEntries int
+}
+
+// DebugStats reports on the cache's internals. Unlike Stats, it scans
+// every entry, taking O(n) time.
+func (mc *MemoryCache) DebugStats() DebugInfo {
+	var info DebugInfo
+	mc.storage.Range(func(_ string, e *entry) bool {
+		info.StoredEntries++
+		if e.live(mc.now()) {
+			info.LiveEntries++
+		}
+		return true
+	})
+	info.Timers = mc.timers.Load()
+	info.Bytes = mc.bytes.Load()
+	if mc.policy != nil {
+		mc.policyMu.Lock()
+		info.PolicyEntries = mc.policy.len()
+		mc.policyMu.Unlock()
+	}
+	return info
+}
+
+// A ShardStat describes one shard of a cache built WithShards.
+type ShardStat struct {
+	// Entries is the number of entries the shard holds, including any
+	// past their deadline which haven't been removed yet.
+	Entries int
+	// Bytes is the estimated memory retained by the shard's entries.
+	Bytes int64
+	// Contended estimates lock contention: it's the number of times
+	// an operation found the shard locked and had to wait, since the
+	// cache was built.
+	Contended uint64
+}
+
+// ShardStats reports on each shard of a cache built WithShards, in
+// shard order, to show up a skewed keyspace or a poor hash (see
+// WithHasher) piling keys into a few shards. It's nil for an unsharded
+// cache. Like DebugStats, it scans every entry, taking O(n) time.
+func (mc *MemoryCache) ShardStats() []ShardStat {
+	sb, ok := mc.storage.(*shardedBackend)
+	if !ok {
+		return nil
+	}
+	stats := make([]ShardStat, len(sb.shards))
+	for i := range sb.shards {
+		sh := &sb.shards[i]
+		sh.mu.RLock()
+		stats[i].Entries = len(sh.entries)
+		for _, e := range sh.entries {
+			stats[i].Bytes += e.cost
+		}
+		sh.mu.RUnlock()
+		stats[i].Contended = sh.contended.Load()
+	}
+	return stats
+}
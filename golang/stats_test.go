@@ -0,0 +1,142 @@
+package enigmacache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDebugStats(t *testing.T) {
+	cache := NewMemoryCache(WithMaxEntries(100))
+	for i := range 10 {
+		cache.Set(fmt.Sprint(i), i, time.Minute)
+	}
+	cache.Set("0", 0, time.Minute) // overwrites stop the old timer
+	cache.Refresh("1", time.Hour)  // and so do refreshes
+
+	info := cache.DebugStats()
+	want := DebugInfo{LiveEntries: 10, StoredEntries: 10, Timers: 10, Bytes: info.Bytes, PolicyEntries: 10}
+	if info != want || info.Bytes <= 0 {
+		t.Fatalf("DebugStats = %+v, want %+v", info, want)
+	}
+
+	cache.ExpireAll()
+	if info := cache.DebugStats(); info != (DebugInfo{}) {
+		t.Fatalf("after ExpireAll, DebugStats = %+v, want all zero", info)
+	}
+}
+
+func TestDebugStatsTimersFire(t *testing.T) {
+	cache, clock := NewTestCache()
+	cache.Set("key", "value", 10*time.Millisecond)
+	clock.Advance(50 * time.Millisecond)
+	if n := cache.DebugStats().Timers; n != 0 {
+		t.Fatalf("Timers = %d after expiry, want 0", n)
+	}
+}
+
+func TestUptimeAndRates(t *testing.T) {
+	cache, clock := NewTestCache()
+	if stats := cache.Stats(); stats.Uptime != 0 || stats.HitsPerSecond != 0 {
+		t.Fatalf("new cache has uptime %v and %v hits/s", stats.Uptime, stats.HitsPerSecond)
+	}
+	cache.Set("key", 1, time.Hour)
+	for range 20 {
+		cache.Get("key")
+	}
+	clock.Advance(10 * time.Second)
+
+	if d := cache.Uptime(); d != 10*time.Second {
+		t.Fatalf("Uptime = %v, want 10s", d)
+	}
+	stats := cache.Stats()
+	if stats.Uptime != 10*time.Second || stats.HitsPerSecond != 2 || stats.SetsPerSecond != 0.1 {
+		t.Fatalf("after 10s Stats has uptime %v, %v hits/s and %v sets/s, want 10s, 2 and 0.1",
+			stats.Uptime, stats.HitsPerSecond, stats.SetsPerSecond)
+	}
+}
+
+func TestStatsCountsDepartures(t *testing.T) {
+	cache, clock := NewTestCache(WithMaxEntries(2))
+	cache.Set("expires", 1, time.Second)
+	cache.Set("removed", 2, time.Hour)
+	cache.Set("removed", 2, time.Hour) // overwrites don't count
+	cache.Expire("removed")
+	clock.Advance(time.Second)
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, key, time.Hour)
+	}
+	cache.ExpireAll()
+
+	stats := cache.Stats()
+	if stats.Expired != 1 || stats.Removed != 1 || stats.Evicted != 1 || stats.Cleared != 2 {
+		t.Errorf("Stats = %+v, want 1 expired, 1 removed, 1 evicted and 2 cleared", stats)
+	}
+}
+
+func TestResetStats(t *testing.T) {
+	cache, clock := NewTestCache()
+	samples, stop := cache.StartSampling(time.Second)
+	defer stop()
+	cache.Set("a", 1, time.Hour)
+	for range 10 {
+		cache.Get("a")
+	}
+	cache.Get("b")
+	clock.Advance(10 * time.Second)
+	<-samples
+
+	cache.ResetStats()
+	cache.Get("a")
+	clock.Advance(time.Second)
+	stats := cache.Stats()
+	want := CacheStats{Hits: 1, Entries: 1, Bytes: stats.Bytes, Uptime: 11 * time.Second, HitsPerSecond: 1}
+	if stats != want {
+		t.Errorf("Stats after ResetStats = %+v, want %+v", stats, want)
+	}
+	if sample := <-samples; sample.Delta != (CacheStats{Hits: 1}) {
+		t.Errorf("delta across the reset = %+v, want 1 hit", sample.Delta)
+	}
+}
+
+func TestShardStatsShowsImbalance(t *testing.T) {
+	if NewMemoryCache().ShardStats() != nil {
+		t.Fatal("unsharded cache reported shard stats")
+	}
+	cache := NewMemoryCache(WithShards(4), WithHasher(func(string) uint64 { return 2 }))
+	for i := range 100 {
+		cache.Set(fmt.Sprint(i), i, time.Hour)
+	}
+	stats := cache.ShardStats()
+	if len(stats) != 4 {
+		t.Fatalf("got %d shards, want 4", len(stats))
+	}
+	for i, s := range stats {
+		if i == 2 {
+			if s.Entries != 100 || s.Bytes != cache.DebugStats().Bytes {
+				t.Errorf("shard 2 = %+v, want all 100 entries and %d bytes", s, cache.DebugStats().Bytes)
+			}
+		} else if s.Entries != 0 || s.Bytes != 0 {
+			t.Errorf("shard %d = %+v, want it empty", i, s)
+		}
+	}
+
+	// Hold the shard's lock while a reader comes along, which has to
+	// wait for it.
+	sh := &cache.storage.(*shardedBackend).shards[2]
+	sh.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.Get("1")
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for sh.contended.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	sh.mu.Unlock()
+	<-done
+	if got := cache.ShardStats()[2].Contended; got == 0 {
+		t.Error("Contended = 0 after a reader waited for the lock")
+	}
+}
@@ -0,0 +1,152 @@
+package enigmacache
+
+import (
+	"sync"
+	"time"
+)
+
+// A Backend is storage for a MemoryCache's entries, for plugging in a
+// map of your own in place of the cache's sync.Map or shards (see
+// WithBackend). Its methods have the semantics of the corresponding
+// sync.Map methods, specialized to string keys and StoredEntry
+// values, and must be safe for concurrent use. The cache keeps all its
+// bookkeeping, expiration included, to itself: a Backend only has to
+// store the entries it's given and hand back the same ones.
+//
+// Range needn't take a snapshot, but f may call the Backend's other
+// methods, so Range mustn't hold a lock they take.
+type Backend interface {
+	Load(key string) (e StoredEntry, ok bool)
+	LoadOrStore(key string, e StoredEntry) (actual StoredEntry, loaded bool)
+	LoadAndDelete(key string) (e StoredEntry, loaded bool)
+	Swap(key string, e StoredEntry) (previous StoredEntry, loaded bool)
+	CompareAndSwap(key string, old, new StoredEntry) (swapped bool)
+	CompareAndDelete(key string, old StoredEntry) (deleted bool)
+	Range(f func(key string, e StoredEntry) bool)
+	Clear()
+}
+
+// A StoredEntry is a cache entry as a Backend stores it. It's an
+// opaque, comparable handle: two StoredEntries are equal only if
+// they're the same entry, so CompareAndSwap and CompareAndDelete can
+// compare them with ==.
+type StoredEntry struct {
+	e *entry
+}
+
+// ExpiresAt returns when the entry expires, taking any idle timeout
+// into account as of its last read. It's the zero Time for an entry
+// which never expires (see NoExpiration).
+func (e StoredEntry) ExpiresAt() time.Time {
+	if d := e.e.deadline(); !d.Equal(never) {
+		return d
+	}
+	return time.Time{}
+}
+
+// Cost returns the entry's cost, as counted against WithMaxMemory.
+func (e StoredEntry) Cost() int64 {
+	return e.e.cost
+}
+
+// WithBackend stores the cache's entries in b rather than in the
+// cache's own map, overriding WithShards. b should be empty, and
+// shouldn't be shared with another cache.
+//
+// The cache's own maps take snapshots (for Save, Range and the like)
+// and replace their contents (for ReplaceAll) in a single step.
+// Over b, a snapshot holds off the cache's writes while it ranges over
+// b, and replacing b's contents blocks writes until it's done, but a
+// read in the meantime may see a mixture of the old contents and the
+// new.
+func WithBackend(b Backend) Option {
+	return func(o *options) {
+		o.backend = b
+	}
+}
+
+// customBackend adapts a Backend to the cache's backend. Like
+// syncMapBackend, it has writers hold swapMu for reading, so Snapshot
+// and Replace can hold them off.
+type customBackend struct {
+	b      Backend
+	swapMu sync.RWMutex
+}
+
+// unwrap returns the entry e holds, reporting ok as given.
+func unwrap(e StoredEntry, ok bool) (*entry, bool) {
+	return e.e, ok
+}
+
+func (c *customBackend) Load(key string) (*entry, bool) {
+	return unwrap(c.b.Load(key))
+}
+
+func (c *customBackend) LoadOrStore(key string, e *entry) (*entry, bool) {
+	c.swapMu.RLock()
+	defer c.swapMu.RUnlock()
+	return unwrap(c.b.LoadOrStore(key, StoredEntry{e}))
+}
+
+func (c *customBackend) LoadAndDelete(key string) (*entry, bool) {
+	c.swapMu.RLock()
+	defer c.swapMu.RUnlock()
+	return unwrap(c.b.LoadAndDelete(key))
+}
+
+func (c *customBackend) Swap(key string, e *entry) (*entry, bool) {
+	c.swapMu.RLock()
+	defer c.swapMu.RUnlock()
+	return unwrap(c.b.Swap(key, StoredEntry{e}))
+}
+
+func (c *customBackend) CompareAndSwap(key string, old, new *entry) bool {
+	c.swapMu.RLock()
+	defer c.swapMu.RUnlock()
+	return c.b.CompareAndSwap(key, StoredEntry{old}, StoredEntry{new})
+}
+
+func (c *customBackend) CompareAndDelete(key string, old *entry) bool {
+	c.swapMu.RLock()
+	defer c.swapMu.RUnlock()
+	return c.b.CompareAndDelete(key, StoredEntry{old})
+}
+
+func (c *customBackend) Range(f func(key string, e *entry) bool) {
+	c.b.Range(func(key string, e StoredEntry) bool {
+		return f(key, e.e)
+	})
+}
+
+func (c *customBackend) Clear() {
+	c.swapMu.RLock()
+	defer c.swapMu.RUnlock()
+	c.b.Clear()
+}
+
+func (c *customBackend) Snapshot() []map[string]*entry {
+	c.swapMu.Lock()
+	defer c.swapMu.Unlock()
+	return []map[string]*entry{c.contents()}
+}
+
+func (c *customBackend) Replace(entries map[string]*entry) map[string]*entry {
+	c.swapMu.Lock()
+	defer c.swapMu.Unlock()
+	old := c.contents()
+	c.b.Clear()
+	for k, e := range entries {
+		c.b.Swap(k, StoredEntry{e})
+	}
+	return old
+}
+
+// contents copies the Backend's entries. c.swapMu must be held.
+func (c *customBackend) contents() map[string]*entry {
+	entries := make(map[string]*entry)
+	c.b.Range(func(key string, e StoredEntry) bool {
+		entries[key] = e.e
+		return true
+	})
+	return entries
+}
@@ -0,0 +1,127 @@
+package enigmacache
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mapBackend is a Backend built on a plain map and a mutex.
+type mapBackend struct {
+	mu      sync.Mutex
+	entries map[string]StoredEntry
+}
+
+func newMapBackend() *mapBackend {
+	return &mapBackend{entries: make(map[string]StoredEntry)}
+}
+
+func (b *mapBackend) Load(key string) (StoredEntry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.entries[key]
+	return e, ok
+}
+
+func (b *mapBackend) LoadOrStore(key string, e StoredEntry) (StoredEntry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if actual, ok := b.entries[key]; ok {
+		return actual, true
+	}
+	b.entries[key] = e
+	return e, false
+}
+
+func (b *mapBackend) LoadAndDelete(key string) (StoredEntry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.entries[key]
+	delete(b.entries, key)
+	return e, ok
+}
+
+func (b *mapBackend) Swap(key string, e StoredEntry) (StoredEntry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prev, ok := b.entries[key]
+	b.entries[key] = e
+	return prev, ok
+}
+
+func (b *mapBackend) CompareAndSwap(key string, old, new StoredEntry) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.entries[key]; !ok || e != old {
+		return false
+	}
+	b.entries[key] = new
+	return true
+}
+
+func (b *mapBackend) CompareAndDelete(key string, old StoredEntry) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.entries[key]; !ok || e != old {
+		return false
+	}
+	delete(b.entries, key)
+	return true
+}
+
+func (b *mapBackend) Range(f func(key string, e StoredEntry) bool) {
+	b.mu.Lock()
+	keys := make([]string, 0, len(b.entries))
+	entries := make([]StoredEntry, 0, len(b.entries))
+	for k, e := range b.entries {
+		keys = append(keys, k)
+		entries = append(entries, e)
+	}
+	b.mu.Unlock()
+	for i, k := range keys {
+		if !f(k, entries[i]) {
+			return
+		}
+	}
+}
+
+func (b *mapBackend) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	clear(b.entries)
+}
+
+func TestWithBackend(t *testing.T) {
+	b := newMapBackend()
+	cache, clock := NewTestCache(WithBackend(b))
+	cache.Set("a", "apple", time.Minute)
+	cache.Set("b", "banana", time.Hour)
+	if value, ok := cache.Get("a"); !ok || value != "apple" {
+		t.Errorf("Get(a) = %v, %v; want apple, true", value, ok)
+	}
+	e, ok := b.Load("b")
+	if !ok || !e.ExpiresAt().Equal(clock.Now().Add(time.Hour)) {
+		t.Errorf("backend holds b = %v, %v, expiring at %v", e, ok, e.ExpiresAt())
+	}
+
+	clock.Advance(time.Minute)
+	if _, ok := b.Load("a"); ok {
+		t.Error("expired entry was left in the backend")
+	}
+
+	var buf bytes.Buffer
+	if err := cache.SaveBinary(&buf, encodeString); err != nil {
+		t.Fatalf("SaveBinary returned %v", err)
+	}
+	cache.ReplaceAll(map[string]WarmEntry{"c": {Value: "cherry", TTL: time.Minute}})
+	if keys := cache.Keys(); len(keys) != 1 || keys[0] != "c" {
+		t.Errorf("Keys = %v after ReplaceAll, want [c]", keys)
+	}
+	if n, err := cache.LoadBinary(&buf, decodeString); err != nil || n != 1 {
+		t.Fatalf("LoadBinary got (%d, %v), want (1, nil)", n, err)
+	}
+	if n := len(b.entries); n != 2 {
+		t.Errorf("backend holds %d entries, want 2", n)
+	}
+}
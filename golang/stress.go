@@ -0,0 +1,183 @@
+package enigmacache
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// StressConfig controls a StressTest run. Zero fields take the
+// defaults noted.
+type StressConfig struct {
+	// Workers is the number of goroutines hammering the cache
+	// (default 8).
+	Workers int
+	// Ops is the number of operations each worker performs (default
+	// 10000).
+	Ops int
+	// Keys is the number of keys each worker owns (default 16).
+	Keys int
+	// Counters is the number of counter keys shared by all workers
+	// (default 4).
+	Counters int
+	// MaxTTL bounds the random TTLs given to the workers' keys, so
+	// some of them expire mid-run (default 5ms).
+	MaxTTL time.Duration
+	// Seed seeds the random choice of operations.
+	Seed uint64
+}
+
+func (cfg *StressConfig) defaults() {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 8
+	}
+	if cfg.Ops <= 0 {
+		cfg.Ops = 10000
+	}
+	if cfg.Keys <= 0 {
+		cfg.Keys = 16
+	}
+	if cfg.Counters <= 0 {
+		cfg.Counters = 4
+	}
+	if cfg.MaxTTL <= 0 {
+		cfg.MaxTTL = 5 * time.Millisecond
+	}
+}
+
+// StressTest hammers c with randomized concurrent Set, Get, Expire,
+// Refresh and Increment calls and checks that the cache's invariants
+// hold, returning an error describing any violations. It's meant for
+// use in tests, run with -race, to check a cache configured with
+// whatever options the caller relies on.
+//
+// Each worker owns its own keys, so it knows what they should hold: a
+// hit must return the value the worker last set (never an older one),
+// and a key the worker has expired must stay gone until it sets it
+// again. Keys may still go missing early, since they expire or get
+// evicted. The counter keys are shared; once the workers are done,
+// each must hold the sum of the increments made to it, unless the
+// cache is bounded and may have evicted it. Finally, Len must agree
+// with the number of entries actually stored. c should not be used
+// for anything else during the run.
+func StressTest(c *MemoryCache, cfg StressConfig) error {
+	cfg.defaults()
+
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		violations []error
+		increments = make([]int64, cfg.Counters)
+	)
+	report := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		violations = append(violations, err)
+	}
+
+	for w := range cfg.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(cfg.Seed, uint64(w)))
+			// want holds the value each key was last set to, or is
+			// missing the key if the worker has expired it.
+			want := make(map[string]int, cfg.Keys)
+			added := make([]int64, cfg.Counters)
+			for op := range cfg.Ops {
+				key := fmt.Sprintf("stress/%d/%d", w, rng.IntN(cfg.Keys))
+				switch rng.IntN(5) {
+				case 0:
+					ttl := time.Duration(rng.Int64N(int64(cfg.MaxTTL))) + 1
+					c.Set(key, op, ttl)
+					want[key] = op
+				case 1:
+					v, ok := c.Get(key)
+					if err := checkRead(key, want, v, ok, "Get"); err != nil {
+						report(err)
+					}
+				case 2:
+					v, ok := c.Expire(key)
+					if err := checkRead(key, want, v, ok, "Expire"); err != nil {
+						report(err)
+					}
+					delete(want, key)
+				case 3:
+					ttl := time.Duration(rng.Int64N(int64(cfg.MaxTTL))) + 1
+					if _, ok := want[key]; !ok && c.Refresh(key, ttl) {
+						report(fmt.Errorf("Refresh revived expired key %q", key))
+					}
+				case 4:
+					i := rng.IntN(cfg.Counters)
+					if _, err := c.Increment(counterKey(i), 1, time.Hour); err != nil {
+						report(err)
+						continue
+					}
+					added[i]++
+				}
+			}
+			mu.Lock()
+			for i, n := range added {
+				increments[i] += n
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if !c.bounded() {
+		for i, want := range increments {
+			got, _ := c.Get(counterKey(i))
+			if got == nil {
+				got = int64(0)
+			}
+			if got != want {
+				violations = append(violations, fmt.Errorf("counter %q = %v after %d increments", counterKey(i), got, want))
+			}
+		}
+	}
+	if err := checkLen(c); err != nil {
+		violations = append(violations, err)
+	}
+	return errors.Join(violations...)
+}
+
+// checkRead validates the result of a read of key against what its
+// owner last wrote.
+func checkRead(key string, want map[string]int, value any, ok bool, op string) error {
+	expected, present := want[key]
+	switch {
+	case ok && !present:
+		return fmt.Errorf("%s resurrected expired key %q with %v", op, key, value)
+	case ok && value != expected:
+		return fmt.Errorf("%s of %q = %v, want %v", op, key, value, expected)
+	}
+	return nil
+}
+
+func counterKey(i int) string {
+	return fmt.Sprintf("stress/counter/%d", i)
+}
+
+// checkLen checks that Len agrees with the number of entries in
+// storage. Expiration timers may still be running, so we look for a
+// moment where the two agree and Len holds steady across the count.
+func checkLen(c *MemoryCache) error {
+	var before, stored, after int
+	for range 100 {
+		before = c.Len()
+		stored = 0
+		c.storage.Range(func(string, *entry) bool {
+			stored++
+			return true
+		})
+		after = c.Len()
+		if before == stored && stored == after {
+			return nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return fmt.Errorf("Len = %d, but %d entries are stored", after, stored)
+}
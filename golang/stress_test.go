@@ -0,0 +1,23 @@
+package enigmacache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStressTest(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"default":  nil,
+		"sharded":  {WithShards(8)},
+		"bounded":  {WithMaxEntries(32)},
+		"stale":    {WithStaleIfError(time.Millisecond)},
+		"compress": {WithValueCompression(1)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := StressConfig{Ops: 2000}
+			if err := StressTest(NewMemoryCache(opts...), cfg); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
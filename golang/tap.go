@@ -0,0 +1,151 @@
+package enigmacache
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// tapBuffer is how many records the expiry tap holds while its writer
+// catches up; past that, records are dropped.
+const tapBuffer = 4096
+
+// A Serializer writes values to a stream, one after another, in some
+// encoding which can tell them apart again.
+type Serializer interface {
+	Encode(w io.Writer, v any) error
+}
+
+// JSONSerializer writes each value as a line of JSON.
+var JSONSerializer Serializer = jsonSerializer{}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Encode(w io.Writer, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// A TapRecord is an entry written to the expiry tap as it leaves the
+// cache: its key and value, the TTL it was last given, which is how
+// long it lived from being set until its deadline, and why and when
+// it left.
+type TapRecord struct {
+	Key    string         `json:"key"`
+	Value  any            `json:"value"`
+	TTL    time.Duration  `json:"ttl"`
+	Reason EvictionReason `json:"reason"`
+	At     time.Time      `json:"at"`
+}
+
+// WithExpiryTap streams entries which expire to w as TapRecords
+// encoded by enc, so they can be archived rather than lost. Only
+// entries whose TTL ran out are written, unless WithExpiryTapReasons
+// says otherwise. The writes happen on a goroutine of their own,
+// through a buffer flushed whenever the tap catches up, so the
+// removal never waits on w; if w falls so far behind that the tap's
+// queue fills, records are dropped and counted in
+// Stats().TapDropped. Records are written in the order entries leave
+// the cache, and count as in-flight work for Drain until they're
+// flushed. Errors from enc or w are logged (see WithLogger) and the
+// record skipped.
+func WithExpiryTap(w io.Writer, enc Serializer) Option {
+	return func(o *options) {
+		o.tapWriter = w
+		o.tapSerializer = enc
+	}
+}
+
+// WithExpiryTapReasons sets the reasons for leaving the cache which
+// get an entry written to the expiry tap (see WithExpiryTap), such as
+// ReasonExpired and ReasonManual to archive deletes too. The default
+// is ReasonExpired alone.
+func WithExpiryTapReasons(reasons ...EvictionReason) Option {
+	return func(o *options) {
+		o.tapReasons = reasons
+	}
+}
+
+// An expiryTap queues TapRecords for a single writer goroutine, which
+// is started when records arrive and exits once it has written and
+// flushed them all.
+type expiryTap struct {
+	out     io.Writer
+	w       *bufio.Writer
+	enc     Serializer
+	reasons map[EvictionReason]bool
+
+	mu      sync.Mutex
+	running bool
+	queue   []TapRecord
+}
+
+func newExpiryTap(w io.Writer, enc Serializer, reasons []EvictionReason) *expiryTap {
+	if len(reasons) == 0 {
+		reasons = []EvictionReason{ReasonExpired}
+	}
+	t := &expiryTap{out: w, w: bufio.NewWriter(w), enc: enc, reasons: make(map[EvictionReason]bool)}
+	for _, r := range reasons {
+		t.reasons[r] = true
+	}
+	return t
+}
+
+// tapped queues e, which has just left the cache for reason, for the
+// expiry tap, if the tap takes entries which left for that reason.
+func (mc *MemoryCache) tapped(key string, e *entry, reason EvictionReason) {
+	t := mc.tap
+	if !t.reasons[reason] {
+		return
+	}
+	r := TapRecord{
+		Key:    key,
+		Value:  e.get(),
+		TTL:    time.Duration(e.deadline().UnixNano() - e.created),
+		Reason: reason,
+		At:     mc.now(),
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.queue) >= tapBuffer {
+		mc.stats.tapDropped.Add(1)
+		return
+	}
+	t.queue = append(t.queue, r)
+	if !t.running {
+		t.running = true
+		mc.tasks.start()
+		go mc.writeTap()
+	}
+}
+
+// writeTap writes the tap's queue until it's empty, flushing each
+// time it gets there.
+func (mc *MemoryCache) writeTap() {
+	t := mc.tap
+	defer mc.tasks.done()
+	for {
+		t.mu.Lock()
+		if len(t.queue) == 0 {
+			t.running = false
+			t.mu.Unlock()
+			return
+		}
+		batch := t.queue
+		t.queue = nil
+		t.mu.Unlock()
+
+		for _, r := range batch {
+			if err := t.enc.Encode(t.w, r); err != nil {
+				mc.logger().Warn("enigma-cache: expiry tap write failed", "key", r.Key, "error", err)
+			}
+		}
+		if err := t.w.Flush(); err != nil {
+			mc.logger().Warn("enigma-cache: expiry tap flush failed", "error", err)
+			// A bufio.Writer which has failed keeps failing; start
+			// afresh, giving up on what it held.
+			t.w.Reset(t.out)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package enigmacache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// A tapLine is a TapRecord as JSONSerializer writes it.
+type tapLine struct {
+	Key    string        `json:"key"`
+	Value  any           `json:"value"`
+	TTL    time.Duration `json:"ttl"`
+	Reason string        `json:"reason"`
+}
+
+// tapOutput decodes the JSON lines written to an expiry tap.
+func tapOutput(t *testing.T, buf *bytes.Buffer) []tapLine {
+	t.Helper()
+	var out []tapLine
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var r tapLine
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("decoding tap output: %v", err)
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func TestExpiryTapWritesExpiredEntriesInOrder(t *testing.T) {
+	var buf bytes.Buffer
+	mc, clock := NewTestCache(WithExpiryTap(&buf, JSONSerializer))
+	mc.Set("c", "third", 3*time.Second)
+	mc.Set("a", "first", time.Second)
+	mc.Set("b", "second", 2*time.Second)
+	mc.Set("kept", "deleted by hand", time.Second)
+	mc.Expire("kept")
+
+	clock.Advance(3 * time.Second)
+	if err := mc.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := tapOutput(t, &buf)
+	if len(got) != 3 {
+		t.Fatalf("tap wrote %d records, want 3: %+v", len(got), got)
+	}
+	for i, want := range []struct {
+		key, value string
+		ttl        time.Duration
+	}{{"a", "first", time.Second}, {"b", "second", 2 * time.Second}, {"c", "third", 3 * time.Second}} {
+		if r := got[i]; r.Key != want.key || r.Value != want.value || r.TTL != want.ttl || r.Reason != "expired" {
+			t.Errorf("record %d = %+v, want %s=%s with TTL %v", i, r, want.key, want.value, want.ttl)
+		}
+	}
+}
+
+func TestExpiryTapReasons(t *testing.T) {
+	var buf bytes.Buffer
+	mc, clock := NewTestCache(WithExpiryTap(&buf, JSONSerializer), WithExpiryTapReasons(ReasonManual))
+	mc.Set("expires", 1, time.Second)
+	mc.Set("deleted", 2, time.Hour)
+	mc.Expire("deleted")
+	clock.Advance(time.Second)
+	mc.Drain(context.Background())
+
+	if got := tapOutput(t, &buf); len(got) != 1 || got[0].Key != "deleted" || got[0].Reason != "manual" {
+		t.Errorf("tap wrote %+v, want only the deleted key", got)
+	}
+}
+
+// stuckWriter is an io.Writer whose writes wait for release to close.
+type stuckWriter struct {
+	release chan struct{}
+	bytes.Buffer
+}
+
+func (w *stuckWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.Buffer.Write(p)
+}
+
+func TestExpiryTapDropsOnBackpressure(t *testing.T) {
+	w := &stuckWriter{release: make(chan struct{})}
+	mc, clock := NewTestCache(WithExpiryTap(w, JSONSerializer))
+	// Each record is bigger than the tap's buffer, so the writer
+	// blocks on the first batch it takes, which may be a whole queue's
+	// worth, and the rest pile up behind it.
+	big := string(bytes.Repeat([]byte("x"), 8192))
+	const n = 2*tapBuffer + 100
+	for i := range n {
+		mc.Set(fmt.Sprint(i), big, time.Second)
+	}
+	clock.Advance(time.Second)
+	dropped := mc.Stats().TapDropped
+	if dropped == 0 {
+		t.Error("TapDropped = 0 with the tap's writer stuck")
+	}
+	close(w.release)
+	mc.Drain(context.Background())
+	if lines := bytes.Count(w.Bytes(), []byte("\n")); uint64(lines)+dropped != n {
+		t.Errorf("tap wrote %d records and dropped %d, want %d in all", lines, dropped, n)
+	}
+}
@@ -0,0 +1,71 @@
+package enigmacache
+
+import (
+	"context"
+	"sync"
+)
+
+// A taskTracker counts work the cache has in flight, so Drain can
+// wait for it to settle. Unlike a sync.WaitGroup it's fine to start
+// new tasks while someone is waiting. The zero taskTracker is ready
+// for use.
+type taskTracker struct {
+	mu sync.Mutex
+	n  int
+	// idle is closed when n drops to zero. It's nil while no one is
+	// waiting, and replaced the next time someone waits.
+	idle chan struct{}
+}
+
+func (t *taskTracker) start() {
+	t.mu.Lock()
+	t.n++
+	t.mu.Unlock()
+}
+
+func (t *taskTracker) done() {
+	t.mu.Lock()
+	t.n--
+	if t.n == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+	t.mu.Unlock()
+}
+
+// wait blocks until no tasks are in flight or ctx is done.
+func (t *taskTracker) wait(ctx context.Context) error {
+	t.mu.Lock()
+	if t.n == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Drain blocks until the work the cache has in flight has finished,
+// or until ctx is done, in which case it returns ctx.Err(). In-flight
+// work covers loader calls, revalidations of stale entries (see
+// WithStaleWhileRevalidate), expiration callbacks, writes to the
+// write-through store abandoned by SetContext, records waiting for
+// the expiry tap, compactions of the WithPersistence journal, and
+// writes queued by WithWriteBehind, which Drain flushes first. Drain
+// doesn't stop new work from starting; if work keeps arriving, Drain
+// returns at the first moment none is in flight.
+func (mc *MemoryCache) Drain(ctx context.Context) error {
+	if mc.behind != nil {
+		mc.logFlush(mc.Flush(ctx))
+	}
+	return mc.tasks.wait(ctx)
+}
@@ -0,0 +1,58 @@
+package enigmacache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDrainWaitsForLoaders(t *testing.T) {
+	cache := NewMemoryCache()
+	started := make(chan struct{})
+	var finished atomic.Int32
+	for _, key := range []string{"a", "b", "c"} {
+		go cache.GetOrComputeDetailed(key, time.Minute, func(context.Context) (any, error) {
+			started <- struct{}{}
+			time.Sleep(50 * time.Millisecond)
+			finished.Add(1)
+			return key, nil
+		})
+	}
+	for i := 0; i < 3; i++ {
+		<-started
+	}
+
+	if err := cache.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain returned %v", err)
+	}
+	if n := finished.Load(); n != 3 {
+		t.Fatalf("Drain returned with %d of 3 loaders finished", n)
+	}
+}
+
+func TestDrainHonorsContext(t *testing.T) {
+	cache := NewMemoryCache()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	go cache.GetOrComputeDetailed("key", time.Minute, func(context.Context) (any, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := cache.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Drain returned %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestDrainIdle(t *testing.T) {
+	if err := NewMemoryCache().Drain(context.Background()); err != nil {
+		t.Fatalf("Drain on an idle cache returned %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package enigmacache
+
+import "time"
+
+// A TieredCache puts a fast local cache, usually a MemoryCache, in
+// front of a shared remote one, such as a redis Cache, so hot keys are
+// served without a round trip. Reads try the local tier first and fall
+// back to the remote, copying what they find there into the local
+// tier. Writes and removals go to both, the remote first.
+//
+// Local copies can go stale when another instance writes the remote
+// tier. Keep them short-lived with localTTL, and call Invalidate when
+// you learn of a change made elsewhere.
+type TieredCache struct {
+	local, remote ReadWriter
+	localTTL      time.Duration
+}
+
+var _ ReadWriter = (*TieredCache)(nil)
+
+// NewTieredCache returns a TieredCache in front of remote, keeping
+// local copies for at most localTTL. With a localTTL of zero or less,
+// local copies live as long as their remote entries, which a read
+// falling back to the remote tier looks up with an extra TTL call.
+func NewTieredCache(local, remote ReadWriter, localTTL time.Duration) *TieredCache {
+	return &TieredCache{local: local, remote: remote, localTTL: localTTL}
+}
+
+// Local returns the local tier.
+func (t *TieredCache) Local() ReadWriter { return t.local }
+
+// Remote returns the remote tier.
+func (t *TieredCache) Remote() ReadWriter { return t.remote }
+
+// capped returns how long to keep a local copy of an entry with ttl
+// left in the remote tier.
+func (t *TieredCache) capped(ttl time.Duration) time.Duration {
+	if t.localTTL > 0 {
+		return min(ttl, t.localTTL)
+	}
+	return ttl
+}
+
+// backfill copies value, found in the remote tier, into the local one.
+func (t *TieredCache) backfill(key string, value any) {
+	ttl := t.localTTL
+	if ttl <= 0 {
+		remaining, ok := t.remote.TTL(key)
+		if !ok {
+			return
+		}
+		ttl = remaining
+	}
+	t.local.Set(key, value, ttl)
+}
+
+// Get returns the value for key from the local tier, or else from the
+// remote tier, copying it into the local one.
+func (t *TieredCache) Get(key string) (value any, ok bool) {
+	if value, ok := t.local.Get(key); ok {
+		return value, true
+	}
+	value, ok = t.remote.Get(key)
+	if ok {
+		t.backfill(key, value)
+	}
+	return value, ok
+}
+
+// Has reports whether key is present in either tier.
+func (t *TieredCache) Has(key string) bool {
+	return t.local.Has(key) || t.remote.Has(key)
+}
+
+// TTL returns how long key has left in the remote tier, which decides
+// when it expires.
+func (t *TieredCache) TTL(key string) (remaining time.Duration, ok bool) {
+	return t.remote.TTL(key)
+}
+
+// Keys returns the keys present in the remote tier.
+func (t *TieredCache) Keys() []string {
+	return t.remote.Keys()
+}
+
+// Len returns the number of entries in the remote tier.
+func (t *TieredCache) Len() int {
+	return t.remote.Len()
+}
+
+// Set stores value for key in both tiers.
+func (t *TieredCache) Set(key string, value any, ttl time.Duration) {
+	t.remote.Set(key, value, ttl)
+	t.local.Set(key, value, t.capped(ttl))
+}
+
+// GetOrSet returns the value for key from either tier, as Get does,
+// and otherwise stores value in both. Whether value is stored is
+// decided by the remote tier, so instances racing to set a key agree
+// on its value.
+func (t *TieredCache) GetOrSet(key string, value any, ttl time.Duration) (actual any, loaded bool) {
+	if actual, ok := t.local.Get(key); ok {
+		return actual, true
+	}
+	actual, loaded = t.remote.GetOrSet(key, value, ttl)
+	if loaded {
+		t.backfill(key, actual)
+	} else {
+		t.local.Set(key, actual, t.capped(ttl))
+	}
+	return actual, loaded
+}
+
+// Expire removes key from both tiers, returning the value the remote
+// tier held, or failing that the local one.
+func (t *TieredCache) Expire(key string) (value any, loaded bool) {
+	value, loaded = t.remote.Expire(key)
+	if local, ok := t.local.Expire(key); ok && !loaded {
+		return local, true
+	}
+	return value, loaded
+}
+
+// Refresh sets the TTL of key in both tiers, reporting whether the
+// remote tier had it. A local copy of a key the remote tier doesn't
+// have is dropped.
+func (t *TieredCache) Refresh(key string, ttl time.Duration) (refreshed bool) {
+	if !t.remote.Refresh(key, ttl) {
+		t.local.Expire(key)
+		return false
+	}
+	t.local.Refresh(key, t.capped(ttl))
+	return true
+}
+
+// ExpireAll removes every entry from both tiers.
+func (t *TieredCache) ExpireAll() {
+	t.remote.ExpireAll()
+	t.local.ExpireAll()
+}
+
+// Invalidate drops the local copy of key, leaving the remote tier
+// alone, for when another instance has changed or removed it; the
+// next read fetches it afresh.
+func (t *TieredCache) Invalidate(key string) {
+	t.local.Expire(key)
+}
@@ -0,0 +1,86 @@
+package enigmacache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTieredCacheBackfills(t *testing.T) {
+	local, clock := NewTestCache()
+	remote := NewMemoryCache(WithClock(clock))
+	tiered := NewTieredCache(local, remote, time.Minute)
+
+	remote.Set("k", "v", time.Hour)
+	if value, ok := tiered.Get("k"); !ok || value != "v" {
+		t.Fatalf("Get = %v, %v; want v, true", value, ok)
+	}
+	if remaining, ok := local.TTL("k"); !ok || remaining != time.Minute {
+		t.Errorf("local copy has TTL %v, %v; want 1m, true", remaining, ok)
+	}
+	// Served locally now, a remote change goes unseen until the local
+	// copy expires or is invalidated.
+	remote.Set("k", "w", time.Hour)
+	if value, _ := tiered.Get("k"); value != "v" {
+		t.Errorf("Get = %v, want the local copy v", value)
+	}
+	tiered.Invalidate("k")
+	if value, _ := tiered.Get("k"); value != "w" {
+		t.Errorf("Get = %v after Invalidate, want w", value)
+	}
+	if stats := remote.Stats(); stats.Hits != 2 {
+		t.Errorf("remote tier had %d hits, want 2", stats.Hits)
+	}
+}
+
+func TestTieredCacheBackfillKeepsRemoteTTL(t *testing.T) {
+	local, clock := NewTestCache()
+	remote := NewMemoryCache(WithClock(clock))
+	tiered := NewTieredCache(local, remote, 0)
+
+	remote.Set("k", "v", time.Hour)
+	tiered.Get("k")
+	if remaining, _ := local.TTL("k"); remaining != time.Hour {
+		t.Errorf("local copy has TTL %v, want the remote's 1h", remaining)
+	}
+}
+
+func TestTieredCacheWrites(t *testing.T) {
+	local, clock := NewTestCache()
+	remote := NewMemoryCache(WithClock(clock))
+	tiered := NewTieredCache(local, remote, time.Minute)
+
+	tiered.Set("a", 1, time.Hour)
+	if !local.Has("a") || !remote.Has("a") {
+		t.Error("Set didn't write both tiers")
+	}
+	if remaining, _ := local.TTL("a"); remaining != time.Minute {
+		t.Errorf("local TTL = %v, want 1m", remaining)
+	}
+
+	remote.Set("b", 2, time.Hour)
+	if actual, loaded := tiered.GetOrSet("b", 3, time.Hour); !loaded || actual != 2 {
+		t.Errorf("GetOrSet = %v, %v; want 2, true", actual, loaded)
+	}
+	if value, _ := local.Get("b"); value != 2 {
+		t.Errorf("local tier holds %v for b, want 2", value)
+	}
+
+	local.Set("stale", 4, time.Minute)
+	if tiered.Refresh("stale", time.Hour) {
+		t.Error("Refresh of a key only the local tier has reported true")
+	}
+	if local.Has("stale") {
+		t.Error("Refresh left a local copy of a key the remote tier doesn't have")
+	}
+
+	if value, loaded := tiered.Expire("a"); !loaded || value != 1 {
+		t.Errorf("Expire = %v, %v; want 1, true", value, loaded)
+	}
+	if local.Has("a") || remote.Has("a") {
+		t.Error("Expire left the key in a tier")
+	}
+	tiered.ExpireAll()
+	if local.Len() != 0 || remote.Len() != 0 {
+		t.Error("ExpireAll left entries behind")
+	}
+}
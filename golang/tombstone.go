@@ -0,0 +1,41 @@
+package enigmacache
+
+import "time"
+
+// WithTombstones makes deleting a key leave a tombstone behind for
+// ttl, so code polling the cache, a change-data-capture feed say, can
+// tell a key that was removed from one that was never there. Expire,
+// ExpirePrefix, ExpireMatch, and deletes by Update and WithinShard
+// leave tombstones; expiry, eviction and ExpireAll don't, since
+// nothing deleted the key. A tombstoned key reads as missing, except
+// that GetAs and GetResult report ErrTombstoned for it. Setting the
+// key replaces its tombstone. Tombstones take up room like other
+// entries, but they aren't reported to OnEvicted when they go.
+func WithTombstones(ttl time.Duration) Option {
+	return func(o *options) {
+		o.tombstoneTTL = ttl
+	}
+}
+
+// tombstone is stored in place of a deleted key's value.
+type tombstone struct{}
+
+func (e *entry) tombstone() bool {
+	_, ok := e.value.(tombstone)
+	return ok
+}
+
+// entomb leaves a tombstone for key, which has just been deleted,
+// unless it has been written again since.
+func (mc *MemoryCache) entomb(key string) {
+	e := mc.newEntry(key, tombstone{}, mc.opts.tombstoneTTL, 0)
+	if _, loaded := mc.storage.LoadOrStore(key, e); !loaded {
+		mc.storedKeeping(key, e, nil)
+	}
+}
+
+// tombstoned reports whether key holds an unexpired tombstone.
+func (mc *MemoryCache) tombstoned(key string) bool {
+	e, ok := mc.storage.Load(key)
+	return ok && e.tombstone() && !e.expired(mc.now())
+}
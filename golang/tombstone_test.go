@@ -0,0 +1,65 @@
+package enigmacache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTombstones(t *testing.T) {
+	var evicted []any
+	cache, clock := NewTestCache(
+		WithTombstones(time.Minute),
+		WithOnEvicted(func(_ string, value any, _ EvictionReason) {
+			evicted = append(evicted, value)
+		}),
+	)
+	cache.Set("key", "value", time.Hour)
+	cache.Expire("key")
+
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("Get found a tombstoned key")
+	}
+	if _, err := GetAs[string](cache, "key"); !errors.Is(err, ErrTombstoned) {
+		t.Fatalf("GetAs err = %v, want ErrTombstoned", err)
+	}
+	if r, ok := cache.GetResult("key"); !ok || !errors.Is(r.Err, ErrTombstoned) {
+		t.Fatalf("GetResult = %+v, %v, want ErrTombstoned", r, ok)
+	}
+	if _, err := GetAs[string](cache, "never"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetAs of a missing key err = %v, want ErrNotFound", err)
+	}
+
+	// The tombstone expires on its own, without being reported.
+	clock.Advance(time.Minute)
+	if _, err := GetAs[string](cache, "key"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetAs after the tombstone expired err = %v, want ErrNotFound", err)
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("Len = %d after the tombstone expired, want 0", cache.Len())
+	}
+	if len(evicted) != 1 || evicted[0] != "value" {
+		t.Fatalf("OnEvicted saw %v, want [value]", evicted)
+	}
+
+	// Setting a tombstoned key resurrects it.
+	cache.Expire("key")
+	cache.Set("key", "again", time.Hour)
+	if v, err := GetAs[string](cache, "key"); err != nil || v != "again" {
+		t.Fatalf("GetAs after resurrection = %q, %v, want again", v, err)
+	}
+}
+
+func TestTombstonesOnlyForDeletes(t *testing.T) {
+	cache, clock := NewTestCache(WithTombstones(time.Minute))
+	cache.Set("expiring", 1, time.Second)
+	clock.Advance(time.Second)
+	if cache.tombstoned("expiring") {
+		t.Fatal("expiry left a tombstone")
+	}
+	cache.Set("cleared", 1, time.Hour)
+	cache.ExpireAll()
+	if cache.tombstoned("cleared") {
+		t.Fatal("ExpireAll left a tombstone")
+	}
+}
@@ -0,0 +1,70 @@
+package enigmacache
+
+import "time"
+
+// A Cache is a type-safe view of an underlying cache, usually a
+// MemoryCache, whose keys are of type K and values of type V, so
+// callers needn't assert the type of every value they read. Keys are
+// turned into strings as for a TypedKeyCache.
+//
+// A Cache only checks the types of values it reads, not of those
+// written to the underlying cache behind its back: a value which
+// isn't a V reads as missing from Get, and as V's zero value from
+// GetOrSet and Expire.
+type Cache[K comparable, V any] struct {
+	keys *TypedKeyCache[K]
+}
+
+// NewCache returns a Cache of string keys to values of type V, backed
+// by cache.
+func NewCache[V any](cache ReadWriter) *Cache[string, V] {
+	return NewTypedCache[string, V](cache, func(key string) string { return key })
+}
+
+// NewTypedCache returns a Cache backed by cache, using key to turn keys
+// into strings. The key function must be injective; see
+// TypedKeyCache.
+func NewTypedCache[K comparable, V any](cache ReadWriter, key func(K) string) *Cache[K, V] {
+	return &Cache[K, V]{keys: NewTypedKeyCache(cache, key)}
+}
+
+// Cache returns the underlying cache.
+func (c *Cache[K, V]) Cache() ReadWriter {
+	return c.keys.Cache()
+}
+
+// Set is ReadWriter.Set, typed.
+func (c *Cache[K, V]) Set(key K, value V, ttl time.Duration) {
+	c.keys.Set(key, value, ttl)
+}
+
+// Get is ReadWriter.Get, typed.
+func (c *Cache[K, V]) Get(key K) (value V, ok bool) {
+	v, found := c.keys.Get(key)
+	value, ok = v.(V)
+	return value, found && ok
+}
+
+// GetOrSet is ReadWriter.GetOrSet, typed.
+func (c *Cache[K, V]) GetOrSet(key K, value V, ttl time.Duration) (actual V, loaded bool) {
+	v, loaded := c.keys.GetOrSet(key, value, ttl)
+	actual, _ = v.(V)
+	return actual, loaded
+}
+
+// Expire is ReadWriter.Expire, typed.
+func (c *Cache[K, V]) Expire(key K) (value V, loaded bool) {
+	v, loaded := c.keys.Expire(key)
+	value, _ = v.(V)
+	return value, loaded
+}
+
+// Refresh is ReadWriter.Refresh, typed.
+func (c *Cache[K, V]) Refresh(key K, ttl time.Duration) (refreshed bool) {
+	return c.keys.Refresh(key, ttl)
+}
+
+// ExpireAll is ReadWriter.ExpireAll.
+func (c *Cache[K, V]) ExpireAll() {
+	c.keys.Cache().ExpireAll()
+}
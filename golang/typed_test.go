@@ -0,0 +1,49 @@
+package enigmacache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	cache := NewCache[int](NewMemoryCache())
+	cache.Set("n", 42, time.Minute)
+	if n, ok := cache.Get("n"); !ok || n != 42 {
+		t.Errorf("Get = %d, %v; want 42, true", n, ok)
+	}
+	if n, loaded := cache.GetOrSet("n", 7, time.Minute); !loaded || n != 42 {
+		t.Errorf("GetOrSet of a present key = %d, %v; want 42, true", n, loaded)
+	}
+	if n, loaded := cache.GetOrSet("m", 7, time.Minute); loaded || n != 7 {
+		t.Errorf("GetOrSet of a missing key = %d, %v; want 7, false", n, loaded)
+	}
+	if !cache.Refresh("n", time.Hour) {
+		t.Error("Refresh did not find the key")
+	}
+	if n, loaded := cache.Expire("n"); !loaded || n != 42 {
+		t.Errorf("Expire = %d, %v; want 42, true", n, loaded)
+	}
+	if _, ok := cache.Get("n"); ok {
+		t.Error("key present after Expire")
+	}
+
+	// A value of another type, written to the underlying cache, reads
+	// as missing.
+	cache.Cache().Set("s", "text", time.Minute)
+	if n, ok := cache.Get("s"); ok || n != 0 {
+		t.Errorf("Get of a string = %d, %v; want 0, false", n, ok)
+	}
+
+	cache.ExpireAll()
+	if n := cache.Cache().Len(); n != 0 {
+		t.Errorf("Len = %d after ExpireAll", n)
+	}
+}
+
+func TestTypedCacheKeys(t *testing.T) {
+	cache := NewTypedCache[userPage, string](NewMemoryCache(), userPageKey)
+	cache.Set(userPage{User: "a", Page: 1}, "first", time.Minute)
+	if value, ok := cache.Get(userPage{User: "a", Page: 1}); !ok || value != "first" {
+		t.Errorf("Get = %q, %v; want first, true", value, ok)
+	}
+}
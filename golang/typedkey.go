@@ -0,0 +1,53 @@
+package enigmacache
+
+import "time"
+
+// A TypedKeyCache stores values under keys of any comparable type K,
+// such as a struct of the fields making up a composite key. Each key
+// is turned into a string by a caller-supplied function and the work
+// is delegated to an underlying cache, usually a MemoryCache.
+//
+// The key function must be injective: distinct keys must produce
+// distinct strings, or their entries will collide. Encoding each
+// field with a length prefix or a quoted form (strconv.Quote, say)
+// avoids the ambiguity of plain concatenation.
+type TypedKeyCache[K comparable] struct {
+	cache ReadWriter
+	key   func(K) string
+}
+
+// NewTypedKeyCache returns a TypedKeyCache backed by cache, using key
+// to turn keys into strings.
+func NewTypedKeyCache[K comparable](cache ReadWriter, key func(K) string) *TypedKeyCache[K] {
+	return &TypedKeyCache[K]{cache: cache, key: key}
+}
+
+// Cache returns the underlying cache.
+func (c *TypedKeyCache[K]) Cache() ReadWriter {
+	return c.cache
+}
+
+// Set is ReadWriter.Set for a typed key.
+func (c *TypedKeyCache[K]) Set(key K, value any, ttl time.Duration) {
+	c.cache.Set(c.key(key), value, ttl)
+}
+
+// GetOrSet is ReadWriter.GetOrSet for a typed key.
+func (c *TypedKeyCache[K]) GetOrSet(key K, value any, ttl time.Duration) (actual any, loaded bool) {
+	return c.cache.GetOrSet(c.key(key), value, ttl)
+}
+
+// Get is ReadWriter.Get for a typed key.
+func (c *TypedKeyCache[K]) Get(key K) (value any, ok bool) {
+	return c.cache.Get(c.key(key))
+}
+
+// Expire is ReadWriter.Expire for a typed key.
+func (c *TypedKeyCache[K]) Expire(key K) (value any, loaded bool) {
+	return c.cache.Expire(c.key(key))
+}
+
+// Refresh is ReadWriter.Refresh for a typed key.
+func (c *TypedKeyCache[K]) Refresh(key K, ttl time.Duration) (refreshed bool) {
+	return c.cache.Refresh(c.key(key), ttl)
+}
@@ -0,0 +1,48 @@
+package enigmacache
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type userPage struct {
+	User string
+	Page int
+}
+
+func userPageKey(k userPage) string {
+	return strconv.Quote(k.User) + ":" + strconv.Itoa(k.Page)
+}
+
+func TestTypedKeyCache(t *testing.T) {
+	cache := NewTypedKeyCache(NewMemoryCache(), userPageKey)
+
+	// With naive concatenation these two would collide as "a:b:1".
+	first := userPage{User: "a:b", Page: 1}
+	second := userPage{User: "a", Page: 0}
+	cache.Set(first, "first", time.Minute)
+	cache.Set(second, "second", time.Minute)
+
+	if value, _ := cache.Get(first); value != "first" {
+		t.Errorf("Get(first) = %v", value)
+	}
+	if value, _ := cache.Get(second); value != "second" {
+		t.Errorf("Get(second) = %v", value)
+	}
+
+	// An equal key finds the same entry.
+	if actual, loaded := cache.GetOrSet(userPage{User: "a:b", Page: 1}, "other", time.Minute); !loaded || actual != "first" {
+		t.Errorf("GetOrSet with an equal key got (%v, %v), want (first, true)", actual, loaded)
+	}
+	if !cache.Refresh(first, time.Hour) {
+		t.Error("Refresh did not find the key")
+	}
+	if value, loaded := cache.Expire(first); !loaded || value != "first" {
+		t.Errorf("Expire got (%v, %v), want (first, true)", value, loaded)
+	}
+	if _, ok := cache.Cache().Get(fmt.Sprint(second)); ok {
+		t.Error("underlying cache used an unexpected key")
+	}
+}
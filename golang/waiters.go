@@ -0,0 +1,116 @@
+package enigmacache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// A waitRegistry lets goroutines wait for an event on a key, such as
+// the key being stored, and wakes them when it's fired. Waiters give
+// up when their context is done, and the last one to leave a key takes
+// its channel with it, so waits which are abandoned don't pile up:
+// the registry only holds channels for keys someone is waiting on.
+// Events that don't belong to a key, such as the cache changing size,
+// use the key "". watched is set on the first wait, so registries no
+// one waits on don't pay for the lock when events fire. The zero
+// waitRegistry is ready for use.
+type waitRegistry struct {
+	watched atomic.Bool
+	mu      sync.Mutex
+	points  map[string]*waitPoint
+}
+
+// A waitPoint is the channel shared by the waiters on one key, closed
+// when the event fires.
+type waitPoint struct {
+	ch      chan struct{}
+	waiters int
+}
+
+// channel registers a waiter on key which never gives up, returning
+// the channel closed when the event next fires, unless ready reports
+// that the waiter needn't wait, in which case it returns nil. ready is
+// called with the registry locked, so an event fired after it looks
+// is bound to find the waiter.
+func (r *waitRegistry) channel(key string, ready func() bool) <-chan struct{} {
+	r.watched.Store(true)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ready() {
+		return nil
+	}
+	return r.join(key).ch
+}
+
+// wait blocks until ready reports true or ctx is done, returning
+// ctx.Err() in the latter case. ready is checked first, and again each
+// time the event fires for key.
+func (r *waitRegistry) wait(ctx context.Context, key string, ready func() bool) error {
+	r.watched.Store(true)
+	for {
+		r.mu.Lock()
+		if ready() {
+			r.mu.Unlock()
+			return nil
+		}
+		p := r.join(key)
+		r.mu.Unlock()
+
+		select {
+		case <-p.ch:
+		case <-ctx.Done():
+			r.leave(key, p)
+			return ctx.Err()
+		}
+	}
+}
+
+// join adds a waiter to key's waitPoint, creating it if need be. r.mu
+// must be held.
+func (r *waitRegistry) join(key string) *waitPoint {
+	p, ok := r.points[key]
+	if !ok {
+		if r.points == nil {
+			r.points = make(map[string]*waitPoint)
+		}
+		p = &waitPoint{ch: make(chan struct{})}
+		r.points[key] = p
+	}
+	p.waiters++
+	return p
+}
+
+// leave removes a waiter which has given up on p, dropping p once no
+// one is waiting on it.
+func (r *waitRegistry) leave(key string, p *waitPoint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.points[key] != p {
+		// The event fired as we gave up.
+		return
+	}
+	if p.waiters--; p.waiters == 0 {
+		delete(r.points, key)
+	}
+}
+
+// fire wakes everyone waiting on key.
+func (r *waitRegistry) fire(key string) {
+	if !r.watched.Load() {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, ok := r.points[key]; ok {
+		close(p.ch)
+		delete(r.points, key)
+	}
+}
+
+// len returns the number of keys being waited on.
+func (r *waitRegistry) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.points)
+}
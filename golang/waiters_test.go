@@ -0,0 +1,85 @@
+package enigmacache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// settledGoroutines waits for the goroutine count to drop to at most
+// want, returning the count.
+func settledGoroutines(want int) int {
+	n := runtime.NumGoroutine()
+	for deadline := time.Now().Add(time.Second); n > want && time.Now().Before(deadline); n = runtime.NumGoroutine() {
+		time.Sleep(time.Millisecond)
+	}
+	return n
+}
+
+func TestCancelledWaitsLeaveNothingBehind(t *testing.T) {
+	cache := NewMemoryCache()
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := range 100 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, ok := cache.GetWait(ctx, fmt.Sprint("never-", i%10)); ok {
+				t.Error("GetWait found a key which was never stored")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := cache.WaitForSize(ctx, 1000, 1000); !errors.Is(err, context.Canceled) {
+				t.Errorf("WaitForSize = %v, want Canceled", err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := cache.watchers.stored.len(); n != 10 {
+		t.Fatalf("%d keys waited on, want 10", n)
+	}
+	cancel()
+	wg.Wait()
+
+	if n := cache.watchers.stored.len() + cache.sizes.len(); n != 0 {
+		t.Fatalf("%d wait points left after every waiter gave up", n)
+	}
+	if n := settledGoroutines(before); n > before {
+		t.Fatalf("%d goroutines after cancelled waits, want %d", n, before)
+	}
+}
+
+func TestDoContextWaiterGivesUp(t *testing.T) {
+	cache := NewMemoryCache()
+	release := make(chan struct{})
+	leader := make(chan error)
+	go func() {
+		_, err := cache.DoContext(context.Background(), "key", func() (any, error) {
+			<-release
+			return "done", nil
+		})
+		leader <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := cache.DoContext(ctx, "key", func() (any, error) {
+		t.Error("waiter ran fn while a call was in flight")
+		return nil, nil
+	}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waiting DoContext = %v, want DeadlineExceeded", err)
+	}
+
+	close(release)
+	if err := <-leader; err != nil {
+		t.Fatalf("leader's DoContext = %v", err)
+	}
+}
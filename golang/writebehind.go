@@ -0,0 +1,137 @@
+package enigmacache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// WithWriteBehind makes a cache built WithWriteThrough write to its
+// store in the background rather than as part of each write: writes
+// and deletes are queued, and the queue is sent to the store every
+// interval, or as soon as it holds batchSize keys. An interval of zero
+// or less only sends it once it holds batchSize keys, or on Flush; a
+// batchSize of zero or less only every interval. Repeated writes to
+// a key while it's queued collapse into the last one. A value which
+// has expired by the time its turn comes isn't written. SetContext
+// can't report the store's errors in this mode; they're logged
+// instead (see WithLogger). Drain flushes the queue before waiting on
+// the rest of the cache's work, as do Close and Shutdown, so queued
+// writes aren't lost on shutdown; Flush sends them without waiting for
+// anything else. Once the cache is closed, deletes go to the store
+// straight away.
+func WithWriteBehind(interval time.Duration, batchSize int) Option {
+	return func(o *options) {
+		o.behindInterval = interval
+		o.behindBatch = batchSize
+	}
+}
+
+// A writeBehind queues writes to the cache's store for WithWriteBehind.
+type writeBehind struct {
+	// flushMu keeps flushes in order, so an older batch can't land
+	// after a newer one.
+	flushMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]behindOp
+	timer   Timer
+}
+
+// A behindOp is a queued write, or a delete.
+type behindOp struct {
+	value     any
+	expiresAt time.Time
+	delete    bool
+}
+
+// queue adds op for key to the queue, flushing it in the background
+// if it's due.
+func (mc *MemoryCache) queue(key string, op behindOp) {
+	w := mc.behind
+	w.mu.Lock()
+	if mc.closed.Load() {
+		// Nothing flushes the queue once the cache is closed, so the
+		// op goes to the store straight away.
+		w.mu.Unlock()
+		mc.logFlush(mc.send(context.Background(), key, op))
+		return
+	}
+	defer w.mu.Unlock()
+	if w.pending == nil {
+		w.pending = make(map[string]behindOp)
+		if d := mc.opts.behindInterval; d > 0 {
+			w.timer = mc.opts.clock.AfterFunc(d, mc.flushInBackground)
+		}
+	}
+	w.pending[key] = op
+	if b := mc.opts.behindBatch; b > 0 && len(w.pending) >= b && mc.tryAcquireBackground() {
+		// Counted before the goroutine starts, so Drain can't miss it.
+		mc.tasks.start()
+		go func() {
+			defer mc.tasks.done()
+			defer mc.releaseBackground()
+			mc.flushQueue()
+		}()
+	}
+}
+
+func (mc *MemoryCache) flushInBackground() {
+	mc.tasks.start()
+	defer mc.tasks.done()
+	mc.flushQueue()
+}
+
+// flushQueue flushes the queue, logging any errors.
+func (mc *MemoryCache) flushQueue() {
+	mc.storeWrites.Add(1)
+	defer mc.storeWrites.Add(-1)
+	mc.logFlush(mc.Flush(context.Background()))
+}
+
+func (mc *MemoryCache) logFlush(err error) {
+	if err != nil {
+		mc.logger().Warn("enigma-cache: write-behind flush failed", "error", err)
+	}
+}
+
+// Flush sends the writes queued by WithWriteBehind to the store
+// straight away, returning the errors the store reports. It does
+// nothing for a cache without write-behind.
+func (mc *MemoryCache) Flush(ctx context.Context) error {
+	w := mc.behind
+	if w == nil {
+		return nil
+	}
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	batch := w.pending
+	w.pending = nil
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.mu.Unlock()
+
+	var errs []error
+	for key, op := range batch {
+		errs = append(errs, mc.send(ctx, key, op))
+	}
+	return errors.Join(errs...)
+}
+
+// send applies op for key to the store, unless it's a value which has
+// expired since it was queued.
+func (mc *MemoryCache) send(ctx context.Context, key string, op behindOp) error {
+	s := mc.opts.writeThrough
+	if op.delete {
+		return s.Delete(ctx, key)
+	}
+	if ttl := op.expiresAt.Sub(mc.now()); ttl > 0 {
+		return s.Set(ctx, key, op.value, ttl)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package enigmacache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWriteBehindFlushesOnInterval(t *testing.T) {
+	l2 := newFakeStore(0)
+	cache, clock := NewTestCache(WithWriteThrough(l2), WithWriteBehind(time.Second, 0))
+	ctx := context.Background()
+
+	for i := range 3 {
+		cache.Set("key", i, time.Minute)
+	}
+	cache.Set("gone", 1, time.Minute)
+	cache.Expire("gone")
+	if _, ok, _ := l2.Get(ctx, "key"); ok {
+		t.Fatal("write reached the store before the flush")
+	}
+
+	clock.Advance(time.Second)
+	if value, ok, _ := l2.Get(ctx, "key"); !ok || value != 2 {
+		t.Fatalf("store holds (%v, %v), want (2, true)", value, ok)
+	}
+	if _, ok, _ := l2.Get(ctx, "gone"); ok {
+		t.Fatal("queued delete didn't reach the store")
+	}
+	if l2.sets != 1 {
+		t.Fatalf("store got %d writes, want 1 after coalescing", l2.sets)
+	}
+}
+
+func TestWriteBehindFlushesFullBatches(t *testing.T) {
+	l2 := newFakeStore(0)
+	cache := NewMemoryCache(WithWriteThrough(l2), WithWriteBehind(time.Hour, 2))
+	ctx := context.Background()
+
+	cache.Set("a", 1, time.Minute)
+	cache.Set("b", 2, time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok, _ := l2.Get(ctx, "b"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("a full batch wasn't flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDrainFlushesWriteBehind(t *testing.T) {
+	l2 := newFakeStore(0)
+	cache := NewMemoryCache(WithWriteThrough(l2), WithWriteBehind(time.Hour, 0))
+	ctx := context.Background()
+
+	cache.Set("key", "value", time.Minute)
+	if err := cache.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, _ := l2.Get(ctx, "key"); !ok || value != "value" {
+		t.Fatalf("store holds (%v, %v) after Drain, want (value, true)", value, ok)
+	}
+}
+
+func TestWriteBehindWithoutInterval(t *testing.T) {
+	l2 := newFakeStore(0)
+	cache, clock := NewTestCache(WithWriteThrough(l2), WithWriteBehind(0, 3))
+	ctx := context.Background()
+
+	cache.Set("a", 1, NoExpiration)
+	cache.Set("b", 2, NoExpiration)
+	if n := len(clock.timers); n != 0 {
+		t.Errorf("write-behind armed %d timers without an interval", n)
+	}
+	clock.Advance(time.Second)
+	if _, ok, _ := l2.Get(ctx, "a"); ok {
+		t.Fatal("writes reached the store before the batch filled")
+	}
+
+	cache.Set("c", 3, NoExpiration)
+	if err := cache.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if _, ok, _ := l2.Get(ctx, key); !ok {
+			t.Errorf("store is missing %q after a full batch", key)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package enigmacache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A Store is a slower tier behind the in-memory cache, such as a
+// remote cache or a database, which the cache can write through to.
+// Implementations must be safe for concurrent use and should honor
+// context cancellation.
+type Store interface {
+	Get(ctx context.Context, key string) (value any, ok bool, err error)
+	Set(ctx context.Context, key string, value any, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// WithWriteThrough makes the cache propagate writes to the given
+// Store: every value stored in memory is also Set in the store, and
+// Expire deletes the key from it. Only SetContext reports the store's
+// errors; the other write methods have nowhere to return them.
+func WithWriteThrough(s Store) Option {
+	return func(o *options) {
+		o.writeThrough = s
+	}
+}
+
+// SetContext sets a key like Set, then writes it through to the
+// cache's write-through store, if it has one. The in-memory write is
+// synchronous; a value the cache's options turn away (see Set) isn't
+// written through, and fails with ErrRejected, or ErrValueTooLarge if
+// it costs more than the whole WithMaxMemory budget. Only the
+// write-through honors ctx. If ctx is done before the store finishes,
+// SetContext returns ctx.Err() straight away and leaves the store's
+// write to finish in the background, where Drain can wait for it.
+func (mc *MemoryCache) SetContext(ctx context.Context, key string, value any, ttl time.Duration) error {
+	key = mc.normalize(key)
+	if mc.closed.Load() {
+		return fmt.Errorf("set %q: %w", key, ErrClosed)
+	}
+	ttl, ok := mc.valueTTL(key, value, ttl)
+	if !ok || mc.rejects(value) {
+		return fmt.Errorf("set %q: %w", key, ErrRejected)
+	}
+	e := mc.newEntry(key, value, ttl, 0)
+	if !mc.fits(e) {
+		return fmt.Errorf("set %q: %d bytes: %w", key, e.cost, ErrValueTooLarge)
+	}
+	prev, ok := mc.putEntry(key, e)
+	if !ok {
+		return fmt.Errorf("set %q: %w", key, ErrRejected)
+	}
+	if prev != nil {
+		mc.replaced(prev)
+	}
+	return mc.writeThrough(ctx, key, value, ttl)
+}
+
+// ExpireContext removes key like Expire, deleting it from the cache's
+// write-through store, if it has one, with ctx, and returns the
+// store's error. The in-memory removal always happens, whatever the
+// store says.
+func (mc *MemoryCache) ExpireContext(ctx context.Context, key string) (value any, loaded bool, err error) {
+	key = mc.normalize(key)
+	switch s := mc.opts.writeThrough; {
+	case s == nil:
+	case mc.behind != nil:
+		mc.queue(key, behindOp{delete: true})
+	default:
+		if err = ctx.Err(); err == nil {
+			err = s.Delete(ctx, key)
+		}
+	}
+	value, _, loaded = mc.pop(key)
+	return value, loaded, err
+}
+
+func (mc *MemoryCache) writeThrough(ctx context.Context, key string, value any, ttl time.Duration) error {
+	s := mc.opts.writeThrough
+	if s == nil {
+		return nil
+	}
+	if mc.behind != nil {
+		mc.queue(key, behindOp{value: value, expiresAt: mc.now().Add(ttl)})
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		// The context can never be cancelled, so there's nothing to
+		// race the store against.
+		return s.Set(ctx, key, value, ttl)
+	}
+
+	if !mc.acquireBackground(ctx) {
+		return ctx.Err()
+	}
+	done := make(chan error, 1)
+	mc.tasks.start()
+	mc.storeWrites.Add(1)
+	go func() {
+		defer mc.tasks.done()
+		defer mc.releaseBackground()
+		defer mc.storeWrites.Add(-1)
+		done <- s.Set(ctx, key, value, ttl)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deleteThrough deletes key from the write-through store, if the
+// cache has one. Like writes, deletes have nowhere to report errors.
+func (mc *MemoryCache) deleteThrough(key string) {
+	if s := mc.opts.writeThrough; s != nil {
+		if mc.behind != nil {
+			mc.queue(key, behindOp{delete: true})
+			return
+		}
+		_ = s.Delete(context.Background(), key)
+	}
+}
+
+// Demote moves the cache's contents to its write-through store, for
+// shedding memory without losing data: each live entry is written to
+// the store with the time it has left as its TTL, and then removed
+// from the cache with ReasonDemoted, so later misses can be served
+// from the store. Writes queued by WithWriteBehind are flushed first.
+// Unlike ExpireAll, nothing is lost: an entry whose write fails stays
+// in the cache, as does one overwritten while Demote is working, and
+// if ctx is done Demote stops, leaving the rest where they are, and
+// returns ctx.Err(). It otherwise returns the store's errors, or
+// ErrNoStore if the cache has no store. Entries past their deadline
+// are left to expire.
+func (mc *MemoryCache) Demote(ctx context.Context) error {
+	s := mc.opts.writeThrough
+	if s == nil {
+		return ErrNoStore
+	}
+	var errs []error
+	if mc.behind != nil {
+		errs = append(errs, mc.Flush(ctx))
+	}
+	mc.storage.Range(func(key string, e *entry) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		now := mc.now()
+		if !e.live(now) {
+			return true
+		}
+		if err := s.Set(ctx, key, e.get(), e.remaining(now)); err != nil {
+			errs = append(errs, err)
+			return true
+		}
+		if mc.storage.CompareAndDelete(key, e) {
+			mc.removed(key, e, ReasonDemoted)
+		}
+		return true
+	})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
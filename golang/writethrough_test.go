@@ -0,0 +1,209 @@
+package enigmacache
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore is an in-memory Store which takes delay to do each
+// write, ignoring cancellation so we can check the cache doesn't wait
+// on it.
+type fakeStore struct {
+	delay time.Duration
+
+	mu     sync.Mutex
+	values map[string]any
+	// ttls holds the TTL each key was last set with.
+	ttls map[string]time.Duration
+	// sets counts calls to Set.
+	sets int
+}
+
+func newFakeStore(delay time.Duration) *fakeStore {
+	return &fakeStore{delay: delay, values: make(map[string]any), ttls: make(map[string]time.Duration)}
+}
+
+func (s *fakeStore) Get(_ context.Context, key string) (any, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	return value, ok, nil
+}
+
+func (s *fakeStore) Set(_ context.Context, key string, value any, ttl time.Duration) error {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.ttls[key] = ttl
+	s.sets++
+	return nil
+}
+
+func (s *fakeStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	return nil
+}
+
+func TestSetContextWritesThrough(t *testing.T) {
+	l2 := newFakeStore(0)
+	cache := NewMemoryCache(WithWriteThrough(l2))
+
+	if err := cache.SetContext(context.Background(), "key", "value", time.Minute); err != nil {
+		t.Fatalf("SetContext returned %v", err)
+	}
+	if value, ok, _ := l2.Get(context.Background(), "key"); !ok || value != "value" {
+		t.Fatalf("store holds (%v, %v), want (value, true)", value, ok)
+	}
+
+	cache.Expire("key")
+	if _, ok, _ := l2.Get(context.Background(), "key"); ok {
+		t.Fatal("Expire did not delete the key from the store")
+	}
+}
+
+func TestSetContextReportsRejections(t *testing.T) {
+	l2 := newFakeStore(0)
+	cache := NewMemoryCache(WithWriteThrough(l2), WithRejectNil(true), WithMaxTTL(time.Hour), WithRejectOverMaxTTL())
+	ctx := context.Background()
+	for name, err := range map[string]error{
+		"nil":       cache.SetContext(ctx, "nil", nil, time.Minute),
+		"long TTL":  cache.SetContext(ctx, "long", "value", 2*time.Hour),
+		"value TTL": cache.SetContext(ctx, "plain", "value", ValueTTL),
+	} {
+		if !errors.Is(err, ErrRejected) {
+			t.Errorf("SetContext of a %s value returned %v, want ErrRejected", name, err)
+		}
+	}
+	if len(l2.values) != 0 {
+		t.Errorf("rejected values reached the store: %v", l2.values)
+	}
+}
+
+func TestSetContextCancelledDuringWriteThrough(t *testing.T) {
+	l2 := newFakeStore(200 * time.Millisecond)
+	cache := NewMemoryCache(WithWriteThrough(l2))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := cache.SetContext(ctx, "key", "value", time.Minute)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("SetContext returned %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("SetContext took %v, it should return when the context is done", elapsed)
+	}
+	if value, ok := cache.Get("key"); !ok || value != "value" {
+		t.Fatalf("in-memory value is (%v, %v), want (value, true)", value, ok)
+	}
+
+	// The abandoned write still lands, and Drain waits for it.
+	if err := cache.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain returned %v", err)
+	}
+	if _, ok, _ := l2.Get(context.Background(), "key"); !ok {
+		t.Fatal("abandoned write never reached the store")
+	}
+}
+
+func TestSetContextAlreadyCancelled(t *testing.T) {
+	l2 := newFakeStore(0)
+	cache := NewMemoryCache(WithWriteThrough(l2))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := cache.SetContext(ctx, "key", "value", time.Minute); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SetContext returned %v, want context.Canceled", err)
+	}
+	if _, ok := cache.Get("key"); !ok {
+		t.Fatal("in-memory write was skipped")
+	}
+	if _, ok, _ := l2.Get(context.Background(), "key"); ok {
+		t.Fatal("store was written despite the cancelled context")
+	}
+}
+
+func TestDemoteMovesEntriesToStore(t *testing.T) {
+	l2 := newFakeStore(0)
+	var reasons []EvictionReason
+	cache, clock := NewTestCache(WithWriteThrough(l2), WithOnEvicted(func(_ string, _ any, reason EvictionReason) {
+		reasons = append(reasons, reason)
+	}))
+	cache.Set("a", 1, time.Minute)
+	cache.Set("b", 2, time.Hour)
+	clock.Advance(20 * time.Second)
+
+	if err := cache.Demote(context.Background()); err != nil {
+		t.Fatalf("Demote returned %v", err)
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("Len = %d after Demote, want 0", cache.Len())
+	}
+	if want := map[string]time.Duration{"a": 40 * time.Second, "b": time.Hour - 20*time.Second}; !maps.Equal(l2.ttls, want) {
+		t.Errorf("store TTLs = %v, want %v", l2.ttls, want)
+	}
+	if len(reasons) != 2 || reasons[0] != ReasonDemoted || reasons[1] != ReasonDemoted {
+		t.Errorf("OnEvicted reasons = %v, want two ReasonDemoted", reasons)
+	}
+
+	// A miss falls through to the store.
+	value, _, err := cache.GetOrComputeDetailed("a", time.Minute, func(ctx context.Context) (any, error) {
+		value, _, err := l2.Get(ctx, "a")
+		return value, err
+	})
+	if err != nil || value != 1 {
+		t.Fatalf("read after Demote = (%v, %v), want (1, nil)", value, err)
+	}
+}
+
+// failingStore is a Store whose writes all fail.
+type failingStore struct{ fakeStore }
+
+func (s *failingStore) Set(context.Context, string, any, time.Duration) error {
+	return errors.New("store down")
+}
+
+func TestDemoteKeepsEntriesTheStoreRejects(t *testing.T) {
+	if err := NewMemoryCache().Demote(context.Background()); !errors.Is(err, ErrNoStore) {
+		t.Fatalf("Demote without a store returned %v, want ErrNoStore", err)
+	}
+	cache := NewMemoryCache(WithWriteThrough(&failingStore{}))
+	cache.Set("a", 1, time.Minute)
+	if err := cache.Demote(context.Background()); err == nil {
+		t.Fatal("Demote hid the store's error")
+	}
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get after failed Demote = (%v, %v), want (1, true)", v, ok)
+	}
+}
+
+func TestExpireContextDeletesThrough(t *testing.T) {
+	l2 := newFakeStore(0)
+	cache := NewMemoryCache(WithWriteThrough(l2))
+	cache.Set("key", "value", time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	value, loaded, err := cache.ExpireContext(ctx, "key")
+	if !errors.Is(err, context.Canceled) || !loaded || value != "value" {
+		t.Errorf("ExpireContext = %v, %v, %v; want value, true, context.Canceled", value, loaded, err)
+	}
+	if cache.Has("key") {
+		t.Error("key stayed in memory when the store's delete was cancelled")
+	}
+
+	cache.Set("key", "value", time.Minute)
+	if _, _, err := cache.ExpireContext(context.Background(), "key"); err != nil {
+		t.Errorf("ExpireContext returned %v", err)
+	}
+	if _, ok, _ := l2.Get(context.Background(), "key"); ok {
+		t.Error("key wasn't deleted from the store")
+	}
+}